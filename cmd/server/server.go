@@ -1,15 +1,24 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"github.com/fsnotify/fsnotify"
 	"github.com/itbellissimo/ratelimit/handler"
 	"github.com/itbellissimo/ratelimit/middleware"
+	"github.com/itbellissimo/ratelimit/pkg/metrics"
 	"github.com/itbellissimo/ratelimit/pkg/ratelimit"
 	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage/cluster"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage/crdt"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage/instrumented"
 	"github.com/spf13/viper"
 	"log"
 	"net/http"
+	"os"
+	"time"
 )
 
 func main() {
@@ -21,14 +30,133 @@ func main() {
 	if err != nil {
 		log.Fatal(err.Error())
 	}
+	if err = ratelimit.ValidateConfig(&cfg); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	storageCfg, err := getStorageConfig()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	adminCfg, err := getAdminConfig()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
 
-	memStorage := storage.NewMemoryCache()
-	rateLimit := ratelimit.NewRateLimit(&cfg, memStorage)
+	evictionPolicy, err := storage.ParseEvictionPolicy(storageCfg.EvictionPolicy)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	ttlMode, err := storage.ParseTTLMode(storageCfg.TTLMode)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	memStorage := storage.NewMemoryCache(
+		storage.WithMaxKeys(storageCfg.MaxKeys),
+		storage.WithMaxBytes(storageCfg.MaxBytes),
+		storage.WithEvictionPolicy(evictionPolicy),
+		storage.WithTTLMode(ttlMode),
+	)
+	reg := metrics.NewRegistry()
+	if err = memStorage.RegisterMetrics(reg); err != nil {
+		log.Fatal(err.Error())
+	}
+	clusterCfg, err := getClusterConfig()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	crdtCfg, err := getCrdtConfig()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if clusterCfg.Enabled && crdtCfg.Enabled {
+		log.Fatal("cluster and crdt modes can't be enabled together")
+	}
+	if clusterCfg.Enabled && clusterCfg.Secret == "" {
+		log.Fatal("cluster mode requires secret of peers")
+	}
+	if crdtCfg.Enabled && (crdtCfg.Secret == "" || crdtCfg.Addr == "") {
+		log.Fatal("crdt mode requires secret and addr of gossip listener")
+	}
+
+	var limitStorage ratelimit.Storager = memStorage
+	var replica *crdt.Store
+	if crdtCfg.Enabled {
+		replica = crdt.New(crdtCfg.Self, crdtCfg.Peers,
+			&crdt.HTTPTransport{Client: &http.Client{Timeout: crdtCfg.Timeout}, Secret: crdtCfg.Secret},
+			crdt.WithGossipInterval(crdtCfg.GossipInterval),
+		)
+		replica.Start(context.Background())
+		limitStorage = replica
+	}
+
+	var peers *cluster.Cluster
+	if clusterCfg.Enabled {
+		peers = cluster.New(clusterCfg.Self, clusterCfg.Peers, memStorage,
+			cluster.WithReplicas(clusterCfg.Replicas),
+			cluster.WithSecret(clusterCfg.Secret),
+			cluster.WithHealthCheck(clusterCfg.HealthInterval, clusterCfg.FailThreshold),
+			cluster.WithHTTPClient(&http.Client{Timeout: clusterCfg.Timeout}),
+		)
+		peers.Start(context.Background())
+		limitStorage = peers
+	}
+	limitStorage, err = instrumented.New(limitStorage, reg, instrumented.DefaultPrefix)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if storageCfg.BreakerThreshold > 0 {
+		limitStorage = ratelimit.NewCircuitBreaker(limitStorage, storageCfg.BreakerThreshold, storageCfg.BreakerOpenTime)
+	}
+	rateLimitOpts := []ratelimit.Option{ratelimit.WithStorageTimeout(storageCfg.Timeout)}
+	if storageCfg.MaxKeys > 0 || storageCfg.MaxBytes > 0 {
+		// local storage of FailLocal policy is bounded like the primary one
+		rateLimitOpts = append(rateLimitOpts, ratelimit.WithFallbackStorage(storage.NewMemoryCache(
+			storage.WithMaxKeys(storageCfg.MaxKeys),
+			storage.WithMaxBytes(storageCfg.MaxBytes),
+		)))
+	}
+	sharedStorage := limitStorage
+	if storageCfg.Namespace != (ratelimit.Namespace{}) {
+		rateLimitOpts = append(rateLimitOpts, ratelimit.WithNamespace(storageCfg.Namespace))
+		sharedStorage = ratelimit.NewNamespacedStorage(limitStorage, storageCfg.Namespace)
+	}
+	bans := ratelimit.NewBanlist(sharedStorage)
+	if err = bans.Refresh(context.Background()); err != nil {
+		log.Printf("banlist refresh error: %s", err.Error())
+	}
+	bans.Start(context.Background())
+	overrides := ratelimit.NewOverrides(sharedStorage)
+	if err = overrides.Refresh(context.Background()); err != nil {
+		log.Printf("overrides refresh error: %s", err.Error())
+	}
+	overrides.Start(context.Background())
+	rateLimitOpts = append(rateLimitOpts, ratelimit.WithBanlist(bans), ratelimit.WithOverrides(overrides))
+	ipListsCfg, err := getIPListsConfig()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	if len(ipListsCfg.DenylistFiles) > 0 {
+		rateLimitOpts = append(rateLimitOpts, ratelimit.WithDenylist(loadIPList(ipListsCfg.DenylistFiles)))
+	}
+	if len(ipListsCfg.AllowlistFiles) > 0 {
+		rateLimitOpts = append(rateLimitOpts, ratelimit.WithAllowlist(loadIPList(ipListsCfg.AllowlistFiles)))
+	}
+	rateLimit := ratelimit.NewRateLimit(&cfg, limitStorage, rateLimitOpts...)
 
 	viper.OnConfigChange(func(e fsnotify.Event) {
-		cfg, err = getConfig()
+		newCfg, err := getConfig()
 		if err != nil {
-			log.Fatal(err.Error())
+			log.Printf("config reload error, the current config is kept: %s", err.Error())
+			return
+		}
+		// rules are indexed by masks, so the new config is set by UpdateConfig
+		if err = rateLimit.UpdateConfig(&newCfg); err != nil {
+			log.Printf("config reload error, the current config is kept: %s", err.Error())
 		}
 	})
 	viper.WatchConfig()
@@ -36,9 +164,33 @@ func main() {
 	mux := http.NewServeMux()
 	server := handler.NewHandler()
 	mux.HandleFunc("/run", server.Run)
-	mux.HandleFunc("/reset", server.Reset)
 
-	h := middleware.RateLimit(mux, rateLimit)
+	root := http.NewServeMux()
+	root.Handle("/metrics", reg.Handler())
+	if peers != nil {
+		root.Handle(cluster.PathPrefix, peers.Handler())
+	}
+	if replica != nil {
+		gossipMux := http.NewServeMux()
+		gossipMux.Handle(crdt.GossipPath, replica.Handler(crdtCfg.Secret))
+		go serveGossip(crdtCfg.Addr, gossipMux)
+	}
+	if adminCfg.Enabled {
+		adminOpts, err := adminOptions(adminCfg)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		adminOpts = append(adminOpts, handler.WithLimiter(rateLimit), handler.WithBanlist(bans), handler.WithOverrides(overrides))
+		admin := handler.NewAdmin(limitStorage, adminOpts...).Handler()
+		if adminCfg.Addr == "" {
+			root.Handle(handler.AdminPrefix, admin)
+		} else {
+			adminMux := http.NewServeMux()
+			adminMux.Handle(handler.AdminPrefix, admin)
+			go serveAdmin(adminCfg, adminMux)
+		}
+	}
+	root.Handle("/", middleware.RateLimit(mux, rateLimit))
 
 	port := "3000"
 	portConfig := viper.Get("server.port")
@@ -46,7 +198,7 @@ func main() {
 		port = fmt.Sprintf("%v", portConfig)
 	}
 
-	err = http.ListenAndServe(":"+port, h)
+	err = http.ListenAndServe(":"+port, root)
 	if err != nil {
 		panic(err.Error())
 	}
@@ -69,6 +221,273 @@ func getConfig() (ratelimit.Config, error) {
 	if err != nil {
 		return ratelimit.Config{}, fmt.Errorf("fatal error config file: %w", err)
 	}
+	for i, override := range rawVal.Overrides {
+		if rawVal.Overrides[i], err = override.Normalize(); err != nil {
+			return ratelimit.Config{}, fmt.Errorf("override %d of config file: %w", i, err)
+		}
+	}
+
+	return rawVal, nil
+}
+
+// ipListsConfig files of static lists, one IP or subnet per line
+type ipListsConfig struct {
+	// DenylistFiles of IPs rejected before rules
+	DenylistFiles []string `mapstructure:"denylist_files"`
+	// AllowlistFiles of IPs that are never limited
+	AllowlistFiles []string `mapstructure:"allowlist_files"`
+}
+
+func getIPListsConfig() (ipListsConfig, error) {
+	var rawVal ipListsConfig
+	err := viper.UnmarshalKey("server.ip_lists", &rawVal)
+	if err != nil {
+		return ipListsConfig{}, fmt.Errorf("fatal error ip lists config: %w", err)
+	}
+
+	return rawVal, nil
+}
+
+// loadIPList load files and watch them for changes
+func loadIPList(files []string) *ratelimit.IPList {
+	list := ratelimit.NewIPList(files...)
+	if err := list.Load(); err != nil {
+		log.Fatal(err.Error())
+	}
+	if err := list.Watch(context.Background()); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	return list
+}
+
+// adminConfig settings of admin API
+type adminConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Addr of separate listener, empty - admin API is served on the port of the server
+	Addr string `mapstructure:"addr"`
+	// Tokens of "Authorization: Bearer" header
+	Tokens []adminCredential `mapstructure:"tokens"`
+	// HMACKeys of signed requests, name is key ID
+	HMACKeys []adminCredential `mapstructure:"hmac_keys"`
+	// ClientCerts by common name, require TLS of separate listener
+	ClientCerts []adminCredential `mapstructure:"client_certs"`
+	TLS         adminTLSConfig    `mapstructure:"tls"`
+	// AuditFile of json lines of mutating calls, empty - audit is written to log
+	AuditFile string `mapstructure:"audit_file"`
+}
+
+// adminCredential of admin principal
+type adminCredential struct {
+	Name   string `mapstructure:"name"`
+	Token  string `mapstructure:"token"`
+	Secret string `mapstructure:"secret"`
+	// Role is read-only or operator
+	Role string `mapstructure:"role"`
+}
+
+// adminTLSConfig of separate admin listener
+type adminTLSConfig struct {
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	// ClientCAFile verify client certificates by the CA
+	ClientCAFile string `mapstructure:"client_ca_file"`
+}
+
+func getAdminConfig() (adminConfig, error) {
+	var rawVal adminConfig
+	err := viper.UnmarshalKey("server.admin", &rawVal)
+	if err != nil {
+		return adminConfig{}, fmt.Errorf("fatal error admin config: %w", err)
+	}
+
+	return rawVal, nil
+}
+
+// adminOptions return authenticators and auditor of admin API by config
+func adminOptions(cfg adminConfig) ([]handler.AdminOption, error) {
+	var auth []handler.Authenticator
+
+	principal := func(c adminCredential) (handler.Principal, error) {
+		role, err := handler.ParseRole(c.Role)
+		if err != nil {
+			return handler.Principal{}, fmt.Errorf("admin credential %q: %w", c.Name, err)
+		}
+		return handler.Principal{Name: c.Name, Role: role}, nil
+	}
+
+	if len(cfg.Tokens) > 0 {
+		tokens := make(map[string]handler.Principal, len(cfg.Tokens))
+		for _, c := range cfg.Tokens {
+			p, err := principal(c)
+			if err != nil {
+				return nil, err
+			}
+			if c.Token == "" {
+				return nil, fmt.Errorf("admin token %q is empty", c.Name)
+			}
+			tokens[c.Token] = p
+		}
+		auth = append(auth, handler.NewBearerAuth(tokens))
+	}
+
+	if len(cfg.HMACKeys) > 0 {
+		keys := make(map[string]handler.HMACKey, len(cfg.HMACKeys))
+		for _, c := range cfg.HMACKeys {
+			p, err := principal(c)
+			if err != nil {
+				return nil, err
+			}
+			if c.Secret == "" {
+				return nil, fmt.Errorf("admin hmac key %q has empty secret", c.Name)
+			}
+			keys[c.Name] = handler.HMACKey{Secret: c.Secret, Principal: p}
+		}
+		auth = append(auth, handler.NewHMACAuth(keys))
+	}
+
+	if len(cfg.ClientCerts) > 0 {
+		if cfg.Addr == "" || cfg.TLS.ClientCAFile == "" {
+			return nil, fmt.Errorf("admin client_certs require addr and tls.client_ca_file")
+		}
+		names := make(map[string]handler.Principal, len(cfg.ClientCerts))
+		for _, c := range cfg.ClientCerts {
+			p, err := principal(c)
+			if err != nil {
+				return nil, err
+			}
+			names[c.Name] = p
+		}
+		auth = append(auth, handler.NewCertAuth(names))
+	}
+
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("admin API requires tokens, hmac_keys or client_certs")
+	}
+	opts := []handler.AdminOption{handler.WithAuth(auth...)}
+
+	if cfg.AuditFile != "" {
+		f, err := os.OpenFile(cfg.AuditFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, fmt.Errorf("open admin audit file: %w", err)
+		}
+		opts = append(opts, handler.WithAuditor(handler.NewJSONAuditor(f)))
+	}
+
+	return opts, nil
+}
+
+// serveAdmin serve admin API on separate listener, with TLS if it is configured
+func serveAdmin(cfg adminConfig, h http.Handler) {
+	server := &http.Server{Addr: cfg.Addr, Handler: h}
+	log.Println("Start admin HTTP server: " + cfg.Addr)
+
+	if cfg.TLS.CertFile == "" {
+		log.Fatal(server.ListenAndServe())
+	}
+
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.TLS.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.TLS.ClientCAFile)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			log.Fatal("no certificates in " + cfg.TLS.ClientCAFile)
+		}
+		tlsCfg.ClientCAs = pool
+		// clients without certificate can still use tokens and signatures
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	server.TLSConfig = tlsCfg
+
+	log.Fatal(server.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile))
+}
+
+// serveGossip serve gossip of replicas on separate listener, so clients can't send deltas
+func serveGossip(addr string, h http.Handler) {
+	log.Println("Start gossip HTTP server: " + addr)
+	log.Fatal(http.ListenAndServe(addr, h))
+}
+
+// storageConfig settings of the limits storage
+type storageConfig struct {
+	MaxKeys        int    `mapstructure:"max_keys"`
+	MaxBytes       int64  `mapstructure:"max_bytes"`
+	EvictionPolicy string `mapstructure:"eviction_policy"`
+	TTLMode        string `mapstructure:"ttl_mode"`
+	// Timeout of one storage call
+	Timeout time.Duration `mapstructure:"timeout"`
+	// BreakerThreshold consecutive storage failures to open circuit breaker, 0 - without breaker
+	BreakerThreshold int           `mapstructure:"breaker_threshold"`
+	BreakerOpenTime  time.Duration `mapstructure:"breaker_open_time"`
+	// Namespace of keys, empty - keys are rule IDs
+	Namespace ratelimit.Namespace `mapstructure:"namespace"`
+}
+
+func getStorageConfig() (storageConfig, error) {
+	var rawVal storageConfig
+	err := viper.UnmarshalKey("server.storage", &rawVal)
+	if err != nil {
+		return storageConfig{}, fmt.Errorf("fatal error storage config: %w", err)
+	}
+
+	return rawVal, nil
+}
+
+// clusterConfig settings of peer-to-peer cluster mode
+type clusterConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Self is URL of this instance as other peers call it
+	Self  string   `mapstructure:"self"`
+	Peers []string `mapstructure:"peers"`
+	// Secret shared by peers, it is required: the cluster rejects all peer requests without it
+	Secret         string        `mapstructure:"secret"`
+	Replicas       int           `mapstructure:"replicas"`
+	HealthInterval time.Duration `mapstructure:"health_interval"`
+	FailThreshold  int           `mapstructure:"fail_threshold"`
+	// Timeout of one forwarded call
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+func getClusterConfig() (clusterConfig, error) {
+	rawVal := clusterConfig{
+		HealthInterval: time.Second,
+		FailThreshold:  2,
+		Timeout:        time.Second,
+	}
+	err := viper.UnmarshalKey("server.cluster", &rawVal)
+	if err != nil {
+		return clusterConfig{}, fmt.Errorf("fatal error cluster config: %w", err)
+	}
+
+	return rawVal, nil
+}
+
+// crdtConfig settings of replicated counters mode
+type crdtConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Addr of gossip listener, it is separate from the port of clients
+	Addr string `mapstructure:"addr"`
+	// Self is URL of this instance as other replicas call it, it is also ID of the replica
+	Self           string        `mapstructure:"self"`
+	Peers          []string      `mapstructure:"peers"`
+	Secret         string        `mapstructure:"secret"`
+	GossipInterval time.Duration `mapstructure:"gossip_interval"`
+	// Timeout of one gossip call
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+func getCrdtConfig() (crdtConfig, error) {
+	rawVal := crdtConfig{
+		GossipInterval: 100 * time.Millisecond,
+		Timeout:        time.Second,
+	}
+	err := viper.UnmarshalKey("server.crdt", &rawVal)
+	if err != nil {
+		return crdtConfig{}, fmt.Errorf("fatal error crdt config: %w", err)
+	}
 
 	return rawVal, nil
 }
@@ -4,6 +4,8 @@ go 1.19
 
 require (
 	github.com/fsnotify/fsnotify v1.6.0
+	github.com/go-sql-driver/mysql v1.7.1
+	github.com/lib/pq v1.10.9
 	github.com/spf13/viper v1.15.0
 	github.com/stretchr/testify v1.8.1
 )
@@ -0,0 +1,624 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// AdminPrefix is path prefix of admin operations
+const AdminPrefix = "/admin/"
+
+// Limiter is rate limiter of admin operations
+type Limiter interface {
+	IdsByIP(ctx context.Context, protocol, method, url string, strIP string) []string
+	IdsByPrefix(prefix string) ([]string, error)
+	RuleIDs() []string
+	ClearByIDs(ctx context.Context, ids []string) error
+	Inspect(ctx context.Context, ids []string) ([]ratelimit.RuleState, error)
+	Blocked(ctx context.Context, cursor string, count int) ([]ratelimit.RuleState, string, error)
+}
+
+// Banlist is list of bans of admin operations
+type Banlist interface {
+	Ban(ctx context.Context, target string, d time.Duration, reason string) (ratelimit.Ban, error)
+	Unban(ctx context.Context, target string) error
+	List(ctx context.Context) ([]ratelimit.Ban, error)
+}
+
+// OverridesTable is table of overrides of admin operations
+type OverridesTable interface {
+	Set(ctx context.Context, override ratelimit.Override) (ratelimit.Override, error)
+	Delete(ctx context.Context, id string) error
+	List(ctx context.Context) ([]ratelimit.Override, error)
+}
+
+// Admin is http handler of admin operations on limits storage
+type Admin struct {
+	storage   ratelimit.Storager
+	limiter   Limiter
+	bans      Banlist
+	overrides OverridesTable
+	auth      []Authenticator
+	auditor   Auditor
+	clock     clock.Clock
+}
+
+// AdminOption of Admin
+type AdminOption func(*Admin)
+
+// WithLimiter set limiter of reset operation, without it reset is not supported
+func WithLimiter(l Limiter) AdminOption {
+	return func(a *Admin) {
+		a.limiter = l
+	}
+}
+
+// WithBanlist set list of bans of ban operations, without it bans are not supported
+func WithBanlist(b Banlist) AdminOption {
+	return func(a *Admin) {
+		a.bans = b
+	}
+}
+
+// WithOverrides set table of override operations, without it overrides are not supported
+func WithOverrides(o OverridesTable) AdminOption {
+	return func(a *Admin) {
+		a.overrides = o
+	}
+}
+
+// WithAuth require every request to be authenticated by one of authenticators, they are tried in order.
+// Without authenticators every request is rejected, so export and import are never open.
+func WithAuth(auth ...Authenticator) AdminOption {
+	return func(a *Admin) {
+		a.auth = append(a.auth, auth...)
+	}
+}
+
+// WithToken require "Authorization: Bearer <token>" header, the token has operator role
+func WithToken(token string) AdminOption {
+	return WithAuth(NewBearerAuth(map[string]Principal{token: {Name: "token", Role: RoleOperator}}))
+}
+
+// WithAuditor set auditor of mutating calls, by default entries are written to log
+func WithAuditor(auditor Auditor) AdminOption {
+	return func(a *Admin) {
+		a.auditor = auditor
+	}
+}
+
+// WithAdminClock set clock of audit entries
+func WithAdminClock(c clock.Clock) AdminOption {
+	return func(a *Admin) {
+		a.clock = c
+	}
+}
+
+// NewAdmin admin handler of storage
+func NewAdmin(s ratelimit.Storager, opts ...AdminOption) *Admin {
+	a := &Admin{storage: s, auditor: logAuditor{}, clock: clock.Real}
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	return a
+}
+
+// Handler of admin operations under AdminPrefix
+func (a *Admin) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(AdminPrefix+"export", a.Export)
+	mux.HandleFunc(AdminPrefix+"import", a.Import)
+	mux.HandleFunc(AdminPrefix+"reset", a.Reset)
+	mux.HandleFunc(AdminPrefix+"inspect", a.Inspect)
+	mux.HandleFunc(AdminPrefix+"blocked", a.Blocked)
+	mux.HandleFunc(AdminPrefix+"bans", a.Bans)
+	mux.HandleFunc(AdminPrefix+"overrides", a.Overrides)
+
+	return a.authorize(mux)
+}
+
+// authorize reject requests that are not authenticated or not allowed to the role, mutating calls are audited
+func (a *Admin) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !mutating(r.Method) {
+			a.serve(next, w, r)
+			return
+		}
+
+		body := &bodyRecorder{ReadCloser: http.NoBody}
+		if r.Body != nil {
+			body.ReadCloser = r.Body
+		}
+		r.Body = body
+		rec := &statusRecorder{ResponseWriter: w}
+
+		p := a.serve(next, rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		a.auditor.Audit(AuditEntry{
+			Time:      a.clock.Now(),
+			Principal: p.Name,
+			Role:      p.Role,
+			Auth:      p.Method,
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Query:     r.URL.RawQuery,
+			Params:    body.params(),
+			Remote:    r.RemoteAddr,
+			Status:    rec.status,
+		})
+	})
+}
+
+// serve authenticate request and call next if the principal is allowed
+func (a *Admin) serve(next http.Handler, w http.ResponseWriter, r *http.Request) Principal {
+	p, ok := a.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return p
+	}
+	if !p.Role.allows(r.Method) {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "role " + string(p.Role) + " is not allowed to " + r.Method})
+		return p
+	}
+
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	return p
+}
+
+// authenticate request by authenticators of the admin
+func (a *Admin) authenticate(r *http.Request) (Principal, bool) {
+	for _, auth := range a.auth {
+		if p, ok := auth.Authenticate(r); ok {
+			return p, true
+		}
+	}
+
+	return Principal{}, false
+}
+
+// Export write dump of all keys. Query param format is json (default) or binary.
+func (a *Admin) Export(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	format, err := ratelimit.ParseDumpFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if _, ok := a.storage.(ratelimit.Scanner); !ok {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": ratelimit.ErrNotSupported.Error()})
+		return
+	}
+
+	contentType := "application/json"
+	if format == ratelimit.DumpBinary {
+		contentType = "application/octet-stream"
+	}
+	// headers of dump are sent with its first bytes, so error before them is answered by error status
+	dw := &dumpWriter{w: w, header: func(h http.Header) {
+		h.Set("Content-Type", contentType)
+		h.Set("Content-Disposition", "attachment; filename=\"ratelimit-dump."+string(format)+"\"")
+	}}
+
+	n, err := ratelimit.Dump(r.Context(), a.storage, dw, format)
+	if err == nil {
+		return
+	}
+	if !dw.written {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ratelimit.ErrCircuitOpen) {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	// headers are already sent, the client gets truncated dump
+	log.Printf("export of storage failed after %d keys: %s", n, err.Error())
+}
+
+// dumpWriter set headers of response on the first write
+type dumpWriter struct {
+	w       http.ResponseWriter
+	header  func(h http.Header)
+	written bool
+}
+
+func (dw *dumpWriter) Write(p []byte) (int, error) {
+	if !dw.written {
+		dw.header(dw.w.Header())
+		dw.written = true
+	}
+
+	return dw.w.Write(p)
+}
+
+// Import restore keys of dump in request body. Format of dump is detected.
+func (a *Admin) Import(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	n, err := ratelimit.Load(r.Context(), a.storage, r.Body)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, ratelimit.ErrInvalidDump) {
+			status = http.StatusBadRequest
+		}
+		writeJSON(w, status, map[string]any{"loaded": n, "error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"loaded": n})
+}
+
+// ResetRequest select limits to reset, exactly one field must be set
+type ResetRequest struct {
+	// IP reset rules matching the IP
+	IP string `json:"ip"`
+	// Prefix reset rules which masks overlap the subnet in CIDR notation
+	Prefix string `json:"prefix"`
+	// RuleID reset the rule
+	RuleID string `json:"rule_id"`
+	// All reset every rule
+	All bool `json:"all"`
+}
+
+// Reset clear counters of rules selected by ResetRequest in request body
+func (a *Admin) Reset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if a.limiter == nil {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": ratelimit.ErrNotSupported.Error()})
+		return
+	}
+
+	var req ResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "wrong params: " + err.Error()})
+		return
+	}
+
+	ids, err := a.resetIDs(r.Context(), req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if err = a.limiter.ClearByIDs(r.Context(), ids); err != nil {
+		log.Printf("reset of rules %v failed: %s", ids, err.Error())
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "reset of limits failed"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"result": "Ok", "reset": ids})
+}
+
+// resetIDs return IDs of rules selected by request
+func (a *Admin) resetIDs(ctx context.Context, req ResetRequest) ([]string, error) {
+	set := 0
+	for _, ok := range []bool{req.IP != "", req.Prefix != "", req.RuleID != "", req.All} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, errors.New("exactly one of ip, prefix, rule_id and all must be set")
+	}
+
+	switch {
+	case req.IP != "":
+		if net.ParseIP(req.IP) == nil {
+			return nil, fmt.Errorf("invalid ip %q", req.IP)
+		}
+		return a.limiter.IdsByIP(ctx, "*", "*", "*", req.IP), nil
+	case req.Prefix != "":
+		return a.limiter.IdsByPrefix(req.Prefix)
+	case req.RuleID != "":
+		for _, id := range a.limiter.RuleIDs() {
+			if id == req.RuleID {
+				return []string{id}, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown rule %q", req.RuleID)
+	}
+
+	return a.limiter.RuleIDs(), nil
+}
+
+// DefaultPageSize is count of items of page when request does not set it
+const DefaultPageSize = 100
+
+// maxPageSize is max count of items of page
+const maxPageSize = 1000
+
+// ruleJSON is rule with its live state
+type ruleJSON struct {
+	ID       string        `json:"id"`
+	Mask     string        `json:"mask"`
+	Handlers []handlerJSON `json:"handlers,omitempty"`
+	// Limit of the client with applied override
+	Limit     int64               `json:"limit"`
+	Override  *ratelimit.Override `json:"override,omitempty"`
+	BlockTime int64               `json:"block_time"`
+	Counter   int64               `json:"counter"`
+	Remaining int64               `json:"remaining"`
+	Blocked   bool                `json:"blocked"`
+	// ResetMs time until counter is reset in milliseconds
+	ResetMs int64 `json:"reset_ms"`
+}
+
+// handlerJSON is handler of rule
+type handlerJSON struct {
+	Protocol      string `json:"protocol,omitempty"`
+	Method        string `json:"method,omitempty"`
+	Url           string `json:"url"`
+	Regexp        bool   `json:"regexp,omitempty"`
+	Template      bool   `json:"template,omitempty"`
+	CaseSensitive bool   `json:"case_sensitive,omitempty"`
+}
+
+// newRuleJSON convert state of rule
+func newRuleJSON(state ratelimit.RuleState) ruleJSON {
+	res := ruleJSON{
+		ID:        state.Rule.ID,
+		Mask:      state.Rule.Mask,
+		Limit:     state.Limit,
+		Override:  state.Override,
+		BlockTime: state.Rule.BlockTime,
+		Counter:   state.Counter,
+		Remaining: state.Remaining,
+		Blocked:   state.Blocked,
+		ResetMs:   state.Reset.Milliseconds(),
+	}
+	for _, lh := range state.Rule.Handlers {
+		res.Handlers = append(res.Handlers, handlerJSON{
+			Protocol:      lh.Protocol,
+			Method:        lh.Method,
+			Url:           lh.Url,
+			Regexp:        lh.Regexp,
+			Template:      lh.Template,
+			CaseSensitive: lh.CaseSensitive,
+		})
+	}
+
+	return res
+}
+
+// Inspect write every rule matching client IP or key with its counter, remaining budget, block status and reset time.
+// Query param ip or key selects the client, optional app selects overrides of application.
+func (a *Admin) Inspect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if a.limiter == nil {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": ratelimit.ErrNotSupported.Error()})
+		return
+	}
+
+	query := r.URL.Query()
+	key := query.Get("key")
+	if ip := query.Get("ip"); ip != "" {
+		if key != "" || net.ParseIP(ip) == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "one valid ip or key must be set"})
+			return
+		}
+		key = ip
+	}
+	if key == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "one valid ip or key must be set"})
+		return
+	}
+
+	ctx := ratelimit.WithClient(r.Context(), ratelimit.Client{Key: key, App: query.Get("app")})
+	states, err := a.limiter.Inspect(ctx, a.limiter.IdsByIP(ctx, "*", "*", "*", key))
+	if err != nil {
+		log.Printf("inspect of %s failed: %s", key, err.Error())
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "inspect of limits failed"})
+		return
+	}
+
+	rules := make([]ruleJSON, 0, len(states))
+	blocked := false
+	for _, state := range states {
+		rules = append(rules, newRuleJSON(state))
+		blocked = blocked || state.Blocked
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"key": key, "blocked": blocked, "rules": rules})
+}
+
+// Blocked write page of currently blocked prefixes. Query params are cursor of the page
+// and count of items, next_cursor of response is empty on the last page.
+func (a *Admin) Blocked(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if a.limiter == nil {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": ratelimit.ErrNotSupported.Error()})
+		return
+	}
+
+	query := r.URL.Query()
+	count := DefaultPageSize
+	if raw := query.Get("count"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > maxPageSize {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("count must be from 1 to %d", maxPageSize)})
+			return
+		}
+		count = n
+	}
+
+	states, next, err := a.limiter.Blocked(r.Context(), query.Get("cursor"), count)
+	if errors.Is(err, ratelimit.ErrNotSupported) {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("list of blocked prefixes failed: %s", err.Error())
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "list of blocked prefixes failed"})
+		return
+	}
+
+	prefixes := make([]ruleJSON, 0, len(states))
+	for _, state := range states {
+		prefixes = append(prefixes, newRuleJSON(state))
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"prefixes": prefixes, "next_cursor": next})
+}
+
+// BanRequest ban target for duration
+type BanRequest struct {
+	// Target is IP, subnet in CIDR notation or client key
+	Target string `json:"target"`
+	// Duration of the ban like "30m", empty - permanent ban
+	Duration string `json:"duration"`
+	Reason   string `json:"reason"`
+}
+
+// Bans list bans on GET, ban target of BanRequest in request body on POST and lift ban of query param target on DELETE
+func (a *Admin) Bans(w http.ResponseWriter, r *http.Request) {
+	if a.bans == nil {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": ratelimit.ErrNotSupported.Error()})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		bans, err := a.bans.List(r.Context())
+		if err != nil {
+			a.writeOpError(w, "list of bans", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"bans": bans})
+	case http.MethodPost:
+		var req BanRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "wrong params: " + err.Error()})
+			return
+		}
+
+		var d time.Duration
+		if req.Duration != "" {
+			var err error
+			if d, err = time.ParseDuration(req.Duration); err != nil || d <= 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid duration %q", req.Duration)})
+				return
+			}
+		}
+
+		ban, err := a.bans.Ban(r.Context(), req.Target, d, req.Reason)
+		if err != nil {
+			a.writeOpError(w, "ban", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, ban)
+	case http.MethodDelete:
+		target := r.URL.Query().Get("target")
+		if err := a.bans.Unban(r.Context(), target); err != nil {
+			a.writeOpError(w, "unban", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"result": "Ok"})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// OverrideRequest set override. Duration like "48h" sets expires_at from now.
+type OverrideRequest struct {
+	ratelimit.Override
+	Duration string `json:"duration"`
+}
+
+// Overrides list overrides on GET, set override of OverrideRequest in request body on POST
+// and delete override by query param id on DELETE
+func (a *Admin) Overrides(w http.ResponseWriter, r *http.Request) {
+	if a.overrides == nil {
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": ratelimit.ErrNotSupported.Error()})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		list, err := a.overrides.List(r.Context())
+		if err != nil {
+			a.writeOpError(w, "list of overrides", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"overrides": list})
+	case http.MethodPost:
+		var req OverrideRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "wrong params: " + err.Error()})
+			return
+		}
+		if req.Duration != "" {
+			d, err := time.ParseDuration(req.Duration)
+			if err != nil || d <= 0 || !req.ExpiresAt.IsZero() {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid duration %q, it can't be set with expires_at", req.Duration)})
+				return
+			}
+			req.ExpiresAt = a.clock.Now().Add(d)
+		}
+
+		override, err := a.overrides.Set(r.Context(), req.Override)
+		if err != nil {
+			a.writeOpError(w, "override", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"id": override.ID(), "override": override})
+	case http.MethodDelete:
+		if err := a.overrides.Delete(r.Context(), r.URL.Query().Get("id")); err != nil {
+			a.writeOpError(w, "delete of override", err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"result": "Ok"})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// writeOpError write error of ban or override operation
+func (a *Admin) writeOpError(w http.ResponseWriter, op string, err error) {
+	switch {
+	case errors.Is(err, ratelimit.ErrInvalidBanTarget), errors.Is(err, ratelimit.ErrInvalidOverride):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, ratelimit.ErrNotSupported):
+		writeJSON(w, http.StatusNotImplemented, map[string]string{"error": err.Error()})
+	default:
+		log.Printf("%s failed: %s", op, err.Error())
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": op + " failed"})
+	}
+}
+
+// writeJSON write value as json response with status
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("write response: %s", err.Error())
+	}
+}
@@ -0,0 +1,367 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/ratelimittest"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testToken is bearer token of admin handlers of tests
+const testToken = "test"
+
+// newTestAdmin return admin handler that authenticates every request as operator
+func newTestAdmin(s ratelimit.Storager, opts ...AdminOption) http.Handler {
+	h := NewAdmin(s, append(opts, WithToken(testToken))...).Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("Authorization", "Bearer "+testToken)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// TestAdmin_NoAuth test admin without authenticators rejects every request
+func TestAdmin_NoAuth(t *testing.T) {
+	h := NewAdmin(storage.NewMemoryCache()).Handler()
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodGet, "/admin/export", nil),
+		httptest.NewRequest(http.MethodPost, "/admin/import", strings.NewReader(`[]`)),
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code, req.URL.Path)
+	}
+}
+
+// TestAdmin_Export test Export and Import functions
+func TestAdmin_Export(t *testing.T) {
+	ctx := context.Background()
+	ttl := uint64(10)
+
+	src := storage.NewMemoryCache()
+	_, _ = src.Inc(ctx, []byte("rule"), &ttl)
+	h := newTestAdmin(src)
+
+	for _, format := range []string{"json", "binary"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/export?format="+format, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		dst := storage.NewMemoryCache()
+		imp := httptest.NewRecorder()
+		newTestAdmin(dst).ServeHTTP(imp, httptest.NewRequest(http.MethodPost, "/admin/import", bytes.NewReader(rec.Body.Bytes())))
+		assert.Equal(t, http.StatusOK, imp.Code)
+		assert.JSONEq(t, `{"loaded":1}`, imp.Body.String())
+
+		value, err := dst.Get(ctx, []byte("rule"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("1"), value)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/export?format=xml", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/import", strings.NewReader("{")))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/export", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+// failedScanner is MemoryCache that fails to scan
+type failedScanner struct {
+	*storage.MemoryCache
+}
+
+func (failedScanner) Scan(context.Context, []byte, string, int) ([]storage.KeyInfo, string, error) {
+	return nil, "", errors.New("scan failed")
+}
+
+// TestAdmin_ExportError test error of storage before the first key of dump is answered by error status
+func TestAdmin_ExportError(t *testing.T) {
+	h := newTestAdmin(ratelimit.NewCircuitBreaker(failedScanner{storage.NewMemoryCache()}, 1, time.Hour))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/export", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.JSONEq(t, `{"error":"scan failed"}`, rec.Body.String())
+	assert.Empty(t, rec.Header().Get("Content-Disposition"))
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/export?format=binary", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.JSONEq(t, `{"error":"`+ratelimit.ErrCircuitOpen.Error()+`"}`, rec.Body.String())
+}
+
+// TestAdmin_Reset test Reset function
+func TestAdmin_Reset(t *testing.T) {
+	ctx := context.Background()
+	cfg := ratelimittest.NewConfig().
+		Rule("a", "10.0.0.0/24", 1, 60).AnyHandler().
+		Rule("b", "10.0.1.0/24", 1, 60).AnyHandler().
+		Build()
+	store := storage.NewMemoryCache()
+	rl := ratelimit.NewRateLimit(&cfg, store)
+	h := newTestAdmin(store, WithLimiter(rl))
+
+	reset := func(body string) *httptest.ResponseRecorder {
+		rl.IncByIDs(ctx, []string{"a"})
+		rl.IncByIDs(ctx, []string{"b"})
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/reset", strings.NewReader(body)))
+		return rec
+	}
+
+	cases := []struct {
+		body    string
+		code    int
+		limited []bool
+	}{
+		{body: `{"ip": "10.0.0.7"}`, code: http.StatusOK, limited: []bool{false, true}},
+		{body: `{"prefix": "10.0.1.128/25"}`, code: http.StatusOK, limited: []bool{true, false}},
+		{body: `{"rule_id": "a"}`, code: http.StatusOK, limited: []bool{false, true}},
+		{body: `{"all": true}`, code: http.StatusOK, limited: []bool{false, false}},
+		{body: `{"rule_id": "c"}`, code: http.StatusBadRequest},
+		{body: `{"ip": "10.0.0.7", "all": true}`, code: http.StatusBadRequest},
+		{body: `{"ip": "nope"}`, code: http.StatusBadRequest},
+		{body: `{"prefix": "10.0.0.1"}`, code: http.StatusBadRequest},
+		{body: `{}`, code: http.StatusBadRequest},
+		{body: `{`, code: http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		assert.NoError(t, rl.ClearByIDs(ctx, []string{"a", "b"}))
+
+		rec := reset(c.body)
+		assert.Equal(t, c.code, rec.Code, c.body)
+		if c.limited != nil {
+			assert.Equal(t, c.limited[0], rl.IsLimitedByIDs(ctx, []string{"a"}), c.body)
+			assert.Equal(t, c.limited[1], rl.IsLimitedByIDs(ctx, []string{"b"}), c.body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	newTestAdmin(store).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/reset", strings.NewReader(`{"all": true}`)))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/reset", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+// TestAdmin_Token test WithToken function
+func TestAdmin_Token(t *testing.T) {
+	h := NewAdmin(storage.NewMemoryCache(), WithToken("secret")).Handler()
+
+	for auth, code := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"secret":        http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Bearer secret": http.StatusOK,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/admin/export", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, code, rec.Code, auth)
+	}
+}
+
+// TestAdmin_Inspect test Inspect and Blocked functions
+func TestAdmin_Inspect(t *testing.T) {
+	ctx := context.Background()
+	cfg := ratelimittest.NewConfig().
+		Rule("a", "10.0.0.0/24", 2, 60).Handler("GET", "/run").
+		Rule("b", "10.0.0.0/16", 5, 60).AnyHandler().
+		Rule("c", "10.1.0.0/16", 1, 60).AnyHandler().
+		Build()
+	store := storage.NewMemoryCache(storage.WithClock(clock.NewManual(time.Unix(0, 0))))
+	rl := ratelimit.NewRateLimit(&cfg, store)
+	h := newTestAdmin(store, WithLimiter(rl))
+
+	rl.IncByIDs(ctx, []string{"a"})
+	rl.IncByIDs(ctx, []string{"a"})
+	rl.IncByIDs(ctx, []string{"b"})
+	rl.IncByIDs(ctx, []string{"c"})
+
+	get := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec
+	}
+
+	rec := get("/admin/inspect?ip=10.0.0.7")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var inspected struct {
+		Key     string     `json:"key"`
+		Blocked bool       `json:"blocked"`
+		Rules   []ruleJSON `json:"rules"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &inspected))
+	assert.Equal(t, "10.0.0.7", inspected.Key)
+	assert.True(t, inspected.Blocked)
+	assert.Len(t, inspected.Rules, 2)
+	assert.Equal(t, "a", inspected.Rules[0].ID)
+	assert.Equal(t, []handlerJSON{{Method: "GET", Url: "/run"}}, inspected.Rules[0].Handlers)
+	assert.Equal(t, int64(2), inspected.Rules[0].Counter)
+	assert.Equal(t, int64(0), inspected.Rules[0].Remaining)
+	assert.True(t, inspected.Rules[0].Blocked)
+	assert.Equal(t, int64(60000), inspected.Rules[0].ResetMs)
+	assert.Equal(t, "b", inspected.Rules[1].ID)
+	assert.Equal(t, int64(4), inspected.Rules[1].Remaining)
+	assert.False(t, inspected.Rules[1].Blocked)
+
+	rec = get("/admin/inspect?key=10.2.0.1")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"key":"10.2.0.1","blocked":false,"rules":[]}`, rec.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, get("/admin/inspect").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/admin/inspect?ip=nope").Code)
+
+	var blocked []string
+	cursor := ""
+	for i := 0; i < 10; i++ {
+		rec = get("/admin/blocked?count=1&cursor=" + cursor)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var page struct {
+			Prefixes   []ruleJSON `json:"prefixes"`
+			NextCursor string     `json:"next_cursor"`
+		}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+		for _, p := range page.Prefixes {
+			blocked = append(blocked, p.Mask)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	assert.ElementsMatch(t, []string{"10.0.0.0/24", "10.1.0.0/16"}, blocked)
+
+	assert.Equal(t, http.StatusBadRequest, get("/admin/blocked?count=0").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/admin/blocked?count=x").Code)
+}
+
+// TestAdmin_Bans test Bans function
+func TestAdmin_Bans(t *testing.T) {
+	store := storage.NewMemoryCache()
+	bans := ratelimit.NewBanlist(store)
+	h := newTestAdmin(store, WithBanlist(bans))
+
+	call := func(method, url, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, url, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := call(http.MethodPost, "/admin/bans", `{"target": "10.0.0.7/24", "duration": "30m", "reason": "abuse"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var ban ratelimit.Ban
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ban))
+	assert.Equal(t, "10.0.0.0/24", ban.Target)
+	assert.NotNil(t, ban.ExpiresAt)
+
+	assert.Equal(t, http.StatusOK, call(http.MethodPost, "/admin/bans", `{"target": "customer-42"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, "/admin/bans", `{"target": ""}`).Code)
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, "/admin/bans", `{"target": "10.0.0.1", "duration": "-1m"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, "/admin/bans", `{`).Code)
+
+	_, banned := bans.Banned("10.0.0.1")
+	assert.True(t, banned)
+
+	rec = call(http.MethodGet, "/admin/bans", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var list struct {
+		Bans []ratelimit.Ban `json:"bans"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	assert.Len(t, list.Bans, 2)
+
+	assert.Equal(t, http.StatusOK, call(http.MethodDelete, "/admin/bans?target=10.0.0.0/24", "").Code)
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodDelete, "/admin/bans", "").Code)
+	_, banned = bans.Banned("10.0.0.1")
+	assert.False(t, banned)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, call(http.MethodPut, "/admin/bans", "").Code)
+
+	rec = httptest.NewRecorder()
+	newTestAdmin(store).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/bans", nil))
+	assert.Equal(t, http.StatusNotImplemented, rec.Code)
+}
+
+// TestAdmin_Overrides test Overrides function and overrides in Inspect
+func TestAdmin_Overrides(t *testing.T) {
+	clk := clock.NewManual(time.Unix(1700000000, 0))
+	cfg := ratelimittest.NewConfig().Rule("a", "10.0.0.0/24", 2, 60).AnyHandler().Build()
+	store := storage.NewMemoryCache(storage.WithClock(clk))
+	overrides := ratelimit.NewOverrides(store, ratelimit.WithOverridesClock(clk))
+	rl := ratelimit.NewRateLimit(&cfg, store, ratelimit.WithOverrides(overrides))
+	h := newTestAdmin(store, WithLimiter(rl), WithOverrides(overrides), WithAdminClock(clk))
+
+	call := func(method, url, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, url, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := call(http.MethodPost, "/admin/overrides", `{"target": "10.0.0.7", "multiplier": 10, "duration": "48h", "reason": "migration"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var set struct {
+		ID       string             `json:"id"`
+		Override ratelimit.Override `json:"override"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &set))
+	assert.Equal(t, "10.0.0.7", set.ID)
+	assert.True(t, clk.Now().Add(48*time.Hour).Equal(set.Override.ExpiresAt))
+
+	rec = call(http.MethodPost, "/admin/overrides", `{"app": "partner", "rule_id": "a", "limit": 100, "expires_at": "2030-01-01T00:00:00Z"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, "/admin/overrides", `{"target": "10.0.0.7", "limit": 5}`).Code)
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, "/admin/overrides", `{"target": "10.0.0.7", "limit": 5, "duration": "x"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodPost, "/admin/overrides", `{"target": "10.0.0.7", "limit": 5, "multiplier": 2, "duration": "1h"}`).Code)
+
+	rec = call(http.MethodGet, "/admin/overrides", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var list struct {
+		Overrides []ratelimit.Override `json:"overrides"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	assert.Len(t, list.Overrides, 2)
+
+	var inspected struct {
+		Rules []ruleJSON `json:"rules"`
+	}
+	rec = call(http.MethodGet, "/admin/inspect?ip=10.0.0.7", "")
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &inspected))
+	assert.Equal(t, int64(20), inspected.Rules[0].Limit)
+	assert.Equal(t, "migration", inspected.Rules[0].Override.Reason)
+
+	inspected.Rules = nil
+	rec = call(http.MethodGet, "/admin/inspect?ip=10.0.0.8&app=partner", "")
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &inspected))
+	assert.Equal(t, int64(100), inspected.Rules[0].Limit)
+
+	assert.Equal(t, http.StatusOK, call(http.MethodDelete, "/admin/overrides?id=10.0.0.7", "").Code)
+	assert.Equal(t, http.StatusBadRequest, call(http.MethodDelete, "/admin/overrides", "").Code)
+
+	inspected.Rules = nil
+	rec = call(http.MethodGet, "/admin/inspect?ip=10.0.0.7", "")
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &inspected))
+	assert.Equal(t, int64(2), inspected.Rules[0].Limit)
+	assert.Nil(t, inspected.Rules[0].Override)
+}
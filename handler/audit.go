@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxAuditParams is max size of request body kept in audit entry
+const maxAuditParams = 1024
+
+// AuditEntry is record of mutating admin call
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Principal is name of the caller, empty if authentication failed
+	Principal string `json:"principal"`
+	Role      Role   `json:"role,omitempty"`
+	Auth      string `json:"auth,omitempty"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Query     string `json:"query,omitempty"`
+	// Params is json body of request, empty if the body is not json or too large
+	Params json.RawMessage `json:"params,omitempty"`
+	Remote string          `json:"remote"`
+	Status int             `json:"status"`
+}
+
+// Auditor record audit entries
+type Auditor interface {
+	Audit(entry AuditEntry)
+}
+
+// AuditorFunc is function Auditor
+type AuditorFunc func(entry AuditEntry)
+
+// Audit call f
+func (f AuditorFunc) Audit(entry AuditEntry) {
+	f(entry)
+}
+
+// JSONAuditor write audit entries as json lines
+type JSONAuditor struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONAuditor create auditor writing to w
+func NewJSONAuditor(w io.Writer) *JSONAuditor {
+	return &JSONAuditor{w: w}
+}
+
+// Audit write entry as json line
+func (a *JSONAuditor) Audit(entry AuditEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("admin audit: %s", err.Error())
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, err = a.w.Write(append(line, '\n')); err != nil {
+		log.Printf("admin audit: %s", err.Error())
+	}
+}
+
+// logAuditor write audit entries to log, it is default auditor
+type logAuditor struct{}
+
+func (logAuditor) Audit(entry AuditEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("admin audit: %s", err.Error())
+		return
+	}
+
+	log.Printf("admin audit: %s", line)
+}
+
+// statusRecorder remember status of response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	return r.ResponseWriter.Write(b)
+}
+
+// bodyRecorder keep the first maxAuditParams bytes of body read by handler
+type bodyRecorder struct {
+	io.ReadCloser
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (r *bodyRecorder) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if left := maxAuditParams - r.buf.Len(); left > 0 {
+		if n <= left {
+			r.buf.Write(p[:n])
+		} else {
+			r.buf.Write(p[:left])
+			r.truncated = true
+		}
+	} else if n > 0 {
+		r.truncated = true
+	}
+
+	return n, err
+}
+
+// params return recorded body if it is complete json
+func (r *bodyRecorder) params() json.RawMessage {
+	if r.truncated || r.buf.Len() == 0 || !json.Valid(r.buf.Bytes()) {
+		return nil
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, r.buf.Bytes()); err != nil {
+		return nil
+	}
+
+	return compact.Bytes()
+}
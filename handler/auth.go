@@ -0,0 +1,296 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Role of admin principal
+type Role string
+
+const (
+	// RoleReadOnly can call read operations: GET and HEAD requests
+	RoleReadOnly Role = "read-only"
+	// RoleOperator can call every operation
+	RoleOperator Role = "operator"
+)
+
+// ParseRole parse role by name
+func ParseRole(name string) (Role, error) {
+	switch Role(name) {
+	case RoleReadOnly, RoleOperator:
+		return Role(name), nil
+	}
+
+	return "", fmt.Errorf("unknown admin role %q", name)
+}
+
+// allows check can role call request with method
+func (r Role) allows(method string) bool {
+	switch r {
+	case RoleOperator:
+		return true
+	case RoleReadOnly:
+		return !mutating(method)
+	}
+
+	return false
+}
+
+// Principal is authenticated caller of admin API
+type Principal struct {
+	Name string
+	Role Role
+	// Method of authentication: bearer, hmac or mtls
+	Method string
+}
+
+// Authenticator authenticate admin request. False if request has no credentials of the authenticator or they are wrong.
+type Authenticator interface {
+	Authenticate(r *http.Request) (Principal, bool)
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext return principal of admin request
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	return p, ok
+}
+
+// BearerAuth authenticate by "Authorization: Bearer <token>" header
+type BearerAuth struct {
+	tokens map[string]Principal
+}
+
+// NewBearerAuth create authenticator of principals by their tokens
+func NewBearerAuth(tokens map[string]Principal) *BearerAuth {
+	a := &BearerAuth{tokens: make(map[string]Principal, len(tokens))}
+	for token, p := range tokens {
+		p.Method = "bearer"
+		a.tokens[token] = p
+	}
+
+	return a
+}
+
+// Authenticate request by token. Every token is compared, so time does not depend on which one matches.
+func (a *BearerAuth) Authenticate(r *http.Request) (Principal, bool) {
+	auth := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(auth, "Bearer ")
+	if token == auth || token == "" {
+		return Principal{}, false
+	}
+
+	var res Principal
+	found := false
+	for t, p := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			res, found = p, true
+		}
+	}
+
+	return res, found
+}
+
+const (
+	// HMACKeyHeader is header of key ID of signed request
+	HMACKeyHeader = "X-Admin-Key"
+	// HMACTimestampHeader is header of unix time of signed request
+	HMACTimestampHeader = "X-Admin-Timestamp"
+	// HMACSignatureHeader is header of hex HMAC-SHA256 signature of request
+	HMACSignatureHeader = "X-Admin-Signature"
+	// DefaultHMACSkew is allowed difference of request timestamp and server time
+	DefaultHMACSkew = 5 * time.Minute
+	// DefaultHMACMaxBody is max size of body of signed request, body is read before the signature is checked
+	DefaultHMACMaxBody = 32 << 20
+)
+
+// HMACKey is shared secret of principal
+type HMACKey struct {
+	Secret    string
+	Principal Principal
+}
+
+// HMACAuth authenticate requests signed by shared secrets. See Sign for the signature.
+// Signature of mutating request is accepted once by the instance: its replay within the skew
+// window is rejected, so identical mutating requests must be signed with different timestamps.
+type HMACAuth struct {
+	keys    map[string]HMACKey
+	skew    time.Duration
+	maxBody int64
+	clock   clock.Clock
+
+	mu sync.Mutex
+	// seen signatures of mutating requests with time they expire at
+	seen      map[string]time.Time
+	nextPrune time.Time
+}
+
+// HMACOption of HMACAuth
+type HMACOption func(*HMACAuth)
+
+// WithHMACSkew set allowed difference of request timestamp and server time
+func WithHMACSkew(skew time.Duration) HMACOption {
+	return func(a *HMACAuth) {
+		a.skew = skew
+	}
+}
+
+// WithHMACMaxBody set max size of body of signed request, larger requests are rejected
+func WithHMACMaxBody(n int64) HMACOption {
+	return func(a *HMACAuth) {
+		a.maxBody = n
+	}
+}
+
+// WithHMACClock set clock of timestamp checks
+func WithHMACClock(c clock.Clock) HMACOption {
+	return func(a *HMACAuth) {
+		a.clock = c
+	}
+}
+
+// NewHMACAuth create authenticator of principals by key IDs
+func NewHMACAuth(keys map[string]HMACKey, opts ...HMACOption) *HMACAuth {
+	a := &HMACAuth{
+		keys:    make(map[string]HMACKey, len(keys)),
+		skew:    DefaultHMACSkew,
+		maxBody: DefaultHMACMaxBody,
+		clock:   clock.Real,
+		seen:    make(map[string]time.Time),
+	}
+	for id, key := range keys {
+		key.Principal.Method = "hmac"
+		a.keys[id] = key
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	return a
+}
+
+// Authenticate request by signature. Body up to max size is read and replaced by its copy.
+func (a *HMACAuth) Authenticate(r *http.Request) (Principal, bool) {
+	key, ok := a.keys[r.Header.Get(HMACKeyHeader)]
+	if !ok {
+		return Principal{}, false
+	}
+
+	ts, err := strconv.ParseInt(r.Header.Get(HMACTimestampHeader), 10, 64)
+	if err != nil {
+		return Principal{}, false
+	}
+	if d := a.clock.Now().Sub(time.Unix(ts, 0)); d > a.skew || d < -a.skew {
+		return Principal{}, false
+	}
+
+	signature, err := hex.DecodeString(r.Header.Get(HMACSignatureHeader))
+	if err != nil {
+		return Principal{}, false
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(io.LimitReader(r.Body, a.maxBody+1))
+		if err != nil || int64(len(body)) > a.maxBody {
+			return Principal{}, false
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	if !hmac.Equal(signature, signature256(key.Secret, r.Method, r.URL.RequestURI(), ts, body)) {
+		return Principal{}, false
+	}
+	if mutating(r.Method) && !a.once(signature, time.Unix(ts, 0).Add(a.skew)) {
+		return Principal{}, false
+	}
+
+	return key.Principal, true
+}
+
+// once remember signature until expireAt, false if it is already seen
+func (a *HMACAuth) once(signature []byte, expireAt time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.clock.Now()
+	if now.After(a.nextPrune) {
+		for sig, exp := range a.seen {
+			if !exp.After(now) {
+				delete(a.seen, sig)
+			}
+		}
+		a.nextPrune = now.Add(a.skew)
+	}
+
+	if exp, ok := a.seen[string(signature)]; ok && exp.After(now) {
+		return false
+	}
+	a.seen[string(signature)] = expireAt
+
+	return true
+}
+
+// Sign set HMAC headers of request by key. Signature is HMAC-SHA256 of
+// method, request URI, unix timestamp and hex SHA256 of body joined by new lines.
+func Sign(r *http.Request, keyID, secret string, body []byte, now time.Time) {
+	ts := now.Unix()
+	r.Header.Set(HMACKeyHeader, keyID)
+	r.Header.Set(HMACTimestampHeader, strconv.FormatInt(ts, 10))
+	r.Header.Set(HMACSignatureHeader, hex.EncodeToString(signature256(secret, r.Method, r.URL.RequestURI(), ts, body)))
+}
+
+// signature256 return HMAC-SHA256 signature of request
+func signature256(secret, method, uri string, ts int64, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + uri + "\n" + strconv.FormatInt(ts, 10) + "\n" + hex.EncodeToString(bodyHash[:])))
+
+	return mac.Sum(nil)
+}
+
+// CertAuth authenticate by verified TLS client certificate. Server must verify client certificates by its CA.
+type CertAuth struct {
+	names map[string]Principal
+}
+
+// NewCertAuth create authenticator of principals by common names of their certificates
+func NewCertAuth(names map[string]Principal) *CertAuth {
+	a := &CertAuth{names: make(map[string]Principal, len(names))}
+	for name, p := range names {
+		p.Method = "mtls"
+		a.names[name] = p
+	}
+
+	return a
+}
+
+// Authenticate request by common name of the verified client certificate
+func (a *CertAuth) Authenticate(r *http.Request) (Principal, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return Principal{}, false
+	}
+
+	p, ok := a.names[r.TLS.VerifiedChains[0][0].Subject.CommonName]
+	return p, ok
+}
+
+// mutating check is method of mutating call
+func mutating(method string) bool {
+	return method != http.MethodGet && method != http.MethodHead
+}
@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/ratelimittest"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBearerAuth test roles of bearer tokens
+func TestBearerAuth(t *testing.T) {
+	h := NewAdmin(storage.NewMemoryCache(), WithAuth(NewBearerAuth(map[string]Principal{
+		"ro": {Name: "viewer", Role: RoleReadOnly},
+		"op": {Name: "ops", Role: RoleOperator},
+	})), WithAuditor(AuditorFunc(func(AuditEntry) {}))).Handler()
+
+	cases := []struct {
+		token  string
+		method string
+		path   string
+		code   int
+	}{
+		{token: "", method: http.MethodGet, path: "/admin/export", code: http.StatusUnauthorized},
+		{token: "ro", method: http.MethodGet, path: "/admin/export", code: http.StatusOK},
+		{token: "ro", method: http.MethodPost, path: "/admin/import", code: http.StatusForbidden},
+		{token: "op", method: http.MethodPost, path: "/admin/import", code: http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, strings.NewReader(""))
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, c.code, rec.Code, c.token+" "+c.method+" "+c.path)
+	}
+}
+
+// TestHMACAuth test Authenticate function of HMACAuth
+func TestHMACAuth(t *testing.T) {
+	clk := clock.NewManual(time.Unix(1700000000, 0))
+	auth := NewHMACAuth(map[string]HMACKey{
+		"ci": {Secret: "s3cret", Principal: Principal{Name: "ci", Role: RoleOperator}},
+	}, WithHMACClock(clk), WithHMACSkew(time.Minute))
+
+	body := []byte(`{"all": true}`)
+	newReq := func() *http.Request {
+		return httptest.NewRequest(http.MethodPost, "/admin/reset?x=1", bytes.NewReader(body))
+	}
+
+	req := newReq()
+	Sign(req, "ci", "s3cret", body, clk.Now())
+	p, ok := auth.Authenticate(req)
+	assert.True(t, ok)
+	assert.Equal(t, Principal{Name: "ci", Role: RoleOperator, Method: "hmac"}, p)
+
+	// body is still readable by handler
+	var decoded map[string]bool
+	assert.NoError(t, json.NewDecoder(req.Body).Decode(&decoded))
+	assert.True(t, decoded["all"])
+
+	req = newReq()
+	Sign(req, "ci", "wrong", body, clk.Now())
+	_, ok = auth.Authenticate(req)
+	assert.False(t, ok)
+
+	req = newReq()
+	Sign(req, "ci", "s3cret", []byte(`{"ip": "10.0.0.1"}`), clk.Now())
+	_, ok = auth.Authenticate(req)
+	assert.False(t, ok, "signature of other body")
+
+	req = newReq()
+	Sign(req, "other", "s3cret", body, clk.Now())
+	_, ok = auth.Authenticate(req)
+	assert.False(t, ok, "unknown key")
+
+	req = newReq()
+	Sign(req, "ci", "s3cret", body, clk.Now())
+	clk.Advance(2 * time.Minute)
+	_, ok = auth.Authenticate(req)
+	assert.False(t, ok, "expired timestamp")
+}
+
+// TestHMACAuth_MaxBody test body larger than max size is rejected
+func TestHMACAuth_MaxBody(t *testing.T) {
+	clk := clock.NewManual(time.Unix(1700000000, 0))
+	auth := NewHMACAuth(map[string]HMACKey{
+		"ci": {Secret: "s3cret", Principal: Principal{Name: "ci", Role: RoleOperator}},
+	}, WithHMACClock(clk), WithHMACMaxBody(4))
+	assert.Equal(t, int64(DefaultHMACMaxBody), NewHMACAuth(nil).maxBody)
+
+	for body, want := range map[string]bool{"1234": true, "12345": false} {
+		req := httptest.NewRequest(http.MethodPost, "/admin/import", strings.NewReader(body))
+		Sign(req, "ci", "s3cret", []byte(body), clk.Now())
+		_, ok := auth.Authenticate(req)
+		assert.Equal(t, want, ok, body)
+	}
+}
+
+// TestHMACAuth_Replay test signed mutating request is accepted once within skew window
+func TestHMACAuth_Replay(t *testing.T) {
+	clk := clock.NewManual(time.Unix(1700000000, 0))
+	auth := NewHMACAuth(map[string]HMACKey{
+		"ci": {Secret: "s3cret", Principal: Principal{Name: "ci", Role: RoleOperator}},
+	}, WithHMACClock(clk), WithHMACSkew(time.Minute))
+
+	send := func(method string, ts time.Time) bool {
+		req := httptest.NewRequest(method, "/admin/reset", nil)
+		Sign(req, "ci", "s3cret", nil, ts)
+		_, ok := auth.Authenticate(req)
+		return ok
+	}
+
+	signedAt := clk.Now()
+	assert.True(t, send(http.MethodPost, signedAt))
+	clk.Advance(30 * time.Second)
+	assert.False(t, send(http.MethodPost, signedAt), "replay")
+	assert.True(t, send(http.MethodPost, clk.Now()), "new timestamp")
+
+	assert.True(t, send(http.MethodGet, clk.Now()))
+	assert.True(t, send(http.MethodGet, clk.Now()), "read requests are not checked")
+
+	// expired signatures are forgotten
+	clk.Advance(2 * time.Minute)
+	assert.False(t, send(http.MethodPost, signedAt))
+	assert.True(t, send(http.MethodPost, clk.Now()))
+	assert.Len(t, auth.seen, 1)
+}
+
+// TestCertAuth test Authenticate function of CertAuth
+func TestCertAuth(t *testing.T) {
+	auth := NewCertAuth(map[string]Principal{"ops.example.com": {Name: "ops", Role: RoleOperator}})
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/export", nil)
+	_, ok := auth.Authenticate(req)
+	assert.False(t, ok, "plain http")
+
+	req.TLS = &tls.ConnectionState{}
+	_, ok = auth.Authenticate(req)
+	assert.False(t, ok, "not verified certificate")
+
+	req.TLS.VerifiedChains = [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "ops.example.com"}}}}
+	p, ok := auth.Authenticate(req)
+	assert.True(t, ok)
+	assert.Equal(t, Principal{Name: "ops", Role: RoleOperator, Method: "mtls"}, p)
+
+	req.TLS.VerifiedChains = [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "dev.example.com"}}}}
+	_, ok = auth.Authenticate(req)
+	assert.False(t, ok, "unknown name")
+}
+
+// TestAdmin_Audit test audit entries of mutating calls
+func TestAdmin_Audit(t *testing.T) {
+	clk := clock.NewManual(time.Unix(1700000000, 0))
+	cfg := ratelimittest.NewConfig().Rule("a", "10.0.0.0/24", 1, 60).AnyHandler().Build()
+	store := storage.NewMemoryCache()
+
+	var buf bytes.Buffer
+	h := NewAdmin(store,
+		WithLimiter(ratelimit.NewRateLimit(&cfg, store)),
+		WithAuth(NewBearerAuth(map[string]Principal{
+			"ro": {Name: "viewer", Role: RoleReadOnly},
+			"op": {Name: "ops", Role: RoleOperator},
+		})),
+		WithAuditor(NewJSONAuditor(&buf)),
+		WithAdminClock(clk),
+	).Handler()
+
+	call := func(method, path, token, body string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	call(http.MethodGet, "/admin/export", "ro", "")
+	call(http.MethodPost, "/admin/reset", "op", `{ "rule_id": "a" }`)
+	call(http.MethodPost, "/admin/reset", "ro", `{"all": true}`)
+	call(http.MethodPost, "/admin/reset", "nope", `{"all": true}`)
+
+	var entries []AuditEntry
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var e AuditEntry
+		assert.NoError(t, dec.Decode(&e))
+		entries = append(entries, e)
+	}
+
+	assert.Len(t, entries, 3)
+	assert.Equal(t, "ops", entries[0].Principal)
+	assert.Equal(t, RoleOperator, entries[0].Role)
+	assert.Equal(t, "bearer", entries[0].Auth)
+	assert.Equal(t, "/admin/reset", entries[0].Path)
+	assert.JSONEq(t, `{"rule_id":"a"}`, string(entries[0].Params))
+	assert.Equal(t, http.StatusOK, entries[0].Status)
+	assert.True(t, clk.Now().Equal(entries[0].Time))
+
+	assert.Equal(t, "viewer", entries[1].Principal)
+	assert.Equal(t, http.StatusForbidden, entries[1].Status)
+
+	assert.Empty(t, entries[2].Principal)
+	assert.Equal(t, http.StatusUnauthorized, entries[2].Status)
+}
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Option of Middleware
+type Option func(*Middleware)
+
+// KeyFunc return client key of request that rules are matched by, IP by default
+type KeyFunc func(r *http.Request) string
+
+// SkipFunc return true if request is not limited
+type SkipFunc func(r *http.Request) bool
+
+// LimitedHandler write response to limited request. Status is zero if RateLimiter is not StatusReporter.
+type LimitedHandler func(w http.ResponseWriter, r *http.Request, status ratelimit.Status)
+
+// ErrorHandler write response to request that failed
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+
+// Logger of Middleware. *log.Logger is Logger.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// HeaderStyle of status headers
+type HeaderStyle int
+
+const (
+	// HeadersIETF RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers of IETF draft
+	HeadersIETF HeaderStyle = iota
+	// HeadersX X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset headers
+	HeadersX
+	// HeadersNone no status headers, Retry-After is still sent to limited requests
+	HeadersNone
+)
+
+// DefaultIPHeader is header of client IP by default
+const DefaultIPHeader = "X-Forwarded-For"
+
+// WithKeyFunc set extraction of client key, by default it is the first IP of X-Forwarded-For header
+func WithKeyFunc(f KeyFunc) Option {
+	return func(m *Middleware) {
+		m.keyFunc = f
+	}
+}
+
+// WithIPHeader take client IP from the first value of header
+func WithIPHeader(name string) Option {
+	return WithKeyFunc(HeaderKey(name))
+}
+
+// WithSkip add predicate of requests that are not limited
+func WithSkip(f SkipFunc) Option {
+	return func(m *Middleware) {
+		m.skip = append(m.skip, f)
+	}
+}
+
+// WithOnLimited set response to limited requests, by default it is 429 with "Too many requests"
+func WithOnLimited(f LimitedHandler) Option {
+	return func(m *Middleware) {
+		m.onLimited = f
+	}
+}
+
+// WithErrorHandler set response to failed requests, by default it is 400 with error text
+func WithErrorHandler(f ErrorHandler) Option {
+	return func(m *Middleware) {
+		m.onError = f
+	}
+}
+
+// WithRequireKey answer requests without client key by error handler with ErrNoClientKey instead of passing them
+func WithRequireKey() Option {
+	return func(m *Middleware) {
+		m.requireKey = true
+	}
+}
+
+// WithHeaderStyle set style of status headers
+func WithHeaderStyle(style HeaderStyle) Option {
+	return func(m *Middleware) {
+		m.headerStyle = style
+	}
+}
+
+// WithLogger set logger of requests and errors, nil disables logging
+func WithLogger(l Logger) Option {
+	return func(m *Middleware) {
+		if l == nil {
+			l = nopLogger{}
+		}
+		m.logger = l
+	}
+}
+
+// HeaderKey return KeyFunc of the first IP of header
+func HeaderKey(name string) KeyFunc {
+	return func(r *http.Request) string {
+		ip, _, _ := strings.Cut(r.Header.Get(name), ",")
+		return strings.TrimSpace(ip)
+	}
+}
+
+// RemoteAddrKey is KeyFunc of IP of the connection
+func RemoteAddrKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// SkipPaths return SkipFunc of requests by exact paths
+func SkipPaths(paths ...string) SkipFunc {
+	set := make(map[string]struct{}, len(paths))
+	for _, p := range paths {
+		set[p] = struct{}{}
+	}
+
+	return func(r *http.Request) bool {
+		_, ok := set[r.URL.Path]
+		return ok
+	}
+}
+
+// defaultOnLimited write 429 with "Too many requests"
+func (m *Middleware) defaultOnLimited(w http.ResponseWriter, r *http.Request, status ratelimit.Status) {
+	w.WriteHeader(http.StatusTooManyRequests)
+	if _, err := w.Write([]byte("Too many requests")); err != nil {
+		m.logger.Printf("%s %s %s", r.Method, r.RequestURI, err.Error())
+	}
+}
+
+// defaultOnError write 400 with error text
+func (m *Middleware) defaultOnError(w http.ResponseWriter, r *http.Request, err error) {
+	w.WriteHeader(http.StatusBadRequest)
+	if _, werr := w.Write([]byte(err.Error())); werr != nil {
+		m.logger.Printf("%s %s %s", r.Method, r.RequestURI, werr.Error())
+	}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Printf(format string, v ...any) {}
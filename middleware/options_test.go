@@ -0,0 +1,222 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/ratelimittest"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"github.com/stretchr/testify/assert"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestNew_Return test limited request does not reach next handler
+func TestNew_Return(t *testing.T) {
+	called := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called++ })
+	h := New(ratelimittest.New().Deny()).Handler(next)
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, newRequest(http.MethodGet, "http://localhost/run", nil, "10.0.0.1"))
+
+	ratelimittest.AssertLimited(t, res)
+	assert.Equal(t, 0, called)
+}
+
+// TestWithKeyFunc test WithKeyFunc and WithIPHeader functions
+func TestWithKeyFunc(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	t.Run("ip header", func(t *testing.T) {
+		rl := ratelimittest.New()
+		h := New(rl, WithIPHeader("X-Real-IP")).Handler(next)
+
+		req := newRequest(http.MethodGet, "http://localhost/run", nil, "10.0.0.1")
+		req.Header.Set("X-Real-IP", " 10.0.0.2 , 10.0.0.3")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Equal(t, "10.0.0.2", rl.Calls()[0].IP)
+	})
+
+	t.Run("remote addr", func(t *testing.T) {
+		rl := ratelimittest.New()
+		h := New(rl, WithKeyFunc(RemoteAddrKey)).Handler(next)
+
+		req := newRequest(http.MethodGet, "http://localhost/run", nil, "10.0.0.1")
+		req.RemoteAddr = "10.0.0.5:4321"
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Equal(t, "10.0.0.5", rl.Calls()[0].IP)
+	})
+}
+
+// TestWithSkip test WithSkip function
+func TestWithSkip(t *testing.T) {
+	rl := ratelimittest.New().Deny()
+	h := New(rl, WithSkip(SkipPaths("/health"))).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, newRequest(http.MethodGet, "http://localhost/health", nil, "10.0.0.1"))
+	ratelimittest.AssertNotLimited(t, res)
+	assert.Empty(t, rl.Calls())
+
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, newRequest(http.MethodGet, "http://localhost/run", nil, "10.0.0.1"))
+	ratelimittest.AssertLimited(t, res)
+}
+
+// TestWithOnLimited test WithOnLimited function
+func TestWithOnLimited(t *testing.T) {
+	rl := ratelimittest.New().Limit(1).Reset(time.Minute)
+	var got ratelimit.Status
+	h := New(rl, WithOnLimited(func(w http.ResponseWriter, r *http.Request, status ratelimit.Status) {
+		got = status
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	h.ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "http://localhost/run", nil, "10.0.0.1"))
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, newRequest(http.MethodGet, "http://localhost/run", nil, "10.0.0.1"))
+
+	assert.Equal(t, http.StatusServiceUnavailable, res.Code)
+	assert.Equal(t, ratelimittest.DefaultID, got.ID)
+	assert.Equal(t, int64(1), got.Limit)
+	ratelimittest.AssertRetryAfter(t, res, 60)
+}
+
+// TestWithErrorHandler test WithRequireKey and WithErrorHandler functions
+func TestWithErrorHandler(t *testing.T) {
+	called := 0
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called++ })
+
+	// request without client key is passed by default
+	res := httptest.NewRecorder()
+	New(ratelimittest.New()).Handler(next).ServeHTTP(res, newRequest(http.MethodGet, "http://localhost/run", nil, ""))
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, 1, called)
+
+	res = httptest.NewRecorder()
+	New(ratelimittest.New(), WithRequireKey()).Handler(next).ServeHTTP(res, newRequest(http.MethodGet, "http://localhost/run", nil, ""))
+	assert.Equal(t, http.StatusBadRequest, res.Code)
+	assert.Equal(t, ErrNoClientKey.Error(), res.Body.String())
+
+	var got error
+	h := New(ratelimittest.New(), WithRequireKey(), WithErrorHandler(func(w http.ResponseWriter, r *http.Request, err error) {
+		got = err
+		w.WriteHeader(http.StatusForbidden)
+	})).Handler(next)
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, newRequest(http.MethodGet, "http://localhost/run", nil, ""))
+	assert.Equal(t, http.StatusForbidden, res.Code)
+	assert.ErrorIs(t, got, ErrNoClientKey)
+	assert.Equal(t, 1, called)
+}
+
+// TestWithHeaderStyle test WithHeaderStyle function
+func TestWithHeaderStyle(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	res := httptest.NewRecorder()
+	New(ratelimittest.New().Limit(3), WithHeaderStyle(HeadersX)).Handler(next).
+		ServeHTTP(res, newRequest(http.MethodGet, "http://localhost/run", nil, "10.0.0.1"))
+	assert.Equal(t, "3", res.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "2", res.Header().Get("X-RateLimit-Remaining"))
+	assert.Empty(t, res.Header().Get("RateLimit-Limit"))
+
+	res = httptest.NewRecorder()
+	New(ratelimittest.New().Limit(3), WithHeaderStyle(HeadersNone)).Handler(next).
+		ServeHTTP(res, newRequest(http.MethodGet, "http://localhost/run", nil, "10.0.0.1"))
+	ratelimittest.AssertNoRateLimitHeaders(t, res)
+}
+
+// TestWithLogger test WithLogger function
+func TestWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	h := New(ratelimittest.New(), WithLogger(log.New(&buf, "", 0))).
+		Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, newRequest(http.MethodPost, "http://localhost/run", nil, "10.0.0.1"))
+
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Contains(t, buf.String(), "POST http://localhost/run")
+
+	assert.NotPanics(t, func() {
+		New(ratelimittest.New(), WithLogger(nil)).Handler(http.NotFoundHandler()).
+			ServeHTTP(httptest.NewRecorder(), newRequest(http.MethodGet, "http://localhost/run", nil, ""))
+	})
+
+	// write errors of default responses are logged by the logger too
+	for _, ip := range []string{"10.0.0.1", ""} {
+		buf.Reset()
+		h = New(ratelimittest.New().Deny(), WithRequireKey(), WithLogger(log.New(&buf, "", 0))).Handler(http.NotFoundHandler())
+		h.ServeHTTP(failedWriter{httptest.NewRecorder()}, newRequest(http.MethodGet, "http://localhost/run", nil, ip))
+		assert.Contains(t, buf.String(), "GET http://localhost/run write failed", ip)
+	}
+}
+
+// failedWriter is ResponseWriter that fails to write body
+type failedWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (failedWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+// TestNew_Banned test banned clients are rejected before rules
+func TestNew_Banned(t *testing.T) {
+	ctx := context.Background()
+	cfg := ratelimittest.NewConfig().Rule("all", "", 100, 60).AnyHandler().Build()
+	store := storage.NewMemoryCache()
+	bans := ratelimit.NewBanlist(store)
+	rl := ratelimit.NewRateLimit(&cfg, store, ratelimit.WithBanlist(bans))
+	h := New(rl).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	_, err := bans.Ban(ctx, "10.0.0.0/24", time.Minute, "")
+	assert.NoError(t, err)
+	_, err = bans.Ban(ctx, "10.0.1.1", 0, "")
+	assert.NoError(t, err)
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, newRequest(http.MethodGet, "http://localhost/run", nil, "10.0.0.7"))
+	ratelimittest.AssertLimited(t, res)
+	ratelimittest.AssertRetryAfter(t, res, 60)
+	assert.False(t, store.Has(ctx, []byte("all")), "rules are not counted")
+
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, newRequest(http.MethodGet, "http://localhost/run", nil, "10.0.1.1"))
+	ratelimittest.AssertLimited(t, res)
+	assert.Empty(t, res.Header().Get("Retry-After"))
+
+	res = httptest.NewRecorder()
+	h.ServeHTTP(res, newRequest(http.MethodGet, "http://localhost/run", nil, "10.0.2.1"))
+	ratelimittest.AssertNotLimited(t, res)
+}
+
+// TestNew_Override test overrides of client limits are applied by the middleware
+func TestNew_Override(t *testing.T) {
+	cfg := ratelimittest.NewConfig().Rule("all", "", 1, 60).AnyHandler().Build()
+	cfg.Overrides = []ratelimit.Override{{App: "partner", Limit: 3, ExpiresAt: time.Now().Add(time.Hour)}}
+	rl := ratelimit.NewRateLimit(&cfg, storage.NewMemoryCache())
+	h := New(rl).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i := int64(1); i <= 3; i++ {
+		req := newRequest(http.MethodGet, "http://localhost/run", nil, "10.0.0.1")
+		req.Header.Set("X-APP", "partner")
+		res := httptest.NewRecorder()
+		h.ServeHTTP(res, req)
+		ratelimittest.AssertNotLimited(t, res)
+		ratelimittest.AssertRateLimitHeaders(t, res, 3, 3-i)
+	}
+
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, newRequest(http.MethodGet, "http://localhost/run", nil, "10.0.0.1"))
+	ratelimittest.AssertLimited(t, res)
+	ratelimittest.AssertRateLimitHeaders(t, res, 1, 0)
+}
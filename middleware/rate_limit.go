@@ -2,88 +2,135 @@ package middleware
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
+	"errors"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit"
 	"log"
 	"net/http"
-	"strings"
+	"strconv"
 	"time"
 )
 
-func RateLimit(next http.Handler, rl RateLimiter) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := r.Context()
+// ErrNoClientKey is error of request without client key, e.g. without IP header
+var ErrNoClientKey = errors.New("client key not found")
 
-		if r.URL.Path == "/reset" && r.Method == http.MethodPost {
-			// reset DTO
-			type ResetRequest struct {
-				IP string `json:"ip"`
-			}
+// RateLimit limit requests to next by rl with default options
+func RateLimit(next http.Handler, rl RateLimiter) http.Handler {
+	return New(rl).Handler(next)
+}
 
-			// decode input or return error
-			var input ResetRequest
-			if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-				w.WriteHeader(http.StatusBadRequest)
-				_, err = fmt.Fprintf(w, "Wrong params Decode. "+err.Error())
-				if err != nil {
-					return
-				}
-				return
-			}
+// Middleware limit requests by RateLimiter
+type Middleware struct {
+	rl          RateLimiter
+	keyFunc     KeyFunc
+	skip        []SkipFunc
+	onLimited   LimitedHandler
+	onError     ErrorHandler
+	requireKey  bool
+	headerStyle HeaderStyle
+	logger      Logger
+}
 
-			if input.IP == "" {
-				w.WriteHeader(http.StatusBadRequest)
-				fmt.Fprintf(w, "Wrong params. IP param not found.")
-				return
-			}
+// New create middleware of rl. By default client IP is taken from X-Forwarded-For header, requests without it are not limited,
+// limited requests get 429, status is sent in RateLimit-* headers and requests are logged by log package.
+func New(rl RateLimiter, opts ...Option) *Middleware {
+	m := &Middleware{
+		rl:      rl,
+		keyFunc: HeaderKey(DefaultIPHeader),
+		logger:  log.Default(),
+	}
+	// default responses log write errors by logger of options
+	m.onLimited, m.onError = m.defaultOnLimited, m.defaultOnError
+	for _, opt := range opts {
+		opt(m)
+	}
 
-			ids := rl.IdsByIP(ctx, "*", "*", "*", input.IP)
-			if len(ids) > 0 {
-				if err := rl.ClearByIDs(ctx, ids); err != nil {
-					w.WriteHeader(http.StatusBadRequest)
-					fmt.Fprintf(w, "Error clean limits by IP")
-					return
-				}
-			}
+	return m
+}
 
-			fmt.Fprintf(w, "{\"result\": \"Ok\"}\n")
-			w.WriteHeader(http.StatusOK)
+// Handler wrap next by the middleware
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.skipped(r) && m.limited(w, r) {
 			return
 		}
 
-		ips := r.Header.Get("X-FORWARDED-FOR")
-		splitIPs := strings.Split(ips, ",")
-
-		if len(splitIPs) > 0 {
-			realIP := splitIPs[0]
-			ids := rl.IdsByIP(ctx, r.Proto, r.Method, r.URL.Path, realIP)
-			if len(ids) > 0 {
-				ids = ids[:1]
-				if rl.IsLimitedByIDs(ctx, ids) {
-					w.WriteHeader(http.StatusTooManyRequests)
-					_, err := w.Write([]byte("Too many requests"))
-					if err != nil {
-						log.Printf("%s %s %s", r.Method, r.RequestURI, err.Error())
-					}
-				}
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		m.logger.Printf("%s %s %s", r.Method, r.RequestURI, time.Since(start))
+	})
+}
+
+// limited check and count request, response to limited or failed request is written
+func (m *Middleware) limited(w http.ResponseWriter, r *http.Request) bool {
+	key := m.keyFunc(r)
+	if key == "" && m.requireKey {
+		m.onError(w, r, ErrNoClientKey)
+		return true
+	}
+	appName := r.Header.Get("X-APP")
+	// client is used by overrides of limits
+	ctx := ratelimit.WithClient(r.Context(), ratelimit.Client{Key: key, App: appName})
 
-				rl.IncByIDs(ctx, ids)
+	if checker, ok := m.rl.(BanChecker); ok {
+		if ban, banned := checker.Banned(ctx, key); banned {
+			status := ratelimit.Status{ID: ratelimit.BanPrefix + ban.Target}
+			if ban.ExpiresAt != nil {
+				if status.Reset = time.Until(*ban.ExpiresAt); status.Reset < 0 {
+					status.Reset = 0
+				}
+				w.Header().Set("Retry-After", strconv.FormatInt(ceilSeconds(status.Reset), 10))
 			}
+			m.onLimited(w, r, status)
+			return true
 		}
+	}
 
-		appName := r.Header.Get("X-APP")
-		if rl.IsLimitedByApp(ctx, r.Proto, r.Method, r.URL.RawPath, r.URL.Query(), appName) {
-			w.WriteHeader(http.StatusTooManyRequests)
-			_, err := w.Write([]byte("Too many requests by app"))
-			if err != nil {
-				log.Printf("%s %s %s", r.Method, r.RequestURI, err.Error())
+	ids := m.rl.IdsByIP(ctx, r.Proto, r.Method, r.URL.Path, key)
+	if len(ids) > 0 {
+		ids = ids[:1]
+		if m.rl.IsLimitedByIDs(ctx, ids) {
+			status, ok := statusByIDs(ctx, m.rl, ids)
+			if ok {
+				m.setStatusHeaders(w.Header(), status)
+				w.Header().Set("Retry-After", strconv.FormatInt(ceilSeconds(status.Reset), 10))
 			}
+			m.onLimited(w, r, status)
+			return true
 		}
 
-		start := time.Now()
-		next.ServeHTTP(w, r)
-		log.Printf("%s %s %s", r.Method, r.RequestURI, time.Since(start))
-	})
+		m.rl.IncByIDs(ctx, ids)
+		if status, ok := statusByIDs(ctx, m.rl, ids); ok {
+			m.setStatusHeaders(w.Header(), status)
+		}
+	}
+
+	if m.rl.IsLimitedByApp(ctx, r.Proto, r.Method, r.URL.RawPath, r.URL.Query(), appName) {
+		m.onLimited(w, r, ratelimit.Status{})
+		return true
+	}
+
+	return false
+}
+
+// skipped check is request skipped by predicates
+func (m *Middleware) skipped(r *http.Request) bool {
+	for _, skip := range m.skip {
+		if skip(r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setStatusHeaders set status headers by style of the middleware
+func (m *Middleware) setStatusHeaders(h http.Header, status ratelimit.Status) {
+	switch m.headerStyle {
+	case HeadersIETF:
+		setStatusHeaders(h, "RateLimit-", status)
+	case HeadersX:
+		setStatusHeaders(h, "X-RateLimit-", status)
+	}
 }
 
 type RateLimiter interface {
@@ -95,6 +142,40 @@ type RateLimiter interface {
 	IsLimitedByApp(ctx context.Context, protocol, method, url string, query map[string][]string, appName string) bool
 }
 
+// StatusReporter is optional interface of RateLimiter. Status is sent in RateLimit-* and Retry-After headers.
+type StatusReporter interface {
+	StatusByIDs(ctx context.Context, ids []string) (ratelimit.Status, bool)
+}
+
+// BanChecker is optional interface of RateLimiter. Banned clients are rejected before rules are checked.
+type BanChecker interface {
+	Banned(ctx context.Context, key string) (ratelimit.Ban, bool)
+}
+
+// statusByIDs return status if rl is StatusReporter
+func statusByIDs(ctx context.Context, rl RateLimiter, ids []string) (ratelimit.Status, bool) {
+	reporter, ok := rl.(StatusReporter)
+	if !ok {
+		return ratelimit.Status{}, false
+	}
+
+	return reporter.StatusByIDs(ctx, ids)
+}
+
+// setStatusHeaders set Limit, Remaining and Reset headers with prefix
+func setStatusHeaders(h http.Header, prefix string, status ratelimit.Status) {
+	h.Set(prefix+"Limit", strconv.FormatInt(status.Limit, 10))
+	h.Set(prefix+"Remaining", strconv.FormatInt(status.Remaining, 10))
+	if status.Reset > 0 {
+		h.Set(prefix+"Reset", strconv.FormatInt(ceilSeconds(status.Reset), 10))
+	}
+}
+
+// ceilSeconds round duration up to seconds
+func ceilSeconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
+
 //
 //// RateLimit is a middleware handler that limits count request by IP and sub mask
 //type RateLimit struct {
@@ -21,7 +21,6 @@ func TestRateLimit(t *testing.T) {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/run/http1.1/get", testHandler)
 	mux.HandleFunc("/run", testHandler)
-	mux.HandleFunc("/reset", testHandler)
 
 	rlm := RateLimit(mux, rl)
 
@@ -78,7 +77,25 @@ func TestRateLimit(t *testing.T) {
 	})
 }
 
-func TestRateLimitReset(t *testing.T) {
+// TestRateLimitResetPath test POST /reset is passed to application
+func TestRateLimitResetPath(t *testing.T) {
+	called := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/reset", func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	cfg := getConfig()
+	rlm := RateLimit(mux, ratelimit.NewRateLimit(&cfg, storage.NewMemoryCache()))
+
+	res := httptest.NewRecorder()
+	rlm.ServeHTTP(res, newRequest(http.MethodPost, "http://localhost:8087/reset", strings.NewReader("{\"ip\": \"123.45.67.11\"}"), ""))
+
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.True(t, called)
+}
+
+func TestRateLimitHeaders(t *testing.T) {
 	testHandler := func(w http.ResponseWriter, r *http.Request) {}
 	memStorage := storage.NewMemoryCache()
 	cfg := getConfig()
@@ -86,92 +103,29 @@ func TestRateLimitReset(t *testing.T) {
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/run/http1.1/get", testHandler)
-	mux.HandleFunc("/run", testHandler)
-	mux.HandleFunc("/reset", testHandler)
 
 	rlm := RateLimit(mux, rl)
+	limit := cfg.ByIp.Data[0].Limit
 
-	t.Run("middleware /reset nil body 123.17.18.11 ID limit: 87206c45-3098-45c1-86c1-0c28296d163f", func(t *testing.T) {
-		method := http.MethodPost
-		url := "http://localhost:8087/reset"
-		req := newRequest(method, url, nil, "")
-
-		res := httptest.NewRecorder()
-		rlm.ServeHTTP(res, req)
-		if res.Code != http.StatusBadRequest {
-			t.Fatalf("Expected %d code response, but got %d", http.StatusBadRequest, res.Code)
-		}
-		assert.Contains(t, res.Body.String(), "Wrong params Decode")
-	})
-
-	t.Run("middleware /reset wrong body 123.17.18.11 ID limit: 87206c45-3098-45c1-86c1-0c28296d163f", func(t *testing.T) {
-		method := http.MethodPost
-		url := "http://localhost:8087/reset"
-		body := strings.NewReader("{\"NO\": \"1\"}")
-		req := newRequest(method, url, body, "")
-
-		res := httptest.NewRecorder()
-		rlm.ServeHTTP(res, req)
-		if res.Code != http.StatusBadRequest {
-			t.Fatalf("Expected %d code response, but got %d", http.StatusBadRequest, res.Code)
-		}
-		assert.Contains(t, res.Body.String(), "IP param not found")
-	})
-
-	t.Run("middleware /reset 123.17.18.11 ID limit: 87206c45-3098-45c1-86c1-0c28296d163f", func(t *testing.T) {
-		method := http.MethodGet
-		url := "http://localhost:8087/run/http1.1/get"
-
-		req := newRequest(method, url, nil, "123.45.67.11")
-
+	for i := int64(1); i <= limit; i++ {
+		req := newRequest(http.MethodGet, "http://localhost:8087/run/http1.1/get", nil, "123.45.67.11")
 		res := httptest.NewRecorder()
-		limit := cfg.ByIp.Data[0].Limit
-		for i := int64(1); i <= limit+1; i++ {
-			rlm.ServeHTTP(res, req)
-			if i <= limit && res.Code != http.StatusOK {
-				t.Fatalf("Expected %d code response, but got %d", http.StatusOK, res.Code)
-			} else if i > limit && res.Code != http.StatusTooManyRequests {
-				t.Fatalf("Expected %d code response, but got %d", http.StatusTooManyRequests, res.Code)
-			}
-		}
-
-		method = http.MethodPost
-		url = "http://localhost:8087/reset"
-
-		body := strings.NewReader("{\"ip\": \"123.45.67.11\"}")
-
-		req = newRequest(method, url, body, "123.45.67.11")
-
-		res = httptest.NewRecorder()
 		rlm.ServeHTTP(res, req)
 
-		if res.Code != http.StatusOK {
-			t.Fatalf("Expected %d code response, but got %d", http.StatusOK, res.Code)
-		}
-
-		assert.Contains(
-			t, res.Body.String(),
-			"\"result\": \"Ok\"",
-			fmt.Sprintf("Expected %s response, but got %s", "\"result\": \"Ok\"", res.Body.String()),
-		)
-
-		// check again limit after reset
-		method = http.MethodGet
-		url = "http://localhost:8087/run/http1.1/get"
+		assert.Equal(t, http.StatusOK, res.Code)
+		assert.Equal(t, "3", res.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, fmt.Sprintf("%d", limit-i), res.Header().Get("RateLimit-Remaining"))
+		assert.Empty(t, res.Header().Get("Retry-After"))
+	}
 
-		req = newRequest(method, url, nil, "123.45.67.11")
+	req := newRequest(http.MethodGet, "http://localhost:8087/run/http1.1/get", nil, "123.45.67.11")
+	res := httptest.NewRecorder()
+	rlm.ServeHTTP(res, req)
 
-		res = httptest.NewRecorder()
-		limit = cfg.ByIp.Data[0].Limit
-		for i := int64(1); i <= limit+1; i++ {
-			rlm.ServeHTTP(res, req)
-			if i <= limit && res.Code != http.StatusOK {
-				t.Fatalf("Expected %d code response, but got %d", http.StatusOK, res.Code)
-			} else if i > limit && res.Code != http.StatusTooManyRequests {
-				t.Fatalf("Expected %d code response, but got %d", http.StatusTooManyRequests, res.Code)
-			}
-		}
-	})
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.Equal(t, "0", res.Header().Get("RateLimit-Remaining"))
+	assert.NotEmpty(t, res.Header().Get("RateLimit-Reset"))
+	assert.NotEmpty(t, res.Header().Get("Retry-After"))
 }
 
 func getConfig() ratelimit.Config {
@@ -0,0 +1,61 @@
+// Package clock is source of time for limits and storages, so expirations can be driven by tests.
+package clock
+
+import (
+	"time"
+)
+
+// Clock tell current time and run timers
+type Clock interface {
+	Now() time.Time
+	// AfterFunc call f after d
+	AfterFunc(d time.Duration, f func()) Timer
+	// NewTicker send time to channel of ticker every d
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer of Clock.AfterFunc
+type Timer interface {
+	// Stop prevent timer from firing. False if the timer has already fired or been stopped.
+	Stop() bool
+}
+
+// Ticker of Clock.NewTicker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is clock of time package
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// OrReal return c or Real if c is nil
+func OrReal(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+
+	return c
+}
@@ -0,0 +1,158 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Manual is Clock for tests. Time stands still until Advance, which fires due timers and tickers in order of
+// their deadlines. Callbacks of timers are called synchronously by Advance, so expirations are deterministic.
+type Manual struct {
+	mu      sync.Mutex
+	now     time.Time
+	seq     uint64
+	waiters []*waiter
+}
+
+// waiter is pending timer or ticker
+type waiter struct {
+	m      *Manual
+	at     time.Time
+	seq    uint64
+	period time.Duration
+	f      func()
+	ch     chan time.Time
+}
+
+// NewManual create manual clock at now
+func NewManual(now time.Time) *Manual {
+	return &Manual{now: now}
+}
+
+// Now is current time of the clock
+func (m *Manual) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.now
+}
+
+// AfterFunc call f when the clock is advanced by d. Timer with d <= 0 fires on the next Advance.
+func (m *Manual) AfterFunc(d time.Duration, f func()) Timer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w := &waiter{m: m, f: f}
+	m.schedule(w, d)
+
+	return w
+}
+
+// NewTicker send time to channel of ticker every d of advanced time. Ticks are dropped if the reader is slow.
+func (m *Manual) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	w := &waiter{m: m, period: d, ch: make(chan time.Time, 1)}
+	m.schedule(w, d)
+
+	return manualTicker{w}
+}
+
+// Advance move the clock forward by d and fire timers and tickers that are due
+func (m *Manual) Advance(d time.Duration) {
+	m.mu.Lock()
+	target := m.now.Add(d)
+	for {
+		if len(m.waiters) == 0 || m.waiters[0].at.After(target) {
+			m.now = target
+			m.mu.Unlock()
+			return
+		}
+
+		w := m.waiters[0]
+		m.waiters = m.waiters[1:]
+		if w.at.After(m.now) {
+			m.now = w.at
+		}
+		now := m.now
+		if w.period > 0 {
+			m.schedule(w, w.period)
+		}
+		m.mu.Unlock()
+
+		if w.ch != nil {
+			select {
+			case w.ch <- now:
+			default:
+			}
+		} else {
+			w.f()
+		}
+
+		m.mu.Lock()
+	}
+}
+
+// Pending is count of timers and tickers that are not fired or stopped
+func (m *Manual) Pending() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.waiters)
+}
+
+// schedule waiter after d. Must be called under mu lock.
+func (m *Manual) schedule(w *waiter, d time.Duration) {
+	m.seq++
+	w.seq = m.seq
+	w.at = m.now.Add(d)
+
+	i := sort.Search(len(m.waiters), func(i int) bool {
+		o := m.waiters[i]
+		return o.at.After(w.at) || (o.at.Equal(w.at) && o.seq > w.seq)
+	})
+	m.waiters = append(m.waiters, nil)
+	copy(m.waiters[i+1:], m.waiters[i:])
+	m.waiters[i] = w
+}
+
+// remove waiter. Must be called under mu lock.
+func (m *Manual) remove(w *waiter) bool {
+	for i, o := range m.waiters {
+		if o == w {
+			m.waiters = append(m.waiters[:i], m.waiters[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Stop timer or ticker
+func (w *waiter) Stop() bool {
+	w.m.mu.Lock()
+	defer w.m.mu.Unlock()
+
+	return w.m.remove(w)
+}
+
+// manualTicker is ticker of Manual clock
+type manualTicker struct {
+	w *waiter
+}
+
+// C is channel of ticks
+func (t manualTicker) C() <-chan time.Time {
+	return t.w.ch
+}
+
+// Stop ticker
+func (t manualTicker) Stop() {
+	t.w.Stop()
+}
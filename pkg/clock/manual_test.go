@@ -0,0 +1,70 @@
+package clock
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// TestManual_AfterFunc test timers of Manual clock
+func TestManual_AfterFunc(t *testing.T) {
+	start := time.Unix(1000, 0)
+	m := NewManual(start)
+
+	var fired []string
+	var at []time.Time
+	timer := func(name string) func() {
+		return func() {
+			fired = append(fired, name)
+			at = append(at, m.Now())
+		}
+	}
+	m.AfterFunc(2*time.Second, timer("b"))
+	m.AfterFunc(time.Second, timer("a"))
+	m.AfterFunc(2*time.Second, timer("c"))
+	stopped := m.AfterFunc(time.Second, timer("stopped"))
+	assert.True(t, stopped.Stop())
+	assert.False(t, stopped.Stop())
+	assert.Equal(t, 3, m.Pending())
+
+	m.Advance(999 * time.Millisecond)
+	assert.Empty(t, fired)
+
+	m.Advance(5 * time.Second)
+	assert.Equal(t, []string{"a", "b", "c"}, fired)
+	assert.Equal(t, []time.Time{start.Add(time.Second), start.Add(2 * time.Second), start.Add(2 * time.Second)}, at)
+	assert.Equal(t, start.Add(5999*time.Millisecond), m.Now())
+	assert.Equal(t, 0, m.Pending())
+
+	// timer scheduled by callback fires in the same advance if it is due
+	m.AfterFunc(time.Second, func() {
+		m.AfterFunc(time.Second, timer("nested"))
+	})
+	m.AfterFunc(0, timer("now"))
+	m.Advance(2 * time.Second)
+	assert.Equal(t, []string{"a", "b", "c", "now", "nested"}, fired)
+}
+
+// TestManual_NewTicker test tickers of Manual clock
+func TestManual_NewTicker(t *testing.T) {
+	start := time.Unix(1000, 0)
+	m := NewManual(start)
+
+	ticker := m.NewTicker(time.Second)
+	m.Advance(time.Second)
+	assert.Equal(t, start.Add(time.Second), <-ticker.C())
+
+	// ticks are dropped when the reader is slow
+	m.Advance(3 * time.Second)
+	assert.Equal(t, start.Add(2*time.Second), <-ticker.C())
+	select {
+	case <-ticker.C():
+		t.Fatal("unexpected tick")
+	default:
+	}
+
+	ticker.Stop()
+	m.Advance(time.Second)
+	assert.Equal(t, 0, m.Pending())
+	assert.Panics(t, func() { m.NewTicker(0) })
+}
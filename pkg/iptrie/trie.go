@@ -0,0 +1,129 @@
+// Package iptrie is binary radix trie of IPv4 and IPv6 prefixes. Lookup of IP takes at most
+// as many steps as bits of the address, whatever the count of prefixes.
+package iptrie
+
+import (
+	"errors"
+	"net"
+)
+
+// ErrInvalidPrefix is error of prefix with non-canonical mask or mask longer than its address
+var ErrInvalidPrefix = errors.New("invalid prefix")
+
+// Trie of prefixes with values. It is not safe for concurrent writes; concurrent lookups are safe.
+type Trie[V any] struct {
+	v4   *node[V]
+	v6   *node[V]
+	size int
+}
+
+// node of trie, child is indexed by the next bit of address
+type node[V any] struct {
+	child [2]*node[V]
+	value V
+	set   bool
+}
+
+// New create empty trie
+func New[V any]() *Trie[V] {
+	return &Trie[V]{v4: &node[V]{}, v6: &node[V]{}}
+}
+
+// Insert value of prefix. Value of existing prefix is replaced. IPv4-mapped IPv6 prefix is inserted as IPv4 one.
+func (t *Trie[V]) Insert(prefix *net.IPNet, value V) error {
+	root, ip := t.root(prefix.IP)
+	if root == nil {
+		return ErrInvalidPrefix
+	}
+	ones, bits := prefix.Mask.Size()
+	if bits == 8*net.IPv6len && len(ip) == net.IPv4len {
+		ones -= 8 * (net.IPv6len - net.IPv4len)
+	}
+	if bits == 0 || ones < 0 || ones > 8*len(ip) {
+		return ErrInvalidPrefix
+	}
+
+	n := root
+	for i := 0; i < ones; i++ {
+		b := bit(ip, i)
+		if n.child[b] == nil {
+			n.child[b] = &node[V]{}
+		}
+		n = n.child[b]
+	}
+
+	if !n.set {
+		t.size++
+	}
+	n.value, n.set = value, true
+
+	return nil
+}
+
+// InsertIP insert value of single address
+func (t *Trie[V]) InsertIP(ip net.IP, value V) error {
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return t.Insert(&net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, value)
+}
+
+// Lookup return value of the longest prefix containing ip
+func (t *Trie[V]) Lookup(ip net.IP) (V, bool) {
+	var res V
+	found := false
+	t.Walk(ip, func(v V) bool {
+		res, found = v, true
+		return true
+	})
+
+	return res, found
+}
+
+// Contains check is ip in some prefix of trie
+func (t *Trie[V]) Contains(ip net.IP) bool {
+	found := false
+	t.Walk(ip, func(V) bool {
+		found = true
+		return false
+	})
+
+	return found
+}
+
+// Len is count of prefixes
+func (t *Trie[V]) Len() int {
+	return t.size
+}
+
+// Walk call f with values of prefixes containing ip from the shortest one until f returns false
+func (t *Trie[V]) Walk(ip net.IP, f func(V) bool) {
+	n, addr := t.root(ip)
+	for i := 0; n != nil; i++ {
+		if n.set && !f(n.value) {
+			return
+		}
+		if i == 8*len(addr) {
+			return
+		}
+		n = n.child[bit(addr, i)]
+	}
+}
+
+// root return root of address family and address of length of the family, nil if ip is invalid
+func (t *Trie[V]) root(ip net.IP) (*node[V], net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return t.v4, ip4
+	}
+	if len(ip) == net.IPv6len {
+		return t.v6, ip
+	}
+
+	return nil, nil
+}
+
+// bit return i-th bit of address
+func bit(ip net.IP, i int) int {
+	return int(ip[i/8]>>(7-uint(i%8))) & 1
+}
@@ -0,0 +1,64 @@
+package iptrie
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func mustCIDR(t *testing.T, s string) *net.IPNet {
+	_, ipNet, err := net.ParseCIDR(s)
+	assert.Nil(t, err)
+	return ipNet
+}
+
+// TestTrie_Lookup test Insert, Lookup and Contains functions
+func TestTrie_Lookup(t *testing.T) {
+	trie := New[string]()
+	for _, prefix := range []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.0/24", "2001:db8::/32", "0.0.0.0/0"} {
+		assert.Nil(t, trie.Insert(mustCIDR(t, prefix), prefix))
+	}
+	assert.Nil(t, trie.InsertIP(net.ParseIP("10.1.2.3"), "10.1.2.3"))
+	assert.Nil(t, trie.Insert(mustCIDR(t, "10.0.0.0/8"), "10.0.0.0/8"))
+	assert.Equal(t, 6, trie.Len())
+
+	for ip, want := range map[string]string{
+		"10.1.2.3":        "10.1.2.3",
+		"10.1.2.4":        "10.1.2.0/24",
+		"10.1.3.1":        "10.1.0.0/16",
+		"10.200.0.1":      "10.0.0.0/8",
+		"192.168.0.1":     "0.0.0.0/0",
+		"::ffff:10.1.2.3": "10.1.2.3",
+		"2001:db8::1":     "2001:db8::/32",
+	} {
+		got, ok := trie.Lookup(net.ParseIP(ip))
+		assert.True(t, ok, ip)
+		assert.Equal(t, want, got, ip)
+		assert.True(t, trie.Contains(net.ParseIP(ip)), ip)
+	}
+
+	_, ok := trie.Lookup(net.ParseIP("2001:db9::1"))
+	assert.False(t, ok)
+	assert.False(t, trie.Contains(net.ParseIP("::1")))
+	assert.False(t, trie.Contains(nil))
+}
+
+// TestTrie_InsertMapped test IPv4-mapped IPv6 prefixes are inserted as IPv4 ones
+func TestTrie_InsertMapped(t *testing.T) {
+	trie := New[string]()
+	assert.Nil(t, trie.Insert(mustCIDR(t, "::ffff:10.0.0.0/104"), "mapped"))
+	assert.Nil(t, trie.Insert(mustCIDR(t, "::ffff:192.168.1.1/128"), "host"))
+
+	got, ok := trie.Lookup(net.ParseIP("10.1.2.3"))
+	assert.True(t, ok)
+	assert.Equal(t, "mapped", got)
+	got, _ = trie.Lookup(net.ParseIP("::ffff:192.168.1.1"))
+	assert.Equal(t, "host", got)
+	assert.False(t, trie.Contains(net.ParseIP("11.0.0.1")))
+
+	// address of the family does not fit mask
+	assert.ErrorIs(t, trie.Insert(&net.IPNet{IP: net.ParseIP("10.0.0.0").To4(), Mask: net.CIDRMask(40, 128)}, "bad"), ErrInvalidPrefix)
+	assert.ErrorIs(t, trie.Insert(&net.IPNet{IP: net.IP{1, 2}, Mask: net.CIDRMask(8, 32)}, "bad"), ErrInvalidPrefix)
+	assert.ErrorIs(t, trie.Insert(&net.IPNet{IP: net.ParseIP("10.0.0.0").To4(), Mask: net.IPMask{255, 0, 255, 0}}, "bad"), ErrInvalidPrefix)
+	assert.Equal(t, 2, trie.Len())
+}
@@ -0,0 +1,223 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	typeCounter = "counter"
+	typeGauge   = "gauge"
+)
+
+// Registry of metrics. Metrics are written in Prometheus text format.
+type Registry struct {
+	mu      sync.RWMutex
+	metrics map[string]metric
+}
+
+// metric is any registered metric
+type metric interface {
+	kind() string
+	help() string
+	write(w io.Writer, name string) error
+}
+
+// NewRegistry create new empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: make(map[string]metric),
+	}
+}
+
+// Counter register new counter
+func (r *Registry) Counter(name, help string) (*Counter, error) {
+	c := &Counter{helpText: help}
+	if err := r.register(name, c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Gauge register new gauge
+func (r *Registry) Gauge(name, help string) (*Gauge, error) {
+	g := &Gauge{helpText: help}
+	if err := r.register(name, g); err != nil {
+		return nil, err
+	}
+
+	return g, nil
+}
+
+// CounterFunc register counter which value is read by fn on every write
+func (r *Registry) CounterFunc(name, help string, fn func() float64) error {
+	return r.register(name, &funcMetric{kindText: typeCounter, helpText: help, fn: fn})
+}
+
+// GaugeFunc register gauge which value is read by fn on every write
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) error {
+	return r.register(name, &funcMetric{kindText: typeGauge, helpText: help, fn: fn})
+}
+
+// WriteTo write all metrics sorted by name in Prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]metric, len(names))
+	for i, name := range names {
+		list[i] = r.metrics[name]
+	}
+	r.mu.RUnlock()
+
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for i, m := range list {
+		if _, err := fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", names[i], m.help(), names[i], m.kind()); err != nil {
+			return cw.n, err
+		}
+		if err := m.write(bw, names[i]); err != nil {
+			return cw.n, err
+		}
+	}
+	err := bw.Flush()
+
+	return cw.n, err
+}
+
+// Handler return http handler that writes all metrics
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = r.WriteTo(w)
+	})
+}
+
+// register add metric by unique name
+func (r *Registry) register(name string, m metric) error {
+	if name == "" {
+		return fmt.Errorf("metric name is empty")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.metrics[name]; ok {
+		return fmt.Errorf("metric %s is already registered", name)
+	}
+	r.metrics[name] = m
+
+	return nil
+}
+
+// Counter is monotonically increasing value
+type Counter struct {
+	helpText string
+	value    atomic.Uint64
+}
+
+// Inc increment counter
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add n to counter
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+// Value of counter
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+func (c *Counter) kind() string { return typeCounter }
+func (c *Counter) help() string { return c.helpText }
+func (c *Counter) write(w io.Writer, name string) error {
+	_, err := fmt.Fprintf(w, "%s %d\n", name, c.Value())
+	return err
+}
+
+// Gauge is value that can go up and down
+type Gauge struct {
+	helpText string
+	bits     atomic.Uint64
+}
+
+// Set value of gauge
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+// Add v to gauge. v can be negative.
+func (g *Gauge) Add(v float64) {
+	for {
+		old := g.bits.Load()
+		if g.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// Value of gauge
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+func (g *Gauge) kind() string { return typeGauge }
+func (g *Gauge) help() string { return g.helpText }
+func (g *Gauge) write(w io.Writer, name string) error {
+	_, err := fmt.Fprintf(w, "%s %s\n", name, formatFloat(g.Value()))
+	return err
+}
+
+// funcMetric is counter or gauge which value is collected on write
+type funcMetric struct {
+	kindText string
+	helpText string
+	fn       func() float64
+}
+
+func (f *funcMetric) kind() string { return f.kindText }
+func (f *funcMetric) help() string { return f.helpText }
+func (f *funcMetric) write(w io.Writer, name string) error {
+	_, err := fmt.Fprintf(w, "%s %s\n", name, formatFloat(f.fn()))
+	return err
+}
+
+// formatFloat format value as Prometheus does
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// countWriter count written bytes
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestRegistry_WriteTo test WriteTo function
+func TestRegistry_WriteTo(t *testing.T) {
+	reg := NewRegistry()
+
+	c, err := reg.Counter("test_requests_total", "Count of requests.")
+	assert.Nil(t, err)
+	c.Inc()
+	c.Add(2)
+	assert.Equal(t, uint64(3), c.Value())
+
+	g, err := reg.Gauge("test_keys", "Count of keys.")
+	assert.Nil(t, err)
+	g.Set(10)
+	g.Add(-2.5)
+	assert.Equal(t, 7.5, g.Value())
+
+	err = reg.GaugeFunc("test_bytes", "Count of bytes.", func() float64 { return 42 })
+	assert.Nil(t, err)
+	err = reg.CounterFunc("test_evictions_total", "Count of evictions.", func() float64 { return 1 })
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	n, err := reg.WriteTo(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(buf.Len()), n)
+	assert.Equal(t, "# HELP test_bytes Count of bytes.\n"+
+		"# TYPE test_bytes gauge\n"+
+		"test_bytes 42\n"+
+		"# HELP test_evictions_total Count of evictions.\n"+
+		"# TYPE test_evictions_total counter\n"+
+		"test_evictions_total 1\n"+
+		"# HELP test_keys Count of keys.\n"+
+		"# TYPE test_keys gauge\n"+
+		"test_keys 7.5\n"+
+		"# HELP test_requests_total Count of requests.\n"+
+		"# TYPE test_requests_total counter\n"+
+		"test_requests_total 3\n", buf.String())
+}
+
+// TestRegistry_register test registration errors
+func TestRegistry_register(t *testing.T) {
+	reg := NewRegistry()
+
+	_, err := reg.Counter("", "Empty name.")
+	assert.NotNil(t, err)
+
+	_, err = reg.Counter("test_total", "Test.")
+	assert.Nil(t, err)
+	_, err = reg.Gauge("test_total", "Test.")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "is already registered")
+}
+
+// TestRegistry_Handler test Handler function
+func TestRegistry_Handler(t *testing.T) {
+	reg := NewRegistry()
+	g, err := reg.Gauge("test_keys", "Count of keys.")
+	assert.Nil(t, err)
+	g.Set(1)
+
+	res := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Contains(t, res.Header().Get("Content-Type"), "text/plain")
+	assert.Contains(t, res.Body.String(), "test_keys 1\n")
+}
@@ -0,0 +1,239 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const typeHistogram = "histogram"
+
+// DefBuckets are default buckets of latency histograms in seconds
+var DefBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// CounterVec register counters partitioned by labels
+func (r *Registry) CounterVec(name, help string, labels ...string) (*CounterVec, error) {
+	v := &CounterVec{vec: newVec(help, labels, func() *Counter { return &Counter{} })}
+	if err := r.register(name, v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// Histogram register new histogram. Buckets are upper bounds, they are sorted. Nil buckets are DefBuckets.
+func (r *Registry) Histogram(name, help string, buckets []float64) (*Histogram, error) {
+	h := newHistogram(help, buckets)
+	if err := r.register(name, h); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// HistogramVec register histograms partitioned by labels
+func (r *Registry) HistogramVec(name, help string, buckets []float64, labels ...string) (*HistogramVec, error) {
+	v := &HistogramVec{vec: newVec(help, labels, func() *Histogram { return newHistogram("", buckets) })}
+	if err := r.register(name, v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// Histogram count observed values in buckets
+type Histogram struct {
+	helpText string
+	buckets  []float64
+	counts   []atomic.Uint64
+	count    atomic.Uint64
+	sumBits  atomic.Uint64
+}
+
+func newHistogram(help string, buckets []float64) *Histogram {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	return &Histogram{
+		helpText: help,
+		buckets:  buckets,
+		counts:   make([]atomic.Uint64, len(buckets)),
+	}
+}
+
+// Observe add value to histogram
+func (h *Histogram) Observe(v float64) {
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		h.counts[i].Add(1)
+	}
+	h.count.Add(1)
+
+	for {
+		old := h.sumBits.Load()
+		if h.sumBits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// Count of observed values
+func (h *Histogram) Count() uint64 {
+	return h.count.Load()
+}
+
+// Sum of observed values
+func (h *Histogram) Sum() float64 {
+	return math.Float64frombits(h.sumBits.Load())
+}
+
+func (h *Histogram) kind() string { return typeHistogram }
+func (h *Histogram) help() string { return h.helpText }
+func (h *Histogram) write(w io.Writer, name string) error {
+	return h.writeLabeled(w, name, "")
+}
+
+// writeLabeled write cumulative buckets, sum and count with labels
+func (h *Histogram) writeLabeled(w io.Writer, name, labels string) error {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i].Load()
+		if _, err := fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep, formatFloat(bound), cumulative); err != nil {
+			return err
+		}
+	}
+
+	count := h.Count()
+	if _, err := fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, count); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "%s_sum%s %s\n", name, braces(labels), formatFloat(h.Sum())); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%s_count%s %d\n", name, braces(labels), count)
+
+	return err
+}
+
+// CounterVec is counters partitioned by label values
+type CounterVec struct {
+	*vec[*Counter]
+}
+
+func (v *CounterVec) kind() string { return typeCounter }
+func (v *CounterVec) write(w io.Writer, name string) error {
+	return v.each(func(labels string, c *Counter) error {
+		_, err := fmt.Fprintf(w, "%s{%s} %d\n", name, labels, c.Value())
+		return err
+	})
+}
+
+// HistogramVec is histograms partitioned by label values
+type HistogramVec struct {
+	*vec[*Histogram]
+}
+
+func (v *HistogramVec) kind() string { return typeHistogram }
+func (v *HistogramVec) write(w io.Writer, name string) error {
+	return v.each(func(labels string, h *Histogram) error {
+		return h.writeLabeled(w, name, labels)
+	})
+}
+
+// vec is children metrics by label values
+type vec[T any] struct {
+	helpText string
+	labels   []string
+	create   func() T
+
+	mu       sync.RWMutex
+	children map[string]T
+}
+
+func newVec[T any](help string, labels []string, create func() T) *vec[T] {
+	return &vec[T]{
+		helpText: help,
+		labels:   labels,
+		create:   create,
+		children: make(map[string]T),
+	}
+}
+
+func (v *vec[T]) help() string { return v.helpText }
+
+// WithLabelValues return child metric of label values, it is created on first call.
+// Count of values must be equal to count of labels.
+func (v *vec[T]) WithLabelValues(values ...string) T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %d label values for %d labels", len(values), len(v.labels)))
+	}
+
+	pairs := make([]string, len(values))
+	for i, value := range values {
+		pairs[i] = v.labels[i] + "=\"" + labelEscaper.Replace(value) + "\""
+	}
+	key := strings.Join(pairs, ",")
+
+	v.mu.RLock()
+	child, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return child
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if child, ok = v.children[key]; !ok {
+		child = v.create()
+		v.children[key] = child
+	}
+
+	return child
+}
+
+// each call fn for children sorted by labels
+func (v *vec[T]) each(fn func(labels string, child T) error) error {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	children := make([]T, len(keys))
+	sort.Strings(keys)
+	for i, key := range keys {
+		children[i] = v.children[key]
+	}
+	v.mu.RUnlock()
+
+	for i, key := range keys {
+		if err := fn(key, children[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// labelEscaper escape label value as Prometheus text format requires
+var labelEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+// braces wrap labels by braces if there are labels
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+
+	return "{" + labels + "}"
+}
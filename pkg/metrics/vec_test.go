@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// TestRegistry_Histogram test Histogram function
+func TestRegistry_Histogram(t *testing.T) {
+	reg := NewRegistry()
+
+	h, err := reg.Histogram("test_duration_seconds", "Duration.", []float64{1, 0.1})
+	assert.Nil(t, err)
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(0.1)
+	h.Observe(3)
+	assert.Equal(t, uint64(4), h.Count())
+	assert.Equal(t, 3.65, h.Sum())
+
+	var buf bytes.Buffer
+	_, err = reg.WriteTo(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "# HELP test_duration_seconds Duration.\n"+
+		"# TYPE test_duration_seconds histogram\n"+
+		"test_duration_seconds_bucket{le=\"0.1\"} 2\n"+
+		"test_duration_seconds_bucket{le=\"1\"} 3\n"+
+		"test_duration_seconds_bucket{le=\"+Inf\"} 4\n"+
+		"test_duration_seconds_sum 3.65\n"+
+		"test_duration_seconds_count 4\n", buf.String())
+}
+
+// TestRegistry_Vec test CounterVec and HistogramVec functions
+func TestRegistry_Vec(t *testing.T) {
+	reg := NewRegistry()
+
+	c, err := reg.CounterVec("test_errors_total", "Errors.", "op", "type")
+	assert.Nil(t, err)
+	c.WithLabelValues("get", "not_found").Inc()
+	c.WithLabelValues("get", "not_found").Inc()
+	c.WithLabelValues("inc", "say \"hi\"").Add(3)
+	assert.Equal(t, uint64(2), c.WithLabelValues("get", "not_found").Value())
+	assert.Panics(t, func() { c.WithLabelValues("get") })
+
+	h, err := reg.HistogramVec("test_duration_seconds", "Duration.", []float64{1}, "op")
+	assert.Nil(t, err)
+	h.WithLabelValues("get").Observe(0.5)
+
+	_, err = reg.CounterVec("test_errors_total", "Errors.")
+	assert.NotNil(t, err)
+
+	var buf bytes.Buffer
+	_, err = reg.WriteTo(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "# HELP test_duration_seconds Duration.\n"+
+		"# TYPE test_duration_seconds histogram\n"+
+		"test_duration_seconds_bucket{op=\"get\",le=\"1\"} 1\n"+
+		"test_duration_seconds_bucket{op=\"get\",le=\"+Inf\"} 1\n"+
+		"test_duration_seconds_sum{op=\"get\"} 0.5\n"+
+		"test_duration_seconds_count{op=\"get\"} 1\n"+
+		"# HELP test_errors_total Errors.\n"+
+		"# TYPE test_errors_total counter\n"+
+		"test_errors_total{op=\"get\",type=\"not_found\"} 2\n"+
+		"test_errors_total{op=\"inc\",type=\"say \\\"hi\\\"\"} 3\n", buf.String())
+}
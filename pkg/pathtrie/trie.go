@@ -0,0 +1,142 @@
+// Package pathtrie is routing trie of url path templates. Template segments are separated by slash:
+// {name} and * match one not empty segment, ** matches any number of segments, other segments match literally.
+package pathtrie
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidTemplate is error of template that does not start with slash or has broken parameter
+var ErrInvalidTemplate = errors.New("invalid path template")
+
+// Trie of templates with values. It is not safe for concurrent writes; concurrent matches are safe.
+type Trie[V any] struct {
+	root *node[V]
+	size int
+}
+
+// node of trie by segment of template
+type node[V any] struct {
+	literal map[string]*node[V]
+	// param is child of {name} and * segments
+	param *node[V]
+	// rest is child of ** segment
+	rest   *node[V]
+	values []V
+}
+
+// New create empty trie
+func New[V any]() *Trie[V] {
+	return &Trie[V]{root: &node[V]{}}
+}
+
+// Parse split template to segments, parameters are replaced by *
+func Parse(template string) ([]string, error) {
+	if !strings.HasPrefix(template, "/") {
+		return nil, fmt.Errorf("%w: %q must start with /", ErrInvalidTemplate, template)
+	}
+
+	segments := strings.Split(template[1:], "/")
+	for i, segment := range segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") && len(segment) > 2 &&
+			!strings.ContainsAny(segment[1:len(segment)-1], "{}") {
+			segments[i] = "*"
+			continue
+		}
+		if strings.ContainsAny(segment, "{}") {
+			return nil, fmt.Errorf("%w: %q has broken parameter %q", ErrInvalidTemplate, template, segment)
+		}
+	}
+
+	return segments, nil
+}
+
+// Insert value of template. Values of the same template are kept in order of insertion.
+func (t *Trie[V]) Insert(template string, value V) error {
+	segments, err := Parse(template)
+	if err != nil {
+		return err
+	}
+
+	n := t.root
+	for _, segment := range segments {
+		switch segment {
+		case "*":
+			if n.param == nil {
+				n.param = &node[V]{}
+			}
+			n = n.param
+		case "**":
+			if n.rest == nil {
+				n.rest = &node[V]{}
+			}
+			n = n.rest
+		default:
+			if n.literal == nil {
+				n.literal = make(map[string]*node[V])
+			}
+			child, ok := n.literal[segment]
+			if !ok {
+				child = &node[V]{}
+				n.literal[segment] = child
+			}
+			n = child
+		}
+	}
+
+	n.values = append(n.values, value)
+	t.size++
+
+	return nil
+}
+
+// Match call f with values of templates matching path until f returns false.
+// Every template is reported once, the order of templates is not defined.
+func (t *Trie[V]) Match(path string, f func(V) bool) {
+	if !strings.HasPrefix(path, "/") {
+		return
+	}
+
+	seen := make(map[*node[V]]bool)
+	t.root.match(strings.Split(path[1:], "/"), seen, f)
+}
+
+// Len is count of inserted values
+func (t *Trie[V]) Len() int {
+	return t.size
+}
+
+// match report nodes matching segments, false if f stopped matching
+func (n *node[V]) match(segments []string, seen map[*node[V]]bool, f func(V) bool) bool {
+	if n.rest != nil {
+		for i := 0; i <= len(segments); i++ {
+			if !n.rest.match(segments[i:], seen, f) {
+				return false
+			}
+		}
+	}
+
+	if len(segments) == 0 {
+		if seen[n] {
+			return true
+		}
+		seen[n] = true
+		for _, value := range n.values {
+			if !f(value) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if child, ok := n.literal[segments[0]]; ok && !child.match(segments[1:], seen, f) {
+		return false
+	}
+	if n.param != nil && segments[0] != "" && !n.param.match(segments[1:], seen, f) {
+		return false
+	}
+
+	return true
+}
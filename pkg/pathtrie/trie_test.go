@@ -0,0 +1,71 @@
+package pathtrie
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"testing"
+)
+
+// TestParse test Parse function
+func TestParse(t *testing.T) {
+	segments, err := Parse("/users/{id}/orders/**")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"users", "*", "orders", "**"}, segments)
+
+	for _, template := range []string{"", "users", "/users/{id", "/users/{}", "/users/id}", "/a{b}", "/{a{b}}"} {
+		_, err = Parse(template)
+		assert.ErrorIs(t, err, ErrInvalidTemplate, template)
+	}
+}
+
+// TestTrie_Match test Insert and Match functions
+func TestTrie_Match(t *testing.T) {
+	trie := New[string]()
+	for _, template := range []string{
+		"/users/{id}/orders",
+		"/users/me/orders",
+		"/static/**",
+		"/api/*/v2",
+		"/a/**/z",
+		"/a/**/**/z",
+		"/",
+		"/Case",
+	} {
+		assert.Nil(t, trie.Insert(template, template))
+	}
+	assert.Nil(t, trie.Insert("/api/*/v2", "again"))
+	assert.NotNil(t, trie.Insert("api", "bad"))
+	assert.Equal(t, 9, trie.Len())
+
+	match := func(path string) []string {
+		res := make([]string, 0)
+		trie.Match(path, func(v string) bool {
+			res = append(res, v)
+			return true
+		})
+		sort.Strings(res)
+		return res
+	}
+	assert.Equal(t, []string{"/users/{id}/orders"}, match("/users/42/orders"))
+	assert.Equal(t, []string{"/users/me/orders", "/users/{id}/orders"}, match("/users/me/orders"))
+	assert.Empty(t, match("/users//orders"))
+	assert.Empty(t, match("/users/42/orders/1"))
+	assert.Equal(t, []string{"/static/**"}, match("/static"))
+	assert.Equal(t, []string{"/static/**"}, match("/static/css/site.css"))
+	assert.Equal(t, []string{"/api/*/v2", "again"}, match("/api/billing/v2"))
+	assert.Empty(t, match("/api/billing/v3"))
+	assert.Equal(t, []string{"/a/**/**/z", "/a/**/z"}, match("/a/b/c/z"))
+	assert.Equal(t, []string{"/a/**/**/z", "/a/**/z"}, match("/a/z"))
+	assert.Equal(t, []string{"/"}, match("/"))
+	assert.Equal(t, []string{"/Case"}, match("/Case"))
+	assert.Empty(t, match("/case"))
+	assert.Empty(t, match("relative"))
+
+	// stop matching
+	calls := 0
+	trie.Match("/api/billing/v2", func(string) bool {
+		calls++
+		return false
+	})
+	assert.Equal(t, 1, calls)
+}
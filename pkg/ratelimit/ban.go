@@ -0,0 +1,297 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BanPrefix is prefix of storage keys of bans
+const BanPrefix = "ban:"
+
+// DefaultBanRefresh is how often bans made by other replicas are read from storage
+const DefaultBanRefresh = time.Second
+
+// ErrInvalidBanTarget is error of empty ban target or target with spaces
+var ErrInvalidBanTarget = errors.New("invalid ban target")
+
+// Ban of IP, subnet or client key
+type Ban struct {
+	// Target is IP, subnet in CIDR notation or client key
+	Target    string    `json:"target"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt nil if the ban is permanent
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Expired check is the ban expired at now
+func (b Ban) Expired(now time.Time) bool {
+	return b.ExpiresAt != nil && !now.Before(*b.ExpiresAt)
+}
+
+// ParseBanTarget normalize ban target: IP and subnet are written in canonical form, other targets are client keys
+func ParseBanTarget(target string) (string, error) {
+	target = strings.TrimSpace(target)
+	if target == "" || strings.ContainsAny(target, " \t\r\n") {
+		return "", ErrInvalidBanTarget
+	}
+
+	if strings.Contains(target, "/") {
+		if _, ipNet, err := net.ParseCIDR(target); err == nil {
+			return ipNet.String(), nil
+		}
+	}
+	if ip := net.ParseIP(target); ip != nil {
+		return ip.String(), nil
+	}
+
+	return target, nil
+}
+
+// Banlist is list of bans kept in storage, so it is shared by replicas of the storage.
+// Bans are checked by local copy that is refreshed from storage by Start.
+type Banlist struct {
+	storage Storager
+	clock   clock.Clock
+	refresh time.Duration
+
+	mu   sync.RWMutex
+	keys map[string]Ban
+	nets []bannedNet
+}
+
+// scanValues call f with every key with prefix and its value. ErrNotSupported if storage is not Scanner.
+func scanValues(ctx context.Context, s Storager, prefix string, f func(key, value []byte)) error {
+	scanner, ok := s.(Scanner)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	cursor := ""
+	for {
+		keys, next, err := scanner.Scan(ctx, []byte(prefix), cursor, storage.DefaultScanCount)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			f(key.Key, key.Value)
+		}
+
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// bannedNet is ban of subnet
+type bannedNet struct {
+	ipNet *net.IPNet
+	ban   Ban
+}
+
+// BanlistOption of Banlist
+type BanlistOption func(*Banlist)
+
+// WithBanClock set clock of ban expirations
+func WithBanClock(c clock.Clock) BanlistOption {
+	return func(b *Banlist) {
+		b.clock = c
+	}
+}
+
+// WithBanRefresh set how often bans are read from storage
+func WithBanRefresh(d time.Duration) BanlistOption {
+	return func(b *Banlist) {
+		b.refresh = d
+	}
+}
+
+// NewBanlist create list of bans in storage. Storage must be Scanner to read bans of other replicas.
+func NewBanlist(s Storager, opts ...BanlistOption) *Banlist {
+	b := &Banlist{
+		storage: s,
+		clock:   clock.Real,
+		refresh: DefaultBanRefresh,
+		keys:    make(map[string]Ban),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+// Start read bans from storage every refresh interval until ctx is done
+func (b *Banlist) Start(ctx context.Context) {
+	ticker := b.clock.NewTicker(b.refresh)
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C():
+				if err := b.Refresh(ctx); err != nil {
+					log.Printf("banlist refresh error: %s", err.Error())
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Ban target for d, zero d is permanent ban. Existing ban of the target is replaced.
+func (b *Banlist) Ban(ctx context.Context, target string, d time.Duration, reason string) (Ban, error) {
+	target, err := ParseBanTarget(target)
+	if err != nil {
+		return Ban{}, err
+	}
+	if d < 0 {
+		return Ban{}, fmt.Errorf("negative ban duration %s", d)
+	}
+
+	now := b.clock.Now()
+	ban := Ban{Target: target, Reason: reason, CreatedAt: now}
+	var ttl *uint64
+	if d > 0 {
+		expiresAt := now.Add(d)
+		ban.ExpiresAt = &expiresAt
+		seconds := uint64((d + time.Second - 1) / time.Second)
+		ttl = &seconds
+	}
+
+	value, err := json.Marshal(ban)
+	if err != nil {
+		return Ban{}, err
+	}
+	// Set keeps ttl of existing key, so ban is replaced by new key
+	if err = b.storage.Del(ctx, []byte(BanPrefix+target)); err != nil {
+		return Ban{}, err
+	}
+	if err = b.storage.Set(ctx, []byte(BanPrefix+target), value, ttl); err != nil {
+		return Ban{}, err
+	}
+
+	b.mu.Lock()
+	b.remove(target)
+	b.add(ban)
+	b.mu.Unlock()
+
+	return ban, nil
+}
+
+// Unban lift ban of target
+func (b *Banlist) Unban(ctx context.Context, target string) error {
+	target, err := ParseBanTarget(target)
+	if err != nil {
+		return err
+	}
+
+	if err = b.storage.Del(ctx, []byte(BanPrefix+target)); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.remove(target)
+	b.mu.Unlock()
+
+	return nil
+}
+
+// List return bans in storage that are not expired
+func (b *Banlist) List(ctx context.Context) ([]Ban, error) {
+	now := b.clock.Now()
+	res := make([]Ban, 0)
+	err := scanValues(ctx, b.storage, BanPrefix, func(key, value []byte) {
+		var ban Ban
+		if err := json.Unmarshal(value, &ban); err != nil {
+			log.Printf("banlist: invalid ban %s: %s", key, err.Error())
+			return
+		}
+		if !ban.Expired(now) {
+			res = append(res, ban)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Refresh replace local copy of bans by bans in storage
+func (b *Banlist) Refresh(ctx context.Context) error {
+	bans, err := b.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.keys = make(map[string]Ban, len(bans))
+	b.nets = nil
+	for _, ban := range bans {
+		b.add(ban)
+	}
+
+	return nil
+}
+
+// Banned check is client key banned by local copy of bans. IP key is also checked by bans of subnets.
+func (b *Banlist) Banned(key string) (Ban, bool) {
+	now := b.clock.Now()
+	ip := net.ParseIP(strings.TrimSpace(key))
+	if ip != nil {
+		key = ip.String()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if ban, ok := b.keys[key]; ok && !ban.Expired(now) {
+		return ban, true
+	}
+	if ip == nil {
+		return Ban{}, false
+	}
+	for _, n := range b.nets {
+		if n.ipNet.Contains(ip) && !n.ban.Expired(now) {
+			return n.ban, true
+		}
+	}
+
+	return Ban{}, false
+}
+
+// add ban to local copy. Must be called under mu lock.
+func (b *Banlist) add(ban Ban) {
+	if strings.Contains(ban.Target, "/") {
+		if _, ipNet, err := net.ParseCIDR(ban.Target); err == nil {
+			b.nets = append(b.nets, bannedNet{ipNet: ipNet, ban: ban})
+			return
+		}
+	}
+	b.keys[ban.Target] = ban
+}
+
+// remove ban of target from local copy. Must be called under mu lock.
+func (b *Banlist) remove(target string) {
+	delete(b.keys, target)
+	for i, n := range b.nets {
+		if n.ban.Target == target {
+			b.nets = append(b.nets[:i], b.nets[i+1:]...)
+			return
+		}
+	}
+}
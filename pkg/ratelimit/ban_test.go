@@ -0,0 +1,142 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// TestParseBanTarget test ParseBanTarget function
+func TestParseBanTarget(t *testing.T) {
+	for target, want := range map[string]string{
+		"10.0.0.1":         "10.0.0.1",
+		" 10.0.0.1 ":       "10.0.0.1",
+		"10.0.0.7/24":      "10.0.0.0/24",
+		"2001:db8::1":      "2001:db8::1",
+		"2001:DB8::/32":    "2001:db8::/32",
+		"customer-42":      "customer-42",
+		"not/a/cidr":       "not/a/cidr",
+		"0:0:0:0:0:0:0:1":  "::1",
+		"10.0.0.0/8":       "10.0.0.0/8",
+		"api-key:abcdef01": "api-key:abcdef01",
+	} {
+		got, err := ParseBanTarget(target)
+		assert.Nil(t, err, target)
+		assert.Equal(t, want, got, target)
+	}
+
+	for _, target := range []string{"", "  ", "a b"} {
+		_, err := ParseBanTarget(target)
+		assert.ErrorIs(t, err, ErrInvalidBanTarget, target)
+	}
+}
+
+// TestBanlist_Ban test Ban, Banned and Unban functions
+func TestBanlist_Ban(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewManual(time.Unix(1700000000, 0))
+	store := storage.NewMemoryCache(storage.WithClock(clk))
+	b := NewBanlist(store, WithBanClock(clk))
+
+	_, err := b.Ban(ctx, "10.0.0.0/24", time.Minute, "abuse")
+	assert.Nil(t, err)
+	_, err = b.Ban(ctx, "customer-42", 0, "")
+	assert.Nil(t, err)
+	_, err = b.Ban(ctx, "10.0.1.1", time.Hour, "")
+	assert.Nil(t, err)
+	_, err = b.Ban(ctx, "10.0.2.1", -time.Hour, "")
+	assert.NotNil(t, err)
+
+	ban, ok := b.Banned("10.0.0.7")
+	assert.True(t, ok)
+	assert.Equal(t, "10.0.0.0/24", ban.Target)
+	assert.Equal(t, "abuse", ban.Reason)
+	assert.Equal(t, clk.Now().Add(time.Minute), *ban.ExpiresAt)
+
+	ban, ok = b.Banned("customer-42")
+	assert.True(t, ok)
+	assert.Nil(t, ban.ExpiresAt)
+
+	_, ok = b.Banned("10.0.1.1")
+	assert.True(t, ok)
+	_, ok = b.Banned("10.0.3.1")
+	assert.False(t, ok)
+
+	bans, err := b.List(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, bans, 3)
+
+	clk.Advance(time.Minute)
+	_, ok = b.Banned("10.0.0.7")
+	assert.False(t, ok, "ban is expired")
+	bans, err = b.List(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, bans, 2)
+
+	assert.Nil(t, b.Unban(ctx, "10.0.1.1"))
+	_, ok = b.Banned("10.0.1.1")
+	assert.False(t, ok)
+	assert.False(t, store.Has(ctx, []byte(BanPrefix+"10.0.1.1")))
+
+	// ban is replaced with new expiration
+	_, err = b.Ban(ctx, "customer-42", time.Second, "")
+	assert.Nil(t, err)
+	clk.Advance(time.Second)
+	_, ok = b.Banned("customer-42")
+	assert.False(t, ok)
+	assert.False(t, store.Has(ctx, []byte(BanPrefix+"customer-42")))
+}
+
+// TestBanlist_Start test bans of other replica are read from shared storage
+func TestBanlist_Start(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clk := clock.NewManual(time.Unix(1700000000, 0))
+	store := storage.NewMemoryCache(storage.WithClock(clk))
+	a := NewBanlist(store, WithBanClock(clk))
+	b := NewBanlist(store, WithBanClock(clk), WithBanRefresh(time.Second))
+	b.Start(ctx)
+
+	_, err := a.Ban(ctx, "10.0.0.0/24", 0, "")
+	assert.Nil(t, err)
+	_, ok := b.Banned("10.0.0.1")
+	assert.False(t, ok)
+
+	clk.Advance(time.Second)
+	assert.Eventually(t, func() bool {
+		_, ok := b.Banned("10.0.0.1")
+		return ok
+	}, time.Second, time.Millisecond)
+
+	assert.Nil(t, a.Unban(ctx, "10.0.0.0/24"))
+	assert.Eventually(t, func() bool {
+		clk.Advance(time.Second)
+		_, ok := b.Banned("10.0.0.1")
+		return !ok
+	}, time.Second, time.Millisecond)
+
+	assert.ErrorIs(t, NewBanlist(struct{ Storager }{store}).Refresh(ctx), ErrNotSupported)
+}
+
+// TestRateLimit_Banned test Banned function
+func TestRateLimit_Banned(t *testing.T) {
+	ctx := context.Background()
+	cfg := TmpConfig()
+	store := storage.NewMemoryCache()
+
+	rl := NewRateLimit(&cfg, store)
+	_, ok := rl.Banned(ctx, "10.0.0.1")
+	assert.False(t, ok)
+
+	bans := NewBanlist(store)
+	_, err := bans.Ban(ctx, "10.0.0.1", 0, "")
+	assert.Nil(t, err)
+
+	rl = NewRateLimit(&cfg, store, WithBanlist(bans))
+	_, ok = rl.Banned(ctx, "10.0.0.1")
+	assert.True(t, ok)
+}
@@ -0,0 +1,370 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by CircuitBreaker while storage calls are not allowed
+var ErrCircuitOpen = errors.New("storage circuit breaker is open")
+
+// ErrNotSupported is returned by storage wrappers when wrapped storage has no such operation
+var ErrNotSupported = errors.New("storage operation is not supported")
+
+// CircuitState state of CircuitBreaker
+type CircuitState int
+
+const (
+	// CircuitClosed storage calls are allowed
+	CircuitClosed CircuitState = iota
+	// CircuitOpen storage calls fail fast with ErrCircuitOpen
+	CircuitOpen
+	// CircuitHalfOpen one probe call is allowed, its result closes or opens the circuit again
+	CircuitHalfOpen
+)
+
+// String name of state
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+
+	return "closed"
+}
+
+// CircuitBreaker is Storager wrapper that stops calling failing storage.
+// After threshold consecutive failures the circuit opens for openTimeout,
+// then one probe call is allowed in half-open state.
+// storage.ValueNotFoundByKey is not a failure.
+type CircuitBreaker struct {
+	storage     Storager
+	threshold   int
+	openTimeout time.Duration
+	clock       clock.Clock
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+	// generation is changed on every state change, results of calls allowed in older generation are ignored
+	generation uint64
+}
+
+// CircuitBreakerOption configure CircuitBreaker
+type CircuitBreakerOption func(cb *CircuitBreaker)
+
+// WithBreakerClock set clock of open timeout. clock.Real by default.
+func WithBreakerClock(clk clock.Clock) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.clock = clock.OrReal(clk)
+	}
+}
+
+// NewCircuitBreaker wrap storage by circuit breaker. threshold less than 1 is 1.
+func NewCircuitBreaker(s Storager, threshold int, openTimeout time.Duration, opts ...CircuitBreakerOption) *CircuitBreaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+
+	cb := &CircuitBreaker{
+		storage:     s,
+		threshold:   threshold,
+		openTimeout: openTimeout,
+		clock:       clock.Real,
+	}
+	for _, opt := range opts {
+		opt(cb)
+	}
+
+	return cb
+}
+
+// State return current state
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == CircuitOpen && cb.clock.Now().Sub(cb.openedAt) >= cb.openTimeout {
+		return CircuitHalfOpen
+	}
+
+	return cb.state
+}
+
+// Get value by key
+func (cb *CircuitBreaker) Get(ctx context.Context, key []byte) ([]byte, error) {
+	gen, err := cb.allow()
+	if err != nil {
+		return nil, err
+	}
+
+	val, err := cb.storage.Get(ctx, key)
+	cb.done(gen, err)
+	return val, err
+}
+
+// Set value by key
+func (cb *CircuitBreaker) Set(ctx context.Context, key []byte, value []byte, ttl *uint64) error {
+	gen, err := cb.allow()
+	if err != nil {
+		return err
+	}
+
+	err = cb.storage.Set(ctx, key, value, ttl)
+	cb.done(gen, err)
+	return err
+}
+
+// Del values by keys
+func (cb *CircuitBreaker) Del(ctx context.Context, list ...[]byte) error {
+	gen, err := cb.allow()
+	if err != nil {
+		return err
+	}
+
+	err = cb.storage.Del(ctx, list...)
+	cb.done(gen, err)
+	return err
+}
+
+// Has check is set value by key. False unless the circuit is closed.
+// Errors of Has are not visible, so its calls are neither probes nor results of the circuit.
+func (cb *CircuitBreaker) Has(ctx context.Context, key []byte) bool {
+	if cb.State() != CircuitClosed {
+		return false
+	}
+
+	return cb.storage.Has(ctx, key)
+}
+
+// Inc value by key
+func (cb *CircuitBreaker) Inc(ctx context.Context, key []byte, ttl *uint64) (int64, error) {
+	gen, err := cb.allow()
+	if err != nil {
+		return 0, err
+	}
+
+	val, err := cb.storage.Inc(ctx, key, ttl)
+	cb.done(gen, err)
+	return val, err
+}
+
+// Decr value by key
+func (cb *CircuitBreaker) Decr(ctx context.Context, key []byte, ttl *uint64) (int64, error) {
+	gen, err := cb.allow()
+	if err != nil {
+		return 0, err
+	}
+
+	val, err := cb.storage.Decr(ctx, key, ttl)
+	cb.done(gen, err)
+	return val, err
+}
+
+// Clear all
+func (cb *CircuitBreaker) Clear(ctx context.Context) error {
+	gen, err := cb.allow()
+	if err != nil {
+		return err
+	}
+
+	err = cb.storage.Clear(ctx)
+	cb.done(gen, err)
+	return err
+}
+
+// TTL of key. ErrNotSupported if wrapped storage is not ExtendedStorager.
+func (cb *CircuitBreaker) TTL(ctx context.Context, key []byte) (time.Duration, error) {
+	ext, ok := cb.storage.(ExtendedStorager)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+	gen, err := cb.allow()
+	if err != nil {
+		return 0, err
+	}
+
+	ttl, err := ext.TTL(ctx, key)
+	cb.done(gen, err)
+	return ttl, err
+}
+
+// IncrBy value by key. ErrNotSupported if wrapped storage is not ExtendedStorager.
+func (cb *CircuitBreaker) IncrBy(ctx context.Context, key []byte, n int64, ttl *uint64) (int64, time.Duration, error) {
+	ext, ok := cb.storage.(ExtendedStorager)
+	if !ok {
+		return 0, 0, ErrNotSupported
+	}
+	gen, err := cb.allow()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	val, left, err := ext.IncrBy(ctx, key, n, ttl)
+	cb.done(gen, err)
+	return val, left, err
+}
+
+// CompareAndSwap value by key. ErrNotSupported if wrapped storage is not ExtendedStorager.
+func (cb *CircuitBreaker) CompareAndSwap(ctx context.Context, key []byte, old, newValue []byte, ttl *uint64) (bool, error) {
+	ext, ok := cb.storage.(ExtendedStorager)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	gen, err := cb.allow()
+	if err != nil {
+		return false, err
+	}
+
+	swapped, err := ext.CompareAndSwap(ctx, key, old, newValue, ttl)
+	cb.done(gen, err)
+	return swapped, err
+}
+
+// SetNX value by key. ErrNotSupported if wrapped storage is not ExtendedStorager.
+func (cb *CircuitBreaker) SetNX(ctx context.Context, key []byte, value []byte, ttl *uint64) (bool, error) {
+	ext, ok := cb.storage.(ExtendedStorager)
+	if !ok {
+		return false, ErrNotSupported
+	}
+	gen, err := cb.allow()
+	if err != nil {
+		return false, err
+	}
+
+	set, err := ext.SetNX(ctx, key, value, ttl)
+	cb.done(gen, err)
+	return set, err
+}
+
+// Keys with prefix. ErrNotSupported if wrapped storage is not KeyLister.
+func (cb *CircuitBreaker) Keys(ctx context.Context, prefix []byte) ([][]byte, error) {
+	lister, ok := cb.storage.(KeyLister)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	gen, err := cb.allow()
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := lister.Keys(ctx, prefix)
+	cb.done(gen, err)
+	return keys, err
+}
+
+// DelPrefix delete keys with prefix. ErrNotSupported if wrapped storage is not KeyLister.
+func (cb *CircuitBreaker) DelPrefix(ctx context.Context, prefix []byte) error {
+	lister, ok := cb.storage.(KeyLister)
+	if !ok {
+		return ErrNotSupported
+	}
+	gen, err := cb.allow()
+	if err != nil {
+		return err
+	}
+
+	err = lister.DelPrefix(ctx, prefix)
+	cb.done(gen, err)
+	return err
+}
+
+// Scan keys. ErrNotSupported if wrapped storage is not Scanner.
+func (cb *CircuitBreaker) Scan(ctx context.Context, prefix []byte, cursor string, count int) ([]storage.KeyInfo, string, error) {
+	scanner, ok := cb.storage.(Scanner)
+	if !ok {
+		return nil, "", ErrNotSupported
+	}
+	gen, err := cb.allow()
+	if err != nil {
+		return nil, "", err
+	}
+
+	keys, next, err := scanner.Scan(ctx, prefix, cursor, count)
+	cb.done(gen, err)
+	return keys, next, err
+}
+
+// CacheBlocked forward blocked key to wrapped storage if it is BlockCacher
+func (cb *CircuitBreaker) CacheBlocked(ctx context.Context, key []byte, value int64, ttl time.Duration) {
+	if cacher, ok := cb.storage.(BlockCacher); ok {
+		cacher.CacheBlocked(ctx, key, value, ttl)
+	}
+}
+
+// allow check is call allowed in current state, return generation the call is allowed in
+func (cb *CircuitBreaker) allow() (uint64, error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case CircuitOpen:
+		if cb.clock.Now().Sub(cb.openedAt) < cb.openTimeout {
+			return 0, ErrCircuitOpen
+		}
+		cb.setState(CircuitHalfOpen)
+		cb.probing = true
+	case CircuitHalfOpen:
+		if cb.probing {
+			return 0, ErrCircuitOpen
+		}
+		cb.probing = true
+	}
+
+	return cb.generation, nil
+}
+
+// done record result of call allowed in generation gen. Result of stale generation is ignored,
+// so in half-open state only the probe closes or opens the circuit.
+func (cb *CircuitBreaker) done(gen uint64, err error) {
+	failed := err != nil && !errors.Is(err, storage.ValueNotFoundByKey)
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if gen != cb.generation {
+		return
+	}
+
+	if cb.state == CircuitHalfOpen {
+		cb.probing = false
+		if failed {
+			cb.open()
+			return
+		}
+
+		cb.setState(CircuitClosed)
+		cb.failures = 0
+		return
+	}
+
+	if !failed {
+		cb.failures = 0
+		return
+	}
+
+	cb.failures++
+	if cb.failures >= cb.threshold {
+		cb.open()
+	}
+}
+
+// open the circuit from now
+func (cb *CircuitBreaker) open() {
+	cb.setState(CircuitOpen)
+	cb.openedAt = cb.clock.Now()
+}
+
+// setState change state and start new generation
+func (cb *CircuitBreaker) setState(state CircuitState) {
+	cb.state = state
+	cb.generation++
+}
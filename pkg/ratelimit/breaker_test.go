@@ -0,0 +1,198 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errStorageDown = errors.New("storage is down")
+
+// flakyStorage is MemoryCache that fails all calls while down
+type flakyStorage struct {
+	*storage.MemoryCache
+	down  atomic.Bool
+	calls atomic.Int64
+}
+
+func newFlakyStorage() *flakyStorage {
+	return &flakyStorage{MemoryCache: storage.NewMemoryCache()}
+}
+
+func (f *flakyStorage) Get(ctx context.Context, key []byte) ([]byte, error) {
+	f.calls.Add(1)
+	if f.down.Load() {
+		return nil, errStorageDown
+	}
+	return f.MemoryCache.Get(ctx, key)
+}
+
+func (f *flakyStorage) Inc(ctx context.Context, key []byte, ttl *uint64) (int64, error) {
+	f.calls.Add(1)
+	if f.down.Load() {
+		return 0, errStorageDown
+	}
+	return f.MemoryCache.Inc(ctx, key, ttl)
+}
+
+func (f *flakyStorage) Del(ctx context.Context, list ...[]byte) error {
+	f.calls.Add(1)
+	if f.down.Load() {
+		return errStorageDown
+	}
+	return f.MemoryCache.Del(ctx, list...)
+}
+
+// TestCircuitBreaker test circuit breaker states
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	flaky := newFlakyStorage()
+	clk := clock.NewManual(time.Now())
+	cb := NewCircuitBreaker(flaky, 2, 200*time.Millisecond, WithBreakerClock(clk))
+	assert.Equal(t, CircuitClosed, cb.State())
+
+	// not found is not a failure
+	for i := 0; i < 3; i++ {
+		_, err := cb.Get(ctx, []byte("key"))
+		assert.Equal(t, storage.ValueNotFoundByKey, err)
+	}
+	assert.Equal(t, CircuitClosed, cb.State())
+
+	flaky.down.Store(true)
+	_, err := cb.Get(ctx, []byte("key"))
+	assert.Equal(t, errStorageDown, err)
+	assert.Equal(t, CircuitClosed, cb.State())
+	_, err = cb.Inc(ctx, []byte("key"), nil)
+	assert.Equal(t, errStorageDown, err)
+	assert.Equal(t, CircuitOpen, cb.State())
+
+	// open circuit fails fast
+	calls := flaky.calls.Load()
+	_, err = cb.Get(ctx, []byte("key"))
+	assert.Equal(t, ErrCircuitOpen, err)
+	assert.False(t, cb.Has(ctx, []byte("key")))
+	assert.Equal(t, calls, flaky.calls.Load())
+
+	// failed probe opens circuit again
+	clk.Advance(200 * time.Millisecond)
+	assert.Equal(t, CircuitHalfOpen, cb.State())
+	_, err = cb.Get(ctx, []byte("key"))
+	assert.Equal(t, errStorageDown, err)
+	assert.Equal(t, CircuitOpen, cb.State())
+
+	// successful probe closes circuit
+	clk.Advance(200 * time.Millisecond)
+	flaky.down.Store(false)
+	assert.False(t, cb.Has(ctx, []byte("key")), "has is not a probe")
+	assert.Equal(t, CircuitHalfOpen, cb.State())
+	gen, err := cb.allow()
+	assert.Nil(t, err)
+	_, err = cb.allow()
+	assert.Equal(t, ErrCircuitOpen, err, "only one probe at a time")
+	cb.done(gen, nil)
+	assert.Equal(t, CircuitClosed, cb.State())
+
+	value, err := cb.Inc(ctx, []byte("key"), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), value)
+}
+
+// TestCircuitBreaker_StaleResult test results of calls allowed before state change are ignored
+func TestCircuitBreaker_StaleResult(t *testing.T) {
+	clk := clock.NewManual(time.Now())
+	cb := NewCircuitBreaker(storage.NewMemoryCache(), 1, time.Second, WithBreakerClock(clk))
+
+	slowOK, err := cb.allow()
+	assert.Nil(t, err)
+	slowFailed, err := cb.allow()
+	assert.Nil(t, err)
+	failed, err := cb.allow()
+	assert.Nil(t, err)
+	cb.done(failed, errStorageDown)
+	assert.Equal(t, CircuitOpen, cb.State())
+
+	// success of call allowed while closed does not close the open circuit
+	cb.done(slowOK, nil)
+	assert.Equal(t, CircuitOpen, cb.State())
+
+	clk.Advance(time.Second)
+	probe, err := cb.allow()
+	assert.Nil(t, err)
+
+	// stale results are not taken as result of the probe
+	cb.done(slowOK, nil)
+	cb.done(slowFailed, errStorageDown)
+	assert.Equal(t, CircuitHalfOpen, cb.State())
+	_, err = cb.allow()
+	assert.Equal(t, ErrCircuitOpen, err, "probe is still running")
+
+	cb.done(probe, nil)
+	assert.Equal(t, CircuitClosed, cb.State())
+
+	// failure of the old probe does not open the closed circuit
+	cb.done(probe, errStorageDown)
+	assert.Equal(t, CircuitClosed, cb.State())
+}
+
+// TestCircuitBreaker_Extended test ExtendedStorager operations
+func TestCircuitBreaker_Extended(t *testing.T) {
+	ctx := context.Background()
+	ttl := uint64(10)
+	cb := NewCircuitBreaker(storage.NewMemoryCache(), 0, time.Second)
+	assert.Equal(t, 1, cb.threshold)
+
+	value, left, err := cb.IncrBy(ctx, []byte("key"), 3, &ttl)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), value)
+	assert.True(t, left > 9*time.Second)
+
+	left, err = cb.TTL(ctx, []byte("key"))
+	assert.Nil(t, err)
+	assert.True(t, left > 9*time.Second)
+
+	swapped, err := cb.CompareAndSwap(ctx, []byte("key"), []byte("3"), []byte("4"), nil)
+	assert.Nil(t, err)
+	assert.True(t, swapped)
+
+	set, err := cb.SetNX(ctx, []byte("key"), []byte("5"), nil)
+	assert.Nil(t, err)
+	assert.False(t, set)
+
+	assert.Nil(t, cb.Set(ctx, []byte("key2"), []byte("1"), nil))
+	value, err = cb.Decr(ctx, []byte("key2"), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), value)
+	assert.Nil(t, cb.Del(ctx, []byte("key2")))
+	assert.Nil(t, cb.Clear(ctx))
+
+	// wrapped storage without extension
+	cb = NewCircuitBreaker(storage.NewShardedMemoryCache(1), 1, time.Second)
+	_, err = cb.TTL(ctx, []byte("key"))
+	assert.Equal(t, ErrNotSupported, err)
+	_, _, err = cb.IncrBy(ctx, []byte("key"), 1, nil)
+	assert.Equal(t, ErrNotSupported, err)
+	_, err = cb.CompareAndSwap(ctx, []byte("key"), nil, nil, nil)
+	assert.Equal(t, ErrNotSupported, err)
+	_, err = cb.SetNX(ctx, []byte("key"), nil, nil)
+	assert.Equal(t, ErrNotSupported, err)
+
+	// keys listing is forwarded
+	assert.Nil(t, cb.Set(ctx, []byte("a:1"), []byte("1"), nil))
+	assert.Nil(t, cb.Set(ctx, []byte("b:1"), []byte("1"), nil))
+	keys, err := cb.Keys(ctx, []byte("a:"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a:1")}, keys)
+	assert.Nil(t, cb.DelPrefix(ctx, []byte("a:")))
+	assert.False(t, cb.Has(ctx, []byte("a:1")))
+	assert.True(t, cb.Has(ctx, []byte("b:1")))
+
+	cb = NewCircuitBreaker(struct{ Storager }{storage.NewMemoryCache()}, 1, time.Second)
+	_, err = cb.Keys(ctx, nil)
+	assert.Equal(t, ErrNotSupported, err)
+	assert.Equal(t, ErrNotSupported, cb.DelPrefix(ctx, nil))
+}
@@ -0,0 +1,251 @@
+package ratelimit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"io"
+	"time"
+)
+
+// DumpFormat is format of storage dump
+type DumpFormat string
+
+const (
+	// DumpJSON is JSON document {"version":1,"entries":[{"key","value","ttl_ms"}]}, keys and values are base64
+	DumpJSON DumpFormat = "json"
+	// DumpBinary is dumpMagic followed by entries of uvarint length prefixed key and value and uvarint ttl in ms
+	DumpBinary DumpFormat = "binary"
+)
+
+// ErrInvalidDump is error of malformed dump
+var ErrInvalidDump = errors.New("invalid dump")
+
+// dumpVersion is version of dump formats
+const dumpVersion = 1
+
+// dumpMagic starts binary dump
+var dumpMagic = []byte("RLDUMP\x01")
+
+// ParseDumpFormat parse format name, empty is DumpJSON
+func ParseDumpFormat(format string) (DumpFormat, error) {
+	switch DumpFormat(format) {
+	case "", DumpJSON:
+		return DumpJSON, nil
+	case DumpBinary:
+		return DumpBinary, nil
+	}
+
+	return "", fmt.Errorf("unknown dump format %q", format)
+}
+
+// dumpEntry is entry of JSON dump. TTLMs is remaining time to live in milliseconds, 0 - without expiration.
+type dumpEntry struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+	TTLMs int64  `json:"ttl_ms,omitempty"`
+}
+
+// Dump write all keys of storage with values and remaining TTLs to w and return count of them.
+// Storage must be Scanner, otherwise ErrNotSupported.
+func Dump(ctx context.Context, s Storager, w io.Writer, format DumpFormat) (int, error) {
+	scanner, ok := s.(Scanner)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+
+	bw := bufio.NewWriter(w)
+	var write func(info storage.KeyInfo, first bool) error
+	switch format {
+	case DumpJSON:
+		if _, err := fmt.Fprintf(bw, "{\"version\":%d,\"entries\":[", dumpVersion); err != nil {
+			return 0, err
+		}
+		write = func(info storage.KeyInfo, first bool) error {
+			if !first {
+				if err := bw.WriteByte(','); err != nil {
+					return err
+				}
+			}
+			data, err := json.Marshal(dumpEntry{Key: info.Key, Value: info.Value, TTLMs: ttlMs(info.TTL)})
+			if err != nil {
+				return err
+			}
+			_, err = bw.Write(data)
+			return err
+		}
+	case DumpBinary:
+		if _, err := bw.Write(dumpMagic); err != nil {
+			return 0, err
+		}
+		write = func(info storage.KeyInfo, _ bool) error {
+			buf := make([]byte, 0, 3*binary.MaxVarintLen64+len(info.Key)+len(info.Value))
+			buf = binary.AppendUvarint(buf, uint64(len(info.Key)))
+			buf = append(buf, info.Key...)
+			buf = binary.AppendUvarint(buf, uint64(len(info.Value)))
+			buf = append(buf, info.Value...)
+			buf = binary.AppendUvarint(buf, uint64(ttlMs(info.TTL)))
+			_, err := bw.Write(buf)
+			return err
+		}
+	default:
+		return 0, fmt.Errorf("unknown dump format %q", format)
+	}
+
+	count := 0
+	cursor := ""
+	for {
+		infos, next, err := scanner.Scan(ctx, nil, cursor, storage.DefaultScanCount)
+		if err != nil {
+			return count, err
+		}
+		for _, info := range infos {
+			if err = write(info, count == 0); err != nil {
+				return count, err
+			}
+			count++
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	if format == DumpJSON {
+		if _, err := bw.WriteString("]}\n"); err != nil {
+			return count, err
+		}
+	}
+
+	return count, bw.Flush()
+}
+
+// Load restore keys of dump from r into storage and return count of them. Format of dump is detected.
+// Existing keys are replaced. TTL is rounded up to seconds.
+func Load(ctx context.Context, s Storager, r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(dumpMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+
+	if bytes.Equal(head, dumpMagic) {
+		_, _ = br.Discard(len(dumpMagic))
+		return loadBinary(ctx, s, br)
+	}
+
+	return loadJSON(ctx, s, br)
+}
+
+// loadJSON restore JSON dump
+func loadJSON(ctx context.Context, s Storager, r io.Reader) (int, error) {
+	var dump struct {
+		Version int         `json:"version"`
+		Entries []dumpEntry `json:"entries"`
+	}
+	if err := json.NewDecoder(r).Decode(&dump); err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidDump, err)
+	}
+	if dump.Version != dumpVersion {
+		return 0, fmt.Errorf("%w: unsupported version %d", ErrInvalidDump, dump.Version)
+	}
+
+	for i, entry := range dump.Entries {
+		if err := restore(ctx, s, entry); err != nil {
+			return i, err
+		}
+	}
+
+	return len(dump.Entries), nil
+}
+
+// loadBinary restore binary dump after magic
+func loadBinary(ctx context.Context, s Storager, r *bufio.Reader) (int, error) {
+	count := 0
+	for {
+		keyLen, err := binary.ReadUvarint(r)
+		if errors.Is(err, io.EOF) {
+			return count, nil
+		}
+		if err != nil {
+			return count, fmt.Errorf("%w: %s", ErrInvalidDump, err)
+		}
+
+		var entry dumpEntry
+		if entry.Key, err = readBytes(r, keyLen); err != nil {
+			return count, err
+		}
+		valueLen, err := binary.ReadUvarint(r)
+		if err != nil {
+			return count, fmt.Errorf("%w: %s", ErrInvalidDump, unexpectedEOF(err))
+		}
+		if entry.Value, err = readBytes(r, valueLen); err != nil {
+			return count, err
+		}
+		ttl, err := binary.ReadUvarint(r)
+		if err != nil {
+			return count, fmt.Errorf("%w: %s", ErrInvalidDump, unexpectedEOF(err))
+		}
+		entry.TTLMs = int64(ttl)
+
+		if err = restore(ctx, s, entry); err != nil {
+			return count, err
+		}
+		count++
+	}
+}
+
+// readBytes read n bytes of binary dump
+func readBytes(r io.Reader, n uint64) ([]byte, error) {
+	if n > 1<<30 {
+		return nil, fmt.Errorf("%w: length %d is too big", ErrInvalidDump, n)
+	}
+
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidDump, unexpectedEOF(err))
+	}
+
+	return data, nil
+}
+
+// unexpectedEOF is error of dump cut in the middle of entry
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// restore entry in storage. The key is deleted first, so its expiration is not kept.
+func restore(ctx context.Context, s Storager, entry dumpEntry) error {
+	if err := s.Del(ctx, entry.Key); err != nil {
+		return err
+	}
+
+	var ttl *uint64
+	if entry.TTLMs > 0 {
+		seconds := uint64((time.Duration(entry.TTLMs)*time.Millisecond + time.Second - 1) / time.Second)
+		ttl = &seconds
+	}
+
+	return s.Set(ctx, entry.Key, entry.Value, ttl)
+}
+
+// ttlMs is remaining ttl in milliseconds for dump, 0 - without expiration
+func ttlMs(ttl time.Duration) int64 {
+	if ttl < 0 {
+		return 0
+	}
+	if ms := ttl.Milliseconds(); ms > 0 {
+		return ms
+	}
+
+	return 1
+}
@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseDumpFormat test ParseDumpFormat function
+func TestParseDumpFormat(t *testing.T) {
+	format, err := ParseDumpFormat("")
+	assert.Nil(t, err)
+	assert.Equal(t, DumpJSON, format)
+	format, err = ParseDumpFormat("binary")
+	assert.Nil(t, err)
+	assert.Equal(t, DumpBinary, format)
+	_, err = ParseDumpFormat("xml")
+	assert.NotNil(t, err)
+}
+
+// TestDump test Dump and Load functions
+func TestDump(t *testing.T) {
+	ctx := context.Background()
+	ttl := uint64(100)
+
+	for _, format := range []DumpFormat{DumpJSON, DumpBinary} {
+		t.Run(string(format), func(t *testing.T) {
+			src := storage.NewMemoryCache()
+			for i := 0; i < storage.DefaultScanCount+5; i++ {
+				_, err := src.Inc(ctx, []byte("counter:"+strings.Repeat("x", i)), &ttl)
+				assert.Nil(t, err)
+			}
+			assert.Nil(t, src.Set(ctx, []byte("blocked"), []byte{0, 1, 0xff}, nil))
+
+			var buf bytes.Buffer
+			n, err := Dump(ctx, src, &buf, format)
+			assert.Nil(t, err)
+			assert.Equal(t, storage.DefaultScanCount+6, n)
+
+			dst := storage.NewMemoryCache()
+			assert.Nil(t, dst.Set(ctx, []byte("blocked"), []byte("old"), &ttl))
+			n, err = Load(ctx, dst, &buf)
+			assert.Nil(t, err)
+			assert.Equal(t, storage.DefaultScanCount+6, n)
+
+			keys, err := dst.Keys(ctx, nil)
+			assert.Nil(t, err)
+			assert.Len(t, keys, storage.DefaultScanCount+6)
+
+			value, err := dst.Inc(ctx, []byte("counter:x"), &ttl)
+			assert.Nil(t, err)
+			assert.Equal(t, int64(2), value)
+			left, err := dst.TTL(ctx, []byte("counter:x"))
+			assert.Nil(t, err)
+			assert.True(t, left > 99*time.Second && left <= 100*time.Second)
+
+			value2, err := dst.Get(ctx, []byte("blocked"))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte{0, 1, 0xff}, value2)
+			left, err = dst.TTL(ctx, []byte("blocked"))
+			assert.Nil(t, err)
+			assert.Equal(t, storage.NoTTL, left)
+		})
+	}
+}
+
+// TestDump_Empty test dump of empty storage
+func TestDump_Empty(t *testing.T) {
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	n, err := Dump(ctx, storage.NewMemoryCache(), &buf, DumpJSON)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, "{\"version\":1,\"entries\":[]}\n", buf.String())
+
+	_, err = Dump(ctx, struct{ Storager }{storage.NewMemoryCache()}, &buf, DumpJSON)
+	assert.ErrorIs(t, err, ErrNotSupported)
+	_, err = Dump(ctx, storage.NewMemoryCache(), &buf, "xml")
+	assert.NotNil(t, err)
+}
+
+// TestLoad_Invalid test Load of malformed dumps
+func TestLoad_Invalid(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewMemoryCache()
+
+	_, err := Load(ctx, mem, strings.NewReader("not a dump"))
+	assert.ErrorIs(t, err, ErrInvalidDump)
+	_, err = Load(ctx, mem, strings.NewReader(`{"version":2,"entries":[]}`))
+	assert.ErrorIs(t, err, ErrInvalidDump)
+
+	n, err := Load(ctx, mem, strings.NewReader("RLDUMP\x01\x01k\x011\x00\x05ab"))
+	assert.ErrorIs(t, err, ErrInvalidDump)
+	assert.Equal(t, 1, n)
+	assert.True(t, mem.Has(ctx, []byte("k")))
+}
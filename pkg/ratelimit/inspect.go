@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"strconv"
+	"time"
+)
+
+// RuleState is live state of rule
+type RuleState struct {
+	Rule ByIpData
+	// Limit of the client of ctx with applied override
+	Limit int64
+	// Override applied to Limit, nil if there is none
+	Override  *Override
+	Counter   int64
+	Remaining int64
+	Blocked   bool
+	// Reset time until counter is reset, zero if there is no counter
+	Reset time.Duration
+}
+
+// Inspect return live state of rules by IDs. Unknown IDs are skipped. Limit is overridden for client of ctx.
+func (rl *rateLimit) Inspect(ctx context.Context, ids []string) ([]RuleState, error) {
+	cfg := rl.GetConfig()
+	res := make([]RuleState, 0, len(ids))
+	for _, storeID := range ids {
+		for _, byIpData := range cfg.ByIp.Data {
+			if byIpData.ID != storeID {
+				continue
+			}
+
+			state, err := rl.ruleState(ctx, cfg, byIpData)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, state)
+			break
+		}
+	}
+
+	return res, nil
+}
+
+// Blocked return up to count blocked rules after cursor and cursor of the next page.
+// Page can have less than count rules while next cursor is not empty. Storage must be Scanner.
+func (rl *rateLimit) Blocked(ctx context.Context, cursor string, count int) ([]RuleState, string, error) {
+	scanner, ok := rl.storage.(Scanner)
+	if !ok {
+		return nil, "", ErrNotSupported
+	}
+
+	cfg := rl.GetConfig()
+	rules := make(map[string]ByIpData, len(cfg.ByIp.Data))
+	for _, byIpData := range cfg.ByIp.Data {
+		rules[byIpData.ID] = byIpData
+	}
+
+	callCtx, cancel := rl.callContext(ctx)
+	keys, next, err := scanner.Scan(callCtx, nil, cursor, count)
+	cancel()
+	if err != nil {
+		return nil, "", err
+	}
+
+	res := make([]RuleState, 0)
+	for _, key := range keys {
+		byIpData, ok := rules[string(key.Key)]
+		if !ok {
+			continue
+		}
+
+		counter, err := strconv.ParseInt(string(key.Value), 10, 64)
+		if err != nil || counter < byIpData.Limit {
+			continue
+		}
+
+		state := RuleState{Rule: byIpData, Limit: byIpData.Limit, Counter: counter, Blocked: true, Reset: key.TTL}
+		if key.TTL == storage.NoTTL {
+			state.Reset = 0
+		}
+		res = append(res, state)
+	}
+
+	return res, next, nil
+}
+
+// ruleState read counter of rule
+func (rl *rateLimit) ruleState(ctx context.Context, cfg *Config, byIpData ByIpData) (RuleState, error) {
+	limit, override := rl.limit(ctx, cfg, byIpData)
+	state := RuleState{Rule: byIpData, Limit: limit, Remaining: limit, Override: override}
+
+	c, err := rl.get(ctx, []byte(byIpData.ID))
+	if errors.Is(err, storage.ValueNotFoundByKey) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+
+	state.Counter, err = strconv.ParseInt(string(c), 10, 64)
+	if err != nil {
+		return state, err
+	}
+
+	state.Remaining = limit - state.Counter
+	if state.Remaining < 0 {
+		state.Remaining = 0
+	}
+	state.Blocked = state.Counter >= limit
+
+	state.Reset = time.Duration(byIpData.BlockTime) * time.Second
+	if ext, ok := rl.storage.(ExtendedStorager); ok {
+		callCtx, cancel := rl.callContext(ctx)
+		ttl, err := ext.TTL(callCtx, []byte(byIpData.ID))
+		cancel()
+		if err == nil && ttl >= 0 {
+			state.Reset = ttl
+		}
+	}
+
+	return state, nil
+}
@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// TestInspect test Inspect function
+func TestInspect(t *testing.T) {
+	ctx := context.Background()
+	cfg := TmpConfig()
+	clk := clock.NewManual(time.Unix(0, 0))
+	rl := NewRateLimit(&cfg, storage.NewMemoryCache(storage.WithClock(clk)))
+
+	id := cfg.ByIp.Data[0].ID
+	states, err := rl.Inspect(ctx, []string{id, "unknown"})
+	assert.Nil(t, err)
+	assert.Equal(t, []RuleState{{Rule: cfg.ByIp.Data[0], Limit: 3, Remaining: 3}}, states)
+
+	for i := 0; i < 3; i++ {
+		rl.IncByIDs(ctx, []string{id})
+	}
+	clk.Advance(4 * time.Second)
+
+	states, err = rl.Inspect(ctx, []string{id})
+	assert.Nil(t, err)
+	assert.Equal(t, []RuleState{{Rule: cfg.ByIp.Data[0], Limit: 3, Counter: 3, Blocked: true, Reset: 6 * time.Second}}, states)
+}
+
+// TestBlocked test Blocked function
+func TestBlocked(t *testing.T) {
+	ctx := context.Background()
+	cfg := TmpConfig()
+	rl := NewRateLimit(&cfg, storage.NewMemoryCache(), WithNamespace(Namespace{Limiter: "test", Version: "1"}))
+
+	for _, byIpData := range cfg.ByIp.Data[:3] {
+		for i := 0; i < 3; i++ {
+			rl.IncByIDs(ctx, []string{byIpData.ID})
+		}
+	}
+	rl.IncByIDs(ctx, []string{cfg.ByIp.Data[3].ID})
+
+	var blocked []string
+	cursor := ""
+	for {
+		states, next, err := rl.Blocked(ctx, cursor, 2)
+		assert.Nil(t, err)
+		for _, state := range states {
+			assert.True(t, state.Blocked)
+			assert.Equal(t, int64(3), state.Counter)
+			blocked = append(blocked, state.Rule.ID)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	assert.ElementsMatch(t, []string{cfg.ByIp.Data[0].ID, cfg.ByIp.Data[1].ID, cfg.ByIp.Data[2].ID}, blocked)
+
+	rl = NewRateLimit(&cfg, struct{ Storager }{storage.NewMemoryCache()})
+	_, _, err := rl.Blocked(ctx, "", 10)
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
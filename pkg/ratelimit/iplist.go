@@ -0,0 +1,172 @@
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/itbellissimo/ratelimit/pkg/iptrie"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DenylistReason is reason of bans of IPs in denylist
+const DenylistReason = "denylist"
+
+// IPList is static list of IPs and subnets loaded from files. Lookups take prefix length steps
+// whatever the size of the list. Files are reloaded by Watch when they change.
+type IPList struct {
+	files []string
+
+	mu   sync.RWMutex
+	trie *iptrie.Trie[string]
+}
+
+// NewIPList create empty list of files, call Load to read them
+func NewIPList(files ...string) *IPList {
+	return &IPList{files: files, trie: iptrie.New[string]()}
+}
+
+// ParseIPList add IPs and subnets of r to trie, one per line. Blank lines and text after # are skipped.
+func ParseIPList(r io.Reader, trie *iptrie.Trie[string]) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+
+		if strings.Contains(text, "/") {
+			_, ipNet, err := net.ParseCIDR(text)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			if err = trie.Insert(ipNet, ipNet.String()); err != nil {
+				return fmt.Errorf("line %d: %w: %s", line, err, text)
+			}
+			continue
+		}
+		ip := net.ParseIP(text)
+		if ip == nil {
+			return fmt.Errorf("line %d: invalid IP address: %s", line, text)
+		}
+		if err := trie.InsertIP(ip, ip.String()); err != nil {
+			return fmt.Errorf("line %d: %w: %s", line, err, text)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Load read all files of list. The list is kept unchanged if some file fails.
+func (l *IPList) Load() error {
+	trie := iptrie.New[string]()
+	for _, name := range l.files {
+		if err := loadIPFile(name, trie); err != nil {
+			return err
+		}
+	}
+
+	l.mu.Lock()
+	l.trie = trie
+	l.mu.Unlock()
+
+	return nil
+}
+
+// Lookup return the longest IP or subnet of list containing ip
+func (l *IPList) Lookup(ip net.IP) (string, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.trie.Lookup(ip)
+}
+
+// Contains check is ip in list
+func (l *IPList) Contains(ip net.IP) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.trie.Contains(ip)
+}
+
+// Len is count of IPs and subnets in list
+func (l *IPList) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	return l.trie.Len()
+}
+
+// Watch reload list when its files change until ctx is done. Directories of files are watched,
+// so files replaced by rename are reloaded too. Reload errors are logged and the list is kept.
+func (l *IPList) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	files := make(map[string]bool, len(l.files))
+	dirs := make(map[string]bool)
+	for _, name := range l.files {
+		name = filepath.Clean(name)
+		files[name] = true
+		dir := filepath.Dir(name)
+		if dirs[dir] {
+			continue
+		}
+		if err = watcher.Add(dir); err != nil {
+			_ = watcher.Close()
+			return err
+		}
+		dirs[dir] = true
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !files[filepath.Clean(event.Name)] || event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) == 0 {
+					continue
+				}
+				if err := l.Load(); err != nil {
+					log.Printf("ip list reload error: %s", err.Error())
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("ip list watch error: %s", err.Error())
+			}
+		}
+	}()
+
+	return nil
+}
+
+// loadIPFile add IPs and subnets of file to trie
+func loadIPFile(name string, trie *iptrie.Trie[string]) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err = ParseIPList(f, trie); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestIPList_Load test ParseIPList, Load and Lookup functions
+func TestIPList_Load(t *testing.T) {
+	dir := t.TempDir()
+	deny := filepath.Join(dir, "deny.txt")
+	assert.Nil(t, os.WriteFile(deny, []byte("# bad ranges\n\n123.45.67.0/24 # scanner\n 10.0.0.1 \n2001:db8::/32\n"), 0o600))
+	more := filepath.Join(dir, "more.txt")
+	assert.Nil(t, os.WriteFile(more, []byte("123.45.67.89\n"), 0o600))
+
+	list := NewIPList(deny, more)
+	assert.Equal(t, 0, list.Len())
+	assert.Nil(t, list.Load())
+	assert.Equal(t, 4, list.Len())
+
+	prefix, ok := list.Lookup(net.ParseIP("123.45.67.1"))
+	assert.True(t, ok)
+	assert.Equal(t, "123.45.67.0/24", prefix)
+	prefix, _ = list.Lookup(net.ParseIP("123.45.67.89"))
+	assert.Equal(t, "123.45.67.89", prefix)
+	assert.True(t, list.Contains(net.ParseIP("10.0.0.1")))
+	assert.True(t, list.Contains(net.ParseIP("2001:db8::5")))
+	assert.False(t, list.Contains(net.ParseIP("10.0.0.2")))
+
+	// broken file keeps the list
+	assert.Nil(t, os.WriteFile(more, []byte("123.45.67.89\nnot-an-ip\n"), 0o600))
+	err := list.Load()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "line 2")
+	assert.Equal(t, 4, list.Len())
+
+	assert.NotNil(t, NewIPList(filepath.Join(dir, "missing.txt")).Load())
+	assert.NotNil(t, ParseIPList(strings.NewReader("10.0.0.0/33"), nil))
+
+	mapped := NewIPList()
+	assert.Nil(t, ParseIPList(strings.NewReader("::ffff:10.0.0.0/104\n"), mapped.trie))
+	assert.True(t, mapped.Contains(net.ParseIP("10.200.0.1")))
+	assert.False(t, mapped.Contains(net.ParseIP("11.0.0.1")))
+}
+
+// TestIPList_Watch test files are reloaded when they change
+func TestIPList_Watch(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "allow.txt")
+	assert.Nil(t, os.WriteFile(file, []byte("10.0.0.0/8\n"), 0o600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	list := NewIPList(file)
+	assert.Nil(t, list.Load())
+	assert.Nil(t, list.Watch(ctx))
+	assert.False(t, list.Contains(net.ParseIP("192.168.0.1")))
+
+	// replace file by rename like editors do
+	tmp := file + ".tmp"
+	assert.Nil(t, os.WriteFile(tmp, []byte("10.0.0.0/8\n192.168.0.0/16\n"), 0o600))
+	assert.Nil(t, os.Rename(tmp, file))
+	assert.Eventually(t, func() bool {
+		return list.Contains(net.ParseIP("192.168.0.1"))
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+// TestRateLimit_IPLists test denylist is banned and allowlist is not limited
+func TestRateLimit_IPLists(t *testing.T) {
+	ctx := context.Background()
+	deny := NewIPList()
+	assert.Nil(t, ParseIPList(strings.NewReader("123.45.67.0/25\n"), deny.trie))
+	allow := NewIPList()
+	assert.Nil(t, ParseIPList(strings.NewReader("123.45.67.0/24\n123.17.17.1\n"), allow.trie))
+
+	cfg := TmpConfig()
+	rl := NewRateLimit(&cfg, storage.NewMemoryCache(), WithDenylist(deny), WithAllowlist(allow))
+
+	ban, banned := rl.Banned(ctx, "123.45.67.1")
+	assert.True(t, banned)
+	assert.Equal(t, Ban{Target: "123.45.67.0/25", Reason: DenylistReason}, ban)
+	_, banned = rl.Banned(ctx, "123.45.67.200")
+	assert.False(t, banned)
+	_, banned = rl.Banned(ctx, "customer-42")
+	assert.False(t, banned)
+
+	assert.Empty(t, rl.IdsByIP(ctx, "*", "*", "*", "123.45.67.200"))
+	assert.Empty(t, rl.IdsByIP(ctx, "*", "*", "*", "123.17.17.1"))
+	assert.Equal(t, []string{"e61f74f3-d46b-4162-a432-5f0447eb1397"}, rl.IdsByIP(ctx, "*", "*", "*", "123.17.17.2"))
+}
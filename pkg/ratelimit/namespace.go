@@ -0,0 +1,292 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NamespaceSeparator separates parts of namespace and the key
+const NamespaceSeparator = ":"
+
+const (
+	// namespaceOpen and namespaceClose enclose parts of namespace in prefix,
+	// so keys without namespace are not taken for namespaced ones
+	namespaceOpen  = "{"
+	namespaceClose = "}"
+)
+
+// KeyLister is optional extension of Storager to work with keys by prefix
+type KeyLister interface {
+	// Keys return sorted keys with prefix
+	Keys(ctx context.Context, prefix []byte) ([][]byte, error)
+	// DelPrefix delete all keys with prefix
+	DelPrefix(ctx context.Context, prefix []byte) error
+}
+
+// KeyCounter is optional extension of Storager to count keys without listing them
+type KeyCounter interface {
+	// Len return count of keys
+	Len(ctx context.Context) (int, error)
+}
+
+// Namespace isolate keys of one limiter in shared storage
+type Namespace struct {
+	Tenant  string `mapstructure:"tenant"`
+	Limiter string `mapstructure:"limiter"`
+	// Version of limiter config, counters of old version are not used by new one
+	Version string `mapstructure:"version"`
+}
+
+// Prefix of keys of namespace: {tenant:limiter:version}: with escaped parts
+func (ns Namespace) Prefix() string {
+	return namespaceOpen + escapeNamespace(ns.Tenant) + NamespaceSeparator +
+		escapeNamespace(ns.Limiter) + NamespaceSeparator +
+		escapeNamespace(ns.Version) + namespaceClose + NamespaceSeparator
+}
+
+// parseNamespace return namespace of key with prefix of Prefix format, false if key has no namespace
+func parseNamespace(key string) (Namespace, bool) {
+	if !strings.HasPrefix(key, namespaceOpen) {
+		return Namespace{}, false
+	}
+	inner, _, ok := strings.Cut(key[len(namespaceOpen):], namespaceClose+NamespaceSeparator)
+	if !ok {
+		return Namespace{}, false
+	}
+	parts := strings.Split(inner, NamespaceSeparator)
+	if len(parts) != 3 {
+		return Namespace{}, false
+	}
+
+	ns := Namespace{
+		Tenant:  unescapeNamespace(parts[0]),
+		Limiter: unescapeNamespace(parts[1]),
+		Version: unescapeNamespace(parts[2]),
+	}
+	// parts of other format are not written by escapeNamespace
+	if ns.Prefix() != namespaceOpen+inner+namespaceClose+NamespaceSeparator {
+		return Namespace{}, false
+	}
+
+	return ns, true
+}
+
+// String is prefix of namespace without the last separator
+func (ns Namespace) String() string {
+	return strings.TrimSuffix(ns.Prefix(), NamespaceSeparator)
+}
+
+// NamespacedStorage is Storager wrapper that stores keys under namespace prefix
+type NamespacedStorage struct {
+	storage Storager
+	ns      Namespace
+	prefix  []byte
+}
+
+// NewNamespacedStorage wrap storage by namespace
+func NewNamespacedStorage(s Storager, ns Namespace) *NamespacedStorage {
+	return &NamespacedStorage{
+		storage: s,
+		ns:      ns,
+		prefix:  []byte(ns.Prefix()),
+	}
+}
+
+// Namespace of storage
+func (s *NamespacedStorage) Namespace() Namespace {
+	return s.ns
+}
+
+// Get value by key
+func (s *NamespacedStorage) Get(ctx context.Context, key []byte) ([]byte, error) {
+	return s.storage.Get(ctx, s.key(key))
+}
+
+// Set value by key
+func (s *NamespacedStorage) Set(ctx context.Context, key []byte, value []byte, ttl *uint64) error {
+	return s.storage.Set(ctx, s.key(key), value, ttl)
+}
+
+// Del values by keys
+func (s *NamespacedStorage) Del(ctx context.Context, list ...[]byte) error {
+	keys := make([][]byte, len(list))
+	for i, key := range list {
+		keys[i] = s.key(key)
+	}
+
+	return s.storage.Del(ctx, keys...)
+}
+
+// Has check is set value by key
+func (s *NamespacedStorage) Has(ctx context.Context, key []byte) bool {
+	return s.storage.Has(ctx, s.key(key))
+}
+
+// Inc value by key
+func (s *NamespacedStorage) Inc(ctx context.Context, key []byte, ttl *uint64) (int64, error) {
+	return s.storage.Inc(ctx, s.key(key), ttl)
+}
+
+// Decr value by key
+func (s *NamespacedStorage) Decr(ctx context.Context, key []byte, ttl *uint64) (int64, error) {
+	return s.storage.Decr(ctx, s.key(key), ttl)
+}
+
+// Clear all keys of the namespace. ErrNotSupported if wrapped storage is not KeyLister.
+func (s *NamespacedStorage) Clear(ctx context.Context) error {
+	lister, ok := s.storage.(KeyLister)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	return lister.DelPrefix(ctx, s.prefix)
+}
+
+// Keys return sorted keys of the namespace with prefix, without namespace prefix
+func (s *NamespacedStorage) Keys(ctx context.Context, prefix []byte) ([][]byte, error) {
+	lister, ok := s.storage.(KeyLister)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	keys, err := lister.Keys(ctx, s.key(prefix))
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		keys[i] = key[len(s.prefix):]
+	}
+
+	return keys, nil
+}
+
+// DelPrefix delete keys of the namespace with prefix
+func (s *NamespacedStorage) DelPrefix(ctx context.Context, prefix []byte) error {
+	lister, ok := s.storage.(KeyLister)
+	if !ok {
+		return ErrNotSupported
+	}
+
+	return lister.DelPrefix(ctx, s.key(prefix))
+}
+
+// Scan keys of the namespace with prefix, keys are returned without namespace prefix.
+// ErrNotSupported if wrapped storage is not Scanner.
+func (s *NamespacedStorage) Scan(ctx context.Context, prefix []byte, cursor string, count int) ([]storage.KeyInfo, string, error) {
+	scanner, ok := s.storage.(Scanner)
+	if !ok {
+		return nil, "", ErrNotSupported
+	}
+
+	keys, next, err := scanner.Scan(ctx, s.key(prefix), cursor, count)
+	if err != nil {
+		return nil, "", err
+	}
+	for i := range keys {
+		keys[i].Key = keys[i].Key[len(s.prefix):]
+	}
+
+	return keys, next, nil
+}
+
+// TTL of key. ErrNotSupported if wrapped storage is not ExtendedStorager.
+func (s *NamespacedStorage) TTL(ctx context.Context, key []byte) (time.Duration, error) {
+	ext, ok := s.storage.(ExtendedStorager)
+	if !ok {
+		return 0, ErrNotSupported
+	}
+
+	return ext.TTL(ctx, s.key(key))
+}
+
+// IncrBy value by key. ErrNotSupported if wrapped storage is not ExtendedStorager.
+func (s *NamespacedStorage) IncrBy(ctx context.Context, key []byte, n int64, ttl *uint64) (int64, time.Duration, error) {
+	ext, ok := s.storage.(ExtendedStorager)
+	if !ok {
+		return 0, 0, ErrNotSupported
+	}
+
+	return ext.IncrBy(ctx, s.key(key), n, ttl)
+}
+
+// CompareAndSwap value by key. ErrNotSupported if wrapped storage is not ExtendedStorager.
+func (s *NamespacedStorage) CompareAndSwap(ctx context.Context, key []byte, old, newValue []byte, ttl *uint64) (bool, error) {
+	ext, ok := s.storage.(ExtendedStorager)
+	if !ok {
+		return false, ErrNotSupported
+	}
+
+	return ext.CompareAndSwap(ctx, s.key(key), old, newValue, ttl)
+}
+
+// SetNX value by key. ErrNotSupported if wrapped storage is not ExtendedStorager.
+func (s *NamespacedStorage) SetNX(ctx context.Context, key []byte, value []byte, ttl *uint64) (bool, error) {
+	ext, ok := s.storage.(ExtendedStorager)
+	if !ok {
+		return false, ErrNotSupported
+	}
+
+	return ext.SetNX(ctx, s.key(key), value, ttl)
+}
+
+// CacheBlocked forward blocked key to wrapped storage if it is BlockCacher
+func (s *NamespacedStorage) CacheBlocked(ctx context.Context, key []byte, value int64, ttl time.Duration) {
+	if cacher, ok := s.storage.(BlockCacher); ok {
+		cacher.CacheBlocked(ctx, s.key(key), value, ttl)
+	}
+}
+
+// key with namespace prefix
+func (s *NamespacedStorage) key(key []byte) []byte {
+	res := make([]byte, 0, len(s.prefix)+len(key))
+	res = append(res, s.prefix...)
+	return append(res, key...)
+}
+
+// Namespaces return sorted namespaces of keys in storage. Keys without namespace are skipped.
+// ErrNotSupported if storage is not KeyLister.
+func Namespaces(ctx context.Context, s Storager) ([]Namespace, error) {
+	lister, ok := s.(KeyLister)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	keys, err := lister.Keys(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[Namespace]struct{})
+	res := make([]Namespace, 0)
+	for _, key := range keys {
+		ns, ok := parseNamespace(string(key))
+		if !ok {
+			continue
+		}
+		if _, ok := seen[ns]; ok {
+			continue
+		}
+		seen[ns] = struct{}{}
+		res = append(res, ns)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].String() < res[j].String() })
+
+	return res, nil
+}
+
+// namespaceEscaper escape separator and braces in parts of namespace
+var namespaceEscaper = strings.NewReplacer("%", "%25", NamespaceSeparator, "%3A", namespaceOpen, "%7B", namespaceClose, "%7D")
+
+var namespaceUnescaper = strings.NewReplacer("%3A", NamespaceSeparator, "%7B", namespaceOpen, "%7D", namespaceClose, "%25", "%")
+
+func escapeNamespace(part string) string {
+	return namespaceEscaper.Replace(part)
+}
+
+func unescapeNamespace(part string) string {
+	return namespaceUnescaper.Replace(part)
+}
@@ -0,0 +1,153 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// TestNamespace_Prefix test Prefix function
+func TestNamespace_Prefix(t *testing.T) {
+	ns := Namespace{Tenant: "acme", Limiter: "api", Version: "2"}
+	assert.Equal(t, "{acme:api:2}:", ns.Prefix())
+	assert.Equal(t, "{acme:api:2}", ns.String())
+
+	ns = Namespace{Tenant: "a:b", Limiter: "100%"}
+	assert.Equal(t, "{a%3Ab:100%25:}:", ns.Prefix())
+	ns = Namespace{Tenant: "{x}", Limiter: "}:"}
+	assert.Equal(t, "{%7Bx%7D:%7D%3A:}:", ns.Prefix())
+}
+
+// TestParseNamespace test parseNamespace function
+func TestParseNamespace(t *testing.T) {
+	for _, ns := range []Namespace{
+		{Tenant: "acme", Limiter: "api", Version: "2"},
+		{Tenant: "a:b", Limiter: "100%"},
+		{Tenant: "{x}", Limiter: "}:"},
+		{},
+	} {
+		got, ok := parseNamespace(ns.Prefix() + "rule:with:colons")
+		assert.True(t, ok, ns.Prefix())
+		assert.Equal(t, ns, got)
+	}
+
+	for _, key := range []string{
+		"ban:2001:db8::1",
+		"override:10.0.0.0/8|rule",
+		"a:b:c:rule",
+		"{a:b}:rule",
+		"{a:b:c:d}:rule",
+		"{a:b:c}rule",
+		"{a%3a:b:c}:rule",
+		"{a:b%:c}:rule",
+	} {
+		_, ok := parseNamespace(key)
+		assert.False(t, ok, key)
+	}
+}
+
+// TestNamespacedStorage test keys of namespaces are isolated
+func TestNamespacedStorage(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewMemoryCache()
+	ttl := uint64(10)
+
+	first := NewNamespacedStorage(mem, Namespace{Tenant: "acme", Limiter: "api", Version: "1"})
+	second := NewNamespacedStorage(mem, Namespace{Tenant: "acme", Limiter: "api", Version: "2"})
+	other := NewNamespacedStorage(mem, Namespace{Tenant: "a:b", Limiter: "web"})
+
+	value, err := first.Inc(ctx, []byte("rule"), &ttl)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), value)
+	value, err = second.Inc(ctx, []byte("rule"), &ttl)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), value)
+	_, _ = first.Inc(ctx, []byte("rule"), &ttl)
+	assert.Nil(t, other.Set(ctx, []byte("rule"), []byte("5"), &ttl))
+	_, _ = mem.Inc(ctx, []byte("plain"), &ttl)
+	assert.Nil(t, mem.Set(ctx, []byte("ban:2001:db8::1"), []byte("{}"), nil))
+
+	val, err := first.Get(ctx, []byte("rule"))
+	assert.Nil(t, err)
+	assert.Equal(t, "2", string(val))
+	assert.True(t, mem.Has(ctx, []byte("{acme:api:1}:rule")))
+	assert.False(t, first.Has(ctx, []byte("plain")))
+
+	left, err := first.TTL(ctx, []byte("rule"))
+	assert.Nil(t, err)
+	assert.True(t, left > 0)
+
+	keys, err := first.Keys(ctx, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("rule")}, keys)
+
+	infos, next, err := first.Scan(ctx, nil, "", 10)
+	assert.Nil(t, err)
+	assert.Empty(t, next)
+	assert.Len(t, infos, 1)
+	assert.Equal(t, "rule", string(infos[0].Key))
+	assert.Equal(t, "2", string(infos[0].Value))
+
+	namespaces, err := Namespaces(ctx, mem)
+	assert.Nil(t, err)
+	assert.Equal(t, []Namespace{
+		{Tenant: "a:b", Limiter: "web"},
+		{Tenant: "acme", Limiter: "api", Version: "1"},
+		{Tenant: "acme", Limiter: "api", Version: "2"},
+	}, namespaces)
+
+	// namespaces of storage decorated by circuit breaker
+	cb := NewCircuitBreaker(mem, 1, time.Second)
+	cbNamespaces, err := Namespaces(ctx, cb)
+	assert.Nil(t, err)
+	assert.Equal(t, namespaces, cbNamespaces)
+	wrapped := NewNamespacedStorage(cb, Namespace{Tenant: "a:b", Limiter: "web"})
+	keys, err = wrapped.Keys(ctx, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("rule")}, keys)
+
+	// Clear is scoped to namespace
+	assert.Nil(t, first.Clear(ctx))
+	assert.False(t, first.Has(ctx, []byte("rule")))
+	assert.True(t, second.Has(ctx, []byte("rule")))
+	assert.True(t, other.Has(ctx, []byte("rule")))
+	assert.True(t, mem.Has(ctx, []byte("plain")))
+
+	assert.Nil(t, second.Del(ctx, []byte("rule")))
+	assert.False(t, second.Has(ctx, []byte("rule")))
+
+	// scoped Clear of storage decorated by circuit breaker
+	assert.Nil(t, wrapped.Clear(ctx))
+	assert.False(t, other.Has(ctx, []byte("rule")))
+	assert.True(t, mem.Has(ctx, []byte("plain")))
+
+	// storage without keys listing
+	plain := NewNamespacedStorage(struct{ Storager }{mem}, Namespace{Limiter: "x"})
+	assert.ErrorIs(t, plain.Clear(ctx), ErrNotSupported)
+	_, err = plain.TTL(ctx, []byte("rule"))
+	assert.ErrorIs(t, err, ErrNotSupported)
+	_, _, err = plain.Scan(ctx, nil, "", 10)
+	assert.ErrorIs(t, err, ErrNotSupported)
+	_, err = Namespaces(ctx, plain.storage)
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
+
+// TestWithNamespace test limiters sharing storage don't collide
+func TestWithNamespace(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewMemoryCache()
+	cfg := TmpConfig()
+	id := cfg.ByIp.Data[0].ID
+
+	first := NewRateLimit(&cfg, mem, WithNamespace(Namespace{Limiter: "first"}))
+	second := NewRateLimit(&cfg, mem, WithNamespace(Namespace{Limiter: "second"}))
+
+	for i := int64(0); i < cfg.ByIp.Data[0].Limit; i++ {
+		first.IncByIDs(ctx, []string{id})
+	}
+	assert.True(t, first.IsLimitedByIDs(ctx, []string{id}))
+	assert.False(t, second.IsLimitedByIDs(ctx, []string{id}))
+	assert.False(t, mem.Has(ctx, []byte(id)))
+}
@@ -0,0 +1,321 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"log"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OverridePrefix is prefix of storage keys of overrides
+const OverridePrefix = "override:"
+
+// DefaultOverridesRefresh is how often overrides made by other replicas are read from storage
+const DefaultOverridesRefresh = time.Second
+
+// ErrInvalidOverride is error of override that can't be applied
+var ErrInvalidOverride = errors.New("invalid override")
+
+// Client of request that overrides are matched by
+type Client struct {
+	// Key is client key of middleware, IP by default
+	Key string
+	// App is name of application from X-APP header
+	App string
+}
+
+type clientKey struct{}
+
+// WithClient return context of request of client
+func WithClient(ctx context.Context, c Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, c)
+}
+
+// ClientFromContext return client of request
+func ClientFromContext(ctx context.Context) (Client, bool) {
+	c, ok := ctx.Value(clientKey{}).(Client)
+	return c, ok
+}
+
+// Override replace or multiply limit of rules for client until expiration
+type Override struct {
+	// Target is IP, subnet in CIDR notation or client key. Empty if App is set.
+	Target string `json:"target,omitempty" mapstructure:"target"`
+	// App is name of application. Empty if Target is set.
+	App string `json:"app,omitempty" mapstructure:"app"`
+	// RuleID of overridden rule, empty - every rule
+	RuleID string `json:"rule_id,omitempty" mapstructure:"rule_id"`
+	// Limit replace limit of the rule
+	Limit int64 `json:"limit,omitempty" mapstructure:"limit"`
+	// Multiplier multiply limit of the rule, used when Limit is not set
+	Multiplier float64   `json:"multiplier,omitempty" mapstructure:"multiplier"`
+	ExpiresAt  time.Time `json:"expires_at" mapstructure:"expires_at"`
+	Reason     string    `json:"reason,omitempty" mapstructure:"reason"`
+}
+
+// ID of override, overrides of the same client and rule have the same ID
+func (o Override) ID() string {
+	client := o.Target
+	if o.App != "" {
+		client = "app:" + o.App
+	}
+	if o.RuleID == "" {
+		return client
+	}
+
+	return client + "|" + o.RuleID
+}
+
+// Normalize check override and write its target in canonical form
+func (o Override) Normalize() (Override, error) {
+	if (o.Target == "") == (o.App == "") {
+		return o, fmt.Errorf("%w: exactly one of target and app must be set", ErrInvalidOverride)
+	}
+	if o.Target != "" {
+		target, err := ParseBanTarget(o.Target)
+		if err != nil {
+			return o, fmt.Errorf("%w: target %q", ErrInvalidOverride, o.Target)
+		}
+		o.Target = target
+	}
+	if (o.Limit > 0) == (o.Multiplier > 0) || o.Limit < 0 || o.Multiplier < 0 {
+		return o, fmt.Errorf("%w: exactly one of positive limit and multiplier must be set", ErrInvalidOverride)
+	}
+	if o.ExpiresAt.IsZero() {
+		return o, fmt.Errorf("%w: expires_at must be set", ErrInvalidOverride)
+	}
+
+	return o, nil
+}
+
+// Apply override to limit of rule
+func (o Override) Apply(limit int64) int64 {
+	if o.Limit > 0 {
+		return o.Limit
+	}
+
+	return int64(math.Round(float64(limit) * o.Multiplier))
+}
+
+// matches check is override of client and rule at now
+func (o Override) matches(c Client, ip net.IP, ruleID string, now time.Time) bool {
+	if o.RuleID != "" && o.RuleID != ruleID {
+		return false
+	}
+	if !now.Before(o.ExpiresAt) {
+		return false
+	}
+	if o.App != "" {
+		return o.App == c.App
+	}
+	if o.Target == c.Key {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	if strings.Contains(o.Target, "/") {
+		_, ipNet, err := net.ParseCIDR(o.Target)
+		return err == nil && ipNet.Contains(ip)
+	}
+
+	return o.Target == ip.String()
+}
+
+// matchOverride return override of client and rule. Override of the rule wins over override of every rule,
+// otherwise the first one in list wins.
+func matchOverride(list []Override, c Client, ruleID string, now time.Time) (Override, bool) {
+	ip := net.ParseIP(strings.TrimSpace(c.Key))
+
+	var wildcard *Override
+	for i := range list {
+		if !list[i].matches(c, ip, ruleID, now) {
+			continue
+		}
+		if list[i].RuleID != "" {
+			return list[i], true
+		}
+		if wildcard == nil {
+			wildcard = &list[i]
+		}
+	}
+	if wildcard != nil {
+		return *wildcard, true
+	}
+
+	return Override{}, false
+}
+
+// Overrides is table of overrides kept in storage, so it is shared by replicas of the storage.
+// Overrides are matched by local copy that is refreshed from storage by Start.
+type Overrides struct {
+	storage Storager
+	clock   clock.Clock
+	refresh time.Duration
+
+	mu   sync.RWMutex
+	list []Override
+}
+
+// OverridesOption of Overrides
+type OverridesOption func(*Overrides)
+
+// WithOverridesClock set clock of override expirations
+func WithOverridesClock(c clock.Clock) OverridesOption {
+	return func(o *Overrides) {
+		o.clock = c
+	}
+}
+
+// WithOverridesRefresh set how often overrides are read from storage
+func WithOverridesRefresh(d time.Duration) OverridesOption {
+	return func(o *Overrides) {
+		o.refresh = d
+	}
+}
+
+// NewOverrides create table of overrides in storage. Storage must be Scanner to read overrides of other replicas.
+func NewOverrides(s Storager, opts ...OverridesOption) *Overrides {
+	o := &Overrides{
+		storage: s,
+		clock:   clock.Real,
+		refresh: DefaultOverridesRefresh,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// Start read overrides from storage every refresh interval until ctx is done
+func (o *Overrides) Start(ctx context.Context) {
+	ticker := o.clock.NewTicker(o.refresh)
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C():
+				if err := o.Refresh(ctx); err != nil {
+					log.Printf("overrides refresh error: %s", err.Error())
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Set override until its expiration. Override with the same ID is replaced.
+func (o *Overrides) Set(ctx context.Context, override Override) (Override, error) {
+	override, err := override.Normalize()
+	if err != nil {
+		return Override{}, err
+	}
+
+	d := override.ExpiresAt.Sub(o.clock.Now())
+	if d <= 0 {
+		return Override{}, fmt.Errorf("%w: expires_at is in the past", ErrInvalidOverride)
+	}
+	ttl := uint64((d + time.Second - 1) / time.Second)
+
+	value, err := json.Marshal(override)
+	if err != nil {
+		return Override{}, err
+	}
+	key := []byte(OverridePrefix + override.ID())
+	// Set keeps ttl of existing key, so override is replaced by new key
+	if err = o.storage.Del(ctx, key); err != nil {
+		return Override{}, err
+	}
+	if err = o.storage.Set(ctx, key, value, &ttl); err != nil {
+		return Override{}, err
+	}
+
+	o.mu.Lock()
+	o.remove(override.ID())
+	o.list = append(o.list, override)
+	o.mu.Unlock()
+
+	return override, nil
+}
+
+// Delete override by ID
+func (o *Overrides) Delete(ctx context.Context, id string) error {
+	if id == "" {
+		return fmt.Errorf("%w: empty id", ErrInvalidOverride)
+	}
+
+	if err := o.storage.Del(ctx, []byte(OverridePrefix+id)); err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	o.remove(id)
+	o.mu.Unlock()
+
+	return nil
+}
+
+// List return overrides in storage that are not expired
+func (o *Overrides) List(ctx context.Context) ([]Override, error) {
+	now := o.clock.Now()
+	res := make([]Override, 0)
+	err := scanValues(ctx, o.storage, OverridePrefix, func(key, value []byte) {
+		var override Override
+		if err := json.Unmarshal(value, &override); err != nil {
+			log.Printf("overrides: invalid override %s: %s", key, err.Error())
+			return
+		}
+		if now.Before(override.ExpiresAt) {
+			res = append(res, override)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Refresh replace local copy of overrides by overrides in storage
+func (o *Overrides) Refresh(ctx context.Context) error {
+	list, err := o.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	o.list = list
+	o.mu.Unlock()
+
+	return nil
+}
+
+// Match return override of client and rule by local copy of overrides
+func (o *Overrides) Match(c Client, ruleID string) (Override, bool) {
+	now := o.clock.Now()
+
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	return matchOverride(o.list, c, ruleID, now)
+}
+
+// remove override by ID from local copy. Must be called under mu lock.
+func (o *Overrides) remove(id string) {
+	for i := range o.list {
+		if o.list[i].ID() == id {
+			o.list = append(o.list[:i], o.list[i+1:]...)
+			return
+		}
+	}
+}
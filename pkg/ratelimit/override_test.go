@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// TestOverride_Normalize test Normalize function
+func TestOverride_Normalize(t *testing.T) {
+	expiresAt := time.Unix(1700000000, 0)
+
+	o, err := Override{Target: "10.0.0.7/24", Multiplier: 2, ExpiresAt: expiresAt}.Normalize()
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.0/24", o.Target)
+	assert.Equal(t, "10.0.0.0/24", o.ID())
+	assert.Equal(t, "app:partner|rule", Override{App: "partner", RuleID: "rule"}.ID())
+
+	for _, o := range []Override{
+		{Limit: 10, ExpiresAt: expiresAt},
+		{Target: "10.0.0.1", App: "partner", Limit: 10, ExpiresAt: expiresAt},
+		{Target: "10.0.0.1", ExpiresAt: expiresAt},
+		{Target: "10.0.0.1", Limit: 10, Multiplier: 2, ExpiresAt: expiresAt},
+		{Target: "10.0.0.1", Limit: -1, ExpiresAt: expiresAt},
+		{Target: "10.0.0.1", Limit: 10},
+		{Target: "a b", Limit: 10, ExpiresAt: expiresAt},
+	} {
+		_, err = o.Normalize()
+		assert.ErrorIs(t, err, ErrInvalidOverride, o)
+	}
+
+	assert.Equal(t, int64(100), Override{Limit: 100}.Apply(3))
+	assert.Equal(t, int64(8), Override{Multiplier: 2.5}.Apply(3))
+}
+
+// TestOverrides_Set test Set, Match, List and Delete functions
+func TestOverrides_Set(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewManual(time.Unix(1700000000, 0))
+	store := storage.NewMemoryCache(storage.WithClock(clk))
+	o := NewOverrides(store, WithOverridesClock(clk))
+
+	_, err := o.Set(ctx, Override{Target: "10.0.0.0/24", Multiplier: 2, ExpiresAt: clk.Now().Add(time.Hour)})
+	assert.Nil(t, err)
+	_, err = o.Set(ctx, Override{Target: "10.0.0.1", RuleID: "b", Limit: 50, ExpiresAt: clk.Now().Add(time.Minute)})
+	assert.Nil(t, err)
+	_, err = o.Set(ctx, Override{App: "partner", Limit: 7, ExpiresAt: clk.Now().Add(time.Hour)})
+	assert.Nil(t, err)
+	_, err = o.Set(ctx, Override{Target: "10.0.0.2", Limit: 7, ExpiresAt: clk.Now()})
+	assert.ErrorIs(t, err, ErrInvalidOverride)
+
+	match, ok := o.Match(Client{Key: "10.0.0.1"}, "a")
+	assert.True(t, ok)
+	assert.Equal(t, "10.0.0.0/24", match.Target)
+
+	match, ok = o.Match(Client{Key: "10.0.0.1"}, "b")
+	assert.True(t, ok)
+	assert.Equal(t, int64(50), match.Limit, "override of rule wins")
+
+	_, ok = o.Match(Client{Key: "10.0.1.1", App: "partner"}, "a")
+	assert.True(t, ok)
+	_, ok = o.Match(Client{Key: "10.0.1.1"}, "a")
+	assert.False(t, ok)
+
+	list, err := o.List(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, list, 3)
+
+	clk.Advance(time.Minute)
+	match, ok = o.Match(Client{Key: "10.0.0.1"}, "b")
+	assert.True(t, ok)
+	assert.Equal(t, float64(2), match.Multiplier, "expired override of rule is skipped")
+
+	// other replica reads overrides from shared storage
+	replica := NewOverrides(store, WithOverridesClock(clk))
+	assert.Nil(t, replica.Refresh(ctx))
+	_, ok = replica.Match(Client{App: "partner"}, "a")
+	assert.True(t, ok)
+
+	assert.Nil(t, o.Delete(ctx, "app:partner"))
+	_, ok = o.Match(Client{App: "partner"}, "a")
+	assert.False(t, ok)
+	assert.ErrorIs(t, o.Delete(ctx, ""), ErrInvalidOverride)
+}
+
+// TestRateLimit_Override test limits of rate limit with overrides
+func TestRateLimit_Override(t *testing.T) {
+	ctx := context.Background()
+	cfg := TmpConfig()
+	id := cfg.ByIp.Data[0].ID
+	cfg.Overrides = []Override{{Target: "123.45.67.0/24", Limit: 4, ExpiresAt: time.Now().Add(time.Hour)}}
+
+	store := storage.NewMemoryCache()
+	overrides := NewOverrides(store)
+	rl := NewRateLimit(&cfg, store, WithOverrides(overrides))
+
+	for i := 0; i < 3; i++ {
+		rl.IncByIDs(ctx, []string{id})
+	}
+	assert.True(t, rl.IsLimitedByIDs(ctx, []string{id}), "client is unknown")
+
+	client := WithClient(ctx, Client{Key: "123.45.67.11"})
+	assert.False(t, rl.IsLimitedByIDs(client, []string{id}))
+	status, ok := rl.StatusByIDs(client, []string{id})
+	assert.True(t, ok)
+	assert.Equal(t, int64(4), status.Limit)
+	assert.Equal(t, int64(1), status.Remaining)
+	assert.Equal(t, "123.45.67.0/24", status.Override.Target)
+
+	_, err := overrides.Set(ctx, Override{Target: "123.45.67.11", Multiplier: 3, ExpiresAt: time.Now().Add(time.Hour)})
+	assert.Nil(t, err)
+	status, _ = rl.StatusByIDs(client, []string{id})
+	assert.Equal(t, int64(9), status.Limit, "override of table wins over config")
+
+	states, err := rl.Inspect(client, []string{id})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(9), states[0].Limit)
+	assert.Equal(t, int64(6), states[0].Remaining)
+}
+
+// TestRateLimit_OverrideExpire test override of config expires by clock of rate limit
+func TestRateLimit_OverrideExpire(t *testing.T) {
+	clk := clock.NewManual(time.Unix(1700000000, 0))
+	cfg := TmpConfig()
+	id := cfg.ByIp.Data[0].ID
+	cfg.Overrides = []Override{{Target: "123.45.67.0/24", Limit: 4, ExpiresAt: clk.Now().Add(time.Hour)}}
+	rl := NewRateLimit(&cfg, storage.NewMemoryCache(storage.WithClock(clk)), WithClock(clk))
+
+	client := WithClient(context.Background(), Client{Key: "123.45.67.11"})
+	status, _ := rl.StatusByIDs(client, []string{id})
+	assert.Equal(t, int64(4), status.Limit)
+
+	clk.Advance(time.Hour)
+	status, _ = rl.StatusByIDs(client, []string{id})
+	assert.Equal(t, cfg.ByIp.Data[0].Limit, status.Limit)
+	assert.Nil(t, status.Override)
+}
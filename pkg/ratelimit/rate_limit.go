@@ -2,12 +2,18 @@ package ratelimit
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"log"
 	"net"
 	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 type Storager interface {
@@ -15,11 +21,41 @@ type Storager interface {
 	Set(ctx context.Context, key []byte, value []byte, ttl *uint64) error
 	Del(ctx context.Context, list ...[]byte) error
 	Has(ctx context.Context, key []byte) bool
+	// Inc increment counter by key and return new value. Not existing key is created with value 1
+	// and ttl in seconds, so the window starts with the first request. Whether ttl of existing key
+	// is kept or refreshed is defined by the storage. ttl does not depend on ctx.
 	Inc(ctx context.Context, key []byte, ttl *uint64) (int64, error)
+	// Decr decrement counter by key with the same contract as Inc
 	Decr(ctx context.Context, key []byte, ttl *uint64) (int64, error)
 	Clear(ctx context.Context) error
 }
 
+// ExtendedStorager is optional extension of Storager with ttl introspection and atomic operations
+type ExtendedStorager interface {
+	Storager
+	// TTL return remaining time to live of key, storage.NoTTL if the key has no expiration
+	TTL(ctx context.Context, key []byte) (time.Duration, error)
+	// IncrBy add n to value by key, not existing key is created with ttl. Return new value and remaining ttl.
+	IncrBy(ctx context.Context, key []byte, n int64, ttl *uint64) (int64, time.Duration, error)
+	// CompareAndSwap set value to newValue only if current value is equal to old
+	CompareAndSwap(ctx context.Context, key []byte, old, newValue []byte, ttl *uint64) (bool, error)
+	// SetNX set value only if the key does not exist
+	SetNX(ctx context.Context, key []byte, value []byte, ttl *uint64) (bool, error)
+}
+
+// BlockCacher is optional extension of Storager that remembers blocked keys locally,
+// so next checks of the key do not go to shared storage until ttl
+type BlockCacher interface {
+	CacheBlocked(ctx context.Context, key []byte, value int64, ttl time.Duration)
+}
+
+// Scanner is optional extension of Storager to iterate keys page by page
+type Scanner interface {
+	// Scan return up to count keys with prefix after cursor and cursor of the next page.
+	// Empty cursor starts iteration, empty next cursor ends it.
+	Scan(ctx context.Context, prefix []byte, cursor string, count int) ([]storage.KeyInfo, string, error)
+}
+
 type LimitHandler struct {
 	ID             string `mapstructure:"id"`
 	Protocol       string
@@ -27,6 +63,10 @@ type LimitHandler struct {
 	Method         string
 	Url            string
 	Regexp         bool
+	// Template url is route template: {name} and * match one segment, ** matches any number of segments
+	Template bool
+	// CaseSensitive compare url without lowercasing
+	CaseSensitive bool `mapstructure:"case_sensitive"`
 }
 
 type ByIpData struct {
@@ -36,6 +76,33 @@ type ByIpData struct {
 	BlockTime  int64 `mapstructure:"block_time"`
 	Mask       string
 	ExcludeIps []string `mapstructure:"exclude_ips"`
+	// FailurePolicy what to do when storage fails: open (default), closed or local
+	FailurePolicy FailurePolicy `mapstructure:"failure_policy"`
+}
+
+// FailurePolicy of limit when storage call fails
+type FailurePolicy string
+
+const (
+	// FailOpen allow request, limit is not checked
+	FailOpen FailurePolicy = "open"
+	// FailClosed reject request as limited
+	FailClosed FailurePolicy = "closed"
+	// FailLocal check limit by local in-memory storage of the instance
+	FailLocal FailurePolicy = "local"
+)
+
+// DefaultFallbackMaxKeys is max count of keys of the default local storage of FailLocal policy
+const DefaultFallbackMaxKeys = 100000
+
+// Validate check is policy known, empty policy is FailOpen
+func (p FailurePolicy) Validate() error {
+	switch p {
+	case "", FailOpen, FailClosed, FailLocal:
+		return nil
+	}
+
+	return fmt.Errorf("unknown failure policy %q", string(p))
 }
 
 type ByIp struct {
@@ -46,12 +113,103 @@ type ByIp struct {
 type Config struct {
 	Title string `mapstructure:"title"`
 	ByIp  ByIp   `mapstructure:"by_ip"`
+	// Overrides of limits for clients, overrides of Overrides table win over them
+	Overrides []Override `mapstructure:"overrides"`
+}
+
+// Status of limit by rule
+type Status struct {
+	ID        string
+	Limit     int64
+	Remaining int64
+	// Reset time until counter is reset. Zero if unknown.
+	Reset time.Duration
+	// Override applied to Limit of the client, nil if there is none
+	Override *Override
 }
 
 // rateLimit
 type rateLimit struct {
-	config  *Config
 	storage Storager
+
+	// fallback is local storage of FailLocal policy
+	fallback Storager
+	// timeout of one storage call, zero - only deadline of the request
+	timeout time.Duration
+	// namespace of keys, nil - keys are rule IDs
+	namespace *Namespace
+	// bans checked before rules, nil - without bans
+	bans *Banlist
+	// overrides of limits for clients, nil - only overrides of config
+	overrides *Overrides
+	// denylist of IPs rejected like banned ones, nil - without denylist
+	denylist *IPList
+	// allowlist of IPs that are never limited, nil - without allowlist
+	allowlist *IPList
+	// index of rules of config by masks
+	index atomic.Pointer[ruleIndex]
+	// clock of expirations of config overrides and of the default fallback storage
+	clock clock.Clock
+}
+
+// Option configure rate limit
+type Option func(rl *rateLimit)
+
+// WithFallbackStorage set local storage of FailLocal policy. By default it is in-memory storage
+// of DefaultFallbackMaxKeys keys, so it should be replaced by one bounded like the primary storage.
+func WithFallbackStorage(s Storager) Option {
+	return func(rl *rateLimit) {
+		rl.fallback = s
+	}
+}
+
+// WithClock set clock of expirations of config overrides and of the default fallback storage. clock.Real by default.
+func WithClock(clk clock.Clock) Option {
+	return func(rl *rateLimit) {
+		rl.clock = clock.OrReal(clk)
+	}
+}
+
+// WithStorageTimeout set timeout of one storage call. The call context is derived from the request context.
+func WithStorageTimeout(timeout time.Duration) Option {
+	return func(rl *rateLimit) {
+		rl.timeout = timeout
+	}
+}
+
+// WithNamespace store counters under namespace, so limiters sharing storage don't collide
+func WithNamespace(ns Namespace) Option {
+	return func(rl *rateLimit) {
+		rl.namespace = &ns
+	}
+}
+
+// WithBanlist check bans before rules
+func WithBanlist(b *Banlist) Option {
+	return func(rl *rateLimit) {
+		rl.bans = b
+	}
+}
+
+// WithOverrides apply overrides of table to limits of clients
+func WithOverrides(o *Overrides) Option {
+	return func(rl *rateLimit) {
+		rl.overrides = o
+	}
+}
+
+// WithDenylist reject IPs of list before rules like banned ones
+func WithDenylist(l *IPList) Option {
+	return func(rl *rateLimit) {
+		rl.denylist = l
+	}
+}
+
+// WithAllowlist never limit IPs of list. Denylist and bans win over it.
+func WithAllowlist(l *IPList) Option {
+	return func(rl *rateLimit) {
+		rl.allowlist = l
+	}
 }
 
 // go:cover ignore
@@ -60,19 +218,61 @@ func (rl *rateLimit) IsLimited(ctx context.Context, req *http.Request) bool {
 	return false
 }
 
-func NewRateLimit(cfg *Config, storage Storager) *rateLimit {
-	return &rateLimit{
-		config:  cfg,
-		storage: storage,
+// NewRateLimit create rate limit by cfg. Invalid rules of cfg are only logged, so cfg which must be
+// rejected like by UpdateConfig has to be checked by ValidateConfig before.
+func NewRateLimit(cfg *Config, store Storager, opts ...Option) *rateLimit {
+	rl := &rateLimit{
+		storage: store,
+		clock:   clock.Real,
+	}
+	for _, opt := range opts {
+		opt(rl)
+	}
+
+	idx, err := newRuleIndex(cfg)
+	if err != nil {
+		log.Printf("rate limit config error: %s", err.Error())
+	}
+	rl.index.Store(idx)
+	if rl.fallback == nil {
+		rl.fallback = storage.NewMemoryCache(storage.WithClock(rl.clock), storage.WithMaxKeys(DefaultFallbackMaxKeys))
 	}
+	if rl.namespace != nil {
+		rl.storage = NewNamespacedStorage(rl.storage, *rl.namespace)
+		rl.fallback = NewNamespacedStorage(rl.fallback, *rl.namespace)
+	}
+
+	return rl
+}
+
+// Banned check is client key banned or denylisted. False if rate limit has neither banlist nor denylist.
+func (rl *rateLimit) Banned(ctx context.Context, key string) (Ban, bool) {
+	if rl.denylist != nil {
+		if ip := net.ParseIP(key); ip != nil {
+			if prefix, ok := rl.denylist.Lookup(ip); ok {
+				return Ban{Target: prefix, Reason: DenylistReason}, true
+			}
+		}
+	}
+	if rl.bans == nil {
+		return Ban{}, false
+	}
+
+	return rl.bans.Banned(key)
 }
 
+// GetConfig return current config. It is replaced by UpdateConfig together with index of its rules.
 func (rl *rateLimit) GetConfig() *Config {
-	return rl.config
+	return rl.index.Load().config
 }
 
+// UpdateConfig replace config of rate limit. Config with invalid mask is rejected and the current one is kept.
 func (rl *rateLimit) UpdateConfig(cfg *Config) error {
-	rl.config = cfg
+	idx, err := newRuleIndex(cfg)
+	if err != nil {
+		return err
+	}
+	rl.index.Store(idx)
 	return nil
 }
 
@@ -81,16 +281,25 @@ func (rl *rateLimit) IncByIDs(ctx context.Context, ids []string) int64 {
 		return 0
 	}
 
+	cfg := rl.GetConfig()
 	for _, storeID := range ids {
-		for _, byIpData := range rl.config.ByIp.Data {
+		for _, byIpData := range cfg.ByIp.Data {
 			ttl := uint64(byIpData.BlockTime)
 			if byIpData.ID != storeID {
 				continue
 			}
 
-			counter, err := rl.storage.Inc(ctx, []byte(storeID), &ttl)
+			counter, err := rl.inc(ctx, []byte(storeID), &ttl)
 			if err != nil {
-				continue
+				log.Printf("rate limit %s storage error: %s. Failure policy: %s", storeID, err.Error(), byIpData.FailurePolicy)
+				if byIpData.FailurePolicy != FailLocal {
+					continue
+				}
+
+				counter, err = rl.fallback.Inc(ctx, []byte(storeID), &ttl)
+				if err != nil {
+					continue
+				}
 			}
 
 			return counter
@@ -106,13 +315,24 @@ func (rl *rateLimit) IsLimitedByIDs(ctx context.Context, ids []string) bool {
 		return false
 	}
 
+	cfg := rl.GetConfig()
 	for _, storeID := range ids {
-		for _, byIpData := range rl.config.ByIp.Data {
+		for _, byIpData := range cfg.ByIp.Data {
 			if byIpData.ID != storeID {
 				continue
 			}
 
-			c, err := rl.storage.Get(ctx, []byte(storeID))
+			c, err := rl.get(ctx, []byte(storeID))
+			if err != nil && !errors.Is(err, storage.ValueNotFoundByKey) {
+				log.Printf("rate limit %s storage error: %s. Failure policy: %s", storeID, err.Error(), byIpData.FailurePolicy)
+
+				switch byIpData.FailurePolicy {
+				case FailClosed:
+					return true
+				case FailLocal:
+					c, err = rl.fallback.Get(ctx, []byte(storeID))
+				}
+			}
 			if err != nil {
 				continue
 			}
@@ -122,7 +342,8 @@ func (rl *rateLimit) IsLimitedByIDs(ctx context.Context, ids []string) bool {
 				continue
 			}
 
-			if counter >= byIpData.Limit {
+			if limit, _ := rl.limit(ctx, cfg, byIpData); counter >= limit {
+				rl.cacheBlocked(ctx, byIpData, counter)
 				return true
 			}
 		}
@@ -131,6 +352,56 @@ func (rl *rateLimit) IsLimitedByIDs(ctx context.Context, ids []string) bool {
 	return false
 }
 
+// StatusByIDs return status of the first limit by IDs. False if there is no limit by IDs.
+// Reset is exact when storage is ExtendedStorager, otherwise it is block time of the limit.
+func (rl *rateLimit) StatusByIDs(ctx context.Context, ids []string) (Status, bool) {
+	cfg := rl.GetConfig()
+	for _, storeID := range ids {
+		for _, byIpData := range cfg.ByIp.Data {
+			if byIpData.ID != storeID {
+				continue
+			}
+
+			limit, override := rl.limit(ctx, cfg, byIpData)
+			status := Status{
+				ID:        storeID,
+				Limit:     limit,
+				Remaining: limit,
+				Override:  override,
+			}
+
+			c, err := rl.get(ctx, []byte(storeID))
+			if err != nil {
+				return status, true
+			}
+
+			counter, err := strconv.ParseInt(string(c), 10, 64)
+			if err != nil {
+				return status, true
+			}
+
+			status.Remaining = limit - counter
+			if status.Remaining < 0 {
+				status.Remaining = 0
+			}
+
+			status.Reset = time.Duration(byIpData.BlockTime) * time.Second
+			if ext, ok := rl.storage.(ExtendedStorager); ok {
+				callCtx, cancel := rl.callContext(ctx)
+				ttl, err := ext.TTL(callCtx, []byte(storeID))
+				cancel()
+				if err == nil && ttl >= 0 {
+					status.Reset = ttl
+				}
+			}
+
+			return status, true
+		}
+	}
+
+	return Status{}, false
+}
+
 // IdsByIP get date limit IDs
 func (rl *rateLimit) IdsByIP(
 	ctx context.Context,
@@ -139,26 +410,23 @@ func (rl *rateLimit) IdsByIP(
 ) []string {
 	protocol = strings.ToLower(protocol)
 	method = strings.ToLower(method)
+	rawURL := url
 	url = strings.ToLower(url)
 	ip := net.ParseIP(strIP)
 
 	res := make([]string, 0)
-	for _, byIpData := range rl.config.ByIp.Data {
-		var err error
-
-		//@TODO: check this
-		if byIpData.Mask == "" {
-			//continue
-		} else {
-			_, IPNet, err := net.ParseCIDR(byIpData.Mask)
-			if err != nil {
-				fmt.Sprintf("%v ====> %T", err, err)
-			}
+	if rl.allowlist != nil && ip != nil && rl.allowlist.Contains(ip) {
+		return res
+	}
 
-			if IPNet != nil && !IPNet.Contains(ip) {
-				continue
-			}
-		}
+	idx := rl.index.Load()
+	var templates map[handlerRef]bool
+	if url != "*" {
+		templates = idx.matchTemplates(rawURL)
+	}
+	for _, i := range idx.rules(ip) {
+		var err error
+		byIpData := idx.config.ByIp.Data[i]
 
 		storeID := byIpData.ID
 		if protocol == "*" && method == "*" && url == "*" {
@@ -166,9 +434,9 @@ func (rl *rateLimit) IdsByIP(
 			continue
 		}
 		//ttl := uint64(byIpData.BlockTime)
-		for _, lh := range byIpData.Handlers {
+		for j, lh := range byIpData.Handlers {
 			var reg *regexp.Regexp
-			if lh.Regexp {
+			if lh.Regexp && !lh.Template {
 				reg, err = regexp.Compile(lh.Url)
 				if err != nil {
 					continue
@@ -187,7 +455,22 @@ func (rl *rateLimit) IdsByIP(
 				continue
 			}
 
-			if ((url == "*") || (!lh.Regexp && strings.ToLower(lh.Url) == url) || (lh.Regexp && reg.MatchString(url))) &&
+			target, lhURL := url, strings.ToLower(lh.Url)
+			if lh.CaseSensitive {
+				target, lhURL = rawURL, lh.Url
+			}
+			urlMatched := url == "*"
+			switch {
+			case urlMatched:
+			case lh.Template:
+				urlMatched = templates[handlerRef{rule: i, handler: j}]
+			case lh.Regexp:
+				urlMatched = reg.MatchString(target)
+			default:
+				urlMatched = lhURL == target
+			}
+
+			if urlMatched &&
 				(lh.Method == "" || (strings.ToLower(lh.Method) == method)) &&
 				(lh.Protocol == "" ||
 					(!lh.ProtocolRegexp && strings.ToLower(lh.Protocol) == protocol) ||
@@ -200,6 +483,38 @@ func (rl *rateLimit) IdsByIP(
 	return res
 }
 
+// IdsByPrefix get IDs of rules which masks overlap subnet prefix in CIDR notation. Rules without mask match every prefix.
+func (rl *rateLimit) IdsByPrefix(prefix string) ([]string, error) {
+	_, subnet, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]string, 0)
+	for _, byIpData := range rl.GetConfig().ByIp.Data {
+		if byIpData.Mask != "" {
+			_, IPNet, _ := net.ParseCIDR(byIpData.Mask)
+			if IPNet != nil && !IPNet.Contains(subnet.IP) && !subnet.Contains(IPNet.IP) {
+				continue
+			}
+		}
+		res = append(res, byIpData.ID)
+	}
+
+	return res, nil
+}
+
+// RuleIDs get IDs of all rules
+func (rl *rateLimit) RuleIDs() []string {
+	cfg := rl.GetConfig()
+	res := make([]string, 0, len(cfg.ByIp.Data))
+	for _, byIpData := range cfg.ByIp.Data {
+		res = append(res, byIpData.ID)
+	}
+
+	return res
+}
+
 func (rl *rateLimit) IsLimitedByApp(ctx context.Context, protocol, method, url string, query map[string][]string, appName string) bool {
 	return false
 }
@@ -209,18 +524,93 @@ func (rl *rateLimit) ClearByIDs(ctx context.Context, ids []string) error {
 		return nil
 	}
 
+	cfg := rl.GetConfig()
 	for _, storeID := range ids {
-		for _, byIpData := range rl.config.ByIp.Data {
+		for _, byIpData := range cfg.ByIp.Data {
 			if byIpData.ID != storeID {
 				continue
 			}
 
-			err := rl.storage.Del(ctx, []byte(storeID))
+			callCtx, cancel := rl.callContext(ctx)
+			err := rl.storage.Del(callCtx, []byte(storeID))
+			cancel()
 			if err != nil {
 				return err
 			}
+
+			if byIpData.FailurePolicy == FailLocal {
+				if err = rl.fallback.Del(ctx, []byte(storeID)); err != nil {
+					return err
+				}
+			}
 		}
 	}
 
 	return nil
 }
+
+// limit return limit of rule of cfg for client of ctx with applied override
+func (rl *rateLimit) limit(ctx context.Context, cfg *Config, byIpData ByIpData) (int64, *Override) {
+	c, ok := ClientFromContext(ctx)
+	if !ok {
+		return byIpData.Limit, nil
+	}
+
+	override, ok := Override{}, false
+	if rl.overrides != nil {
+		override, ok = rl.overrides.Match(c, byIpData.ID)
+	}
+	if !ok && len(cfg.Overrides) > 0 {
+		override, ok = matchOverride(cfg.Overrides, c, byIpData.ID, rl.clock.Now())
+	}
+	if !ok {
+		return byIpData.Limit, nil
+	}
+
+	return override.Apply(byIpData.Limit), &override
+}
+
+// callContext return context of one storage call
+func (rl *rateLimit) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if rl.timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, rl.timeout)
+}
+
+// get value by key from storage with call timeout
+func (rl *rateLimit) get(ctx context.Context, key []byte) ([]byte, error) {
+	callCtx, cancel := rl.callContext(ctx)
+	defer cancel()
+
+	return rl.storage.Get(callCtx, key)
+}
+
+// inc value by key in storage with call timeout
+func (rl *rateLimit) inc(ctx context.Context, key []byte, ttl *uint64) (int64, error) {
+	callCtx, cancel := rl.callContext(ctx)
+	defer cancel()
+
+	return rl.storage.Inc(callCtx, key, ttl)
+}
+
+// cacheBlocked remember blocked key in storage if it is BlockCacher. Verdict lives until ttl of the counter.
+func (rl *rateLimit) cacheBlocked(ctx context.Context, byIpData ByIpData, counter int64) {
+	cacher, ok := rl.storage.(BlockCacher)
+	if !ok {
+		return
+	}
+
+	ttl := time.Duration(byIpData.BlockTime) * time.Second
+	if ext, ok := rl.storage.(ExtendedStorager); ok {
+		callCtx, cancel := rl.callContext(ctx)
+		left, err := ext.TTL(callCtx, []byte(byIpData.ID))
+		cancel()
+		if err == nil && left >= 0 {
+			ttl = left
+		}
+	}
+
+	cacher.CacheBlocked(ctx, []byte(byIpData.ID), counter, ttl)
+}
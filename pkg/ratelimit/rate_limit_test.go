@@ -9,6 +9,7 @@ import (
 	"net/http/httptest"
 	"strconv"
 	"testing"
+	"time"
 )
 
 // TestNewMemoryCache test NewMemoryCache function
@@ -18,9 +19,9 @@ func TestNewRateLimit(t *testing.T) {
 	rl := NewRateLimit(&cfg, memStorage)
 
 	assert.Equal(t, fmt.Sprintf("%T", &rateLimit{}), fmt.Sprintf("%T", rl))
-	assert.Equal(t, "RateLimit test rules", rl.config.Title)
+	assert.Equal(t, "RateLimit test rules", rl.GetConfig().Title)
 	assert.NotNil(t, rl)
-	assert.NotNil(t, rl.config)
+	assert.NotNil(t, rl.GetConfig())
 	assert.NotNil(t, rl.storage)
 }
 
@@ -338,6 +339,157 @@ func Test_IncByIDs(t *testing.T) {
 	assert.Equal(t, int64(0), counter)
 }
 
+// TestStatusByIDs test StatusByIDs function
+func TestStatusByIDs(t *testing.T) {
+	ctx := context.Background()
+	cfg := TmpConfig()
+	memStorage := storage.NewMemoryCache()
+	rl := NewRateLimit(&cfg, memStorage)
+	id := cfg.ByIp.Data[0].ID
+	ttl := uint64(5)
+
+	_, ok := rl.StatusByIDs(ctx, []string{})
+	assert.False(t, ok)
+	_, ok = rl.StatusByIDs(ctx, []string{"undefined"})
+	assert.False(t, ok)
+
+	status, ok := rl.StatusByIDs(ctx, []string{id})
+	assert.True(t, ok)
+	assert.Equal(t, Status{ID: id, Limit: 3, Remaining: 3}, status)
+
+	_, _, err := memStorage.IncrBy(ctx, []byte(id), 2, &ttl)
+	assert.Nil(t, err)
+	status, ok = rl.StatusByIDs(ctx, []string{"undefined", id})
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), status.Remaining)
+	assert.True(t, status.Reset > 4*time.Second && status.Reset <= 5*time.Second)
+
+	_, _, err = memStorage.IncrBy(ctx, []byte(id), 5, nil)
+	assert.Nil(t, err)
+	status, _ = rl.StatusByIDs(ctx, []string{id})
+	assert.Equal(t, int64(0), status.Remaining)
+
+	// without ttl reset is block time
+	err = memStorage.Set(ctx, []byte(cfg.ByIp.Data[1].ID), []byte("1"), nil)
+	assert.Nil(t, err)
+	status, _ = rl.StatusByIDs(ctx, []string{cfg.ByIp.Data[1].ID})
+	assert.Equal(t, int64(2), status.Remaining)
+	assert.Equal(t, 10*time.Second, status.Reset)
+}
+
+// TestFailurePolicy test limits when storage fails
+func TestFailurePolicy(t *testing.T) {
+	ctx := context.Background()
+	ids := []string{"87206c45-3098-45c1-86c1-0c28296d163f"}
+
+	newLimit := func(policy FailurePolicy) (*rateLimit, *flakyStorage) {
+		cfg := TmpConfig()
+		cfg.ByIp.Data[0].FailurePolicy = policy
+		flaky := newFlakyStorage()
+		flaky.down.Store(true)
+
+		return NewRateLimit(&cfg, flaky), flaky
+	}
+
+	t.Run("open", func(t *testing.T) {
+		rl, _ := newLimit(FailOpen)
+		for i := 0; i < 5; i++ {
+			assert.Equal(t, int64(0), rl.IncByIDs(ctx, ids))
+			assert.False(t, rl.IsLimitedByIDs(ctx, ids))
+		}
+	})
+
+	t.Run("default is open", func(t *testing.T) {
+		rl, _ := newLimit("")
+		rl.IncByIDs(ctx, ids)
+		assert.False(t, rl.IsLimitedByIDs(ctx, ids))
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		rl, flaky := newLimit(FailClosed)
+		assert.True(t, rl.IsLimitedByIDs(ctx, ids))
+
+		// not found counter is not a failure
+		flaky.down.Store(false)
+		assert.False(t, rl.IsLimitedByIDs(ctx, ids))
+	})
+
+	t.Run("local", func(t *testing.T) {
+		rl, flaky := newLimit(FailLocal)
+		for i := int64(1); i <= 3; i++ {
+			assert.False(t, rl.IsLimitedByIDs(ctx, ids))
+			assert.Equal(t, i, rl.IncByIDs(ctx, ids))
+		}
+		assert.True(t, rl.IsLimitedByIDs(ctx, ids))
+
+		flaky.down.Store(false)
+		assert.False(t, rl.IsLimitedByIDs(ctx, ids))
+		assert.Nil(t, rl.ClearByIDs(ctx, ids))
+
+		flaky.down.Store(true)
+		assert.False(t, rl.IsLimitedByIDs(ctx, ids))
+	})
+
+	t.Run("local with fallback storage", func(t *testing.T) {
+		cfg := TmpConfig()
+		cfg.ByIp.Data[0].FailurePolicy = FailLocal
+		flaky := newFlakyStorage()
+		flaky.down.Store(true)
+		fallback := storage.NewMemoryCache()
+		rl := NewRateLimit(&cfg, flaky, WithFallbackStorage(fallback))
+
+		rl.IncByIDs(ctx, ids)
+		assert.True(t, fallback.Has(ctx, []byte(ids[0])))
+	})
+}
+
+// TestStorageTimeout test storage calls get context with timeout
+func TestStorageTimeout(t *testing.T) {
+	cfg := TmpConfig()
+	rl := NewRateLimit(&cfg, storage.NewMemoryCache(), WithStorageTimeout(50*time.Millisecond))
+
+	callCtx, cancel := rl.callContext(context.Background())
+	deadline, ok := callCtx.Deadline()
+	assert.True(t, ok)
+	assert.True(t, time.Until(deadline) <= 50*time.Millisecond)
+	cancel()
+	assert.NotNil(t, callCtx.Err())
+
+	rl = NewRateLimit(&cfg, storage.NewMemoryCache())
+	callCtx, cancel = rl.callContext(context.Background())
+	defer cancel()
+	_, ok = callCtx.Deadline()
+	assert.False(t, ok)
+}
+
+// TestIdsByPrefix test IdsByPrefix and RuleIDs functions
+func TestIdsByPrefix(t *testing.T) {
+	cfg := TmpConfig()
+	rl := NewRateLimit(&cfg, storage.NewMemoryCache())
+
+	ids, err := rl.IdsByPrefix("123.17.18.0/24")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1f09b207-3f0c-4bd7-ae74-b602e049ae5d", "d21e62e9-4c9a-49b2-a7be-7a3851219f8b"}, ids)
+
+	ids, err = rl.IdsByPrefix("123.45.67.128/25")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"87206c45-3098-45c1-86c1-0c28296d163f"}, ids)
+
+	ids, err = rl.IdsByPrefix("123.0.0.0/8")
+	assert.Nil(t, err)
+	assert.Len(t, ids, 4)
+
+	ids, err = rl.IdsByPrefix("10.0.0.0/8")
+	assert.Nil(t, err)
+	assert.Empty(t, ids)
+
+	_, err = rl.IdsByPrefix("123.17.18.1")
+	assert.NotNil(t, err)
+
+	assert.Len(t, rl.RuleIDs(), 4)
+	assert.Equal(t, "87206c45-3098-45c1-86c1-0c28296d163f", rl.RuleIDs()[0])
+}
+
 // TmpConfig return fixed Config
 func TmpConfig() Config {
 	return Config{
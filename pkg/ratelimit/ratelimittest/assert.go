@@ -0,0 +1,74 @@
+package ratelimittest
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// AssertLimited assert response is 429 Too Many Requests
+func AssertLimited(t testing.TB, res *httptest.ResponseRecorder) bool {
+	t.Helper()
+
+	return assert.Equal(t, http.StatusTooManyRequests, res.Code, "response must be limited")
+}
+
+// AssertNotLimited assert response is not 429 Too Many Requests
+func AssertNotLimited(t testing.TB, res *httptest.ResponseRecorder) bool {
+	t.Helper()
+
+	return assert.NotEqual(t, http.StatusTooManyRequests, res.Code, "response must not be limited")
+}
+
+// AssertRateLimitHeaders assert RateLimit-Limit and RateLimit-Remaining headers of response
+func AssertRateLimitHeaders(t testing.TB, res *httptest.ResponseRecorder, limit, remaining int64) bool {
+	t.Helper()
+
+	ok := assertIntHeader(t, res.Header(), "RateLimit-Limit", limit)
+	return assertIntHeader(t, res.Header(), "RateLimit-Remaining", remaining) && ok
+}
+
+// AssertRateLimitReset assert RateLimit-Reset header of response in seconds
+func AssertRateLimitReset(t testing.TB, res *httptest.ResponseRecorder, seconds int64) bool {
+	t.Helper()
+
+	return assertIntHeader(t, res.Header(), "RateLimit-Reset", seconds)
+}
+
+// AssertRetryAfter assert Retry-After header of response in seconds
+func AssertRetryAfter(t testing.TB, res *httptest.ResponseRecorder, seconds int64) bool {
+	t.Helper()
+
+	return assertIntHeader(t, res.Header(), "Retry-After", seconds)
+}
+
+// AssertNoRateLimitHeaders assert response has no RateLimit-* and Retry-After headers
+func AssertNoRateLimitHeaders(t testing.TB, res *httptest.ResponseRecorder) bool {
+	t.Helper()
+
+	ok := true
+	for _, name := range []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"} {
+		ok = assert.Empty(t, res.Header().Get(name), "header %s must not be set", name) && ok
+	}
+
+	return ok
+}
+
+// assertIntHeader assert header is set to integer value
+func assertIntHeader(t testing.TB, h http.Header, name string, value int64) bool {
+	t.Helper()
+
+	raw := h.Get(name)
+	if !assert.NotEmpty(t, raw, "header %s is not set", name) {
+		return false
+	}
+
+	got, err := strconv.ParseInt(raw, 10, 64)
+	if !assert.NoError(t, err, "header %s is not integer", name) {
+		return false
+	}
+
+	return assert.Equal(t, value, got, "header %s", name)
+}
@@ -0,0 +1,123 @@
+package ratelimittest
+
+import (
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit"
+	"strconv"
+)
+
+// ConfigBuilder build Config fixtures fluently. Options of rules are applied to the last added rule:
+//
+//	cfg := ratelimittest.NewConfig().
+//		Rule("api", "10.0.0.0/24", 3, 60).Handler("GET", "/api").
+//		Rule("all", "", 100, 1).AnyHandler().
+//		Build()
+type ConfigBuilder struct {
+	cfg ratelimit.Config
+}
+
+// NewConfig create builder of config titled "test" without rules
+func NewConfig() *ConfigBuilder {
+	return &ConfigBuilder{cfg: ratelimit.Config{Title: "test"}}
+}
+
+// Title of config
+func (b *ConfigBuilder) Title(title string) *ConfigBuilder {
+	b.cfg.Title = title
+	return b
+}
+
+// ExcludeIPs excluded from all rules
+func (b *ConfigBuilder) ExcludeIPs(ips ...string) *ConfigBuilder {
+	b.cfg.ByIp.ExcludeIps = append(b.cfg.ByIp.ExcludeIps, ips...)
+	return b
+}
+
+// Rule add rule by IP mask with limit of requests per blockTime seconds. Empty mask matches every IP.
+func (b *ConfigBuilder) Rule(id, mask string, limit, blockTime int64) *ConfigBuilder {
+	b.cfg.ByIp.Data = append(b.cfg.ByIp.Data, ratelimit.ByIpData{
+		ID:        id,
+		Mask:      mask,
+		Limit:     limit,
+		BlockTime: blockTime,
+	})
+	return b
+}
+
+// Handler add handler of the rule by method and exact url. Empty method matches every method.
+func (b *ConfigBuilder) Handler(method, url string) *ConfigBuilder {
+	return b.handler(ratelimit.LimitHandler{Method: method, Url: url})
+}
+
+// RegexpHandler add handler of the rule by method and url regexp
+func (b *ConfigBuilder) RegexpHandler(method, urlRegexp string) *ConfigBuilder {
+	return b.handler(ratelimit.LimitHandler{Method: method, Url: urlRegexp, Regexp: true})
+}
+
+// TemplateHandler add handler of the rule by method and url template like /users/{id}/orders or /static/**
+func (b *ConfigBuilder) TemplateHandler(method, template string) *ConfigBuilder {
+	return b.handler(ratelimit.LimitHandler{Method: method, Url: template, Template: true})
+}
+
+// ProtocolHandler add handler of the rule by protocol, method and exact url
+func (b *ConfigBuilder) ProtocolHandler(protocol, method, url string) *ConfigBuilder {
+	return b.handler(ratelimit.LimitHandler{Protocol: protocol, Method: method, Url: url})
+}
+
+// AnyHandler add handler of the rule that matches every request
+func (b *ConfigBuilder) AnyHandler() *ConfigBuilder {
+	return b.RegexpHandler("", ".*")
+}
+
+// CaseSensitive compare url of the last handler of the rule without lowercasing
+func (b *ConfigBuilder) CaseSensitive() *ConfigBuilder {
+	rule := b.last()
+	if len(rule.Handlers) == 0 {
+		panic("ratelimittest: CaseSensitive is used before handler")
+	}
+	rule.Handlers[len(rule.Handlers)-1].CaseSensitive = true
+	return b
+}
+
+// RuleExcludeIPs excluded from the rule
+func (b *ConfigBuilder) RuleExcludeIPs(ips ...string) *ConfigBuilder {
+	rule := b.last()
+	rule.ExcludeIps = append(rule.ExcludeIps, ips...)
+	return b
+}
+
+// FailurePolicy of the rule
+func (b *ConfigBuilder) FailurePolicy(policy ratelimit.FailurePolicy) *ConfigBuilder {
+	b.last().FailurePolicy = policy
+	return b
+}
+
+// Build return copy of the config, so builder can be reused
+func (b *ConfigBuilder) Build() ratelimit.Config {
+	cfg := b.cfg
+	cfg.ByIp.ExcludeIps = append([]string(nil), b.cfg.ByIp.ExcludeIps...)
+	cfg.ByIp.Data = make([]ratelimit.ByIpData, len(b.cfg.ByIp.Data))
+	for i, rule := range b.cfg.ByIp.Data {
+		rule.Handlers = append([]ratelimit.LimitHandler(nil), rule.Handlers...)
+		rule.ExcludeIps = append([]string(nil), rule.ExcludeIps...)
+		cfg.ByIp.Data[i] = rule
+	}
+
+	return cfg
+}
+
+// handler add h to the last rule with ID by the rule and position
+func (b *ConfigBuilder) handler(h ratelimit.LimitHandler) *ConfigBuilder {
+	rule := b.last()
+	h.ID = rule.ID + "-" + strconv.Itoa(len(rule.Handlers)+1)
+	rule.Handlers = append(rule.Handlers, h)
+	return b
+}
+
+// last return the last added rule, panics if there is none
+func (b *ConfigBuilder) last() *ratelimit.ByIpData {
+	if len(b.cfg.ByIp.Data) == 0 {
+		panic("ratelimittest: rule option is used before Rule")
+	}
+
+	return &b.cfg.ByIp.Data[len(b.cfg.ByIp.Data)-1]
+}
@@ -0,0 +1,297 @@
+// Package ratelimittest is helpers to test handlers behind middleware.RateLimit without storage and timing:
+// scriptable fake of RateLimiter, fluent builder of Config fixtures and assertions of responses.
+package ratelimittest
+
+import (
+	"context"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Mode is decision of Limiter when nothing is scripted
+type Mode int
+
+const (
+	// Allow never limit requests
+	Allow Mode = iota
+	// Deny limit every request
+	Deny
+	// Count limit requests when counter reaches the limit, like real rate limit
+	Count
+)
+
+// DefaultID is ID of rule returned by IdsByIP when no IDs are set
+const DefaultID = "test"
+
+// Call is recorded call of Limiter
+type Call struct {
+	Method string
+	IDs    []string
+	IP     string
+	URL    string
+}
+
+// Limiter is fake middleware.RateLimiter and middleware.StatusReporter.
+// All rules match every request, decisions are scripted and calls are recorded.
+type Limiter struct {
+	mu        sync.Mutex
+	mode      Mode
+	limit     int64
+	reset     time.Duration
+	ids       []string
+	ips       map[string]bool
+	script    []bool
+	counters  map[string]int64
+	calls     []Call
+	appLimits bool
+}
+
+// New create fake limiter that allows everything
+func New() *Limiter {
+	return &Limiter{
+		ids:      []string{DefaultID},
+		ips:      make(map[string]bool),
+		counters: make(map[string]int64),
+	}
+}
+
+// Allow never limit requests
+func (l *Limiter) Allow() *Limiter {
+	return l.setMode(Allow)
+}
+
+// Deny limit every request
+func (l *Limiter) Deny() *Limiter {
+	return l.setMode(Deny)
+}
+
+// Limit count requests by rule IDs and limit them when counter reaches n
+func (l *Limiter) Limit(n int64) *Limiter {
+	l.mu.Lock()
+	l.limit = n
+	l.mu.Unlock()
+
+	return l.setMode(Count)
+}
+
+// Reset is time until counters are reset reported by StatusByIDs
+func (l *Limiter) Reset(d time.Duration) *Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.reset = d
+	return l
+}
+
+// IDs set rule IDs returned by IdsByIP. No IDs means no rule matches.
+func (l *Limiter) IDs(ids ...string) *Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.ids = append([]string(nil), ids...)
+	return l
+}
+
+// OnlyIPs make rules match only requests from ips
+func (l *Limiter) OnlyIPs(ips ...string) *Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, ip := range ips {
+		l.ips[ip] = true
+	}
+	return l
+}
+
+// Script set decisions of the next IsLimitedByIDs calls. Mode is used when they are over.
+func (l *Limiter) Script(limited ...bool) *Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.script = append(l.script, limited...)
+	return l
+}
+
+// DenyApps limit every request by IsLimitedByApp
+func (l *Limiter) DenyApps() *Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.appLimits = true
+	return l
+}
+
+// Calls return recorded calls
+func (l *Limiter) Calls() []Call {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]Call(nil), l.calls...)
+}
+
+// CallCount return count of recorded calls of method
+func (l *Limiter) CallCount(method string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := 0
+	for _, c := range l.calls {
+		if c.Method == method {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Counter return counter by rule ID
+func (l *Limiter) Counter(id string) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.counters[id]
+}
+
+// Clear forget calls, counters and rest of the script
+func (l *Limiter) Clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.calls = nil
+	l.script = nil
+	l.counters = make(map[string]int64)
+}
+
+// IsLimited check request by rules matched by IdsByIP
+func (l *Limiter) IsLimited(ctx context.Context, req *http.Request) bool {
+	l.record(Call{Method: "IsLimited", URL: req.URL.Path})
+
+	l.mu.Lock()
+	ids := l.ids
+	l.mu.Unlock()
+
+	return l.decide(ids)
+}
+
+// IsLimitedByIDs return the next scripted decision or decision of Mode
+func (l *Limiter) IsLimitedByIDs(ctx context.Context, ids []string) bool {
+	l.record(Call{Method: "IsLimitedByIDs", IDs: ids})
+
+	return l.decide(ids)
+}
+
+// IncByIDs increment counters of ids and return counter of the first one
+func (l *Limiter) IncByIDs(ctx context.Context, ids []string) int64 {
+	l.record(Call{Method: "IncByIDs", IDs: ids})
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var res int64
+	for i, id := range ids {
+		l.counters[id]++
+		if i == 0 {
+			res = l.counters[id]
+		}
+	}
+
+	return res
+}
+
+// IdsByIP return IDs of the limiter if ip matches
+func (l *Limiter) IdsByIP(ctx context.Context, protocol, method, url string, strIP string) []string {
+	l.record(Call{Method: "IdsByIP", IP: strIP, URL: url})
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.ips) > 0 && !l.ips[strIP] {
+		return nil
+	}
+
+	return append([]string(nil), l.ids...)
+}
+
+// ClearByIDs reset counters of ids
+func (l *Limiter) ClearByIDs(ctx context.Context, ids []string) error {
+	l.record(Call{Method: "ClearByIDs", IDs: ids})
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, id := range ids {
+		delete(l.counters, id)
+	}
+
+	return nil
+}
+
+// IsLimitedByApp is true after DenyApps
+func (l *Limiter) IsLimitedByApp(ctx context.Context, protocol, method, url string, query map[string][]string, appName string) bool {
+	l.record(Call{Method: "IsLimitedByApp", URL: url})
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.appLimits
+}
+
+// StatusByIDs return status of the first id. Limit is 0 in Allow and Deny modes.
+func (l *Limiter) StatusByIDs(ctx context.Context, ids []string) (ratelimit.Status, bool) {
+	if len(ids) == 0 {
+		return ratelimit.Status{}, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	status := ratelimit.Status{ID: ids[0], Limit: l.limit, Reset: l.reset}
+	if remaining := l.limit - l.counters[ids[0]]; remaining > 0 {
+		status.Remaining = remaining
+	}
+
+	return status, true
+}
+
+// decide whether ids are limited
+func (l *Limiter) decide(ids []string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.script) > 0 {
+		limited := l.script[0]
+		l.script = l.script[1:]
+		return limited
+	}
+
+	switch l.mode {
+	case Deny:
+		return true
+	case Count:
+		for _, id := range ids {
+			if l.counters[id] >= l.limit {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (l *Limiter) setMode(mode Mode) *Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.mode = mode
+	return l
+}
+
+func (l *Limiter) record(c Call) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c.IDs = append([]string(nil), c.IDs...)
+	l.calls = append(l.calls, c)
+}
@@ -0,0 +1,142 @@
+package ratelimittest_test
+
+import (
+	"context"
+	"github.com/itbellissimo/ratelimit/middleware"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/ratelimittest"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var (
+	_ middleware.RateLimiter    = (*ratelimittest.Limiter)(nil)
+	_ middleware.StatusReporter = (*ratelimittest.Limiter)(nil)
+)
+
+func serve(h http.Handler, ip string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "http://localhost/run", nil)
+	req.Header.Set("X-FORWARDED-FOR", ip)
+	res := httptest.NewRecorder()
+	h.ServeHTTP(res, req)
+
+	return res
+}
+
+// TestLimiter_Mode test Allow, Deny and Count modes of Limiter
+func TestLimiter_Mode(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	t.Run("allow", func(t *testing.T) {
+		rl := ratelimittest.New()
+		res := serve(middleware.RateLimit(next, rl), "10.0.0.1")
+		ratelimittest.AssertNotLimited(t, res)
+		assert.Equal(t, 1, rl.CallCount("IncByIDs"))
+		assert.Equal(t, int64(1), rl.Counter(ratelimittest.DefaultID))
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		rl := ratelimittest.New().Deny()
+		res := serve(middleware.RateLimit(next, rl), "10.0.0.1")
+		ratelimittest.AssertLimited(t, res)
+	})
+
+	t.Run("count", func(t *testing.T) {
+		rl := ratelimittest.New().Limit(2).Reset(1500 * time.Millisecond)
+		h := middleware.RateLimit(next, rl)
+
+		res := serve(h, "10.0.0.1")
+		ratelimittest.AssertNotLimited(t, res)
+		ratelimittest.AssertRateLimitHeaders(t, res, 2, 1)
+		ratelimittest.AssertRateLimitReset(t, res, 2)
+
+		serve(h, "10.0.0.1")
+		res = serve(h, "10.0.0.1")
+		ratelimittest.AssertLimited(t, res)
+		ratelimittest.AssertRetryAfter(t, res, 2)
+	})
+
+	t.Run("only ips", func(t *testing.T) {
+		rl := ratelimittest.New().Deny().OnlyIPs("10.0.0.1")
+		h := middleware.RateLimit(next, rl)
+		ratelimittest.AssertLimited(t, serve(h, "10.0.0.1"))
+
+		res := serve(h, "10.0.0.2")
+		ratelimittest.AssertNotLimited(t, res)
+		ratelimittest.AssertNoRateLimitHeaders(t, res)
+	})
+}
+
+// TestLimiter_Script test Script function
+func TestLimiter_Script(t *testing.T) {
+	ctx := context.Background()
+	rl := ratelimittest.New().Script(true, false, true)
+
+	assert.True(t, rl.IsLimitedByIDs(ctx, []string{"a"}))
+	assert.False(t, rl.IsLimitedByIDs(ctx, []string{"a"}))
+	assert.True(t, rl.IsLimitedByIDs(ctx, []string{"a"}))
+	assert.False(t, rl.IsLimitedByIDs(ctx, []string{"a"}))
+
+	calls := rl.Calls()
+	assert.Len(t, calls, 4)
+	assert.Equal(t, ratelimittest.Call{Method: "IsLimitedByIDs", IDs: []string{"a"}}, calls[0])
+
+	rl.Clear()
+	assert.Empty(t, rl.Calls())
+}
+
+// TestLimiter_ClearByIDs test ClearByIDs function
+func TestLimiter_ClearByIDs(t *testing.T) {
+	ctx := context.Background()
+	rl := ratelimittest.New().Limit(1).IDs("a", "b")
+
+	ids := rl.IdsByIP(ctx, "HTTP/1.1", "GET", "/", "10.0.0.1")
+	assert.Equal(t, []string{"a", "b"}, ids)
+	assert.Equal(t, int64(1), rl.IncByIDs(ctx, ids))
+	assert.True(t, rl.IsLimitedByIDs(ctx, ids))
+
+	assert.NoError(t, rl.ClearByIDs(ctx, []string{"a", "b"}))
+	assert.False(t, rl.IsLimitedByIDs(ctx, ids))
+}
+
+// TestConfigBuilder test config built fluently with real rate limit
+func TestConfigBuilder(t *testing.T) {
+	cfg := ratelimittest.NewConfig().
+		Rule("api", "10.0.0.0/24", 2, 60).Handler("GET", "/run").
+		Rule("all", "", 100, 1).AnyHandler().FailurePolicy(ratelimit.FailClosed).
+		Build()
+
+	assert.Equal(t, "test", cfg.Title)
+	assert.Len(t, cfg.ByIp.Data, 2)
+	assert.Equal(t, "api-1", cfg.ByIp.Data[0].Handlers[0].ID)
+	assert.Equal(t, ratelimit.FailClosed, cfg.ByIp.Data[1].FailurePolicy)
+
+	tmpl := ratelimittest.NewConfig().Rule("users", "", 1, 1).TemplateHandler("GET", "/users/{id}").CaseSensitive().Build()
+	assert.Equal(t, ratelimit.LimitHandler{ID: "users-1", Method: "GET", Url: "/users/{id}", Template: true, CaseSensitive: true},
+		tmpl.ByIp.Data[0].Handlers[0])
+
+	rl := ratelimit.NewRateLimit(&cfg, storage.NewMemoryCache())
+	h := middleware.RateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), rl)
+
+	ratelimittest.AssertNotLimited(t, serve(h, "10.0.0.1"))
+	ratelimittest.AssertNotLimited(t, serve(h, "10.0.0.1"))
+	ratelimittest.AssertLimited(t, serve(h, "10.0.0.1"))
+
+	res := serve(h, "10.0.1.1")
+	ratelimittest.AssertNotLimited(t, res)
+	ratelimittest.AssertRateLimitHeaders(t, res, 100, 99)
+}
+
+// TestConfigBuilder_Panic test rule option without rule
+func TestConfigBuilder_Panic(t *testing.T) {
+	assert.Panics(t, func() {
+		ratelimittest.NewConfig().Handler("GET", "/")
+	})
+	assert.Panics(t, func() {
+		ratelimittest.NewConfig().Rule("api", "", 1, 1).CaseSensitive()
+	})
+}
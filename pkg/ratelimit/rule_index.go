@@ -0,0 +1,156 @@
+package ratelimit
+
+import (
+	"fmt"
+	"github.com/itbellissimo/ratelimit/pkg/iptrie"
+	"github.com/itbellissimo/ratelimit/pkg/pathtrie"
+	"log"
+	"net"
+	"sort"
+	"strings"
+)
+
+// handlerRef is position of handler in config
+type handlerRef struct {
+	rule    int
+	handler int
+}
+
+// ruleIndex of rules of config by masks, so rules of IP are found in prefix length steps
+type ruleIndex struct {
+	config *Config
+	// masks are positions of rules in config by mask
+	masks *iptrie.Trie[[]int]
+	// always are positions of rules without mask, they match every IP
+	always []int
+	// templates of url of handlers, lowercase ones are matched with lowercase url
+	templates     *pathtrie.Trie[handlerRef]
+	caseTemplates *pathtrie.Trie[handlerRef]
+}
+
+// newRuleIndex index rules of cfg. Rules with invalid mask match every IP like rules without mask
+// and rules with unknown failure policy fail open, the error of the first of them is returned with the index.
+func newRuleIndex(cfg *Config) (*ruleIndex, error) {
+	idx := &ruleIndex{
+		config:        cfg,
+		masks:         iptrie.New[[]int](),
+		templates:     pathtrie.New[handlerRef](),
+		caseTemplates: pathtrie.New[handlerRef](),
+	}
+	if cfg == nil {
+		return idx, nil
+	}
+
+	var firstErr error
+	nets := make(map[string]*net.IPNet)
+	positions := make(map[string][]int)
+	for i, byIpData := range cfg.ByIp.Data {
+		if err := byIpData.FailurePolicy.Validate(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("rule %s: %w", byIpData.ID, err)
+		}
+		idx.addTemplates(i, byIpData.Handlers)
+		if byIpData.Mask == "" {
+			idx.always = append(idx.always, i)
+			continue
+		}
+		ipNet, err := parseMask(byIpData.Mask)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("rule %s: %w", byIpData.ID, err)
+			}
+			idx.always = append(idx.always, i)
+			continue
+		}
+		key := ipNet.String()
+		nets[key] = ipNet
+		positions[key] = append(positions[key], i)
+	}
+	for key, ipNet := range nets {
+		if err := idx.masks.Insert(ipNet, positions[key]); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("mask %s: %w", key, err)
+			}
+			idx.always = append(idx.always, positions[key]...)
+		}
+	}
+	sort.Ints(idx.always)
+
+	return idx, firstErr
+}
+
+// ValidateConfig check cfg like UpdateConfig does, the error of the first invalid rule is returned
+func ValidateConfig(cfg *Config) error {
+	_, err := newRuleIndex(cfg)
+	return err
+}
+
+// parseMask parse mask in CIDR notation, IPv4-mapped IPv6 mask is converted to IPv4 one
+func parseMask(mask string) (*net.IPNet, error) {
+	_, ipNet, err := net.ParseCIDR(mask)
+	if err != nil {
+		return nil, err
+	}
+
+	ones, bits := ipNet.Mask.Size()
+	// masked IP is IPv4-mapped only if at least 96 bits of mask are set
+	if ip4 := ipNet.IP.To4(); ip4 != nil && bits == 8*net.IPv6len {
+		ipNet = &net.IPNet{IP: ip4, Mask: net.CIDRMask(ones-8*(net.IPv6len-net.IPv4len), 8*net.IPv4len)}
+	}
+
+	return ipNet, nil
+}
+
+// rules return positions of rules matching ip in order of config
+func (idx *ruleIndex) rules(ip net.IP) []int {
+	if ip == nil {
+		return idx.always
+	}
+
+	res := append([]int(nil), idx.always...)
+	idx.masks.Walk(ip, func(positions []int) bool {
+		res = append(res, positions...)
+		return true
+	})
+	if !sort.IntsAreSorted(res) {
+		sort.Ints(res)
+	}
+
+	return res
+}
+
+// addTemplates index template handlers of rule. Invalid templates never match.
+func (idx *ruleIndex) addTemplates(rule int, handlers []LimitHandler) {
+	for j, lh := range handlers {
+		if !lh.Template {
+			continue
+		}
+
+		ref := handlerRef{rule: rule, handler: j}
+		var err error
+		if lh.CaseSensitive {
+			err = idx.caseTemplates.Insert(lh.Url, ref)
+		} else {
+			err = idx.templates.Insert(strings.ToLower(lh.Url), ref)
+		}
+		if err != nil {
+			log.Printf("rule %s: %s", idx.config.ByIp.Data[rule].ID, err.Error())
+		}
+	}
+}
+
+// matchTemplates return handlers which templates match url path, nil if there are no templates
+func (idx *ruleIndex) matchTemplates(path string) map[handlerRef]bool {
+	if idx.templates.Len() == 0 && idx.caseTemplates.Len() == 0 {
+		return nil
+	}
+
+	res := make(map[handlerRef]bool)
+	add := func(ref handlerRef) bool {
+		res[ref] = true
+		return true
+	}
+	idx.templates.Match(strings.ToLower(path), add)
+	idx.caseTemplates.Match(path, add)
+
+	return res
+}
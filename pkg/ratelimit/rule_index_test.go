@@ -0,0 +1,186 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// TestRuleIndex_Rules test rules are found by masks in order of config
+func TestRuleIndex_Rules(t *testing.T) {
+	cfg := Config{ByIp: ByIp{Data: []ByIpData{
+		{ID: "wide", Mask: "10.0.0.0/8"},
+		{ID: "any"},
+		{ID: "narrow", Mask: "10.1.2.0/24"},
+		{ID: "v6", Mask: "2001:db8::/32"},
+		{ID: "same", Mask: "10.1.2.7/24"},
+		{ID: "broken", Mask: "10.1.2.0/99"},
+		{ID: "mapped", Mask: "::ffff:10.1.0.0/112"},
+	}}}
+	idx, err := newRuleIndex(&cfg)
+	assert.ErrorContains(t, err, "rule broken")
+
+	ids := func(ip string) []string {
+		res := make([]string, 0)
+		for _, i := range idx.rules(net.ParseIP(ip)) {
+			res = append(res, cfg.ByIp.Data[i].ID)
+		}
+		return res
+	}
+	assert.Equal(t, []string{"wide", "any", "narrow", "same", "broken", "mapped"}, ids("10.1.2.3"))
+	assert.Equal(t, []string{"wide", "any", "broken"}, ids("10.9.9.9"))
+	assert.Equal(t, []string{"any", "v6", "broken"}, ids("2001:db8::1"))
+	assert.Equal(t, []string{"any", "broken"}, ids("not-an-ip"))
+	idx, err = newRuleIndex(nil)
+	assert.Nil(t, err)
+	assert.Empty(t, idx.rules(net.ParseIP("10.1.2.3")))
+}
+
+// TestRateLimit_UpdateConfig test rules of new config are indexed
+func TestRateLimit_UpdateConfig(t *testing.T) {
+	ctx := context.Background()
+	cfg := TmpConfig()
+	rl := NewRateLimit(&cfg, storage.NewMemoryCache())
+	assert.Equal(t, []string{"87206c45-3098-45c1-86c1-0c28296d163f"}, rl.IdsByIP(ctx, "*", "*", "*", "123.45.67.1"))
+
+	newCfg := Config{ByIp: ByIp{Data: []ByIpData{{ID: "new", Mask: "123.45.0.0/16"}}}}
+	assert.Nil(t, rl.UpdateConfig(&newCfg))
+	assert.Equal(t, []string{"new"}, rl.IdsByIP(ctx, "*", "*", "*", "123.45.67.1"))
+	assert.Equal(t, &newCfg, rl.GetConfig())
+
+	// invalid mask keeps the current config
+	badCfg := Config{ByIp: ByIp{Data: []ByIpData{{ID: "bad", Mask: "123.45.67.0/33"}}}}
+	assert.NotNil(t, rl.UpdateConfig(&badCfg))
+	assert.Equal(t, &newCfg, rl.GetConfig())
+	assert.Equal(t, []string{"new"}, rl.IdsByIP(ctx, "*", "*", "*", "123.45.67.1"))
+
+	// unknown failure policy keeps the current config
+	typoCfg := Config{ByIp: ByIp{Data: []ByIpData{{ID: "typo", FailurePolicy: "close"}}}}
+	assert.EqualError(t, rl.UpdateConfig(&typoCfg), `rule typo: unknown failure policy "close"`)
+	assert.Equal(t, &newCfg, rl.GetConfig())
+
+	// IPv4-mapped mask is IPv4 one
+	mappedCfg := Config{ByIp: ByIp{Data: []ByIpData{{ID: "mapped", Mask: "::ffff:123.45.67.0/120"}, {ID: "v4", Mask: "123.45.67.0/24"}}}}
+	assert.Nil(t, rl.UpdateConfig(&mappedCfg))
+	assert.Equal(t, []string{"mapped", "v4"}, rl.IdsByIP(ctx, "*", "*", "*", "123.45.67.1"))
+}
+
+// TestValidateConfig test configs are validated like by UpdateConfig
+func TestValidateConfig(t *testing.T) {
+	cfg := TmpConfig()
+	assert.Nil(t, ValidateConfig(&cfg))
+	assert.Nil(t, ValidateConfig(nil))
+
+	for _, bad := range []Config{
+		{ByIp: ByIp{Data: []ByIpData{{ID: "bad", Mask: "123.45.67.0/33"}}}},
+		{ByIp: ByIp{Data: []ByIpData{{ID: "typo", FailurePolicy: "close"}}}},
+	} {
+		bad := bad
+		err := ValidateConfig(&bad)
+		assert.ErrorContains(t, err, "rule "+bad.ByIp.Data[0].ID)
+
+		rl := NewRateLimit(&cfg, storage.NewMemoryCache())
+		assert.Equal(t, err, rl.UpdateConfig(&bad))
+	}
+}
+
+// benchmarkConfig return config of n rules with /24 masks, every fourth one is IPv6
+func benchmarkConfig(n int) Config {
+	cfg := Config{ByIp: ByIp{Data: make([]ByIpData, n)}}
+	for i := range cfg.ByIp.Data {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, 0x0A000000+uint32(i)<<8)
+		mask := ip.String() + "/24"
+		if i%4 == 3 {
+			mask = fmt.Sprintf("2001:db8:%x:%x::/64", i>>16, i&0xffff)
+		}
+		cfg.ByIp.Data[i] = ByIpData{
+			ID:        "rule-" + strconv.Itoa(i),
+			Handlers:  []LimitHandler{{Method: "GET", Url: "/run"}},
+			Limit:     10,
+			BlockTime: 60,
+			Mask:      mask,
+		}
+	}
+
+	return cfg
+}
+
+func BenchmarkRateLimit_IdsByIP(b *testing.B) {
+	ctx := context.Background()
+	for _, n := range []int{10, 1000, 100000} {
+		cfg := benchmarkConfig(n)
+		rl := NewRateLimit(&cfg, storage.NewMemoryCache())
+		ips := []string{"10.0.1.5", "2001:db8:0:3::1", "192.168.0.1"}
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_ = rl.IdsByIP(ctx, "HTTP/1.1", "GET", "/run", ips[i%len(ips)])
+			}
+		})
+	}
+}
+
+// TestRateLimit_IdsByIP_Templates test handlers by url templates and case sensitive handlers
+func TestRateLimit_IdsByIP_Templates(t *testing.T) {
+	ctx := context.Background()
+	cfg := Config{ByIp: ByIp{Data: []ByIpData{
+		{ID: "orders", Handlers: []LimitHandler{{Method: "GET", Url: "/Users/{id}/orders", Template: true}}},
+		{ID: "static", Mask: "10.0.0.0/8", Handlers: []LimitHandler{{Url: "/static/**", Template: true}}},
+		{ID: "api", Handlers: []LimitHandler{{Url: "/api/*/V2", Template: true, CaseSensitive: true}}},
+		{ID: "exact", Handlers: []LimitHandler{{Url: "/Run", CaseSensitive: true}}},
+		{ID: "regexp", Handlers: []LimitHandler{{Url: "^/Report/[0-9]+$", Regexp: true, CaseSensitive: true}}},
+		{ID: "broken", Handlers: []LimitHandler{{Url: "/users/{id", Template: true}}},
+	}}}
+	rl := NewRateLimit(&cfg, storage.NewMemoryCache())
+
+	ids := func(method, url, ip string) []string {
+		return rl.IdsByIP(ctx, "HTTP/1.1", method, url, ip)
+	}
+	assert.Equal(t, []string{"orders"}, ids("GET", "/users/42/ORDERS", "1.2.3.4"))
+	assert.Empty(t, ids("POST", "/users/42/orders", "1.2.3.4"))
+	assert.Empty(t, ids("GET", "/users/42/orders/7", "1.2.3.4"))
+	assert.Equal(t, []string{"static"}, ids("GET", "/static/css/site.css", "10.1.1.1"))
+	assert.Empty(t, ids("GET", "/static/css/site.css", "1.2.3.4"))
+	assert.Equal(t, []string{"api"}, ids("GET", "/api/billing/V2", "1.2.3.4"))
+	assert.Empty(t, ids("GET", "/api/billing/v2", "1.2.3.4"))
+	assert.Equal(t, []string{"exact"}, ids("GET", "/Run", "1.2.3.4"))
+	assert.Empty(t, ids("GET", "/run", "1.2.3.4"))
+	assert.Equal(t, []string{"regexp"}, ids("GET", "/Report/12", "1.2.3.4"))
+	assert.Empty(t, ids("GET", "/report/12", "1.2.3.4"))
+	assert.Empty(t, ids("GET", "/users/{id", "1.2.3.4"))
+	assert.Equal(t, []string{"orders", "api", "exact", "regexp", "broken"}, rl.IdsByIP(ctx, "*", "*", "*", "1.2.3.4"))
+}
+
+// TestRateLimit_UpdateConfigConcurrent test config is replaced while requests are checked. Run with -race.
+func TestRateLimit_UpdateConfigConcurrent(t *testing.T) {
+	ctx := context.Background()
+	cfg := TmpConfig()
+	rl := NewRateLimit(&cfg, storage.NewMemoryCache())
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			newCfg := TmpConfig()
+			assert.Nil(t, rl.UpdateConfig(&newCfg))
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		ids := rl.IdsByIP(ctx, "*", "*", "*", "123.45.67.1")
+		rl.IncByIDs(ctx, ids)
+		rl.IsLimitedByIDs(ctx, ids)
+		rl.StatusByIDs(ctx, ids)
+		_, _ = rl.IdsByPrefix("123.45.67.0/24")
+		_ = rl.RuleIDs()
+		_, _ = rl.Inspect(ctx, ids)
+		_ = rl.ClearByIDs(ctx, ids)
+	}
+	<-done
+}
@@ -0,0 +1,489 @@
+// Package cluster is peer-to-peer storage of several rate limit instances without external infrastructure.
+//
+// Every instance owns a slice of keys by consistent hash ring of peers. Operations with keys
+// of other peers are forwarded to the owner over HTTP, so a counter is kept by one instance only.
+// Peers are checked by health requests: unhealthy peers are removed from the ring and their keys
+// are owned by the rest of peers until they recover. Counters are not moved between peers
+// on rebalancing, moved keys start from zero on the new owner and old values expire by their ttl.
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// PathPrefix of cluster handlers
+	PathPrefix = "/cluster/"
+	// secretHeader is header of shared secret of peers
+	secretHeader = "X-Cluster-Secret"
+)
+
+// ErrPeerUnavailable is returned when key owner can't be called
+var ErrPeerUnavailable = errors.New("cluster peer is unavailable")
+
+// Cluster is ratelimit.Storager that keeps own keys in local storage and forwards other keys to their owners
+type Cluster struct {
+	self     string
+	local    ratelimit.Storager
+	client   *http.Client
+	replicas int
+	secret   string
+
+	healthInterval time.Duration
+	failThreshold  int
+	clock          clock.Clock
+
+	mu    sync.RWMutex
+	ring  *Ring
+	peers map[string]*peerState
+}
+
+// peerState health of peer
+type peerState struct {
+	healthy  bool
+	failures int
+}
+
+// Option configure Cluster
+type Option func(c *Cluster)
+
+// WithReplicas set count of virtual nodes of one peer on the ring
+func WithReplicas(replicas int) Option {
+	return func(c *Cluster) {
+		c.replicas = replicas
+	}
+}
+
+// WithHTTPClient set client of peer requests. Client timeout bounds one forwarded call.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Cluster) {
+		c.client = client
+	}
+}
+
+// WithSecret set shared secret of peers. Requests without the secret are rejected by Handler,
+// Handler of cluster without secret rejects all requests.
+func WithSecret(secret string) Option {
+	return func(c *Cluster) {
+		c.secret = secret
+	}
+}
+
+// WithHealthCheck set interval of health checks and count of failed checks to remove peer from the ring
+func WithHealthCheck(interval time.Duration, failThreshold int) Option {
+	return func(c *Cluster) {
+		c.healthInterval = interval
+		c.failThreshold = failThreshold
+	}
+}
+
+// WithClock set clock of health checks. clock.Real by default.
+func WithClock(clk clock.Clock) Option {
+	return func(c *Cluster) {
+		c.clock = clock.OrReal(clk)
+	}
+}
+
+// New create cluster storage. self is base URL of this instance as other peers call it, e.g. http://10.0.0.1:3000.
+// peers are base URLs of all instances, self is added if it is missing.
+func New(self string, peers []string, local ratelimit.Storager, opts ...Option) *Cluster {
+	c := &Cluster{
+		self:           normalize(self),
+		local:          local,
+		client:         &http.Client{Timeout: time.Second},
+		healthInterval: time.Second,
+		failThreshold:  2,
+		clock:          clock.Real,
+		peers:          make(map[string]*peerState),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.failThreshold < 1 {
+		c.failThreshold = 1
+	}
+
+	for _, peer := range peers {
+		peer = normalize(peer)
+		if peer != "" && peer != c.self {
+			c.peers[peer] = &peerState{healthy: true}
+		}
+	}
+	c.rebuild()
+
+	return c
+}
+
+// Self return base URL of this instance
+func (c *Cluster) Self() string {
+	return c.self
+}
+
+// Owner return peer owning the key
+func (c *Cluster) Owner(key []byte) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.ring.Get(key)
+}
+
+// Healthy return sorted peers of the ring, including self
+func (c *Cluster) Healthy() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.ring.Peers()
+}
+
+// Start check health of peers every health interval until ctx is done
+func (c *Cluster) Start(ctx context.Context) {
+	ticker := c.clock.NewTicker(c.healthInterval)
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C():
+				c.CheckPeers(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// CheckPeers call health handler of every peer and rebuild the ring if health of peers is changed
+func (c *Cluster) CheckPeers(ctx context.Context) {
+	c.mu.RLock()
+	peers := make([]string, 0, len(c.peers))
+	for peer := range c.peers {
+		peers = append(peers, peer)
+	}
+	c.mu.RUnlock()
+
+	for _, peer := range peers {
+		c.report(peer, c.checkPeer(ctx, peer))
+	}
+}
+
+// Get value by key
+func (c *Cluster) Get(ctx context.Context, key []byte) ([]byte, error) {
+	owner := c.Owner(key)
+	if owner == c.self {
+		return c.local.Get(ctx, key)
+	}
+
+	resp, err := c.forward(ctx, owner, request{Op: opGet, Key: key})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Value, nil
+}
+
+// Set value by key
+func (c *Cluster) Set(ctx context.Context, key []byte, value []byte, ttl *uint64) error {
+	owner := c.Owner(key)
+	if owner == c.self {
+		return c.local.Set(ctx, key, value, ttl)
+	}
+
+	_, err := c.forward(ctx, owner, request{Op: opSet, Key: key, Value: value, TTL: ttl})
+	return err
+}
+
+// Del values by keys
+func (c *Cluster) Del(ctx context.Context, list ...[]byte) error {
+	for _, key := range list {
+		owner := c.Owner(key)
+		if owner == c.self {
+			if err := c.local.Del(ctx, key); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, err := c.forward(ctx, owner, request{Op: opDel, Key: key}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Has check is set value by key. False if owner is unavailable.
+func (c *Cluster) Has(ctx context.Context, key []byte) bool {
+	owner := c.Owner(key)
+	if owner == c.self {
+		return c.local.Has(ctx, key)
+	}
+
+	resp, err := c.forward(ctx, owner, request{Op: opHas, Key: key})
+	return err == nil && resp.Found
+}
+
+// Inc value by key
+func (c *Cluster) Inc(ctx context.Context, key []byte, ttl *uint64) (int64, error) {
+	owner := c.Owner(key)
+	if owner == c.self {
+		return c.local.Inc(ctx, key, ttl)
+	}
+
+	resp, err := c.forward(ctx, owner, request{Op: opInc, Key: key, TTL: ttl})
+	return resp.Counter, err
+}
+
+// Decr value by key
+func (c *Cluster) Decr(ctx context.Context, key []byte, ttl *uint64) (int64, error) {
+	owner := c.Owner(key)
+	if owner == c.self {
+		return c.local.Decr(ctx, key, ttl)
+	}
+
+	resp, err := c.forward(ctx, owner, request{Op: opDecr, Key: key, TTL: ttl})
+	return resp.Counter, err
+}
+
+// Clear all keys of all healthy peers
+func (c *Cluster) Clear(ctx context.Context) error {
+	if err := c.local.Clear(ctx); err != nil {
+		return err
+	}
+
+	for _, peer := range c.Healthy() {
+		if peer == c.self {
+			continue
+		}
+		if _, err := c.forward(ctx, peer, request{Op: opClear}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Handler serve storage requests of peers and health checks. Mount it at PathPrefix.
+// Forwarded requests are always executed by local storage, so different rings of peers can't make a loop.
+// Peers are authenticated by shared secret, all requests are rejected if it is not set.
+func (c *Cluster) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(PathPrefix+"health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc(PathPrefix+"storage", c.serveStorage)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.secret == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get(secretHeader)), []byte(c.secret)) != 1 {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// serveStorage execute forwarded operation by local storage
+func (c *Cluster) serveStorage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	var resp response
+	var err error
+	switch req.Op {
+	case opGet:
+		resp.Value, err = c.local.Get(ctx, req.Key)
+	case opSet:
+		err = c.local.Set(ctx, req.Key, req.Value, req.TTL)
+	case opDel:
+		err = c.local.Del(ctx, req.Key)
+	case opHas:
+		resp.Found = c.local.Has(ctx, req.Key)
+	case opInc:
+		resp.Counter, err = c.local.Inc(ctx, req.Key, req.TTL)
+	case opDecr:
+		resp.Counter, err = c.local.Decr(ctx, req.Key, req.TTL)
+	case opClear:
+		err = c.local.Clear(ctx)
+	default:
+		http.Error(w, fmt.Sprintf("unknown operation %q", req.Op), http.StatusBadRequest)
+		return
+	}
+
+	if errors.Is(err, storage.ValueNotFoundByKey) {
+		resp.NotFound = true
+	} else if err != nil {
+		resp.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// forward operation to peer. Failed call is counted as failed health check of the peer.
+func (c *Cluster) forward(ctx context.Context, peer string, req request) (response, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return response{}, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, peer+PathPrefix+"storage", bytes.NewReader(body))
+	if err != nil {
+		return response{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	c.setSecret(httpReq)
+
+	httpResp, err := c.client.Do(httpReq)
+	if err != nil {
+		c.report(peer, false)
+		return response{}, fmt.Errorf("%w: %s: %s", ErrPeerUnavailable, peer, err.Error())
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		c.report(peer, false)
+		return response{}, fmt.Errorf("%w: %s: status %d", ErrPeerUnavailable, peer, httpResp.StatusCode)
+	}
+	// only consecutive failures make the peer unhealthy
+	c.report(peer, true)
+
+	var resp response
+	if err = json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return response{}, fmt.Errorf("%w: %s: %s", ErrPeerUnavailable, peer, err.Error())
+	}
+
+	if resp.NotFound {
+		return resp, storage.ValueNotFoundByKey
+	}
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+
+	return resp, nil
+}
+
+// checkPeer call health handler of peer
+func (c *Cluster) checkPeer(ctx context.Context, peer string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer+PathPrefix+"health", nil)
+	if err != nil {
+		return false
+	}
+	c.setSecret(req)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode == http.StatusOK
+}
+
+// report result of peer call and rebuild the ring when peer health is changed
+func (c *Cluster) report(peer string, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state, exists := c.peers[peer]
+	if !exists {
+		return
+	}
+
+	if ok {
+		state.failures = 0
+		if !state.healthy {
+			state.healthy = true
+			log.Printf("cluster peer %s is healthy, rebalancing", peer)
+			c.rebuildLocked()
+		}
+		return
+	}
+
+	state.failures++
+	if state.healthy && state.failures >= c.failThreshold {
+		state.healthy = false
+		log.Printf("cluster peer %s is unhealthy, rebalancing", peer)
+		c.rebuildLocked()
+	}
+}
+
+// rebuild the ring of self and healthy peers
+func (c *Cluster) rebuild() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rebuildLocked()
+}
+
+func (c *Cluster) rebuildLocked() {
+	peers := []string{c.self}
+	for peer, state := range c.peers {
+		if state.healthy {
+			peers = append(peers, peer)
+		}
+	}
+
+	c.ring = NewRing(c.replicas, peers...)
+}
+
+// setSecret add shared secret to peer request
+func (c *Cluster) setSecret(req *http.Request) {
+	if c.secret != "" {
+		req.Header.Set(secretHeader, c.secret)
+	}
+}
+
+// normalize peer URL
+func normalize(peer string) string {
+	return strings.TrimRight(strings.TrimSpace(peer), "/")
+}
+
+// operations of peer requests
+const (
+	opGet   = "get"
+	opSet   = "set"
+	opDel   = "del"
+	opHas   = "has"
+	opInc   = "inc"
+	opDecr  = "decr"
+	opClear = "clear"
+)
+
+// request is forwarded storage operation
+type request struct {
+	Op    string  `json:"op"`
+	Key   []byte  `json:"key,omitempty"`
+	Value []byte  `json:"value,omitempty"`
+	TTL   *uint64 `json:"ttl,omitempty"`
+}
+
+// response is result of forwarded operation
+type response struct {
+	Value    []byte `json:"value,omitempty"`
+	Counter  int64  `json:"counter,omitempty"`
+	Found    bool   `json:"found,omitempty"`
+	NotFound bool   `json:"not_found,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
@@ -0,0 +1,280 @@
+package cluster
+
+import (
+	"context"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var ten = uint64(10)
+
+// node is in-process cluster instance
+type node struct {
+	server  *httptest.Server
+	cluster *Cluster
+	local   *storage.MemoryCache
+	// down makes the instance answer 503 to everything
+	down atomic.Bool
+}
+
+// startNodes start n in-process instances of one cluster
+func startNodes(t *testing.T, n int, opts ...Option) []*node {
+	nodes := make([]*node, n)
+	peers := make([]string, n)
+	for i := range nodes {
+		srv := httptest.NewUnstartedServer(nil)
+		nodes[i] = &node{server: srv, local: storage.NewMemoryCache()}
+		peers[i] = "http://" + srv.Listener.Addr().String()
+	}
+
+	for i, nd := range nodes {
+		nd.cluster = New(peers[i], peers, nd.local, append([]Option{WithSecret("test")}, opts...)...)
+		handler := nd.cluster.Handler()
+		nd := nd
+		nd.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if nd.down.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			handler.ServeHTTP(w, r)
+		})
+		nd.server.Start()
+		t.Cleanup(nd.server.Close)
+	}
+
+	return nodes
+}
+
+// TestCluster_Inc test every key is counted by its owner only
+func TestCluster_Inc(t *testing.T) {
+	nodes := startNodes(t, 3)
+	ctx := context.Background()
+
+	for i := 0; i < 30; i++ {
+		key := []byte("key_" + strconv.Itoa(i))
+		for j, nd := range nodes {
+			value, err := nd.cluster.Inc(ctx, key, &ten)
+			assert.Nil(t, err)
+			assert.Equal(t, int64(j+1), value)
+		}
+
+		owner := nodes[0].cluster.Owner(key)
+		for _, nd := range nodes {
+			assert.Equal(t, owner, nd.cluster.Owner(key))
+			assert.Equal(t, nd.cluster.Self() == owner, nd.local.Has(ctx, key))
+
+			val, err := nd.cluster.Get(ctx, key)
+			assert.Nil(t, err)
+			assert.Equal(t, "3", string(val))
+		}
+	}
+}
+
+// TestCluster_Storager test forwarded operations of Storager
+func TestCluster_Storager(t *testing.T) {
+	nodes := startNodes(t, 2)
+	ctx := context.Background()
+	c := nodes[0].cluster
+
+	// find key of the other peer
+	var key []byte
+	for i := 0; ; i++ {
+		key = []byte("key_" + strconv.Itoa(i))
+		if c.Owner(key) != c.Self() {
+			break
+		}
+	}
+
+	_, err := c.Get(ctx, key)
+	assert.Equal(t, storage.ValueNotFoundByKey, err)
+	assert.False(t, c.Has(ctx, key))
+
+	err = c.Set(ctx, key, []byte("value"), &ten)
+	assert.Nil(t, err)
+	assert.True(t, c.Has(ctx, key))
+	val, err := c.Get(ctx, key)
+	assert.Nil(t, err)
+	assert.Equal(t, "value", string(val))
+
+	_, err = c.Inc(ctx, key, &ten)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "is not integer")
+
+	err = c.Del(ctx, key)
+	assert.Nil(t, err)
+	assert.False(t, nodes[1].local.Has(ctx, key))
+
+	value, err := c.Decr(ctx, key, &ten)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), value)
+
+	_, _ = nodes[0].local.Inc(ctx, []byte("local"), &ten)
+	err = c.Clear(ctx)
+	assert.Nil(t, err)
+	assert.False(t, nodes[0].local.Has(ctx, []byte("local")))
+	assert.False(t, nodes[1].local.Has(ctx, key))
+}
+
+// TestCluster_Rebalance test keys of failed peer are owned by the rest of peers until it recovers
+func TestCluster_Rebalance(t *testing.T) {
+	nodes := startNodes(t, 3, WithHealthCheck(time.Second, 1))
+	ctx := context.Background()
+	c := nodes[0].cluster
+	down := nodes[2]
+
+	var key []byte
+	for i := 0; ; i++ {
+		key = []byte("key_" + strconv.Itoa(i))
+		if c.Owner(key) == down.cluster.Self() {
+			break
+		}
+	}
+
+	down.down.Store(true)
+
+	// forwarded call fails and removes the peer from the ring
+	_, err := c.Inc(ctx, key, &ten)
+	assert.ErrorIs(t, err, ErrPeerUnavailable)
+	assert.Len(t, c.Healthy(), 2)
+	assert.NotEqual(t, down.cluster.Self(), c.Owner(key))
+
+	value, err := c.Inc(ctx, key, &ten)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), value)
+
+	// health check returns the peer to the ring
+	c.CheckPeers(ctx)
+	assert.Len(t, c.Healthy(), 2)
+
+	down.down.Store(false)
+	c.CheckPeers(ctx)
+	assert.Len(t, c.Healthy(), 3)
+	assert.Equal(t, down.cluster.Self(), c.Owner(key))
+}
+
+// TestCluster_ConsecutiveFailures test successful forwarded call resets failures of the peer
+func TestCluster_ConsecutiveFailures(t *testing.T) {
+	nodes := startNodes(t, 2, WithHealthCheck(time.Second, 2))
+	ctx := context.Background()
+	c := nodes[0].cluster
+	peer := nodes[1]
+
+	var key []byte
+	for i := 0; ; i++ {
+		key = []byte("key_" + strconv.Itoa(i))
+		if c.Owner(key) == peer.cluster.Self() {
+			break
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		peer.down.Store(true)
+		_, err := c.Inc(ctx, key, &ten)
+		assert.ErrorIs(t, err, ErrPeerUnavailable)
+
+		peer.down.Store(false)
+		_, err = c.Inc(ctx, key, &ten)
+		assert.Nil(t, err)
+	}
+	assert.Len(t, c.Healthy(), 2)
+
+	peer.down.Store(true)
+	for i := 0; i < 2; i++ {
+		_, _ = c.Inc(ctx, key, &ten)
+	}
+	assert.Len(t, c.Healthy(), 1)
+}
+
+// TestCluster_Start test health checks run every interval of the clock
+func TestCluster_Start(t *testing.T) {
+	clk := clock.NewManual(time.Now())
+	nodes := startNodes(t, 2, WithHealthCheck(time.Minute, 1), WithClock(clk))
+	c := nodes[0].cluster
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Start(ctx)
+
+	nodes[1].down.Store(true)
+	assert.Len(t, c.Healthy(), 2, "peer is checked only by ticks")
+	assert.Eventually(t, func() bool {
+		clk.Advance(time.Minute)
+		return len(c.Healthy()) == 1
+	}, time.Second, time.Millisecond)
+
+	nodes[1].down.Store(false)
+	assert.Eventually(t, func() bool {
+		clk.Advance(time.Minute)
+		return len(c.Healthy()) == 2
+	}, time.Second, time.Millisecond)
+}
+
+// TestCluster_Secret test peer requests without the secret are rejected
+func TestCluster_Secret(t *testing.T) {
+	nodes := startNodes(t, 2, WithSecret("secret"))
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		_, err := nodes[0].cluster.Inc(ctx, []byte("key_"+strconv.Itoa(i)), &ten)
+		assert.Nil(t, err)
+	}
+
+	resp, err := http.Get(nodes[1].server.URL + PathPrefix + "health")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+// TestCluster_NoSecret test cluster without secret serves no peer requests
+func TestCluster_NoSecret(t *testing.T) {
+	nodes := startNodes(t, 2, WithSecret(""))
+	ctx := context.Background()
+
+	failed := 0
+	for i := 0; i < 10; i++ {
+		if _, err := nodes[0].cluster.Inc(ctx, []byte("key_"+strconv.Itoa(i)), &ten); err != nil {
+			failed++
+		}
+	}
+	assert.True(t, failed > 0, "keys of the other peer are not served")
+
+	for _, path := range []string{"health", "storage"} {
+		req := httptest.NewRequest(http.MethodPost, PathPrefix+path, strings.NewReader(`{"op":"clear"}`))
+		res := httptest.NewRecorder()
+		nodes[1].cluster.Handler().ServeHTTP(res, req)
+		assert.Equal(t, http.StatusForbidden, res.Code, path)
+	}
+}
+
+// TestCluster_RateLimit test limit is shared by instances
+func TestCluster_RateLimit(t *testing.T) {
+	nodes := startNodes(t, 3)
+	ctx := context.Background()
+	cfg := &ratelimit.Config{ByIp: ratelimit.ByIp{Data: []ratelimit.ByIpData{
+		{ID: "rule", Limit: 3, BlockTime: 10},
+	}}}
+
+	limiters := make([]ratelimit.Storager, len(nodes))
+	for i, nd := range nodes {
+		limiters[i] = nd.cluster
+	}
+
+	for i := 0; i < 3; i++ {
+		rl := ratelimit.NewRateLimit(cfg, limiters[i])
+		assert.False(t, rl.IsLimitedByIDs(ctx, []string{"rule"}))
+		rl.IncByIDs(ctx, []string{"rule"})
+	}
+
+	for _, s := range limiters {
+		assert.True(t, ratelimit.NewRateLimit(cfg, s).IsLimitedByIDs(ctx, []string{"rule"}))
+	}
+}
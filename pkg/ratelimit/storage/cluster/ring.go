@@ -0,0 +1,69 @@
+package cluster
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// DefaultReplicas is count of virtual nodes of one peer on the ring
+const DefaultReplicas = 128
+
+// Ring is consistent hash ring of peers. Ring is immutable, it is rebuilt when peers change.
+type Ring struct {
+	hashes []uint32
+	owners map[uint32]string
+	peers  []string
+}
+
+// NewRing create ring of peers with replicas virtual nodes per peer. replicas <= 0 is DefaultReplicas.
+func NewRing(replicas int, peers ...string) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+
+	r := &Ring{
+		hashes: make([]uint32, 0, replicas*len(peers)),
+		owners: make(map[uint32]string, replicas*len(peers)),
+		peers:  make([]string, 0, len(peers)),
+	}
+	for _, peer := range peers {
+		r.peers = append(r.peers, peer)
+		for i := 0; i < replicas; i++ {
+			hash := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + "#" + peer))
+			// on collision the smaller peer wins, so every instance builds the same ring
+			if owner, ok := r.owners[hash]; ok {
+				if owner < peer {
+					continue
+				}
+			} else {
+				r.hashes = append(r.hashes, hash)
+			}
+			r.owners[hash] = peer
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	sort.Strings(r.peers)
+
+	return r
+}
+
+// Get return peer owning the key. Empty string if ring has no peers.
+func (r *Ring) Get(key []byte) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+
+	hash := crc32.ChecksumIEEE(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= hash })
+	if i == len(r.hashes) {
+		i = 0
+	}
+
+	return r.owners[r.hashes[i]]
+}
+
+// Peers return sorted peers of the ring
+func (r *Ring) Peers() []string {
+	return append([]string(nil), r.peers...)
+}
@@ -0,0 +1,42 @@
+package cluster
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+)
+
+// TestRing_Get test keys are spread over peers and the same ring is built from any order of peers
+func TestRing_Get(t *testing.T) {
+	assert.Equal(t, "", NewRing(0).Get([]byte("key")))
+
+	ring := NewRing(0, "http://a", "http://b", "http://c")
+	other := NewRing(0, "http://c", "http://a", "http://b")
+	assert.Equal(t, []string{"http://a", "http://b", "http://c"}, ring.Peers())
+
+	owned := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		key := []byte("key_" + strconv.Itoa(i))
+		owner := ring.Get(key)
+		assert.Equal(t, owner, other.Get(key))
+		owned[owner]++
+	}
+
+	assert.Len(t, owned, 3)
+	for _, n := range owned {
+		assert.Greater(t, n, 600)
+	}
+}
+
+// TestRing_Rebalance test only keys of removed peer change owner
+func TestRing_Rebalance(t *testing.T) {
+	ring := NewRing(0, "http://a", "http://b", "http://c")
+	smaller := NewRing(0, "http://a", "http://b")
+
+	for i := 0; i < 1000; i++ {
+		key := []byte("key_" + strconv.Itoa(i))
+		if owner := ring.Get(key); owner != "http://c" {
+			assert.Equal(t, owner, smaller.Get(key))
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package storage_test
+
+import (
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage/storagetest"
+	"testing"
+)
+
+// TestMemoryCache_Conformance test MemoryCache by storage conformance suite
+func TestMemoryCache_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T, clk clock.Clock) ratelimit.Storager {
+		return storage.NewMemoryCache(storage.WithClock(clk))
+	})
+}
+
+// TestMemoryCache_Conformance_Bounded test bounded MemoryCache by storage conformance suite
+func TestMemoryCache_Conformance_Bounded(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T, clk clock.Clock) ratelimit.Storager {
+		return storage.NewMemoryCache(storage.WithClock(clk), storage.WithMaxKeys(1000), storage.WithEvictionPolicy(storage.EvictLimited))
+	})
+}
+
+// TestShardedMemoryCache_Conformance test ShardedMemoryCache by storage conformance suite
+func TestShardedMemoryCache_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T, clk clock.Clock) ratelimit.Storager {
+		return storage.NewShardedMemoryCache(4, storage.WithShardedClock(clk))
+	})
+}
@@ -0,0 +1,527 @@
+// Package crdt is eventually consistent storage of counters replicated by gossip.
+//
+// Every replica keeps PN-counter of every key: increments and decrements of every replica are
+// counted separately and merged by maximum, so replicas converge in any order of deltas and
+// tolerate lost or repeated ones. Replicas send changed keys to peers every gossip interval.
+//
+// Over-admission is bounded by gossip: a replica doesn't see increments of others made during
+// the last gossip interval, and during a partition every side of it counts requests on its own,
+// so up to limit requests per side can be admitted in one window.
+//
+// Window of a key starts with its first increment. Replicas that start the window independently
+// merge into the earliest one, state of a window that ended before the other one started is discarded,
+// so counters of a previous window are never merged into the next one. Del, Set and Clear are replicated
+// as resets: the latest reset of a key wins.
+package crdt
+
+import (
+	"context"
+	"fmt"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Entry is replicated state of one key
+type Entry struct {
+	// P increments by replica
+	P map[string]int64 `json:"p,omitempty"`
+	// N decrements by replica
+	N map[string]int64 `json:"n,omitempty"`
+	// ExpireAt unix nano, 0 - without expiration
+	ExpireAt int64 `json:"expire_at,omitempty"`
+	// Window unix nano of start of the window of counters
+	Window int64 `json:"window,omitempty"`
+	// Reset unix nano of the last Set or Del of the key
+	Reset int64 `json:"reset,omitempty"`
+	// Deleted is tombstone, it is kept until ExpireAt to replicate Del
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// Delta is gossip message with changed keys of a replica
+type Delta struct {
+	From string `json:"from"`
+	// ClearedAt unix nano of the last Clear known by the replica
+	ClearedAt int64            `json:"cleared_at,omitempty"`
+	Entries   map[string]Entry `json:"entries,omitempty"`
+}
+
+// Transport send deltas to peers
+type Transport interface {
+	Send(ctx context.Context, peer string, delta Delta) error
+}
+
+// Store is ratelimit.Storager of counters replicated between peers by gossip
+type Store struct {
+	replica   string
+	peers     []string
+	transport Transport
+
+	gossipInterval time.Duration
+	tombstoneTTL   time.Duration
+	clock          clock.Clock
+
+	mu        sync.Mutex
+	entries   map[string]*Entry
+	clearedAt int64
+	seq       uint64
+	// dirty keys of every peer with seq of their change
+	dirty map[string]map[string]uint64
+	// sentClear is the last ClearedAt received by every peer
+	sentClear map[string]int64
+}
+
+// Option configure Store
+type Option func(s *Store)
+
+// WithGossipInterval set how often changed keys are sent to peers. 100ms by default.
+func WithGossipInterval(interval time.Duration) Option {
+	return func(s *Store) {
+		s.gossipInterval = interval
+	}
+}
+
+// WithTombstoneTTL set how long deleted keys are kept to replicate Del. 1 minute by default.
+func WithTombstoneTTL(ttl time.Duration) Option {
+	return func(s *Store) {
+		s.tombstoneTTL = ttl
+	}
+}
+
+// WithClock set clock of windows and gossip. clock.Real by default.
+func WithClock(clk clock.Clock) Option {
+	return func(s *Store) {
+		s.clock = clock.OrReal(clk)
+	}
+}
+
+// New create replica of counters. replica is unique ID of this instance, peers are IDs of other replicas for transport.
+func New(replica string, peers []string, transport Transport, opts ...Option) *Store {
+	s := &Store{
+		replica:        replica,
+		transport:      transport,
+		gossipInterval: 100 * time.Millisecond,
+		tombstoneTTL:   time.Minute,
+		clock:          clock.Real,
+		entries:        make(map[string]*Entry),
+		dirty:          make(map[string]map[string]uint64),
+		sentClear:      make(map[string]int64),
+	}
+	for _, peer := range peers {
+		if peer == replica {
+			continue
+		}
+		s.peers = append(s.peers, peer)
+		s.dirty[peer] = make(map[string]uint64)
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Start gossip every gossip interval until ctx is done
+func (s *Store) Start(ctx context.Context) {
+	ticker := s.clock.NewTicker(s.gossipInterval)
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C():
+				if err := s.Gossip(ctx); err != nil {
+					log.Printf("crdt gossip error: %s", err.Error())
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Gossip send changed keys to every peer. Keys are sent again until the peer receives them.
+func (s *Store) Gossip(ctx context.Context) error {
+	s.purge(s.clock.Now().UnixNano())
+
+	var firstErr error
+	for _, peer := range s.peers {
+		delta, sent, ok := s.delta(peer)
+		if !ok {
+			continue
+		}
+
+		if err := s.transport.Send(ctx, peer, delta); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("send delta to %s: %w", peer, err)
+			}
+			continue
+		}
+
+		s.mu.Lock()
+		for key, seq := range sent {
+			if s.dirty[peer][key] == seq {
+				delete(s.dirty[peer], key)
+			}
+		}
+		if delta.ClearedAt > s.sentClear[peer] {
+			s.sentClear[peer] = delta.ClearedAt
+		}
+		s.mu.Unlock()
+	}
+
+	return firstErr
+}
+
+// Receive merge delta of peer. Changed keys are sent further to other peers.
+func (s *Store) Receive(ctx context.Context, delta Delta) {
+	now := s.clock.Now().UnixNano()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if delta.ClearedAt > s.clearedAt {
+		s.clear(delta.ClearedAt, now)
+		s.sentClear[delta.From] = delta.ClearedAt
+	}
+
+	for key, remote := range delta.Entries {
+		if remote.Reset < s.clearedAt || expired(&remote, now) {
+			continue
+		}
+
+		local, ok := s.entries[key]
+		if !ok || expired(local, now) {
+			s.entries[key] = copyEntry(&remote)
+			s.changed(key, delta.From)
+			continue
+		}
+
+		if merge(local, &remote) {
+			s.changed(key, delta.From)
+		}
+	}
+}
+
+// Get value of counter by key
+func (s *Store) Get(ctx context.Context, key []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.live(string(key), s.clock.Now().UnixNano())
+	if !ok {
+		return nil, storage.ValueNotFoundByKey
+	}
+
+	return []byte(strconv.FormatInt(value(e), 10)), nil
+}
+
+// Set value of counter by key. Only integer values are supported. Set is replicated as reset of the key.
+func (s *Store) Set(ctx context.Context, key []byte, val []byte, ttl *uint64) error {
+	if len(key) == 0 {
+		return fmt.Errorf("key is empty")
+	}
+
+	valInt, err := strconv.ParseInt(string(val), 10, 64)
+	if err != nil {
+		return fmt.Errorf("value by key %s is not integer", key)
+	}
+
+	now := s.clock.Now().UnixNano()
+	strKey := string(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := &Entry{P: make(map[string]int64), N: make(map[string]int64), Window: now}
+	if old, ok := s.live(strKey, now); ok {
+		e.ExpireAt, e.Window = old.ExpireAt, old.Window
+	} else if ttl != nil && *ttl > 0 {
+		e.ExpireAt = now + int64(time.Duration(*ttl)*time.Second)
+	}
+	e.Reset = s.nextReset(strKey, now)
+	if valInt >= 0 {
+		e.P[s.replica] = valInt
+	} else {
+		e.N[s.replica] = -valInt
+	}
+
+	s.entries[strKey] = e
+	s.changed(strKey, "")
+
+	return nil
+}
+
+// Del values by keys. Deleted keys are kept as tombstones to replicate Del.
+func (s *Store) Del(ctx context.Context, list ...[]byte) error {
+	now := s.clock.Now().UnixNano()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range list {
+		strKey := string(key)
+		s.entries[strKey] = &Entry{
+			ExpireAt: now + int64(s.tombstoneTTL),
+			Window:   now,
+			Reset:    s.nextReset(strKey, now),
+			Deleted:  true,
+		}
+		s.changed(strKey, "")
+	}
+
+	return nil
+}
+
+// Has check is set value by key
+func (s *Store) Has(ctx context.Context, key []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.live(string(key), s.clock.Now().UnixNano())
+	return ok
+}
+
+// Inc value by key. Window of the key starts with the first increment.
+func (s *Store) Inc(ctx context.Context, key []byte, ttl *uint64) (int64, error) {
+	return s.add(key, 1, ttl)
+}
+
+// Decr value by key
+func (s *Store) Decr(ctx context.Context, key []byte, ttl *uint64) (int64, error) {
+	return s.add(key, -1, ttl)
+}
+
+// Clear all keys of all replicas
+func (s *Store) Clear(ctx context.Context) error {
+	now := s.clock.Now().UnixNano()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clearedAt := now
+	if clearedAt <= s.clearedAt {
+		clearedAt = s.clearedAt + 1
+	}
+	s.clear(clearedAt, now)
+
+	return nil
+}
+
+// add delta to the counter of this replica
+func (s *Store) add(key []byte, delta int64, ttl *uint64) (int64, error) {
+	now := s.clock.Now().UnixNano()
+	strKey := string(key)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.live(strKey, now)
+	if !ok {
+		reset := s.clearedAt
+		if old, exists := s.entries[strKey]; exists && old.Reset > reset {
+			reset = old.Reset
+		}
+
+		e = &Entry{P: make(map[string]int64), N: make(map[string]int64), Reset: reset, Window: now}
+		if ttl != nil && *ttl > 0 {
+			e.ExpireAt = now + int64(time.Duration(*ttl)*time.Second)
+		}
+		s.entries[strKey] = e
+	}
+
+	if delta > 0 {
+		e.P[s.replica] += delta
+	} else {
+		e.N[s.replica] -= delta
+	}
+	s.changed(strKey, "")
+
+	return value(e), nil
+}
+
+// live return not expired and not deleted entry
+func (s *Store) live(key string, now int64) (*Entry, bool) {
+	e, ok := s.entries[key]
+	if !ok || e.Deleted || expired(e, now) {
+		return nil, false
+	}
+
+	return e, true
+}
+
+// nextReset return reset time of key later than the known one
+func (s *Store) nextReset(key string, now int64) int64 {
+	reset := now
+	if reset <= s.clearedAt {
+		reset = s.clearedAt + 1
+	}
+	if e, ok := s.entries[key]; ok && reset <= e.Reset {
+		reset = e.Reset + 1
+	}
+
+	return reset
+}
+
+// changed mark key as dirty for all peers except the source of change
+func (s *Store) changed(key string, from string) {
+	s.seq++
+	for _, peer := range s.peers {
+		if peer != from {
+			s.dirty[peer][key] = s.seq
+		}
+	}
+}
+
+// clear drop all entries reset before clearedAt
+func (s *Store) clear(clearedAt int64, now int64) {
+	s.clearedAt = clearedAt
+	for key, e := range s.entries {
+		if e.Reset < clearedAt {
+			s.entries[key] = &Entry{ExpireAt: now + int64(s.tombstoneTTL), Window: now, Reset: clearedAt, Deleted: true}
+		}
+	}
+}
+
+// delta build delta of dirty keys for peer. False if there is nothing to send.
+func (s *Store) delta(peer string) (Delta, map[string]uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dirty := s.dirty[peer]
+	if len(dirty) == 0 && s.sentClear[peer] >= s.clearedAt {
+		return Delta{}, nil, false
+	}
+
+	delta := Delta{From: s.replica, ClearedAt: s.clearedAt, Entries: make(map[string]Entry, len(dirty))}
+	sent := make(map[string]uint64, len(dirty))
+	for key, seq := range dirty {
+		sent[key] = seq
+		if e, ok := s.entries[key]; ok {
+			delta.Entries[key] = *copyEntry(e)
+		}
+	}
+
+	return delta, sent, true
+}
+
+// purge drop expired entries
+func (s *Store) purge(now int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, e := range s.entries {
+		if expired(e, now) {
+			delete(s.entries, key)
+			for _, dirty := range s.dirty {
+				delete(dirty, key)
+			}
+		}
+	}
+}
+
+// merge remote entry into local one. Return true if local entry is changed.
+func merge(local, remote *Entry) bool {
+	if remote.Reset < local.Reset {
+		return false
+	}
+	if remote.Reset > local.Reset {
+		*local = *copyEntry(remote)
+		return true
+	}
+
+	// window that ended before the other one started is the previous one
+	if remote.ExpireAt != 0 && remote.ExpireAt <= local.Window {
+		return false
+	}
+	if local.ExpireAt != 0 && local.ExpireAt <= remote.Window {
+		*local = *copyEntry(remote)
+		return true
+	}
+
+	changed := mergeMax(&local.P, remote.P)
+	changed = mergeMax(&local.N, remote.N) || changed
+
+	// the earliest window wins, tombstone doesn't shorten live window
+	expireAt := local.ExpireAt
+	switch {
+	case local.Deleted && remote.Deleted:
+		if remote.ExpireAt > expireAt {
+			expireAt = remote.ExpireAt
+		}
+	case local.Deleted:
+		expireAt = remote.ExpireAt
+	case !remote.Deleted:
+		if remote.ExpireAt != 0 && (expireAt == 0 || remote.ExpireAt < expireAt) {
+			expireAt = remote.ExpireAt
+		}
+	}
+	if expireAt != local.ExpireAt {
+		local.ExpireAt = expireAt
+		changed = true
+	}
+	if remote.Window < local.Window {
+		local.Window = remote.Window
+		changed = true
+	}
+
+	deleted := local.Deleted && remote.Deleted
+	if deleted != local.Deleted {
+		local.Deleted = deleted
+		changed = true
+	}
+
+	return changed
+}
+
+// mergeMax merge counters of replicas by maximum
+func mergeMax(local *map[string]int64, remote map[string]int64) bool {
+	changed := false
+	for replica, n := range remote {
+		if *local == nil {
+			*local = make(map[string]int64)
+		}
+		if n > (*local)[replica] {
+			(*local)[replica] = n
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// value of PN-counter
+func value(e *Entry) int64 {
+	var v int64
+	for _, n := range e.P {
+		v += n
+	}
+	for _, n := range e.N {
+		v -= n
+	}
+
+	return v
+}
+
+// expired check is entry expired at now
+func expired(e *Entry, now int64) bool {
+	return e.ExpireAt != 0 && now >= e.ExpireAt
+}
+
+// copyEntry deep copy of entry
+func copyEntry(e *Entry) *Entry {
+	c := *e
+	c.P = make(map[string]int64, len(e.P))
+	for replica, n := range e.P {
+		c.P[replica] = n
+	}
+	c.N = make(map[string]int64, len(e.N))
+	for replica, n := range e.N {
+		c.N[replica] = n
+	}
+
+	return &c
+}
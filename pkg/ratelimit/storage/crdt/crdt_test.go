@@ -0,0 +1,343 @@
+package crdt
+
+import (
+	"context"
+	"errors"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+var ten = uint64(10)
+
+var errPartitioned = errors.New("network is partitioned")
+
+// network is in-process transport between replicas with partitions
+type network struct {
+	mu       sync.Mutex
+	replicas map[string]*Store
+	// group of replica, replicas of different groups can't reach each other
+	group map[string]int
+}
+
+func (n *network) Send(ctx context.Context, peer string, delta Delta) error {
+	n.mu.Lock()
+	to, ok := n.replicas[peer]
+	partitioned := n.group[peer] != n.group[delta.From]
+	n.mu.Unlock()
+
+	if !ok || partitioned {
+		return errPartitioned
+	}
+
+	to.Receive(ctx, delta)
+	return nil
+}
+
+// partition split replicas into groups, replicas without group are in group 0
+func (n *network) partition(groups ...[]string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.group = make(map[string]int)
+	for i, g := range groups {
+		for _, replica := range g {
+			n.group[replica] = i + 1
+		}
+	}
+}
+
+// heal remove all partitions
+func (n *network) heal() {
+	n.partition()
+}
+
+// newNetwork create replicas connected by in-process network
+func newNetwork(names ...string) (*network, map[string]*Store) {
+	n := &network{replicas: make(map[string]*Store), group: make(map[string]int)}
+	for _, name := range names {
+		n.replicas[name] = New(name, names, n)
+	}
+
+	return n, n.replicas
+}
+
+// gossip run gossip rounds of all replicas
+func gossip(t *testing.T, replicas map[string]*Store, rounds int) {
+	for i := 0; i < rounds; i++ {
+		for _, s := range replicas {
+			_ = s.Gossip(context.Background())
+		}
+	}
+}
+
+// assertValue check value of key on replicas
+func assertValue(t *testing.T, want string, key string, replicas ...*Store) {
+	for _, s := range replicas {
+		val, err := s.Get(context.Background(), []byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, want, string(val), s.replica)
+	}
+}
+
+// TestStore_Inc test replicas converge to sum of their increments
+func TestStore_Inc(t *testing.T) {
+	_, r := newNetwork("a", "b", "c")
+	ctx := context.Background()
+
+	value, err := r["a"].Inc(ctx, []byte("key"), &ten)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), value)
+	_, _ = r["b"].Inc(ctx, []byte("key"), &ten)
+	_, _ = r["b"].Inc(ctx, []byte("key"), &ten)
+	_, _ = r["c"].Decr(ctx, []byte("key"), &ten)
+
+	_, err = r["a"].Get(ctx, []byte("other"))
+	assert.Equal(t, storage.ValueNotFoundByKey, err)
+
+	gossip(t, r, 1)
+	assertValue(t, "2", "key", r["a"], r["b"], r["c"])
+	assert.True(t, r["c"].Has(ctx, []byte("key")))
+
+	// repeated gossip doesn't change values
+	gossip(t, r, 2)
+	assertValue(t, "2", "key", r["a"], r["b"], r["c"])
+	for _, s := range r {
+		for _, dirty := range s.dirty {
+			assert.Len(t, dirty, 0)
+		}
+	}
+}
+
+// TestStore_Partition test replicas count on their own during partition and converge after it
+func TestStore_Partition(t *testing.T) {
+	n, r := newNetwork("a", "b", "c")
+	ctx := context.Background()
+
+	n.partition([]string{"a"}, []string{"b", "c"})
+	_, _ = r["a"].Inc(ctx, []byte("key"), &ten)
+	_, _ = r["a"].Inc(ctx, []byte("key"), &ten)
+	_, _ = r["b"].Inc(ctx, []byte("key"), &ten)
+	_, _ = r["c"].Inc(ctx, []byte("key"), &ten)
+	_, _ = r["c"].Inc(ctx, []byte("key"), &ten)
+
+	err := r["a"].Gossip(ctx)
+	assert.ErrorIs(t, err, errPartitioned)
+	gossip(t, r, 1)
+	assertValue(t, "2", "key", r["a"])
+	assertValue(t, "3", "key", r["b"], r["c"])
+
+	// undelivered deltas are sent after the partition heals
+	n.heal()
+	gossip(t, r, 1)
+	assertValue(t, "5", "key", r["a"], r["b"], r["c"])
+}
+
+// TestStore_Relay test changes reach partitioned replica through other one
+func TestStore_Relay(t *testing.T) {
+	n, r := newNetwork("a", "b", "c")
+	ctx := context.Background()
+
+	_, _ = r["a"].Inc(ctx, []byte("key"), &ten)
+	// a reaches b only, b reaches c later
+	n.partition([]string{"a", "b"}, []string{"c"})
+	_ = r["a"].Gossip(ctx)
+	n.partition([]string{"a"}, []string{"b", "c"})
+	_ = r["b"].Gossip(ctx)
+
+	assertValue(t, "1", "key", r["c"])
+}
+
+// TestStore_RateLimit test over-admission of partitioned replicas is bounded by limit per side
+func TestStore_RateLimit(t *testing.T) {
+	n, r := newNetwork("a", "b")
+	ctx := context.Background()
+	cfg := &ratelimit.Config{ByIp: ratelimit.ByIp{Data: []ratelimit.ByIpData{
+		{ID: "rule", Limit: 3, BlockTime: 10},
+	}}}
+
+	n.partition([]string{"a"}, []string{"b"})
+	admitted := 0
+	for i := 0; i < 10; i++ {
+		for _, name := range []string{"a", "b"} {
+			rl := ratelimit.NewRateLimit(cfg, r[name])
+			if !rl.IsLimitedByIDs(ctx, []string{"rule"}) {
+				rl.IncByIDs(ctx, []string{"rule"})
+				admitted++
+			}
+		}
+		gossip(t, r, 1)
+	}
+	assert.Equal(t, 6, admitted)
+
+	n.heal()
+	gossip(t, r, 1)
+	assertValue(t, "6", "rule", r["a"], r["b"])
+}
+
+// TestStore_Del test deletion is replicated and wins over older state
+func TestStore_Del(t *testing.T) {
+	n, r := newNetwork("a", "b")
+	ctx := context.Background()
+
+	_, _ = r["a"].Inc(ctx, []byte("key"), &ten)
+	_, _ = r["b"].Inc(ctx, []byte("key"), &ten)
+	gossip(t, r, 1)
+
+	n.partition([]string{"a"}, []string{"b"})
+	assert.Nil(t, r["a"].Del(ctx, []byte("key")))
+	_, _ = r["b"].Inc(ctx, []byte("key"), &ten)
+	assert.False(t, r["a"].Has(ctx, []byte("key")))
+
+	// new window after deletion
+	value, err := r["a"].Inc(ctx, []byte("key"), &ten)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), value)
+
+	n.heal()
+	gossip(t, r, 1)
+	assertValue(t, "1", "key", r["a"], r["b"])
+}
+
+// TestStore_Set test Set is replicated as reset of the key
+func TestStore_Set(t *testing.T) {
+	_, r := newNetwork("a", "b")
+	ctx := context.Background()
+
+	err := r["a"].Set(ctx, []byte("key"), []byte("value"), &ten)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "is not integer")
+	err = r["a"].Set(ctx, []byte(""), []byte("1"), &ten)
+	assert.NotNil(t, err)
+
+	_, _ = r["b"].Inc(ctx, []byte("key"), &ten)
+	gossip(t, r, 1)
+
+	assert.Nil(t, r["a"].Set(ctx, []byte("key"), []byte("7"), &ten))
+	assert.Nil(t, r["b"].Set(ctx, []byte("negative"), []byte("-2"), &ten))
+	gossip(t, r, 1)
+	assertValue(t, "7", "key", r["a"], r["b"])
+	assertValue(t, "-2", "negative", r["a"], r["b"])
+}
+
+// TestStore_Clear test Clear is replicated
+func TestStore_Clear(t *testing.T) {
+	_, r := newNetwork("a", "b", "c")
+	ctx := context.Background()
+
+	_, _ = r["a"].Inc(ctx, []byte("key1"), &ten)
+	_, _ = r["b"].Inc(ctx, []byte("key2"), &ten)
+	gossip(t, r, 1)
+
+	assert.Nil(t, r["c"].Clear(ctx))
+	gossip(t, r, 1)
+	for _, s := range r {
+		assert.False(t, s.Has(ctx, []byte("key1")))
+		assert.False(t, s.Has(ctx, []byte("key2")))
+	}
+
+	_, _ = r["a"].Inc(ctx, []byte("key1"), &ten)
+	gossip(t, r, 1)
+	assertValue(t, "1", "key1", r["a"], r["b"], r["c"])
+}
+
+// TestStore_Expire test windows expire on all replicas and the earliest window wins
+func TestStore_Expire(t *testing.T) {
+	_, r := newNetwork("a", "b")
+	ctx := context.Background()
+	one := uint64(1)
+	clk := clock.NewManual(time.Now())
+	for _, s := range r {
+		s.clock = clk
+	}
+
+	_, _ = r["a"].Inc(ctx, []byte("key"), &one)
+	clk.Advance(500 * time.Millisecond)
+	_, _ = r["b"].Inc(ctx, []byte("key"), &ten)
+	gossip(t, r, 1)
+	assertValue(t, "2", "key", r["a"], r["b"])
+
+	clk.Advance(500 * time.Millisecond)
+	assert.False(t, r["a"].Has(ctx, []byte("key")))
+	assert.False(t, r["b"].Has(ctx, []byte("key")))
+
+	gossip(t, r, 1)
+	assert.Len(t, r["a"].entries, 0)
+}
+
+// TestStore_PartitionWindows test counters of the previous window are not merged into the next one
+// when a partitioned replica still sees the previous window by its clock
+func TestStore_PartitionWindows(t *testing.T) {
+	n, r := newNetwork("a", "b")
+	ctx := context.Background()
+	one := uint64(1)
+	clkA := clock.NewManual(time.Unix(1700000000, 0))
+	clkB := clock.NewManual(clkA.Now().Add(-500 * time.Millisecond))
+	r["a"].clock, r["b"].clock = clkA, clkB
+
+	for i := 0; i < 3; i++ {
+		_, _ = r["a"].Inc(ctx, []byte("key"), &one)
+	}
+	gossip(t, r, 1)
+	assertValue(t, "3", "key", r["a"], r["b"])
+
+	n.partition([]string{"a"}, []string{"b"})
+	clkA.Advance(1200 * time.Millisecond)
+	clkB.Advance(1200 * time.Millisecond)
+	value, err := r["a"].Inc(ctx, []byte("key"), &one)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), value, "the next window of a")
+	assertValue(t, "3", "key", r["b"])
+
+	n.heal()
+	gossip(t, r, 2)
+	assertValue(t, "1", "key", r["a"], r["b"])
+}
+
+// TestHTTPTransport test gossip between replicas over HTTP
+func TestHTTPTransport(t *testing.T) {
+	ctx := context.Background()
+	servers := make([]*httptest.Server, 2)
+	peers := make([]string, 2)
+	for i := range servers {
+		servers[i] = httptest.NewUnstartedServer(nil)
+		peers[i] = "http://" + servers[i].Listener.Addr().String()
+	}
+
+	replicas := make([]*Store, 2)
+	for i, srv := range servers {
+		replicas[i] = New(peers[i], peers, &HTTPTransport{Secret: "secret"})
+		srv.Config.Handler = replicas[i].Handler("secret")
+		srv.Start()
+		defer srv.Close()
+	}
+
+	_, _ = replicas[0].Inc(ctx, []byte("key"), &ten)
+	_, _ = replicas[1].Inc(ctx, []byte("key"), &ten)
+	assert.Nil(t, replicas[0].Gossip(ctx))
+	assert.Nil(t, replicas[1].Gossip(ctx))
+	assertValue(t, "2", "key", replicas...)
+
+	// wrong secret
+	err := (&HTTPTransport{}).Send(ctx, peers[0], Delta{From: "x"})
+	assert.NotNil(t, err)
+}
+
+// TestStore_HandlerNoSecret test handler without secret rejects every delta
+func TestStore_HandlerNoSecret(t *testing.T) {
+	replica := New("http://a", nil, &HTTPTransport{})
+	_, _ = replica.Inc(context.Background(), []byte("key"), &ten)
+
+	srv := httptest.NewServer(replica.Handler(""))
+	defer srv.Close()
+	for _, secret := range []string{"", "guess"} {
+		err := (&HTTPTransport{Secret: secret}).Send(context.Background(), srv.URL, Delta{From: "x"})
+		assert.NotNil(t, err)
+	}
+	assertValue(t, "1", "key", replica)
+}
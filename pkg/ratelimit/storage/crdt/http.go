@@ -0,0 +1,83 @@
+package crdt
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	// GossipPath is path of gossip handler
+	GossipPath = "/crdt/gossip"
+	// secretHeader is header of shared secret of replicas
+	secretHeader = "X-Crdt-Secret"
+)
+
+// HTTPTransport send deltas to GossipPath of peers. Peers are base URLs of replicas.
+type HTTPTransport struct {
+	Client *http.Client
+	// Secret shared by replicas, Handler rejects requests without it
+	Secret string
+}
+
+// Send post delta to peer
+func (t *HTTPTransport) Send(ctx context.Context, peer string, delta Delta) error {
+	body, err := json.Marshal(delta)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(peer, "/")+GossipPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if t.Secret != "" {
+		req.Header.Set(secretHeader, t.Secret)
+	}
+
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Handler receive deltas of peers sent by HTTPTransport. Mount it at GossipPath of listener
+// that is not reachable by clients. Deltas are trusted, so all requests are rejected if secret is empty.
+func (s *Store) Handler(secret string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if secret == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get(secretHeader)), []byte(secret)) != 1 {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		var delta Delta
+		if err := json.NewDecoder(r.Body).Decode(&delta); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.Receive(r.Context(), delta)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,286 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/itbellissimo/ratelimit/pkg/metrics"
+	"math"
+	"time"
+)
+
+// EvictedValue is counter value of keys evicted with EvictLimited policy
+const EvictedValue = math.MaxInt64
+
+// entryOverhead approximate memory of one key in MemoryCache besides key and value bytes
+const entryOverhead = 128
+
+// defaultEvictedCapacity count of remembered evicted keys when max keys is not set
+const defaultEvictedCapacity = 4096
+
+// EvictionPolicy what to do with counters evicted from bounded MemoryCache
+type EvictionPolicy int
+
+const (
+	// EvictDrop forget evicted counter. Next request of the key starts from zero.
+	EvictDrop EvictionPolicy = iota
+	// EvictLimited remember evicted key until its remaining ttl and report it as EvictedValue,
+	// so the evicted client stays limited. Keys without ttl are forgotten like with EvictDrop.
+	EvictLimited
+)
+
+// ParseEvictionPolicy parse policy by name: "drop" or "limited". Empty name is "drop".
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	switch name {
+	case "", "drop":
+		return EvictDrop, nil
+	case "limited":
+		return EvictLimited, nil
+	}
+
+	return EvictDrop, fmt.Errorf("unknown eviction policy %q", name)
+}
+
+// MemoryCacheOption configure MemoryCache
+type MemoryCacheOption func(c *MemoryCache)
+
+// WithMaxKeys limit count of keys. Least recently used keys are evicted. Zero is unlimited.
+func WithMaxKeys(n int) MemoryCacheOption {
+	return func(c *MemoryCache) {
+		c.maxKeys = n
+	}
+}
+
+// WithMaxBytes limit approximate memory of keys and values. Zero is unlimited.
+func WithMaxBytes(n int64) MemoryCacheOption {
+	return func(c *MemoryCache) {
+		c.maxBytes = n
+	}
+}
+
+// WithEvictionPolicy set policy of evicted counters
+func WithEvictionPolicy(policy EvictionPolicy) MemoryCacheOption {
+	return func(c *MemoryCache) {
+		c.policy = policy
+	}
+}
+
+// WithTTLMode set what to do with ttl of existing counters. KeepTTL by default.
+func WithTTLMode(mode TTLMode) MemoryCacheOption {
+	return func(c *MemoryCache) {
+		c.ttlMode = mode
+	}
+}
+
+// WithClock set clock of expirations. clock.Real by default.
+func WithClock(clk clock.Clock) MemoryCacheOption {
+	return func(c *MemoryCache) {
+		c.clock = clock.OrReal(clk)
+	}
+}
+
+// MemoryCacheStats counters of MemoryCache
+type MemoryCacheStats struct {
+	Keys           int
+	Bytes          int64 // tracked only for bounded cache
+	Evictions      uint64
+	EvictedBytes   uint64
+	EvictedLimited uint64 // requests answered with EvictedValue
+}
+
+// Stats return current counters
+func (c *MemoryCache) Stats() MemoryCacheStats {
+	c.dataMu.RLock()
+	defer c.dataMu.RUnlock()
+
+	return MemoryCacheStats{
+		Keys:           len(c.data),
+		Bytes:          c.bytes,
+		Evictions:      c.evictions.Load(),
+		EvictedBytes:   c.evictedBytes.Load(),
+		EvictedLimited: c.evictedLimited.Load(),
+	}
+}
+
+// RegisterMetrics register eviction metrics of the cache
+func (c *MemoryCache) RegisterMetrics(reg *metrics.Registry) error {
+	if err := reg.GaugeFunc("ratelimit_memory_cache_keys", "Count of keys in memory cache.", func() float64 {
+		return float64(c.Stats().Keys)
+	}); err != nil {
+		return err
+	}
+
+	if err := reg.GaugeFunc("ratelimit_memory_cache_bytes", "Approximate memory of keys in bounded memory cache.", func() float64 {
+		return float64(c.Stats().Bytes)
+	}); err != nil {
+		return err
+	}
+
+	if err := reg.CounterFunc("ratelimit_memory_cache_evictions_total", "Count of evicted keys.", func() float64 {
+		return float64(c.evictions.Load())
+	}); err != nil {
+		return err
+	}
+
+	if err := reg.CounterFunc("ratelimit_memory_cache_evicted_bytes_total", "Approximate memory of evicted keys.", func() float64 {
+		return float64(c.evictedBytes.Load())
+	}); err != nil {
+		return err
+	}
+
+	return reg.CounterFunc("ratelimit_memory_cache_evicted_limited_total", "Count of requests to evicted keys answered as limited.", func() float64 {
+		return float64(c.evictedLimited.Load())
+	})
+}
+
+// lruEntry is element of MemoryCache lru list
+type lruEntry struct {
+	key  string
+	size int64
+}
+
+// bounded check is cache limited by keys or bytes
+func (c *MemoryCache) bounded() bool {
+	return c.maxKeys > 0 || c.maxBytes > 0
+}
+
+// touch mark key as recently used and evict old keys. Must be called under dataMu lock.
+func (c *MemoryCache) touch(strKey string, ttl *uint64) {
+	if !c.bounded() {
+		return
+	}
+
+	size := int64(len(strKey)+len(c.data[strKey])) + entryOverhead
+	el, ok := c.entries[strKey]
+	if !ok {
+		el = c.lru.PushFront(&lruEntry{key: strKey})
+		c.entries[strKey] = el
+	} else {
+		c.lru.MoveToFront(el)
+	}
+
+	entry := el.Value.(*lruEntry)
+	c.bytes += size - entry.size
+	entry.size = size
+
+	c.evict(strKey)
+}
+
+// evict the least recently used keys except key until cache is in bounds. Must be called under dataMu lock.
+func (c *MemoryCache) evict(except string) {
+	for el := c.lru.Back(); el != nil && c.overflow(); {
+		entry := el.Value.(*lruEntry)
+		el = el.Prev()
+		if entry.key == except {
+			continue
+		}
+
+		// key is remembered as limited only until its remaining ttl, keys without ttl are forgotten
+		expireAt, hasTTL := c.expires[entry.key]
+		c.deleteKey(entry.key)
+		_ = c.callCancel([]byte(entry.key))
+
+		c.evictions.Add(1)
+		c.evictedBytes.Add(uint64(entry.size))
+		if c.policy == EvictLimited && hasTTL && expireAt.After(c.clock.Now()) {
+			c.evicted.add(hashKey(entry.key), expireAt)
+		}
+	}
+}
+
+// overflow check is cache out of bounds
+func (c *MemoryCache) overflow() bool {
+	return (c.maxKeys > 0 && len(c.data) > c.maxKeys) || (c.maxBytes > 0 && c.bytes > c.maxBytes)
+}
+
+// deleteKey remove key from data and lru. Must be called under dataMu lock.
+func (c *MemoryCache) deleteKey(strKey string) {
+	delete(c.data, strKey)
+	if el, ok := c.entries[strKey]; ok {
+		c.bytes -= el.Value.(*lruEntry).size
+		c.lru.Remove(el)
+		delete(c.entries, strKey)
+	}
+}
+
+// isEvicted check is key evicted with EvictLimited policy. Must be called under dataMu lock.
+func (c *MemoryCache) isEvicted(strKey string) bool {
+	if c.evicted == nil || !c.evicted.has(hashKey(strKey), c.clock.Now()) {
+		return false
+	}
+
+	c.evictedLimited.Add(1)
+	return true
+}
+
+// forgetEvicted remove key from evicted keys. Must be called under dataMu lock.
+func (c *MemoryCache) forgetEvicted(strKey string) {
+	if c.evicted != nil {
+		c.evicted.remove(hashKey(strKey))
+	}
+}
+
+// evictedSet remember hashes of evicted keys in fixed capacity, the oldest are forgotten first
+type evictedSet struct {
+	items map[uint64]evictedItem
+	ring  []evictedRef
+	next  int
+	seq   uint64
+}
+
+type evictedItem struct {
+	seq      uint64
+	expireAt time.Time // zero - until forgotten
+}
+
+// evictedRef is position of hash in ring. seq detects hashes added again later.
+type evictedRef struct {
+	hash uint64
+	seq  uint64
+}
+
+// newEvictedSet create set for capacity hashes
+func newEvictedSet(capacity int) *evictedSet {
+	return &evictedSet{
+		items: make(map[uint64]evictedItem, capacity),
+		ring:  make([]evictedRef, 0, capacity),
+	}
+}
+
+// add hash of evicted key
+func (s *evictedSet) add(hash uint64, expireAt time.Time) {
+	s.seq++
+	ref := evictedRef{hash: hash, seq: s.seq}
+	if len(s.ring) < cap(s.ring) {
+		s.ring = append(s.ring, ref)
+	} else {
+		old := s.ring[s.next]
+		if item, ok := s.items[old.hash]; ok && item.seq == old.seq {
+			delete(s.items, old.hash)
+		}
+		s.ring[s.next] = ref
+		s.next = (s.next + 1) % cap(s.ring)
+	}
+	s.items[hash] = evictedItem{seq: s.seq, expireAt: expireAt}
+}
+
+// has check is hash remembered and not expired
+func (s *evictedSet) has(hash uint64, now time.Time) bool {
+	item, ok := s.items[hash]
+	return ok && (item.expireAt.IsZero() || now.Before(item.expireAt))
+}
+
+// remove hash
+func (s *evictedSet) remove(hash uint64) {
+	delete(s.items, hash)
+}
+
+// hashKey is FNV-1a hash of key
+func hashKey(key string) uint64 {
+	hash := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint64(key[i])
+		hash *= 1099511628211
+	}
+
+	return hash
+}
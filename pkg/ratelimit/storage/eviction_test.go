@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/itbellissimo/ratelimit/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// TestParseEvictionPolicy test ParseEvictionPolicy function
+func TestParseEvictionPolicy(t *testing.T) {
+	policy, err := ParseEvictionPolicy("")
+	assert.Nil(t, err)
+	assert.Equal(t, EvictDrop, policy)
+
+	policy, err = ParseEvictionPolicy("drop")
+	assert.Nil(t, err)
+	assert.Equal(t, EvictDrop, policy)
+
+	policy, err = ParseEvictionPolicy("limited")
+	assert.Nil(t, err)
+	assert.Equal(t, EvictLimited, policy)
+
+	_, err = ParseEvictionPolicy("lfu")
+	assert.NotNil(t, err)
+}
+
+// TestMemoryCache_MaxKeys test LRU eviction by count of keys
+func TestMemoryCache_MaxKeys(t *testing.T) {
+	mem := NewMemoryCache(WithMaxKeys(3))
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		err := mem.Set(ctx, []byte("key_"+strconv.Itoa(i)), []byte("value"), &twenty)
+		assert.Nil(t, err)
+	}
+
+	// key_0 becomes the most recently used, key_1 is evicted
+	_, err := mem.Get(ctx, []byte("key_0"))
+	assert.Nil(t, err)
+	_, _ = mem.Inc(ctx, []byte("key_3"), &twenty)
+
+	assert.True(t, mem.Has(ctx, []byte("key_0")))
+	assert.False(t, mem.Has(ctx, []byte("key_1")))
+	assert.True(t, mem.Has(ctx, []byte("key_2")))
+	assert.True(t, mem.Has(ctx, []byte("key_3")))
+
+	mem.timerMu.RLock()
+	_, ok := mem.timers["key_1"]
+	mem.timerMu.RUnlock()
+	assert.False(t, ok)
+
+	// evicted counter starts from zero with drop policy
+	value, err := mem.Inc(ctx, []byte("key_1"), &twenty)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), value)
+
+	stats := mem.Stats()
+	assert.Equal(t, 3, stats.Keys)
+	assert.Equal(t, uint64(2), stats.Evictions)
+	assert.Equal(t, uint64(0), stats.EvictedLimited)
+	assert.Equal(t, int64(3*entryOverhead+5+5+5+1+5+1), stats.Bytes)
+}
+
+// TestMemoryCache_MaxBytes test LRU eviction by memory
+func TestMemoryCache_MaxBytes(t *testing.T) {
+	mem := NewMemoryCache(WithMaxBytes(2*entryOverhead + 40))
+	ctx := context.Background()
+
+	err := mem.Set(ctx, []byte("key_0"), bytes.Repeat([]byte("a"), 10), &zero)
+	assert.Nil(t, err)
+	err = mem.Set(ctx, []byte("key_1"), bytes.Repeat([]byte("b"), 10), &zero)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), mem.Stats().Evictions)
+
+	// value grows over the budget, the oldest key is evicted
+	err = mem.Set(ctx, []byte("key_1"), bytes.Repeat([]byte("b"), 30), &zero)
+	assert.Nil(t, err)
+	assert.False(t, mem.Has(ctx, []byte("key_0")))
+	assert.True(t, mem.Has(ctx, []byte("key_1")))
+
+	// single key over the budget is kept
+	err = mem.Set(ctx, []byte("key_2"), bytes.Repeat([]byte("c"), 100), &zero)
+	assert.Nil(t, err)
+	assert.True(t, mem.Has(ctx, []byte("key_2")))
+	assert.False(t, mem.Has(ctx, []byte("key_1")))
+
+	stats := mem.Stats()
+	assert.Equal(t, 1, stats.Keys)
+	assert.Equal(t, int64(entryOverhead+5+100), stats.Bytes)
+	assert.Equal(t, uint64(2), stats.Evictions)
+	assert.Equal(t, uint64(2*entryOverhead+5+10+5+30), stats.EvictedBytes)
+
+	err = mem.Del(ctx, []byte("key_2"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), mem.Stats().Bytes)
+}
+
+// TestMemoryCache_EvictLimited test evicted counters are reported as limited
+func TestMemoryCache_EvictLimited(t *testing.T) {
+	clk := clock.NewManual(time.Now())
+	mem := NewMemoryCache(WithClock(clk), WithMaxKeys(2), WithEvictionPolicy(EvictLimited))
+	ctx := context.Background()
+	one := uint64(1)
+
+	_, _ = mem.Inc(ctx, []byte("key_0"), &one)
+	_, _ = mem.Inc(ctx, []byte("key_1"), &twenty)
+	_, _ = mem.Inc(ctx, []byte("key_2"), &twenty)
+
+	assert.True(t, mem.Has(ctx, []byte("key_0")))
+	val, err := mem.Get(ctx, []byte("key_0"))
+	assert.Nil(t, err)
+	assert.Equal(t, strconv.FormatInt(EvictedValue, 10), string(val))
+
+	value, err := mem.Inc(ctx, []byte("key_0"), &one)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(EvictedValue), value)
+
+	// evicted keys do not take place in data
+	assert.Equal(t, 2, mem.Stats().Keys)
+	assert.Equal(t, uint64(2), mem.Stats().EvictedLimited)
+
+	// evicted key is forgotten after its ttl
+	clk.Advance(1100 * time.Millisecond)
+	value, err = mem.Inc(ctx, []byte("key_0"), &one)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), value)
+
+	// Set and Del forget evicted key
+	_, _ = mem.Inc(ctx, []byte("key_3"), &twenty)
+	_, _ = mem.Inc(ctx, []byte("key_4"), &twenty)
+	assert.Equal(t, strconv.FormatInt(EvictedValue, 10), string(mustGet(t, mem, "key_2")))
+	err = mem.Set(ctx, []byte("key_2"), []byte("5"), &twenty)
+	assert.Nil(t, err)
+	assert.Equal(t, "5", string(mustGet(t, mem, "key_2")))
+
+	assert.Equal(t, strconv.FormatInt(EvictedValue, 10), string(mustGet(t, mem, "key_3")))
+	err = mem.Del(ctx, []byte("key_3"))
+	assert.Nil(t, err)
+	assert.False(t, mem.Has(ctx, []byte("key_3")))
+
+	err = mem.Clear(ctx)
+	assert.Nil(t, err)
+	assert.False(t, mem.Has(ctx, []byte("key_4")))
+}
+
+// TestMemoryCache_EvictLimitedTTL test evicted key is limited for its remaining ttl and keys without ttl are forgotten
+func TestMemoryCache_EvictLimitedTTL(t *testing.T) {
+	clk := clock.NewManual(time.Now())
+	mem := NewMemoryCache(WithClock(clk), WithMaxKeys(1), WithEvictionPolicy(EvictLimited))
+	ctx := context.Background()
+
+	_, _ = mem.Inc(ctx, []byte("key_0"), &twenty)
+	clk.Advance(15 * time.Second)
+	_, _ = mem.Inc(ctx, []byte("key_1"), &twenty)
+	assert.Equal(t, strconv.FormatInt(EvictedValue, 10), string(mustGet(t, mem, "key_0")))
+
+	// 5 seconds of ttl were left, not the full ttl
+	clk.Advance(5 * time.Second)
+	assert.False(t, mem.Has(ctx, []byte("key_0")))
+
+	assert.Nil(t, mem.Set(ctx, []byte("key_2"), []byte("1"), nil))
+	_, _ = mem.Inc(ctx, []byte("key_3"), &twenty)
+	assert.False(t, mem.Has(ctx, []byte("key_2")))
+	assert.Equal(t, uint64(1), mem.Stats().EvictedLimited)
+}
+
+// Test_evictedSet test evictedSet keeps fixed capacity
+func Test_evictedSet(t *testing.T) {
+	s := newEvictedSet(2)
+	now := time.Now()
+
+	s.add(1, time.Time{})
+	s.add(2, now.Add(time.Second))
+	assert.True(t, s.has(1, now))
+	assert.True(t, s.has(2, now))
+	assert.False(t, s.has(2, now.Add(time.Second)))
+
+	// 1 is added again, so overwriting its first position keeps it
+	s.add(1, time.Time{})
+	assert.True(t, s.has(1, now))
+	assert.True(t, s.has(2, now))
+	s.add(3, time.Time{})
+	assert.True(t, s.has(1, now))
+	assert.False(t, s.has(2, now))
+	assert.True(t, s.has(3, now))
+	assert.Len(t, s.items, 2)
+
+	s.remove(3)
+	assert.False(t, s.has(3, now))
+}
+
+// TestMemoryCache_RegisterMetrics test RegisterMetrics function
+func TestMemoryCache_RegisterMetrics(t *testing.T) {
+	mem := NewMemoryCache(WithMaxKeys(1))
+	ctx := context.Background()
+	reg := metrics.NewRegistry()
+
+	err := mem.RegisterMetrics(reg)
+	assert.Nil(t, err)
+	err = mem.RegisterMetrics(reg)
+	assert.NotNil(t, err)
+
+	_, _ = mem.Inc(ctx, []byte("key_0"), &twenty)
+	_, _ = mem.Inc(ctx, []byte("key_1"), &twenty)
+
+	var buf bytes.Buffer
+	_, err = reg.WriteTo(&buf)
+	assert.Nil(t, err)
+	assert.Contains(t, buf.String(), "ratelimit_memory_cache_keys 1\n")
+	assert.Contains(t, buf.String(), "ratelimit_memory_cache_evictions_total 1\n")
+	assert.Contains(t, buf.String(), "ratelimit_memory_cache_evicted_limited_total 0\n")
+}
+
+// mustGet return value by key or fail test
+func mustGet(t *testing.T, mem *MemoryCache, key string) []byte {
+	val, err := mem.Get(context.Background(), []byte(key))
+	assert.Nil(t, err)
+
+	return val
+}
@@ -0,0 +1,308 @@
+// Package instrumented is Storager decorator that records metrics of storage operations.
+package instrumented
+
+import (
+	"context"
+	"errors"
+	"github.com/itbellissimo/ratelimit/pkg/metrics"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"time"
+)
+
+// DefaultPrefix of metric names
+const DefaultPrefix = "ratelimit_storage"
+
+// KeysTimeout of counting keys on scrape of keys gauge
+const KeysTimeout = time.Second
+
+// operations of storage
+const (
+	opGet            = "get"
+	opSet            = "set"
+	opDel            = "del"
+	opHas            = "has"
+	opInc            = "inc"
+	opDecr           = "decr"
+	opClear          = "clear"
+	opTTL            = "ttl"
+	opIncrBy         = "incr_by"
+	opCompareAndSwap = "compare_and_swap"
+	opSetNX          = "set_nx"
+	opKeys           = "keys"
+	opLen            = "len"
+	opDelPrefix      = "del_prefix"
+	opScan           = "scan"
+)
+
+// Storage is ratelimit.Storager wrapper that records latency of every operation,
+// errors by operation and type, and count of keys if wrapped storage is ratelimit.KeyCounter or ratelimit.KeyLister.
+// Optional extensions of wrapped storage are kept, missing ones return ratelimit.ErrNotSupported.
+type Storage struct {
+	storage  ratelimit.Storager
+	duration *metrics.HistogramVec
+	errs     *metrics.CounterVec
+}
+
+// New wrap storage and register its metrics in reg. Metric names start with prefix, DefaultPrefix if it is empty:
+// <prefix>_operation_duration_seconds{op}, <prefix>_errors_total{op,type} and <prefix>_keys.
+func New(s ratelimit.Storager, reg *metrics.Registry, prefix string) (*Storage, error) {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+
+	duration, err := reg.HistogramVec(prefix+"_operation_duration_seconds", "Latency of storage operations.", nil, "op")
+	if err != nil {
+		return nil, err
+	}
+
+	errs, err := reg.CounterVec(prefix+"_errors_total", "Count of storage errors by operation and type.", "op", "type")
+	if err != nil {
+		return nil, err
+	}
+
+	is := &Storage{storage: s, duration: duration, errs: errs}
+	if op, count := keyCount(s); count != nil {
+		err = reg.GaugeFunc(prefix+"_keys", "Count of keys in storage.", func() float64 {
+			ctx, cancel := context.WithTimeout(context.Background(), KeysTimeout)
+			defer cancel()
+
+			n, err := count(ctx)
+			if err != nil {
+				is.errs.WithLabelValues(op, ErrorType(err)).Inc()
+				return 0
+			}
+
+			return float64(n)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return is, nil
+}
+
+// keyCount return operation and function counting keys of storage, nil if storage can not count them.
+// Len of ratelimit.KeyCounter is preferred to listing of all keys.
+func keyCount(s ratelimit.Storager) (string, func(ctx context.Context) (int, error)) {
+	if counter, ok := s.(ratelimit.KeyCounter); ok {
+		return opLen, counter.Len
+	}
+	if lister, ok := s.(ratelimit.KeyLister); ok {
+		return opKeys, func(ctx context.Context) (int, error) {
+			keys, err := lister.Keys(ctx, nil)
+			return len(keys), err
+		}
+	}
+
+	return "", nil
+}
+
+// ErrorType return label of error type
+func ErrorType(err error) string {
+	switch {
+	case errors.Is(err, storage.ValueNotFoundByKey):
+		return "not_found"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, ratelimit.ErrCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, ratelimit.ErrNotSupported):
+		return "not_supported"
+	}
+
+	return "other"
+}
+
+// Get value by key
+func (s *Storage) Get(ctx context.Context, key []byte) ([]byte, error) {
+	start := time.Now()
+	val, err := s.storage.Get(ctx, key)
+	s.observe(opGet, start, err)
+
+	return val, err
+}
+
+// Set value by key
+func (s *Storage) Set(ctx context.Context, key []byte, value []byte, ttl *uint64) error {
+	start := time.Now()
+	err := s.storage.Set(ctx, key, value, ttl)
+	s.observe(opSet, start, err)
+
+	return err
+}
+
+// Del values by keys
+func (s *Storage) Del(ctx context.Context, list ...[]byte) error {
+	start := time.Now()
+	err := s.storage.Del(ctx, list...)
+	s.observe(opDel, start, err)
+
+	return err
+}
+
+// Has check is set value by key
+func (s *Storage) Has(ctx context.Context, key []byte) bool {
+	start := time.Now()
+	ok := s.storage.Has(ctx, key)
+	s.observe(opHas, start, nil)
+
+	return ok
+}
+
+// Inc value by key
+func (s *Storage) Inc(ctx context.Context, key []byte, ttl *uint64) (int64, error) {
+	start := time.Now()
+	val, err := s.storage.Inc(ctx, key, ttl)
+	s.observe(opInc, start, err)
+
+	return val, err
+}
+
+// Decr value by key
+func (s *Storage) Decr(ctx context.Context, key []byte, ttl *uint64) (int64, error) {
+	start := time.Now()
+	val, err := s.storage.Decr(ctx, key, ttl)
+	s.observe(opDecr, start, err)
+
+	return val, err
+}
+
+// Clear all
+func (s *Storage) Clear(ctx context.Context) error {
+	start := time.Now()
+	err := s.storage.Clear(ctx)
+	s.observe(opClear, start, err)
+
+	return err
+}
+
+// TTL of key. ErrNotSupported if wrapped storage is not ExtendedStorager.
+func (s *Storage) TTL(ctx context.Context, key []byte) (time.Duration, error) {
+	ext, ok := s.storage.(ratelimit.ExtendedStorager)
+	if !ok {
+		return 0, ratelimit.ErrNotSupported
+	}
+
+	start := time.Now()
+	ttl, err := ext.TTL(ctx, key)
+	s.observe(opTTL, start, err)
+
+	return ttl, err
+}
+
+// IncrBy value by key. ErrNotSupported if wrapped storage is not ExtendedStorager.
+func (s *Storage) IncrBy(ctx context.Context, key []byte, n int64, ttl *uint64) (int64, time.Duration, error) {
+	ext, ok := s.storage.(ratelimit.ExtendedStorager)
+	if !ok {
+		return 0, 0, ratelimit.ErrNotSupported
+	}
+
+	start := time.Now()
+	val, left, err := ext.IncrBy(ctx, key, n, ttl)
+	s.observe(opIncrBy, start, err)
+
+	return val, left, err
+}
+
+// CompareAndSwap value by key. ErrNotSupported if wrapped storage is not ExtendedStorager.
+func (s *Storage) CompareAndSwap(ctx context.Context, key []byte, old, newValue []byte, ttl *uint64) (bool, error) {
+	ext, ok := s.storage.(ratelimit.ExtendedStorager)
+	if !ok {
+		return false, ratelimit.ErrNotSupported
+	}
+
+	start := time.Now()
+	swapped, err := ext.CompareAndSwap(ctx, key, old, newValue, ttl)
+	s.observe(opCompareAndSwap, start, err)
+
+	return swapped, err
+}
+
+// SetNX value by key. ErrNotSupported if wrapped storage is not ExtendedStorager.
+func (s *Storage) SetNX(ctx context.Context, key []byte, value []byte, ttl *uint64) (bool, error) {
+	ext, ok := s.storage.(ratelimit.ExtendedStorager)
+	if !ok {
+		return false, ratelimit.ErrNotSupported
+	}
+
+	start := time.Now()
+	set, err := ext.SetNX(ctx, key, value, ttl)
+	s.observe(opSetNX, start, err)
+
+	return set, err
+}
+
+// Keys with prefix. ErrNotSupported if wrapped storage is not KeyLister.
+func (s *Storage) Keys(ctx context.Context, prefix []byte) ([][]byte, error) {
+	lister, ok := s.storage.(ratelimit.KeyLister)
+	if !ok {
+		return nil, ratelimit.ErrNotSupported
+	}
+
+	start := time.Now()
+	keys, err := lister.Keys(ctx, prefix)
+	s.observe(opKeys, start, err)
+
+	return keys, err
+}
+
+// Len return count of keys. ErrNotSupported if wrapped storage is not KeyCounter.
+func (s *Storage) Len(ctx context.Context) (int, error) {
+	counter, ok := s.storage.(ratelimit.KeyCounter)
+	if !ok {
+		return 0, ratelimit.ErrNotSupported
+	}
+
+	start := time.Now()
+	n, err := counter.Len(ctx)
+	s.observe(opLen, start, err)
+
+	return n, err
+}
+
+// DelPrefix delete keys with prefix. ErrNotSupported if wrapped storage is not KeyLister.
+func (s *Storage) DelPrefix(ctx context.Context, prefix []byte) error {
+	lister, ok := s.storage.(ratelimit.KeyLister)
+	if !ok {
+		return ratelimit.ErrNotSupported
+	}
+
+	start := time.Now()
+	err := lister.DelPrefix(ctx, prefix)
+	s.observe(opDelPrefix, start, err)
+
+	return err
+}
+
+// Scan keys. ErrNotSupported if wrapped storage is not Scanner.
+func (s *Storage) Scan(ctx context.Context, prefix []byte, cursor string, count int) ([]storage.KeyInfo, string, error) {
+	scanner, ok := s.storage.(ratelimit.Scanner)
+	if !ok {
+		return nil, "", ratelimit.ErrNotSupported
+	}
+
+	start := time.Now()
+	keys, next, err := scanner.Scan(ctx, prefix, cursor, count)
+	s.observe(opScan, start, err)
+
+	return keys, next, err
+}
+
+// CacheBlocked forward blocked key to wrapped storage if it is BlockCacher
+func (s *Storage) CacheBlocked(ctx context.Context, key []byte, value int64, ttl time.Duration) {
+	if cacher, ok := s.storage.(ratelimit.BlockCacher); ok {
+		cacher.CacheBlocked(ctx, key, value, ttl)
+	}
+}
+
+// observe record latency and error of operation
+func (s *Storage) observe(op string, start time.Time, err error) {
+	s.duration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if err != nil {
+		s.errs.WithLabelValues(op, ErrorType(err)).Inc()
+	}
+}
@@ -0,0 +1,128 @@
+package instrumented
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/itbellissimo/ratelimit/pkg/metrics"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var ten = uint64(10)
+
+// TestErrorType test ErrorType function
+func TestErrorType(t *testing.T) {
+	assert.Equal(t, "not_found", ErrorType(storage.ValueNotFoundByKey))
+	assert.Equal(t, "timeout", ErrorType(fmt.Errorf("call: %w", context.DeadlineExceeded)))
+	assert.Equal(t, "canceled", ErrorType(context.Canceled))
+	assert.Equal(t, "circuit_open", ErrorType(ratelimit.ErrCircuitOpen))
+	assert.Equal(t, "not_supported", ErrorType(ratelimit.ErrNotSupported))
+	assert.Equal(t, "other", ErrorType(errors.New("boom")))
+}
+
+// TestStorage test metrics of operations
+func TestStorage(t *testing.T) {
+	ctx := context.Background()
+	reg := metrics.NewRegistry()
+	mem := storage.NewMemoryCache()
+
+	s, err := New(mem, reg, "")
+	assert.Nil(t, err)
+	_, err = New(mem, reg, "")
+	assert.NotNil(t, err)
+
+	value, err := s.Inc(ctx, []byte("key"), &ten)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), value)
+	_, _ = s.Inc(ctx, []byte("key"), &ten)
+	_, err = s.Get(ctx, []byte("missing"))
+	assert.Equal(t, storage.ValueNotFoundByKey, err)
+	assert.Nil(t, s.Set(ctx, []byte("text"), []byte("value"), &ten))
+	_, err = s.Inc(ctx, []byte("text"), &ten)
+	assert.NotNil(t, err)
+	assert.True(t, s.Has(ctx, []byte("key")))
+
+	left, err := s.TTL(ctx, []byte("key"))
+	assert.Nil(t, err)
+	assert.True(t, left > 0)
+
+	var buf bytes.Buffer
+	_, err = reg.WriteTo(&buf)
+	assert.Nil(t, err)
+	out := buf.String()
+	assert.Contains(t, out, "ratelimit_storage_operation_duration_seconds_count{op=\"inc\"} 3\n")
+	assert.Contains(t, out, "ratelimit_storage_operation_duration_seconds_count{op=\"get\"} 1\n")
+	assert.Contains(t, out, "ratelimit_storage_operation_duration_seconds_count{op=\"ttl\"} 1\n")
+	assert.Contains(t, out, "ratelimit_storage_errors_total{op=\"get\",type=\"not_found\"} 1\n")
+	assert.Contains(t, out, "ratelimit_storage_errors_total{op=\"inc\",type=\"other\"} 1\n")
+	assert.Contains(t, out, "ratelimit_storage_keys 2\n")
+}
+
+// TestStorage_NotSupported test optional extensions of storage without them
+func TestStorage_NotSupported(t *testing.T) {
+	ctx := context.Background()
+	reg := metrics.NewRegistry()
+
+	s, err := New(struct{ ratelimit.Storager }{storage.NewMemoryCache()}, reg, "test")
+	assert.Nil(t, err)
+
+	_, err = s.TTL(ctx, []byte("key"))
+	assert.ErrorIs(t, err, ratelimit.ErrNotSupported)
+	_, _, err = s.Scan(ctx, nil, "", 1)
+	assert.ErrorIs(t, err, ratelimit.ErrNotSupported)
+	assert.ErrorIs(t, s.DelPrefix(ctx, nil), ratelimit.ErrNotSupported)
+	_, err = s.Len(ctx)
+	assert.ErrorIs(t, err, ratelimit.ErrNotSupported)
+
+	var buf bytes.Buffer
+	_, err = reg.WriteTo(&buf)
+	assert.Nil(t, err)
+	assert.NotContains(t, buf.String(), "test_keys")
+}
+
+// listerStorage is storage that lists keys but does not count them
+type listerStorage struct {
+	ratelimit.Storager
+	lister   ratelimit.KeyLister
+	deadline bool
+}
+
+func (s *listerStorage) Keys(ctx context.Context, prefix []byte) ([][]byte, error) {
+	_, s.deadline = ctx.Deadline()
+	return s.lister.Keys(ctx, prefix)
+}
+
+func (s *listerStorage) DelPrefix(ctx context.Context, prefix []byte) error {
+	return s.lister.DelPrefix(ctx, prefix)
+}
+
+// TestStorage_Keys test keys gauge counts keys by Len and lists them with timeout only without it
+func TestStorage_Keys(t *testing.T) {
+	ctx := context.Background()
+	mem := storage.NewMemoryCache()
+	_, _ = mem.Inc(ctx, []byte("key"), &ten)
+
+	reg := metrics.NewRegistry()
+	s, err := New(mem, reg, "len")
+	assert.Nil(t, err)
+	n, err := s.Len(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	lister := &listerStorage{Storager: mem, lister: mem}
+	_, err = New(lister, reg, "list")
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+	_, err = reg.WriteTo(&buf)
+	assert.Nil(t, err)
+	out := buf.String()
+	assert.Contains(t, out, "len_keys 1\n")
+	assert.Contains(t, out, "len_operation_duration_seconds_count{op=\"len\"} 1\n")
+	assert.Contains(t, out, "list_keys 1\n")
+	assert.True(t, lister.deadline)
+}
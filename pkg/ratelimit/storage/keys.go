@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// Keys return sorted keys with prefix. Evicted keys are not listed.
+func (c *MemoryCache) Keys(ctx context.Context, prefix []byte) ([][]byte, error) {
+	c.dataMu.RLock()
+	defer c.dataMu.RUnlock()
+
+	strPrefix := string(prefix)
+	keys := make([]string, 0)
+	for strKey := range c.data {
+		if strings.HasPrefix(strKey, strPrefix) {
+			keys = append(keys, strKey)
+		}
+	}
+
+	return sortedKeys(keys), nil
+}
+
+// Len return count of keys. Evicted keys are not counted.
+func (c *MemoryCache) Len(ctx context.Context) (int, error) {
+	c.dataMu.RLock()
+	defer c.dataMu.RUnlock()
+
+	return len(c.data), nil
+}
+
+// DelPrefix delete all keys with prefix. Evicted keys of EvictLimited policy are kept until their ttl.
+func (c *MemoryCache) DelPrefix(ctx context.Context, prefix []byte) error {
+	c.dataMu.Lock()
+	defer c.dataMu.Unlock()
+
+	strPrefix := string(prefix)
+	for strKey := range c.data {
+		if !strings.HasPrefix(strKey, strPrefix) {
+			continue
+		}
+
+		c.deleteKey(strKey)
+		if err := c.callCancel([]byte(strKey)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Keys return sorted keys with prefix
+func (c *ShardedMemoryCache) Keys(ctx context.Context, prefix []byte) ([][]byte, error) {
+	now := c.clock.Now()
+	keys := make([]string, 0)
+	for _, s := range c.shards {
+		s.mu.RLock()
+		for strKey, item := range s.items {
+			if !item.expired(now) && strings.HasPrefix(strKey, string(prefix)) {
+				keys = append(keys, strKey)
+			}
+		}
+		s.mu.RUnlock()
+	}
+
+	return sortedKeys(keys), nil
+}
+
+// Len return count of keys without listing them
+func (c *ShardedMemoryCache) Len(ctx context.Context) (int, error) {
+	now := c.clock.Now()
+	n := 0
+	for _, s := range c.shards {
+		s.mu.RLock()
+		for _, item := range s.items {
+			if !item.expired(now) {
+				n++
+			}
+		}
+		s.mu.RUnlock()
+	}
+
+	return n, nil
+}
+
+// DelPrefix delete all keys with prefix
+func (c *ShardedMemoryCache) DelPrefix(ctx context.Context, prefix []byte) error {
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for strKey := range s.items {
+			if strings.HasPrefix(strKey, string(prefix)) {
+				delete(s.items, strKey)
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	return nil
+}
+
+// sortedKeys sort keys and convert them to bytes
+func sortedKeys(keys []string) [][]byte {
+	sort.Strings(keys)
+
+	res := make([][]byte, len(keys))
+	for i, strKey := range keys {
+		res[i] = []byte(strKey)
+	}
+
+	return res
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// TestMemoryCache_Keys test Keys, DelPrefix and Len functions
+func TestMemoryCache_Keys(t *testing.T) {
+	mem := NewMemoryCache()
+	ctx := context.Background()
+
+	for _, key := range []string{"b:2", "a:1", "b:1", "c"} {
+		_, _ = mem.Inc(ctx, []byte(key), &ten)
+	}
+
+	keys, err := mem.Keys(ctx, []byte("b:"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("b:1"), []byte("b:2")}, keys)
+
+	keys, err = mem.Keys(ctx, nil)
+	assert.Nil(t, err)
+	assert.Len(t, keys, 4)
+
+	err = mem.DelPrefix(ctx, []byte("b:"))
+	assert.Nil(t, err)
+	keys, _ = mem.Keys(ctx, nil)
+	assert.Equal(t, [][]byte{[]byte("a:1"), []byte("c")}, keys)
+	assert.Equal(t, 2, mem.Stats().Keys)
+	n, err := mem.Len(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	mem.timerMu.RLock()
+	assert.Len(t, mem.timers, 2)
+	mem.timerMu.RUnlock()
+}
+
+// TestShardedMemoryCache_Keys test Keys, DelPrefix and Len functions
+func TestShardedMemoryCache_Keys(t *testing.T) {
+	mem := NewShardedMemoryCache(4)
+	ctx := context.Background()
+
+	for _, key := range []string{"b:2", "a:1", "b:1", "c"} {
+		_, _ = mem.Inc(ctx, []byte(key), &ten)
+	}
+
+	keys, err := mem.Keys(ctx, []byte("b:"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("b:1"), []byte("b:2")}, keys)
+
+	err = mem.DelPrefix(ctx, []byte("b:"))
+	assert.Nil(t, err)
+	keys, _ = mem.Keys(ctx, nil)
+	assert.Equal(t, [][]byte{[]byte("a:1"), []byte("c")}, keys)
+	n, err := mem.Len(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+}
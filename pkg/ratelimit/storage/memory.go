@@ -1,30 +1,96 @@
 package storage
 
 import (
+	"bytes"
+	"container/list"
 	"context"
 	"errors"
 	"fmt"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var ValueNotFoundByKey = errors.New("value is not found by key")
 
+// NoTTL is ttl of keys without expiration
+const NoTTL = time.Duration(-1)
+
+// TTLMode what to do with ttl of existing counter on Inc, Decr and IncrBy
+type TTLMode int
+
+const (
+	// KeepTTL keep ttl set on creation of the counter: fixed window from the first request
+	KeepTTL TTLMode = iota
+	// RefreshTTL restart ttl on every change of the counter: window from the last request
+	RefreshTTL
+)
+
+// ParseTTLMode parse mode by name: "keep" or "refresh". Empty name is "keep".
+func ParseTTLMode(name string) (TTLMode, error) {
+	switch name {
+	case "", "keep":
+		return KeepTTL, nil
+	case "refresh":
+		return RefreshTTL, nil
+	}
+
+	return KeepTTL, fmt.Errorf("unknown ttl mode %q", name)
+}
+
 type MemoryCache struct {
 	dataMu sync.RWMutex
 	data   map[string][]byte
 
 	timerMu sync.RWMutex
-	timers  map[string]*time.Timer
+	timers  map[string]clock.Timer
+	expires map[string]time.Time
+	ttlMode TTLMode
+	clock   clock.Clock
+
+	// bounds of memory, zero is unlimited
+	maxKeys  int
+	maxBytes int64
+	policy   EvictionPolicy
+
+	lru     *list.List // front is the most recently used key
+	entries map[string]*list.Element
+	bytes   int64
+	evicted *evictedSet
+
+	evictions      atomic.Uint64
+	evictedBytes   atomic.Uint64
+	evictedLimited atomic.Uint64
 }
 
 // NewMemoryCache create new storage in memory
-func NewMemoryCache() *MemoryCache {
-	return &MemoryCache{
-		data:   make(map[string][]byte),
-		timers: make(map[string]*time.Timer),
+func NewMemoryCache(opts ...MemoryCacheOption) *MemoryCache {
+	c := &MemoryCache{
+		data:    make(map[string][]byte),
+		timers:  make(map[string]clock.Timer),
+		expires: make(map[string]time.Time),
+		clock:   clock.Real,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.bounded() {
+		c.lru = list.New()
+		c.entries = make(map[string]*list.Element)
+
+		if c.policy == EvictLimited {
+			capacity := c.maxKeys
+			if capacity <= 0 {
+				capacity = defaultEvictedCapacity
+			}
+			c.evicted = newEvictedSet(capacity)
+		}
 	}
+
+	return c
 }
 
 // Has check is set value by key
@@ -32,54 +98,30 @@ func (c *MemoryCache) Has(ctx context.Context, key []byte) bool {
 	c.dataMu.RLock()
 	defer c.dataMu.RUnlock()
 
-	_, ok := c.data[string(key)]
-	return ok
+	strKey := string(key)
+	if _, ok := c.data[strKey]; ok {
+		return true
+	}
+
+	return c.evicted != nil && c.evicted.has(hashKey(strKey), c.clock.Now())
 }
 
-// Inc value by key
+// Inc increment value by key. Not existing key is created with value 1 and ttl,
+// ttl of existing key is kept or refreshed by TTLMode of the cache.
 func (c *MemoryCache) Inc(ctx context.Context, key []byte, ttl *uint64) (int64, error) {
 	c.dataMu.Lock()
 	defer c.dataMu.Unlock()
 
-	strKey := string(key)
-	if _, ok := c.data[string(key)]; !ok {
-		c.data[strKey] = []byte("1")
-		return int64(1), ValueNotFoundByKey
-	}
-
-	valInt, err := c.sliceByteToInt64(key)
-	if err != nil {
-		return int64(0), err
-	}
-	valInt++
-	c.data[strKey] = []byte(strconv.FormatInt(valInt, 10))
-
-	c.initCancel(ctx, strKey, ttl)
-
-	return valInt, nil
+	return c.add(key, 1, ttl)
 }
 
-// Decr decrement value by key
+// Decr decrement value by key. Not existing key is created with value -1 and ttl,
+// ttl of existing key is kept or refreshed by TTLMode of the cache.
 func (c *MemoryCache) Decr(ctx context.Context, key []byte, ttl *uint64) (int64, error) {
 	c.dataMu.Lock()
 	defer c.dataMu.Unlock()
-	strKey := string(key)
 
-	if _, ok := c.data[strKey]; !ok {
-		c.data[strKey] = []byte("-1")
-		return int64(-1), ValueNotFoundByKey
-	}
-
-	valInt, err := c.sliceByteToInt64(key)
-	if err != nil {
-		return int64(0), err
-	}
-	valInt--
-	c.data[strKey] = []byte(strconv.FormatInt(valInt, 10))
-
-	c.initCancel(ctx, strKey, ttl)
-
-	return valInt, nil
+	return c.add(key, -1, ttl)
 }
 
 // Get value by key
@@ -87,11 +129,17 @@ func (c *MemoryCache) Get(ctx context.Context, key []byte) ([]byte, error) {
 	c.dataMu.Lock()
 	defer c.dataMu.Unlock()
 
-	if _, ok := c.data[string(key)]; !ok {
+	strKey := string(key)
+	if _, ok := c.data[strKey]; !ok {
+		if c.isEvicted(strKey) {
+			return []byte(strconv.FormatInt(EvictedValue, 10)), nil
+		}
+
 		return nil, ValueNotFoundByKey
 	}
 
-	return c.data[string(key)], nil
+	c.touch(strKey, nil)
+	return c.data[strKey], nil
 }
 
 // Set value by key
@@ -105,8 +153,10 @@ func (c *MemoryCache) Set(ctx context.Context, key []byte, value []byte, ttl *ui
 
 	strKey := string(key)
 	c.data[strKey] = value
+	c.forgetEvicted(strKey)
 
-	c.initCancel(ctx, strKey, ttl)
+	c.initCancel(strKey, ttl, false)
+	c.touch(strKey, ttl)
 	return nil
 }
 
@@ -117,7 +167,8 @@ func (c *MemoryCache) Del(ctx context.Context, list ...[]byte) error {
 
 	for _, key := range list {
 		strKey := string(key)
-		delete(c.data, strKey)
+		c.deleteKey(strKey)
+		c.forgetEvicted(strKey)
 		err := c.callCancel(key)
 		if err != nil {
 			return err
@@ -135,29 +186,119 @@ func (c *MemoryCache) Clear(ctx context.Context) error {
 	c.timerMu.Lock()
 	defer c.timerMu.Unlock()
 	for _, t := range c.timers {
-		if !t.Stop() {
-			<-t.C
-		}
+		t.Stop()
 	}
 
 	c.data = make(map[string][]byte)
-	c.timers = make(map[string]*time.Timer)
+	c.timers = make(map[string]clock.Timer)
+	c.expires = make(map[string]time.Time)
+	c.bytes = 0
+	if c.bounded() {
+		c.lru.Init()
+		c.entries = make(map[string]*list.Element)
+	}
+	if c.evicted != nil {
+		c.evicted = newEvictedSet(cap(c.evicted.ring))
+	}
 
 	return nil
 }
 
-// callCancel call cancel function that cancel removing by ttl
+// TTL return remaining time to live of key. NoTTL if the key has no expiration.
+func (c *MemoryCache) TTL(ctx context.Context, key []byte) (time.Duration, error) {
+	c.dataMu.RLock()
+	defer c.dataMu.RUnlock()
+
+	strKey := string(key)
+	if _, ok := c.data[strKey]; !ok {
+		return 0, ValueNotFoundByKey
+	}
+
+	return c.ttl(strKey), nil
+}
+
+// IncrBy add n to value by key and return new value with remaining ttl.
+// Counter contract is the same as of Inc.
+func (c *MemoryCache) IncrBy(ctx context.Context, key []byte, n int64, ttl *uint64) (int64, time.Duration, error) {
+	c.dataMu.Lock()
+	defer c.dataMu.Unlock()
+
+	valInt, err := c.add(key, n, ttl)
+	if err != nil {
+		return int64(0), 0, err
+	}
+
+	return valInt, c.ttl(string(key)), nil
+}
+
+// CompareAndSwap set value by key to newValue only if current value is equal to old.
+// Not existing key is never swapped. Expiration of the key is kept, ttl is used if the key has none.
+func (c *MemoryCache) CompareAndSwap(ctx context.Context, key []byte, old, newValue []byte, ttl *uint64) (bool, error) {
+	c.dataMu.Lock()
+	defer c.dataMu.Unlock()
+
+	strKey := string(key)
+	val, ok := c.data[strKey]
+	if !ok || !bytes.Equal(val, old) {
+		return false, nil
+	}
+
+	c.data[strKey] = newValue
+	c.initCancel(strKey, ttl, false)
+	c.touch(strKey, ttl)
+
+	return true, nil
+}
+
+// SetNX set value by key only if the key does not exist
+func (c *MemoryCache) SetNX(ctx context.Context, key []byte, value []byte, ttl *uint64) (bool, error) {
+	if len(key) == 0 {
+		return false, fmt.Errorf("key is empty")
+	}
+
+	c.dataMu.Lock()
+	defer c.dataMu.Unlock()
+
+	strKey := string(key)
+	if _, ok := c.data[strKey]; ok || c.isEvicted(strKey) {
+		return false, nil
+	}
+
+	c.data[strKey] = value
+	c.initCancel(strKey, ttl, false)
+	c.touch(strKey, ttl)
+
+	return true, nil
+}
+
+// ttl return remaining time to live of key. Must be called under dataMu lock.
+func (c *MemoryCache) ttl(strKey string) time.Duration {
+	c.timerMu.RLock()
+	defer c.timerMu.RUnlock()
+
+	expireAt, ok := c.expires[strKey]
+	if !ok {
+		return NoTTL
+	}
+
+	if d := expireAt.Sub(c.clock.Now()); d > 0 {
+		return d
+	}
+
+	return 0
+}
+
+// callCancel call cancel function that cancel removing by ttl.
+// Timer that has already fired is not drained: its goroutine sees the timer is removed and keeps the key.
 func (c *MemoryCache) callCancel(key []byte) error {
 	c.timerMu.Lock()
 	defer c.timerMu.Unlock()
 
 	strKey := string(key)
 	if t, ok := c.timers[strKey]; ok {
-		if !t.Stop() {
-			<-t.C
-		}
-
+		t.Stop()
 		delete(c.timers, strKey)
+		delete(c.expires, strKey)
 	}
 
 	return nil
@@ -178,38 +319,62 @@ func (c *MemoryCache) sliceByteToInt64(key []byte) (int64, error) {
 	return valInt, nil
 }
 
-func (c *MemoryCache) initCancel(ctx context.Context, strKey string, ttl *uint64) {
+// initCancel start removing key by ttl. Existing timer is kept or restarted if refresh.
+// Timer does not depend on context of the call, so the key is removed even when the request is done.
+func (c *MemoryCache) initCancel(strKey string, ttl *uint64, refresh bool) {
 	if ttl == nil || *ttl <= 0 {
 		return
 	}
 
 	c.timerMu.Lock()
 	defer c.timerMu.Unlock()
-	if _, ok := c.timers[strKey]; ok {
-		return
+	if old, ok := c.timers[strKey]; ok {
+		if !refresh {
+			return
+		}
+		old.Stop()
 	}
-	t := time.NewTimer(time.Duration(*ttl) * time.Second)
-	c.timers[strKey] = t
 
-	go func() {
-		select {
-		case <-t.C:
-			c.dataMu.Lock()
-			delete(c.data, strKey)
-			c.dataMu.Unlock()
-
-			c.timerMu.Lock()
-			delete(c.timers, strKey)
-			c.timerMu.Unlock()
-		case <-ctx.Done():
-			if !t.Stop() {
-				<-t.C
-			}
+	d := time.Duration(*ttl) * time.Second
+	var t clock.Timer
+	t = c.clock.AfterFunc(d, func() {
+		c.dataMu.Lock()
+		defer c.dataMu.Unlock()
+		c.timerMu.Lock()
+		defer c.timerMu.Unlock()
 
-			c.timerMu.Lock()
-			delete(c.timers, strKey)
-			c.timerMu.Unlock()
+		// timer is stopped or restarted after firing
+		if c.timers[strKey] != t {
 			return
 		}
-	}()
+
+		c.deleteKey(strKey)
+		delete(c.timers, strKey)
+		delete(c.expires, strKey)
+	})
+	c.timers[strKey] = t
+	c.expires[strKey] = c.clock.Now().Add(d)
+}
+
+// add delta to value by key. Must be called under dataMu lock.
+func (c *MemoryCache) add(key []byte, delta int64, ttl *uint64) (int64, error) {
+	strKey := string(key)
+	valInt := int64(0)
+	if _, ok := c.data[strKey]; ok {
+		var err error
+		valInt, err = c.sliceByteToInt64(key)
+		if err != nil {
+			return int64(0), err
+		}
+	} else if c.isEvicted(strKey) {
+		return EvictedValue, nil
+	}
+
+	valInt += delta
+	c.data[strKey] = []byte(strconv.FormatInt(valInt, 10))
+
+	c.initCancel(strKey, ttl, c.ttlMode == RefreshTTL)
+	c.touch(strKey, ttl)
+
+	return valInt, nil
 }
@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"fmt"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
 	"github.com/stretchr/testify/assert"
 	"strconv"
 	"testing"
@@ -33,6 +34,7 @@ func Test_NewMemoryCache(t *testing.T) {
 
 // TestMemoryCache_Set test Set function
 func TestMemoryCache_Set(t *testing.T) {
+	clk := clock.NewManual(time.Now())
 	type setDataTest struct {
 		memoryCache *MemoryCache
 		ctx         context.Context
@@ -45,7 +47,7 @@ func TestMemoryCache_Set(t *testing.T) {
 
 	var setDataTests = []setDataTest{
 		{
-			memoryCache: NewMemoryCache(),
+			memoryCache: NewMemoryCache(WithClock(clk)),
 			ctx:         context.Background(),
 			key:         []byte("set_key1"),
 			value:       []byte("value1"),
@@ -53,7 +55,7 @@ func TestMemoryCache_Set(t *testing.T) {
 			err:         nil,
 		},
 		{
-			memoryCache: NewMemoryCache(),
+			memoryCache: NewMemoryCache(WithClock(clk)),
 			ctx:         context.Background(),
 			key:         []byte(""),
 			value:       []byte("value2"),
@@ -61,7 +63,7 @@ func TestMemoryCache_Set(t *testing.T) {
 			err:         fmt.Errorf("key is empty"),
 		},
 		{
-			memoryCache: NewMemoryCache(),
+			memoryCache: NewMemoryCache(WithClock(clk)),
 			ctx:         context.Background(),
 			key:         []byte("set_key2"),
 			value:       []byte("value2"),
@@ -70,7 +72,7 @@ func TestMemoryCache_Set(t *testing.T) {
 			timeout:     2,
 		},
 		{
-			memoryCache: NewMemoryCache(),
+			memoryCache: NewMemoryCache(WithClock(clk)),
 			ctx:         context.Background(),
 			key:         []byte("set_key2"),
 			value:       []byte("value2"),
@@ -79,7 +81,7 @@ func TestMemoryCache_Set(t *testing.T) {
 			timeout:     2,
 		},
 		{
-			memoryCache: NewMemoryCache(),
+			memoryCache: NewMemoryCache(WithClock(clk)),
 			ctx:         context.Background(),
 			key:         []byte("set_key3"),
 			value:       []byte("value3"),
@@ -115,7 +117,7 @@ func TestMemoryCache_Set(t *testing.T) {
 		}
 
 		if test.timeout > 0 {
-			time.Sleep(time.Duration(test.timeout) * time.Second)
+			clk.Advance(time.Duration(test.timeout) * time.Second)
 			if test.ttl > test.timeout { // long-lived cache
 				test.memoryCache.dataMu.RLock()
 				_, ok := test.memoryCache.data[string(test.key)]
@@ -142,7 +144,8 @@ func TestMemoryCache_Set(t *testing.T) {
 
 // TestMemoryCache_Get test Get function
 func TestMemoryCache_Get(t *testing.T) {
-	mem := NewMemoryCache()
+	clk := clock.NewManual(time.Now())
+	mem := NewMemoryCache(WithClock(clk))
 	ctx := context.Background()
 
 	err := mem.Set(ctx, []byte("key1"), []byte("value1"), &zero)
@@ -157,7 +160,7 @@ func TestMemoryCache_Get(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, "value2", string(k2Val))
 
-	time.Sleep(time.Duration(3) * time.Second)
+	clk.Advance(time.Duration(3) * time.Second)
 	k2Val, err = mem.Get(ctx, []byte("key2"))
 	assert.NotNil(t, err)
 	assert.Len(t, k2Val, 0)
@@ -169,7 +172,7 @@ func TestMemoryCache_Get(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, "value_new1", string(k1Val))
 
-	time.Sleep(time.Duration(1) * time.Second)
+	clk.Advance(time.Duration(1) * time.Second)
 
 	err = mem.Set(ctx, []byte("key1"), []byte("value_new2"), &ten)
 	assert.Equal(t, nil, err)
@@ -177,7 +180,7 @@ func TestMemoryCache_Get(t *testing.T) {
 	assert.Equal(t, nil, err)
 	assert.Equal(t, "value_new2", string(k1Val))
 
-	time.Sleep(time.Duration(3) * time.Second)
+	clk.Advance(time.Duration(3) * time.Second)
 	k1Val, err = mem.Get(ctx, []byte("key1"))
 	assert.NotNil(t, err)
 	assert.Len(t, k1Val, 0)
@@ -212,14 +215,19 @@ func TestMemoryCache_Inc_NotExist_Key(t *testing.T) {
 	assert.Equal(t, "", string(val))
 
 	value, err := mem.Inc(ctx, []byte("inc_key_not_exists"), &fifteen)
-	assert.NotNil(t, err)
-	assert.Equal(t, ValueNotFoundByKey, err)
+	assert.Nil(t, err)
 	assert.Equal(t, int64(1), value)
+
+	// the first increment starts ttl
+	ttl, err := mem.TTL(ctx, []byte("inc_key_not_exists"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 14*time.Second && ttl <= 15*time.Second)
 }
 
 // TestMemoryCache_Inc test Inc function
 func TestMemoryCache_Inc(t *testing.T) {
-	mem := NewMemoryCache()
+	clk := clock.NewManual(time.Now())
+	mem := NewMemoryCache(WithClock(clk))
 	ctx := context.Background()
 
 	val, err := mem.Get(ctx, []byte("inc_key"))
@@ -229,42 +237,105 @@ func TestMemoryCache_Inc(t *testing.T) {
 
 	for i := 1; i <= 10; i++ {
 		value, err := mem.Inc(ctx, []byte("inc_key"), &fifteen)
-		if i == 1 {
-			assert.NotNil(t, err)
-			assert.Equal(t, ValueNotFoundByKey, err)
-		} else {
-			assert.Nil(t, err)
-		}
-		time.Sleep(time.Duration(1) * time.Second)
+		assert.Nil(t, err)
 		assert.Equal(t, int64(i), value)
 	}
 
-	for i := 1; i <= 5; i++ {
-		value, err := mem.Inc(ctx, []byte("inc_key2"), &three)
-		if i == 5 {
-			assert.NotNil(t, err)
-			assert.Equal(t, ValueNotFoundByKey, err)
-			assert.Equal(t, int64(1), value)
-		} else {
-			if i == 1 {
-				assert.NotNil(t, err)
-				assert.Equal(t, ValueNotFoundByKey, err)
-			} else {
-				assert.Nil(t, err)
-				assert.Equal(t, int64(i), value)
-			}
-		}
+	// the key created by the first increment is removed by ttl without next increments
+	one := uint64(1)
+	value, err := mem.Inc(ctx, []byte("inc_key2"), &one)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), value)
 
-		val, err := mem.Get(ctx, []byte("inc_key2"))
-		if i == 5 {
-			assert.Nil(t, err)
-			assert.Equal(t, "1", string(val))
-		} else {
-			assert.Nil(t, err)
-			assert.Equal(t, strconv.Itoa(i), string(val))
-		}
-		time.Sleep(time.Duration(1) * time.Second)
+	clk.Advance(1100 * time.Millisecond)
+	assert.False(t, mem.Has(ctx, []byte("inc_key2")))
+
+	value, err = mem.Inc(ctx, []byte("inc_key2"), &one)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), value)
+}
+
+// TestMemoryCache_Inc_CanceledContext test ttl does not depend on context of Inc
+func TestMemoryCache_Inc_CanceledContext(t *testing.T) {
+	clk := clock.NewManual(time.Now())
+	mem := NewMemoryCache(WithClock(clk))
+	ctx, cancel := context.WithCancel(context.Background())
+	one := uint64(1)
+
+	_, err := mem.Inc(ctx, []byte("inc_key"), &one)
+	assert.Nil(t, err)
+	cancel()
+
+	clk.Advance(1100 * time.Millisecond)
+	assert.False(t, mem.Has(context.Background(), []byte("inc_key")))
+}
+
+// TestMemoryCache_Inc_KeepTTL test fixed window: ttl is set on creation and kept on next increments
+func TestMemoryCache_Inc_KeepTTL(t *testing.T) {
+	clk := clock.NewManual(time.Now())
+	mem := NewMemoryCache(WithClock(clk), WithTTLMode(KeepTTL))
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		value, err := mem.Inc(ctx, []byte("inc_key"), &two)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(i), value)
+		clk.Advance(600 * time.Millisecond)
+	}
+
+	// 1.8s after the first increment, ttl is counted from it
+	ttl, err := mem.TTL(ctx, []byte("inc_key"))
+	assert.Nil(t, err)
+	assert.Equal(t, 200*time.Millisecond, ttl)
+
+	clk.Advance(300 * time.Millisecond)
+	assert.False(t, mem.Has(ctx, []byte("inc_key")))
+}
+
+// TestMemoryCache_Inc_RefreshTTL test sliding window: ttl is restarted by every increment
+func TestMemoryCache_Inc_RefreshTTL(t *testing.T) {
+	clk := clock.NewManual(time.Now())
+	mem := NewMemoryCache(WithClock(clk), WithTTLMode(RefreshTTL))
+	ctx := context.Background()
+
+	for i := 1; i <= 4; i++ {
+		value, err := mem.Inc(ctx, []byte("inc_key"), &two)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(i), value)
+		clk.Advance(600 * time.Millisecond)
 	}
+
+	// 2.4s after the first increment the key is alive, ttl is counted from the last one
+	assert.True(t, mem.Has(ctx, []byte("inc_key")))
+	ttl, err := mem.TTL(ctx, []byte("inc_key"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1400*time.Millisecond, ttl)
+
+	value, ttl, err := mem.IncrBy(ctx, []byte("inc_key"), 2, &two)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), value)
+	assert.Equal(t, 2*time.Second, ttl)
+
+	clk.Advance(2100 * time.Millisecond)
+	assert.False(t, mem.Has(ctx, []byte("inc_key")))
+}
+
+// TestParseTTLMode test ParseTTLMode function
+func TestParseTTLMode(t *testing.T) {
+	mode, err := ParseTTLMode("")
+	assert.Nil(t, err)
+	assert.Equal(t, KeepTTL, mode)
+
+	mode, err = ParseTTLMode("keep")
+	assert.Nil(t, err)
+	assert.Equal(t, KeepTTL, mode)
+
+	mode, err = ParseTTLMode("refresh")
+	assert.Nil(t, err)
+	assert.Equal(t, RefreshTTL, mode)
+
+	_, err = ParseTTLMode("sliding")
+	assert.NotNil(t, err)
 }
 
 // TestMemoryCache_Decr test Decr function
@@ -284,36 +355,22 @@ func TestMemoryCache_Decr(t *testing.T) {
 		k := 2 - i
 		value, err := mem.Decr(ctx, []byte("decr_key"), &fifteen)
 		assert.Nil(t, err)
-		time.Sleep(time.Duration(1) * time.Second)
 		assert.Equal(t, int64(k), value)
 	}
 
-	for i := -1; i >= -5; i-- {
+	for i := -1; i >= -3; i-- {
 		value, err := mem.Decr(ctx, []byte("decr_key2"), &three)
-		if i == -5 {
-			assert.NotNil(t, err)
-			assert.Equal(t, int64(-1), value)
-		} else {
-			if i == -1 {
-				assert.NotNil(t, err)
-				assert.Equal(t, ValueNotFoundByKey, err)
-				assert.Equal(t, int64(i), value)
-			} else {
-				assert.Nil(t, err)
-				assert.Equal(t, int64(i), value)
-			}
-		}
+		assert.Nil(t, err)
+		assert.Equal(t, int64(i), value)
 
 		val, err := mem.Get(ctx, []byte("decr_key2"))
-		if i == -5 {
-			assert.Nil(t, err)
-			assert.Equal(t, "-1", string(val))
-		} else {
-			assert.Nil(t, err)
-			assert.Equal(t, strconv.Itoa(i), string(val))
-		}
-		time.Sleep(time.Duration(1) * time.Second)
+		assert.Nil(t, err)
+		assert.Equal(t, strconv.Itoa(i), string(val))
 	}
+
+	ttl, err := mem.TTL(ctx, []byte("decr_key2"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 2*time.Second && ttl <= 3*time.Second)
 }
 
 // TestMemoryCache_Del test Del function
@@ -372,7 +429,8 @@ func TestMemoryCache_Clear(t *testing.T) {
 
 // TestMemoryCache_callCancel test callCancel function
 func TestMemoryCache_callCancel(t *testing.T) {
-	mem := NewMemoryCache()
+	clk := clock.NewManual(time.Now())
+	mem := NewMemoryCache(WithClock(clk))
 	ctx := context.Background()
 
 	val, err := mem.Get(ctx, []byte("callCancel"))
@@ -390,7 +448,7 @@ func TestMemoryCache_callCancel(t *testing.T) {
 	_, ok := mem.timers["callCancel"]
 	assert.False(t, ok)
 
-	time.Sleep(time.Duration(5) * time.Second)
+	clk.Advance(time.Duration(5) * time.Second)
 
 	val, err = mem.Get(ctx, []byte("callCancel"))
 	assert.Nil(t, err)
@@ -423,8 +481,117 @@ func TestMemoryCache_sliceByteToInt64(t *testing.T) {
 // TestMemoryCache_initCancel test initCancel function
 func TestMemoryCache_initCancel(t *testing.T) {
 	mem := NewMemoryCache()
+
+	mem.initCancel("key1", &three, false)
+	first := mem.timers["key1"]
+	assert.NotNil(t, first)
+
+	mem.initCancel("key1", &ten, false)
+	assert.Same(t, first, mem.timers["key1"])
+
+	mem.initCancel("key1", &ten, true)
+	assert.NotSame(t, first, mem.timers["key1"])
+}
+
+// TestMemoryCache_TTL test TTL function
+func TestMemoryCache_TTL(t *testing.T) {
+	mem := NewMemoryCache()
+	ctx := context.Background()
+
+	_, err := mem.TTL(ctx, []byte("ttl_key"))
+	assert.Equal(t, ValueNotFoundByKey, err)
+
+	err = mem.Set(ctx, []byte("ttl_key"), []byte("value"), &zero)
+	assert.Nil(t, err)
+	ttl, err := mem.TTL(ctx, []byte("ttl_key"))
+	assert.Nil(t, err)
+	assert.Equal(t, NoTTL, ttl)
+
+	err = mem.Set(ctx, []byte("ttl_key2"), []byte("value"), &ten)
+	assert.Nil(t, err)
+	ttl, err = mem.TTL(ctx, []byte("ttl_key2"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 9*time.Second && ttl <= 10*time.Second)
+
+	err = mem.Del(ctx, []byte("ttl_key2"))
+	assert.Nil(t, err)
+	mem.timerMu.RLock()
+	assert.Len(t, mem.expires, 0)
+	mem.timerMu.RUnlock()
+}
+
+// TestMemoryCache_IncrBy test IncrBy function
+func TestMemoryCache_IncrBy(t *testing.T) {
+	mem := NewMemoryCache()
+	ctx := context.Background()
+
+	value, ttl, err := mem.IncrBy(ctx, []byte("incr_key"), 5, &ten)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), value)
+	assert.True(t, ttl > 9*time.Second && ttl <= 10*time.Second)
+
+	value, ttl, err = mem.IncrBy(ctx, []byte("incr_key"), -2, &twenty)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), value)
+	assert.True(t, ttl > 9*time.Second && ttl <= 10*time.Second)
+
+	value, ttl, err = mem.IncrBy(ctx, []byte("incr_key_no_ttl"), 1, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), value)
+	assert.Equal(t, NoTTL, ttl)
+
+	err = mem.Set(ctx, []byte("string_key"), []byte("value"), &ten)
+	assert.Nil(t, err)
+	_, _, err = mem.IncrBy(ctx, []byte("string_key"), 1, &ten)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "is not integer")
+}
+
+// TestMemoryCache_CompareAndSwap test CompareAndSwap function
+func TestMemoryCache_CompareAndSwap(t *testing.T) {
+	mem := NewMemoryCache()
 	ctx := context.Background()
 
-	mem.initCancel(ctx, "key1", &three)
-	assert.NotNil(t, mem.timers["key1"])
+	swapped, err := mem.CompareAndSwap(ctx, []byte("cas_key"), nil, []byte("1"), &ten)
+	assert.Nil(t, err)
+	assert.False(t, swapped)
+	assert.False(t, mem.Has(ctx, []byte("cas_key")))
+
+	err = mem.Set(ctx, []byte("cas_key"), []byte("1"), &zero)
+	assert.Nil(t, err)
+
+	swapped, err = mem.CompareAndSwap(ctx, []byte("cas_key"), []byte("2"), []byte("3"), &ten)
+	assert.Nil(t, err)
+	assert.False(t, swapped)
+
+	swapped, err = mem.CompareAndSwap(ctx, []byte("cas_key"), []byte("1"), []byte("2"), &ten)
+	assert.Nil(t, err)
+	assert.True(t, swapped)
+	assert.Equal(t, "2", string(mustGet(t, mem, "cas_key")))
+
+	ttl, err := mem.TTL(ctx, []byte("cas_key"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 9*time.Second && ttl <= 10*time.Second)
+}
+
+// TestMemoryCache_SetNX test SetNX function
+func TestMemoryCache_SetNX(t *testing.T) {
+	mem := NewMemoryCache()
+	ctx := context.Background()
+
+	_, err := mem.SetNX(ctx, []byte(""), []byte("value"), &ten)
+	assert.NotNil(t, err)
+
+	ok, err := mem.SetNX(ctx, []byte("nx_key"), []byte("value1"), &ten)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	ok, err = mem.SetNX(ctx, []byte("nx_key"), []byte("value2"), &ten)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, "value1", string(mustGet(t, mem, "nx_key")))
+
+	ttl, err := mem.TTL(ctx, []byte("nx_key"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 9*time.Second && ttl <= 10*time.Second)
 }
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultScanCount is count of keys of one Scan page when count is not positive
+const DefaultScanCount = 100
+
+// KeyInfo is key with its value and remaining ttl returned by Scan
+type KeyInfo struct {
+	Key   []byte
+	Value []byte
+	// TTL remaining time to live, NoTTL if the key has no expiration
+	TTL time.Duration
+}
+
+// Scan return up to count keys with prefix in key order after cursor, and cursor of the next page.
+// Empty cursor starts iteration, empty next cursor ends it. Cursor is the last returned key, so it stays
+// valid when keys are changed: every key that exists during the whole iteration is returned exactly once.
+// Scan doesn't change LRU order of keys.
+func (c *MemoryCache) Scan(ctx context.Context, prefix []byte, cursor string, count int) ([]KeyInfo, string, error) {
+	after, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	c.dataMu.RLock()
+	defer c.dataMu.RUnlock()
+
+	keys := make([]string, 0)
+	for strKey := range c.data {
+		if strings.HasPrefix(strKey, string(prefix)) && (after == "" || strKey > after) {
+			keys = append(keys, strKey)
+		}
+	}
+
+	keys, next := page(keys, count)
+	res := make([]KeyInfo, len(keys))
+	for i, strKey := range keys {
+		res[i] = KeyInfo{
+			Key:   []byte(strKey),
+			Value: append([]byte(nil), c.data[strKey]...),
+			TTL:   c.ttl(strKey),
+		}
+	}
+
+	return res, next, nil
+}
+
+// Scan return up to count keys with prefix in key order after cursor, and cursor of the next page.
+// Cursor contract is the same as of MemoryCache.
+func (c *ShardedMemoryCache) Scan(ctx context.Context, prefix []byte, cursor string, count int) ([]KeyInfo, string, error) {
+	after, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := c.clock.Now()
+	items := make(map[string]KeyInfo)
+	keys := make([]string, 0)
+	for _, s := range c.shards {
+		s.mu.RLock()
+		for strKey, item := range s.items {
+			if item.expired(now) || !strings.HasPrefix(strKey, string(prefix)) || (after != "" && strKey <= after) {
+				continue
+			}
+
+			info := KeyInfo{Key: []byte(strKey), Value: item.value, TTL: NoTTL}
+			if item.value == nil {
+				info.Value = []byte(strconv.FormatInt(item.counter.Load(), 10))
+			}
+			if expireAt := item.expireAt.Load(); expireAt != 0 {
+				info.TTL = time.Unix(0, expireAt).Sub(now)
+			}
+			items[strKey] = info
+			keys = append(keys, strKey)
+		}
+		s.mu.RUnlock()
+	}
+
+	keys, next := page(keys, count)
+	res := make([]KeyInfo, len(keys))
+	for i, strKey := range keys {
+		res[i] = items[strKey]
+	}
+
+	return res, next, nil
+}
+
+// page sort keys and return the first count of them with cursor of the next page
+func page(keys []string, count int) ([]string, string) {
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+
+	sort.Strings(keys)
+	if len(keys) <= count {
+		return keys, ""
+	}
+
+	keys = keys[:count]
+	return keys, encodeCursor(keys[count-1])
+}
+
+// encodeCursor make opaque cursor of the last returned key
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// decodeCursor return the last returned key of cursor
+func decodeCursor(cursor string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", fmt.Errorf("invalid cursor: %w", err)
+	}
+
+	return string(key), nil
+}
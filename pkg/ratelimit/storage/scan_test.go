@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// scanner is storage with Scan
+type scanner interface {
+	Scan(ctx context.Context, prefix []byte, cursor string, count int) ([]KeyInfo, string, error)
+	Set(ctx context.Context, key []byte, value []byte, ttl *uint64) error
+	Inc(ctx context.Context, key []byte, ttl *uint64) (int64, error)
+	Del(ctx context.Context, list ...[]byte) error
+}
+
+// scanAll iterate all pages of scan and return keys
+func scanAll(t *testing.T, s scanner, prefix string, count int, each func()) []string {
+	keys := make([]string, 0)
+	cursor := ""
+	for {
+		page, next, err := s.Scan(context.Background(), []byte(prefix), cursor, count)
+		assert.Nil(t, err)
+		assert.LessOrEqual(t, len(page), count)
+		for _, info := range page {
+			keys = append(keys, string(info.Key))
+		}
+		if each != nil {
+			each()
+		}
+		if next == "" {
+			return keys
+		}
+		cursor = next
+	}
+}
+
+// testScan test Scan of storage
+func testScan(t *testing.T, s scanner) {
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		_, _ = s.Inc(ctx, []byte("counter:"+strconv.Itoa(i)), &ten)
+	}
+	_ = s.Set(ctx, []byte("value"), []byte("text"), &zero)
+
+	page, next, err := s.Scan(ctx, []byte("counter:"), "", 3)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, next)
+	assert.Len(t, page, 3)
+	assert.Equal(t, "counter:0", string(page[0].Key))
+	assert.Equal(t, "1", string(page[0].Value))
+	assert.True(t, page[0].TTL > 9*time.Second && page[0].TTL <= 10*time.Second)
+
+	page, next, err = s.Scan(ctx, []byte("value"), "", 0)
+	assert.Nil(t, err)
+	assert.Empty(t, next)
+	assert.Equal(t, []KeyInfo{{Key: []byte("value"), Value: []byte("text"), TTL: NoTTL}}, page)
+
+	_, _, err = s.Scan(ctx, nil, "not base64!", 3)
+	assert.NotNil(t, err)
+
+	// keys existing during the whole scan are returned once, changes don't break cursor
+	added := 0
+	keys := scanAll(t, s, "counter:", 3, func() {
+		_ = s.Del(ctx, []byte("counter:"+strconv.Itoa(9-added)))
+		_, _ = s.Inc(ctx, []byte("counter:0"+strconv.Itoa(added)), &ten)
+		added++
+	})
+
+	seen := make(map[string]int)
+	for _, key := range keys {
+		seen[key]++
+	}
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, 1, seen["counter:"+strconv.Itoa(i)])
+	}
+	for _, n := range seen {
+		assert.Equal(t, 1, n)
+	}
+}
+
+// TestMemoryCache_Scan test Scan function
+func TestMemoryCache_Scan(t *testing.T) {
+	testScan(t, NewMemoryCache())
+}
+
+// TestShardedMemoryCache_Scan test Scan function
+func TestShardedMemoryCache_Scan(t *testing.T) {
+	testScan(t, NewShardedMemoryCache(4))
+}
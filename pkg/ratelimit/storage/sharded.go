@@ -0,0 +1,284 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ShardedMemoryCache storage in memory split into shards by key hash.
+// Every shard has its own lock, counters are stored as atomic int64,
+// so Inc of an existing key takes only a shard read lock.
+type ShardedMemoryCache struct {
+	shards  []*memoryShard
+	mask    uint64
+	ttlMode TTLMode
+	clock   clock.Clock
+}
+
+// ShardedMemoryCacheOption configure ShardedMemoryCache
+type ShardedMemoryCacheOption func(c *ShardedMemoryCache)
+
+// WithShardedTTLMode set what to do with ttl of existing counters. KeepTTL by default.
+func WithShardedTTLMode(mode TTLMode) ShardedMemoryCacheOption {
+	return func(c *ShardedMemoryCache) {
+		c.ttlMode = mode
+	}
+}
+
+// WithShardedClock set clock of expirations. clock.Real by default.
+func WithShardedClock(clk clock.Clock) ShardedMemoryCacheOption {
+	return func(c *ShardedMemoryCache) {
+		c.clock = clock.OrReal(clk)
+	}
+}
+
+type memoryShard struct {
+	mu    sync.RWMutex
+	items map[string]*shardItem
+}
+
+// shardItem is a value of ShardedMemoryCache. value is nil for counters.
+type shardItem struct {
+	counter  atomic.Int64
+	value    []byte
+	expireAt atomic.Int64 // unix nano, 0 - without expiration
+}
+
+// NewShardedMemoryCache create new sharded storage in memory.
+// Count of shards is rounded up to power of two. If shards <= 0 it is chosen by GOMAXPROCS.
+func NewShardedMemoryCache(shards int, opts ...ShardedMemoryCacheOption) *ShardedMemoryCache {
+	if shards <= 0 {
+		shards = runtime.GOMAXPROCS(0) * 4
+	}
+
+	n := 1
+	for n < shards {
+		n <<= 1
+	}
+
+	c := &ShardedMemoryCache{
+		shards: make([]*memoryShard, n),
+		mask:   uint64(n - 1),
+		clock:  clock.Real,
+	}
+	for i := range c.shards {
+		c.shards[i] = &memoryShard{items: make(map[string]*shardItem)}
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Has check is set value by key
+func (c *ShardedMemoryCache) Has(ctx context.Context, key []byte) bool {
+	s := c.shard(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, ok := s.items[string(key)]
+	return ok && !item.expired(c.clock.Now())
+}
+
+// Inc increment value by key. Counter contract is the same as of MemoryCache.
+func (c *ShardedMemoryCache) Inc(ctx context.Context, key []byte, ttl *uint64) (int64, error) {
+	return c.add(key, 1, ttl)
+}
+
+// Decr decrement value by key
+func (c *ShardedMemoryCache) Decr(ctx context.Context, key []byte, ttl *uint64) (int64, error) {
+	return c.add(key, -1, ttl)
+}
+
+// Get value by key
+func (c *ShardedMemoryCache) Get(ctx context.Context, key []byte) ([]byte, error) {
+	s := c.shard(key)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, ok := s.items[string(key)]
+	if !ok || item.expired(c.clock.Now()) {
+		return nil, ValueNotFoundByKey
+	}
+
+	if item.value == nil {
+		return []byte(strconv.FormatInt(item.counter.Load(), 10)), nil
+	}
+
+	return item.value, nil
+}
+
+// Set value by key. Expiration of the existing key is kept, same as in MemoryCache.
+func (c *ShardedMemoryCache) Set(ctx context.Context, key []byte, value []byte, ttl *uint64) error {
+	if len(key) == 0 {
+		return fmt.Errorf("key is empty")
+	}
+
+	now := c.clock.Now()
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := &shardItem{}
+	if valInt, err := strconv.ParseInt(string(value), 10, 64); err == nil && strconv.FormatInt(valInt, 10) == string(value) {
+		item.counter.Store(valInt)
+	} else {
+		item.value = value
+	}
+
+	strKey := string(key)
+	if old, ok := s.items[strKey]; ok && !old.expired(now) {
+		item.expireAt.Store(old.expireAt.Load())
+	}
+	item.initExpire(now, ttl)
+	s.items[strKey] = item
+
+	return nil
+}
+
+// Del value by key
+func (c *ShardedMemoryCache) Del(ctx context.Context, list ...[]byte) error {
+	for _, key := range list {
+		s := c.shard(key)
+		s.mu.Lock()
+		delete(s.items, string(key))
+		s.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Clear all
+func (c *ShardedMemoryCache) Clear(ctx context.Context) error {
+	for _, s := range c.shards {
+		s.mu.Lock()
+		s.items = make(map[string]*shardItem)
+		s.mu.Unlock()
+	}
+
+	return nil
+}
+
+// DeleteExpired remove expired keys from all shards
+func (c *ShardedMemoryCache) DeleteExpired() {
+	now := c.clock.Now()
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for k, item := range s.items {
+			if item.expired(now) {
+				delete(s.items, k)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// StartJanitor run DeleteExpired every interval until ctx is done.
+// Expired keys are not visible without janitor, but they hold memory until next write.
+func (c *ShardedMemoryCache) StartJanitor(ctx context.Context, interval time.Duration) {
+	ticker := c.clock.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C():
+				c.DeleteExpired()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// add delta to counter by key. Existing counters are changed under shard read lock.
+func (c *ShardedMemoryCache) add(key []byte, delta int64, ttl *uint64) (int64, error) {
+	now := c.clock.Now()
+	s := c.shard(key)
+
+	s.mu.RLock()
+	item, ok := s.items[string(key)]
+	if ok && item.value == nil && !item.expired(now) {
+		valInt := item.counter.Add(delta)
+		c.expire(item, now, ttl)
+		s.mu.RUnlock()
+
+		return valInt, nil
+	}
+	s.mu.RUnlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	strKey := string(key)
+	item, ok = s.items[strKey]
+	if !ok || item.expired(now) {
+		item = &shardItem{}
+		item.counter.Store(delta)
+		item.initExpire(now, ttl)
+		s.items[strKey] = item
+
+		return delta, nil
+	}
+
+	if item.value != nil {
+		valInt, err := strconv.ParseInt(string(item.value), 10, 64)
+		if err != nil {
+			return int64(0), fmt.Errorf("value by key %s is not integer", strKey)
+		}
+
+		counter := &shardItem{}
+		counter.counter.Store(valInt)
+		counter.expireAt.Store(item.expireAt.Load())
+		item = counter
+		s.items[strKey] = item
+	}
+
+	valInt := item.counter.Add(delta)
+	c.expire(item, now, ttl)
+
+	return valInt, nil
+}
+
+// shard return shard of the key. Hash is FNV-1a.
+func (c *ShardedMemoryCache) shard(key []byte) *memoryShard {
+	hash := uint64(14695981039346656037)
+	for _, b := range key {
+		hash ^= uint64(b)
+		hash *= 1099511628211
+	}
+
+	return c.shards[hash&c.mask]
+}
+
+// expire set expiration of changed counter by ttl mode
+func (c *ShardedMemoryCache) expire(item *shardItem, now time.Time, ttl *uint64) {
+	if c.ttlMode == RefreshTTL && ttl != nil && *ttl > 0 {
+		item.expireAt.Store(now.Add(time.Duration(*ttl) * time.Second).UnixNano())
+		return
+	}
+
+	item.initExpire(now, ttl)
+}
+
+// expired check is item expired at now
+func (i *shardItem) expired(now time.Time) bool {
+	expireAt := i.expireAt.Load()
+	return expireAt != 0 && now.UnixNano() >= expireAt
+}
+
+// initExpire set expiration if ttl is positive and item has no expiration yet
+func (i *shardItem) initExpire(now time.Time, ttl *uint64) {
+	if ttl == nil || *ttl <= 0 {
+		return
+	}
+
+	i.expireAt.CompareAndSwap(0, now.Add(time.Duration(*ttl)*time.Second).UnixNano())
+}
@@ -0,0 +1,280 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test_NewShardedMemoryCache test NewShardedMemoryCache function
+func Test_NewShardedMemoryCache(t *testing.T) {
+	mem := NewShardedMemoryCache(5)
+	assert.Len(t, mem.shards, 8)
+	assert.Equal(t, uint64(7), mem.mask)
+
+	mem = NewShardedMemoryCache(0)
+	assert.NotEmpty(t, mem.shards)
+	assert.Equal(t, 0, len(mem.shards)&(len(mem.shards)-1))
+}
+
+// TestShardedMemoryCache_Set test Set and Get functions
+func TestShardedMemoryCache_Set(t *testing.T) {
+	mem := NewShardedMemoryCache(4)
+	ctx := context.Background()
+
+	err := mem.Set(ctx, []byte(""), []byte("value"), &zero)
+	assert.Equal(t, fmt.Errorf("key is empty"), err)
+
+	err = mem.Set(ctx, []byte("key1"), []byte("value1"), &zero)
+	assert.Nil(t, err)
+	val, err := mem.Get(ctx, []byte("key1"))
+	assert.Nil(t, err)
+	assert.Equal(t, "value1", string(val))
+
+	err = mem.Set(ctx, []byte("key2"), []byte("007"), &zero)
+	assert.Nil(t, err)
+	val, err = mem.Get(ctx, []byte("key2"))
+	assert.Nil(t, err)
+	assert.Equal(t, "007", string(val))
+
+	err = mem.Set(ctx, []byte("key3"), []byte("42"), &zero)
+	assert.Nil(t, err)
+	val, err = mem.Get(ctx, []byte("key3"))
+	assert.Nil(t, err)
+	assert.Equal(t, "42", string(val))
+
+	val, err = mem.Get(ctx, []byte("not_exists_key"))
+	assert.Equal(t, ValueNotFoundByKey, err)
+	assert.Len(t, val, 0)
+
+	assert.True(t, mem.Has(ctx, []byte("key1")))
+	assert.False(t, mem.Has(ctx, []byte("not_exists_key")))
+}
+
+// TestShardedMemoryCache_Expire test ttl of keys
+func TestShardedMemoryCache_Expire(t *testing.T) {
+	clk := clock.NewManual(time.Now())
+	mem := NewShardedMemoryCache(4, WithShardedClock(clk))
+	ctx := context.Background()
+	one := uint64(1)
+
+	err := mem.Set(ctx, []byte("key1"), []byte("value1"), &one)
+	assert.Nil(t, err)
+	_, err = mem.Inc(ctx, []byte("key2"), &one)
+	assert.Nil(t, err)
+	assert.True(t, mem.Has(ctx, []byte("key1")))
+	assert.True(t, mem.Has(ctx, []byte("key2")))
+
+	clk.Advance(1100 * time.Millisecond)
+	assert.False(t, mem.Has(ctx, []byte("key1")))
+	_, err = mem.Get(ctx, []byte("key2"))
+	assert.Equal(t, ValueNotFoundByKey, err)
+
+	mem.DeleteExpired()
+	for _, s := range mem.shards {
+		assert.Len(t, s.items, 0)
+	}
+
+	value, err := mem.Inc(ctx, []byte("key2"), &one)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), value)
+}
+
+// TestShardedMemoryCache_TTLMode test keep and refresh ttl of counters
+func TestShardedMemoryCache_TTLMode(t *testing.T) {
+	ctx := context.Background()
+
+	clk := clock.NewManual(time.Now())
+	keep := NewShardedMemoryCache(1, WithShardedClock(clk), WithShardedTTLMode(KeepTTL))
+	refresh := NewShardedMemoryCache(1, WithShardedClock(clk), WithShardedTTLMode(RefreshTTL))
+	for i := 0; i < 4; i++ {
+		_, err := keep.Inc(ctx, []byte("inc_key"), &two)
+		assert.Nil(t, err)
+		_, err = refresh.Inc(ctx, []byte("inc_key"), &two)
+		assert.Nil(t, err)
+		clk.Advance(600 * time.Millisecond)
+	}
+
+	// 2.4s after the first increment
+	assert.False(t, keep.Has(ctx, []byte("inc_key")))
+	assert.True(t, refresh.Has(ctx, []byte("inc_key")))
+
+	val, err := refresh.Get(ctx, []byte("inc_key"))
+	assert.Nil(t, err)
+	assert.Equal(t, "4", string(val))
+}
+
+// TestShardedMemoryCache_StartJanitor test StartJanitor function
+func TestShardedMemoryCache_StartJanitor(t *testing.T) {
+	clk := clock.NewManual(time.Now())
+	mem := NewShardedMemoryCache(1, WithShardedClock(clk))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	one := uint64(1)
+
+	err := mem.Set(ctx, []byte("key1"), []byte("value1"), &one)
+	assert.Nil(t, err)
+
+	mem.StartJanitor(ctx, 100*time.Millisecond)
+	clk.Advance(1100 * time.Millisecond)
+
+	assert.Eventually(t, func() bool {
+		mem.shards[0].mu.RLock()
+		defer mem.shards[0].mu.RUnlock()
+		return len(mem.shards[0].items) == 0
+	}, time.Second, time.Millisecond)
+}
+
+// TestShardedMemoryCache_Inc test Inc and Decr functions
+func TestShardedMemoryCache_Inc(t *testing.T) {
+	mem := NewShardedMemoryCache(4)
+	ctx := context.Background()
+
+	for i := 1; i <= 10; i++ {
+		value, err := mem.Inc(ctx, []byte("inc_key"), &fifteen)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(i), value)
+	}
+
+	val, err := mem.Get(ctx, []byte("inc_key"))
+	assert.Nil(t, err)
+	assert.Equal(t, "10", string(val))
+
+	value, err := mem.Decr(ctx, []byte("inc_key"), &fifteen)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(9), value)
+
+	value, err = mem.Decr(ctx, []byte("decr_key"), &fifteen)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), value)
+
+	err = mem.Set(ctx, []byte("set_key"), []byte("2"), &ten)
+	assert.Nil(t, err)
+	value, err = mem.Inc(ctx, []byte("set_key"), &ten)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), value)
+
+	err = mem.Set(ctx, []byte("padded_key"), []byte("02"), &ten)
+	assert.Nil(t, err)
+	value, err = mem.Inc(ctx, []byte("padded_key"), &ten)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), value)
+
+	err = mem.Set(ctx, []byte("string_key"), []byte("value"), &ten)
+	assert.Nil(t, err)
+	value, err = mem.Inc(ctx, []byte("string_key"), &ten)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "is not integer")
+	assert.Equal(t, int64(0), value)
+}
+
+// TestShardedMemoryCache_Inc_Concurrent test Inc from many goroutines
+func TestShardedMemoryCache_Inc_Concurrent(t *testing.T) {
+	mem := NewShardedMemoryCache(8)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				_, _ = mem.Inc(ctx, []byte("key_"+strconv.Itoa(i%10)), &ten)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < 10; i++ {
+		val, err := mem.Get(ctx, []byte("key_"+strconv.Itoa(i)))
+		assert.Nil(t, err)
+		assert.Equal(t, "1600", string(val))
+	}
+}
+
+// TestShardedMemoryCache_Del test Del and Clear functions
+func TestShardedMemoryCache_Del(t *testing.T) {
+	mem := NewShardedMemoryCache(4)
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		err := mem.Set(ctx, []byte("item_"+strconv.Itoa(i)), []byte(strconv.Itoa(i)), &twenty)
+		assert.Nil(t, err)
+	}
+
+	err := mem.Del(ctx, []byte("item_0"), []byte("item_1"), []byte("not_exists_key"))
+	assert.Nil(t, err)
+	assert.False(t, mem.Has(ctx, []byte("item_0")))
+	assert.False(t, mem.Has(ctx, []byte("item_1")))
+	assert.True(t, mem.Has(ctx, []byte("item_2")))
+
+	err = mem.Clear(ctx)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.False(t, mem.Has(ctx, []byte("item_"+strconv.Itoa(i))))
+	}
+}
+
+// benchKeys are keys of parallel benchmarks, so goroutines hit different shards
+var benchKeys = func() [][]byte {
+	keys := make([][]byte, 1024)
+	for i := range keys {
+		keys[i] = []byte("bench_key_" + strconv.Itoa(i))
+	}
+	return keys
+}()
+
+// benchmarkIncParallel run Inc on many goroutines. Compare scaling with -cpu=1,4,16.
+func benchmarkIncParallel(b *testing.B, inc func(ctx context.Context, key []byte, ttl *uint64) (int64, error)) {
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			_, _ = inc(ctx, benchKeys[i&(len(benchKeys)-1)], &twenty)
+			i++
+		}
+	})
+}
+
+// benchmarkGetParallel run Get on many goroutines. Compare scaling with -cpu=1,4,16.
+func benchmarkGetParallel(b *testing.B, get func(ctx context.Context, key []byte) ([]byte, error)) {
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			_, _ = get(ctx, benchKeys[i&(len(benchKeys)-1)])
+			i++
+		}
+	})
+}
+
+func BenchmarkMemoryCache_IncParallel(b *testing.B) {
+	benchmarkIncParallel(b, NewMemoryCache().Inc)
+}
+
+func BenchmarkShardedMemoryCache_IncParallel(b *testing.B) {
+	benchmarkIncParallel(b, NewShardedMemoryCache(0).Inc)
+}
+
+func BenchmarkMemoryCache_GetParallel(b *testing.B) {
+	mem := NewMemoryCache()
+	for _, key := range benchKeys {
+		_ = mem.Set(context.Background(), key, []byte("1"), &zero)
+	}
+	benchmarkGetParallel(b, mem.Get)
+}
+
+func BenchmarkShardedMemoryCache_GetParallel(b *testing.B) {
+	mem := NewShardedMemoryCache(0)
+	for _, key := range benchKeys {
+		_ = mem.Set(context.Background(), key, []byte("1"), &zero)
+	}
+	benchmarkGetParallel(b, mem.Get)
+}
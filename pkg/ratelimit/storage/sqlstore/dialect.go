@@ -0,0 +1,172 @@
+package sqlstore
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// operations of storage. Every query starts with /* ratelimit:<op> */ comment, so it is visible in database logs.
+const (
+	opCreate     = "create"
+	opGet        = "get"
+	opHas        = "has"
+	opSet        = "set"
+	opSetNX      = "set_nx"
+	opInc        = "inc"
+	opIncSelect  = "inc_select"
+	opDel        = "del"
+	opClear      = "clear"
+	opPurge      = "purge"
+	opCASCounter = "cas_counter"
+	opCASValue   = "cas_value"
+	opScan       = "scan"
+	opScanRange  = "scan_range"
+	opDelPrefix  = "del_prefix"
+	opDelRange   = "del_range"
+	opCount      = "count"
+)
+
+// live is condition of not expired row
+const live = "(expire_at = 0 OR expire_at > :now)"
+
+// expired is condition of expired row
+const expired = "(expire_at <> 0 AND expire_at <= :now)"
+
+// Dialect of SQL database
+type Dialect struct {
+	name string
+	// numbered placeholders can be referenced many times, e.g. $1
+	numbered bool
+	// returning is support of RETURNING in upsert
+	returning bool
+	queries   map[string]string
+}
+
+// common queries of all dialects
+var common = map[string]string{
+	opGet:        "SELECT counter, value, expire_at FROM {t} WHERE key_name = :key AND " + live,
+	opHas:        "SELECT 1 FROM {t} WHERE key_name = :key AND " + live,
+	opDel:        "DELETE FROM {t} WHERE key_name = :key",
+	opClear:      "DELETE FROM {t}",
+	opPurge:      "DELETE FROM {t} WHERE " + expired,
+	opIncSelect:  "SELECT counter, value, expire_at FROM {t} WHERE key_name = :key",
+	opDelPrefix:  "DELETE FROM {t} WHERE key_name >= :prefix",
+	opDelRange:   "DELETE FROM {t} WHERE key_name >= :prefix AND key_name < :end",
+	opScan:       "SELECT key_name, counter, value, expire_at FROM {t} WHERE key_name > :after AND key_name >= :prefix AND " + live + " ORDER BY key_name LIMIT :limit",
+	opCount:      "SELECT COUNT(*) FROM {t} WHERE " + live,
+	opScanRange:  "SELECT key_name, counter, value, expire_at FROM {t} WHERE key_name > :after AND key_name >= :prefix AND key_name < :end AND " + live + " ORDER BY key_name LIMIT :limit",
+	opCASCounter: "UPDATE {t} SET counter = :counter, value = :value, expire_at = CASE WHEN expire_at = 0 THEN :expire ELSE expire_at END WHERE key_name = :key AND " + live + " AND value IS NULL AND counter = :old_counter",
+	opCASValue:   "UPDATE {t} SET counter = :counter, value = :value, expire_at = CASE WHEN expire_at = 0 THEN :expire ELSE expire_at END WHERE key_name = :key AND " + live + " AND value = :old_value",
+}
+
+// Postgres is dialect of PostgreSQL
+var Postgres = newDialect("postgres", true, true, map[string]string{
+	opCreate: "CREATE TABLE IF NOT EXISTS {t} (" +
+		"key_name BYTEA PRIMARY KEY, " +
+		"counter BIGINT NOT NULL DEFAULT 0, " +
+		"value BYTEA NULL, " +
+		"expire_at BIGINT NOT NULL DEFAULT 0); " +
+		"CREATE INDEX IF NOT EXISTS {t}_expire_at ON {t} (expire_at)",
+	opSet: "INSERT INTO {t} AS c (key_name, counter, value, expire_at) VALUES (:key, :counter, :value, :expire) " +
+		"ON CONFLICT (key_name) DO UPDATE SET counter = EXCLUDED.counter, value = EXCLUDED.value, " +
+		"expire_at = CASE WHEN c.expire_at <> 0 AND c.expire_at > :now THEN c.expire_at ELSE EXCLUDED.expire_at END",
+	opSetNX: "INSERT INTO {t} AS c (key_name, counter, value, expire_at) VALUES (:key, :counter, :value, :expire) " +
+		"ON CONFLICT (key_name) DO UPDATE SET counter = EXCLUDED.counter, value = EXCLUDED.value, expire_at = EXCLUDED.expire_at " +
+		"WHERE c.expire_at <> 0 AND c.expire_at <= :now",
+	opInc: "INSERT INTO {t} AS c (key_name, counter, value, expire_at) VALUES (:key, :delta, NULL, :expire) " +
+		"ON CONFLICT (key_name) DO UPDATE SET " +
+		"counter = CASE WHEN c.expire_at <> 0 AND c.expire_at <= :now THEN EXCLUDED.counter ELSE c.counter + EXCLUDED.counter END, " +
+		"expire_at = CASE WHEN c.expire_at = 0 OR c.expire_at <= :now THEN EXCLUDED.expire_at ELSE c.expire_at END, " +
+		"value = NULL " +
+		"WHERE c.value IS NULL OR (c.expire_at <> 0 AND c.expire_at <= :now) " +
+		"RETURNING counter, expire_at",
+})
+
+// MySQL is dialect of MySQL and MariaDB. Columns of ON DUPLICATE KEY UPDATE are assigned in order,
+// so every expression reads old values of columns assigned after it.
+var MySQL = newDialect("mysql", false, false, map[string]string{
+	opCreate: "CREATE TABLE IF NOT EXISTS {t} (" +
+		"key_name VARBINARY(255) PRIMARY KEY, " +
+		"counter BIGINT NOT NULL DEFAULT 0, " +
+		"value BLOB NULL, " +
+		"expire_at BIGINT NOT NULL DEFAULT 0, " +
+		"INDEX {t}_expire_at (expire_at))",
+	opSet: "INSERT INTO {t} (key_name, counter, value, expire_at) VALUES (:key, :counter, :value, :expire) " +
+		"ON DUPLICATE KEY UPDATE " +
+		"expire_at = CASE WHEN expire_at <> 0 AND expire_at > :now THEN expire_at ELSE VALUES(expire_at) END, " +
+		"counter = VALUES(counter), value = VALUES(value)",
+	opSetNX: "INSERT INTO {t} (key_name, counter, value, expire_at) VALUES (:key, :counter, :value, :expire) " +
+		"ON DUPLICATE KEY UPDATE " +
+		"counter = CASE WHEN " + expired + " THEN VALUES(counter) ELSE counter END, " +
+		"value = CASE WHEN " + expired + " THEN VALUES(value) ELSE value END, " +
+		"expire_at = CASE WHEN " + expired + " THEN VALUES(expire_at) ELSE expire_at END",
+	opInc: "INSERT INTO {t} (key_name, counter, value, expire_at) VALUES (:key, :delta, NULL, :expire) " +
+		"ON DUPLICATE KEY UPDATE " +
+		"counter = CASE WHEN " + expired + " THEN VALUES(counter) WHEN value IS NULL THEN counter + VALUES(counter) ELSE counter END, " +
+		"value = CASE WHEN " + expired + " THEN NULL ELSE value END, " +
+		"expire_at = CASE WHEN expire_at = 0 OR expire_at <= :now THEN VALUES(expire_at) ELSE expire_at END",
+})
+
+// newDialect add common queries to queries of dialect
+func newDialect(name string, numbered, returning bool, queries map[string]string) Dialect {
+	d := Dialect{name: name, numbered: numbered, returning: returning, queries: make(map[string]string)}
+	for op, query := range common {
+		d.queries[op] = query
+	}
+	for op, query := range queries {
+		d.queries[op] = query
+	}
+
+	return d
+}
+
+// String name of dialect
+func (d Dialect) String() string {
+	return d.name
+}
+
+// query is compiled query with names of its positional arguments
+type query struct {
+	sql   string
+	names []string
+}
+
+// paramRe is named parameter of query template
+var paramRe = regexp.MustCompile(`:[a-z_]+`)
+
+// compile query of operation for table. Named parameters are replaced by placeholders of dialect.
+func (d Dialect) compile(op, table string) query {
+	tmpl := strings.ReplaceAll(d.queries[op], "{t}", table)
+
+	var names []string
+	index := make(map[string]int)
+	sql := paramRe.ReplaceAllStringFunc(tmpl, func(param string) string {
+		name := param[1:]
+		if !d.numbered {
+			names = append(names, name)
+			return "?"
+		}
+
+		n, ok := index[name]
+		if !ok {
+			names = append(names, name)
+			n = len(names)
+			index[name] = n
+		}
+
+		return "$" + strconv.Itoa(n)
+	})
+
+	return query{sql: "/* ratelimit:" + op + " */ " + sql, names: names}
+}
+
+// args of query by names
+func (q query) args(params map[string]any) []any {
+	args := make([]any, len(q.names))
+	for i, name := range q.names {
+		args[i] = params[name]
+	}
+
+	return args
+}
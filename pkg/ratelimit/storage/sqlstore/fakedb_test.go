@@ -0,0 +1,338 @@
+package sqlstore
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// fakeRow is row of fake table
+type fakeRow struct {
+	counter  int64
+	value    []byte
+	expireAt int64
+}
+
+// fakeDB is in-memory table that executes queries of dialect by their operation tag.
+// Statements are serialized, a transaction holds the table until commit or rollback.
+type fakeDB struct {
+	mu      sync.Mutex
+	queries map[string]query
+	ops     map[string]string
+	rows    map[string]fakeRow
+	// executed operations
+	log []string
+}
+
+// newFakeDB open sql.DB over fake table for queries of dialect
+func newFakeDB(dialect Dialect, table string) (*sql.DB, *fakeDB) {
+	f := &fakeDB{
+		queries: make(map[string]query),
+		ops:     make(map[string]string),
+		rows:    make(map[string]fakeRow),
+	}
+	for op := range dialect.queries {
+		q := dialect.compile(op, table)
+		f.queries[q.sql] = q
+		f.ops[q.sql] = op
+	}
+
+	return sql.OpenDB(fakeConnector{db: f}), f
+}
+
+// executed return operations executed since the last call
+func (f *fakeDB) executed() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ops := f.log
+	f.log = nil
+	return ops
+}
+
+type fakeConnector struct {
+	db *fakeDB
+}
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{db: c.db}, nil
+}
+
+func (c fakeConnector) Driver() driver.Driver {
+	return fakeDriver{}
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, fmt.Errorf("use connector")
+}
+
+type fakeConn struct {
+	db *fakeDB
+	tx *fakeTx
+}
+
+func (c *fakeConn) Prepare(sqlText string) (driver.Stmt, error) {
+	op, ok := c.db.ops[sqlText]
+	if !ok {
+		return nil, fmt.Errorf("unknown query: %s", sqlText)
+	}
+
+	return &fakeStmt{conn: c, op: op, q: c.db.queries[sqlText]}, nil
+}
+
+func (c *fakeConn) Close() error {
+	if c.tx != nil {
+		return c.tx.Rollback()
+	}
+
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.db.mu.Lock()
+	snapshot := make(map[string]fakeRow, len(c.db.rows))
+	for k, r := range c.db.rows {
+		snapshot[k] = r
+	}
+	c.tx = &fakeTx{conn: c, snapshot: snapshot}
+
+	return c.tx, nil
+}
+
+type fakeTx struct {
+	conn     *fakeConn
+	snapshot map[string]fakeRow
+}
+
+func (t *fakeTx) Commit() error {
+	t.conn.tx = nil
+	t.conn.db.mu.Unlock()
+	return nil
+}
+
+func (t *fakeTx) Rollback() error {
+	t.conn.db.rows = t.snapshot
+	t.conn.tx = nil
+	t.conn.db.mu.Unlock()
+	return nil
+}
+
+type fakeStmt struct {
+	conn *fakeConn
+	op   string
+	q    query
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	_, affected, err := s.run(args)
+	return driver.RowsAffected(affected), err
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows, _, err := s.run(args)
+	return rows, err
+}
+
+// run operation of statement with positional args
+func (s *fakeStmt) run(args []driver.Value) (*fakeRows, int64, error) {
+	if len(args) != len(s.q.names) {
+		return nil, 0, fmt.Errorf("%s: %d args, %d expected", s.op, len(args), len(s.q.names))
+	}
+	p := make(map[string]driver.Value, len(args))
+	for i, name := range s.q.names {
+		p[name] = args[i]
+	}
+
+	db := s.conn.db
+	if s.conn.tx == nil {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+	}
+	db.log = append(db.log, s.op)
+
+	return db.run(s.op, p)
+}
+
+// run operation with named params on table
+func (f *fakeDB) run(op string, p map[string]driver.Value) (*fakeRows, int64, error) {
+	now, _ := p["now"].(int64)
+	live := func(r fakeRow) bool {
+		return r.expireAt == 0 || r.expireAt > now
+	}
+	k, _ := p["key"].([]byte)
+	key := string(k)
+	row, exists := f.rows[key]
+	newRow := func(counter string) fakeRow {
+		value, _ := p["value"].([]byte)
+		return fakeRow{counter: p[counter].(int64), value: value, expireAt: p["expire"].(int64)}
+	}
+
+	switch op {
+	case opCreate:
+		return nil, 0, nil
+	case opHas:
+		rows := &fakeRows{cols: []string{"one"}}
+		if exists && live(row) {
+			rows.data = [][]driver.Value{{int64(1)}}
+		}
+		return rows, 0, nil
+	case opGet:
+		if !exists || !live(row) {
+			return &fakeRows{cols: []string{"counter", "value", "expire_at"}}, 0, nil
+		}
+		return &fakeRows{
+			cols: []string{"counter", "value", "expire_at"},
+			data: [][]driver.Value{{row.counter, row.value, row.expireAt}},
+		}, 0, nil
+	case opIncSelect:
+		if !exists {
+			return &fakeRows{cols: []string{"counter", "value", "expire_at"}}, 0, nil
+		}
+		return &fakeRows{
+			cols: []string{"counter", "value", "expire_at"},
+			data: [][]driver.Value{{row.counter, row.value, row.expireAt}},
+		}, 0, nil
+	case opDel:
+		delete(f.rows, key)
+		if exists {
+			return nil, 1, nil
+		}
+		return nil, 0, nil
+	case opClear, opPurge, opDelPrefix, opDelRange:
+		var n int64
+		for k, r := range f.rows {
+			if f.match(op, k, p) && (op != opPurge || !live(r)) {
+				delete(f.rows, k)
+				n++
+			}
+		}
+		return nil, n, nil
+	case opScan, opScanRange:
+		keys := make([]string, 0)
+		for k, r := range f.rows {
+			if f.match(op, k, p) && k > string(p["after"].([]byte)) && live(r) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		if limit := int(p["limit"].(int64)); len(keys) > limit {
+			keys = keys[:limit]
+		}
+		rows := &fakeRows{cols: []string{"key_name", "counter", "value", "expire_at"}}
+		for _, k := range keys {
+			r := f.rows[k]
+			rows.data = append(rows.data, []driver.Value{[]byte(k), r.counter, r.value, r.expireAt})
+		}
+		return rows, 0, nil
+	case opCount:
+		var n int64
+		for _, r := range f.rows {
+			if live(r) {
+				n++
+			}
+		}
+		return &fakeRows{cols: []string{"count"}, data: [][]driver.Value{{n}}}, 0, nil
+	case opCASCounter, opCASValue:
+		if !exists || !live(row) {
+			return nil, 0, nil
+		}
+		if op == opCASCounter && (row.value != nil || row.counter != p["old_counter"].(int64)) {
+			return nil, 0, nil
+		}
+		if op == opCASValue && (row.value == nil || !bytes.Equal(row.value, p["old_value"].([]byte))) {
+			return nil, 0, nil
+		}
+		r := newRow("counter")
+		if row.expireAt != 0 {
+			r.expireAt = row.expireAt
+		}
+		f.rows[key] = r
+		return nil, 1, nil
+	case opSet:
+		r := newRow("counter")
+		if exists && row.expireAt != 0 && row.expireAt > now {
+			r.expireAt = row.expireAt
+		}
+		f.rows[key] = r
+		return nil, 1, nil
+	case opSetNX:
+		if exists && live(row) {
+			return nil, 0, nil
+		}
+		f.rows[key] = newRow("counter")
+		return nil, 1, nil
+	case opInc:
+		cols := []string{"counter", "expire_at"}
+		switch {
+		case !exists || !live(row):
+			row = newRow("delta")
+			row.value = nil
+		case row.value != nil:
+			// Postgres skips the update, MySQL keeps the row
+			return &fakeRows{cols: cols}, 0, nil
+		default:
+			row.counter += p["delta"].(int64)
+			if row.expireAt == 0 {
+				row.expireAt = p["expire"].(int64)
+			}
+		}
+		f.rows[key] = row
+		return &fakeRows{cols: cols, data: [][]driver.Value{{row.counter, row.expireAt}}}, 1, nil
+	}
+
+	return nil, 0, fmt.Errorf("unsupported operation %s", op)
+}
+
+// match key by prefix and end parameters of operation
+func (f *fakeDB) match(op string, key string, p map[string]driver.Value) bool {
+	if op == opClear || op == opPurge {
+		return true
+	}
+	if prefix, _ := p["prefix"].([]byte); key < string(prefix) {
+		return false
+	}
+	if end, ok := p["end"].([]byte); ok && key >= string(end) {
+		return false
+	}
+
+	return true
+}
+
+type fakeRows struct {
+	cols []string
+	data [][]driver.Value
+	pos  int
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.cols
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.data) {
+		return io.EOF
+	}
+	copy(dest, r.data[r.pos])
+	r.pos++
+
+	return nil
+}
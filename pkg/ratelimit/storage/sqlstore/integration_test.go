@@ -0,0 +1,111 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage/storagetest"
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Integration tests run queries of dialects on real databases. A test of dialect is skipped
+// unless DSN of its database is set in environment, see make test-sql.
+
+// integrationDB is database of dialect given by environment
+type integrationDB struct {
+	dialect Dialect
+	driver  string
+	env     string
+}
+
+var integrationDBs = []integrationDB{
+	{dialect: Postgres, driver: "postgres", env: "RATELIMIT_TEST_POSTGRES_DSN"},
+	{dialect: MySQL, driver: "mysql", env: "RATELIMIT_TEST_MYSQL_DSN"},
+}
+
+// tableSeq make names of test tables unique
+var tableSeq atomic.Int64
+
+// openIntegrationDB open database by DSN of environment, the test is skipped without it
+func openIntegrationDB(t *testing.T, idb integrationDB) *sql.DB {
+	dsn := os.Getenv(idb.env)
+	if dsn == "" {
+		t.Skipf("%s is not set", idb.env)
+	}
+
+	db, err := sql.Open(idb.driver, dsn)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		t.Fatalf("ping %s: %v", idb.driver, err)
+	}
+
+	return db
+}
+
+// newIntegrationStore create store in new table that is dropped after the test
+func newIntegrationStore(t *testing.T, db *sql.DB, dialect Dialect, clk clock.Clock) *Store {
+	table := fmt.Sprintf("ratelimit_test_%d_%d", os.Getpid(), tableSeq.Add(1))
+	s := New(db, dialect, WithTable(table), WithClock(clk))
+	if err := s.CreateTable(context.Background()); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	t.Cleanup(func() {
+		_, _ = db.Exec("DROP TABLE IF EXISTS " + table)
+	})
+
+	return s
+}
+
+// TestIntegration_Conformance test queries of dialects on real databases by storage conformance suite
+func TestIntegration_Conformance(t *testing.T) {
+	for _, idb := range integrationDBs {
+		idb := idb
+		t.Run(idb.dialect.String(), func(t *testing.T) {
+			db := openIntegrationDB(t, idb)
+			storagetest.Run(t, func(t *testing.T, clk clock.Clock) ratelimit.Storager {
+				return newIntegrationStore(t, db, idb.dialect, clk)
+			})
+		})
+	}
+}
+
+// TestIntegration_Purge test CreateTable is idempotent and Purge deletes only expired rows on real databases
+func TestIntegration_Purge(t *testing.T) {
+	ctx := context.Background()
+	for _, idb := range integrationDBs {
+		idb := idb
+		t.Run(idb.dialect.String(), func(t *testing.T) {
+			db := openIntegrationDB(t, idb)
+			clk := clock.NewManual(time.Unix(1700000000, 0))
+			s := newIntegrationStore(t, db, idb.dialect, clk)
+			assert.Nil(t, s.CreateTable(ctx))
+
+			_, err := s.Inc(ctx, []byte("short"), &ten)
+			assert.Nil(t, err)
+			_, err = s.Inc(ctx, []byte("forever"), nil)
+			assert.Nil(t, err)
+			clk.Advance(10 * time.Second)
+
+			n, err := s.Purge(ctx)
+			assert.Nil(t, err)
+			assert.Equal(t, int64(1), n)
+			keys, err := s.Keys(ctx, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, [][]byte{[]byte("forever")}, keys)
+		})
+	}
+}
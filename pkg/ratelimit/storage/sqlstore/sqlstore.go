@@ -0,0 +1,456 @@
+// Package sqlstore is rate limit storage in relational database over database/sql.
+//
+// Keys are rows of one table: integer values are kept in counter column and incremented
+// atomically by upsert, other values are kept in value column. expire_at is unix time in
+// milliseconds, 0 - without expiration. Expired rows are not visible and are deleted by purge job.
+// Counter contract is the same as of storage.MemoryCache with KeepTTL mode.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"log"
+	"strconv"
+	"time"
+)
+
+// DefaultTable is name of table of keys
+const DefaultTable = "ratelimit_keys"
+
+// Store is ratelimit.Storager in SQL database
+type Store struct {
+	db      *sql.DB
+	dialect Dialect
+	table   string
+	queries map[string]query
+	clock   clock.Clock
+}
+
+// Option configure Store
+type Option func(s *Store)
+
+// WithTable set name of table. The name is put into queries as is, it must be trusted.
+func WithTable(table string) Option {
+	return func(s *Store) {
+		s.table = table
+	}
+}
+
+// WithClock set clock of expirations and purge job. clock.Real by default.
+func WithClock(clk clock.Clock) Option {
+	return func(s *Store) {
+		s.clock = clock.OrReal(clk)
+	}
+}
+
+// New create storage in db of dialect
+func New(db *sql.DB, dialect Dialect, opts ...Option) *Store {
+	s := &Store{
+		db:      db,
+		dialect: dialect,
+		table:   DefaultTable,
+		queries: make(map[string]query),
+		clock:   clock.Real,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	for op := range dialect.queries {
+		s.queries[op] = dialect.compile(op, s.table)
+	}
+
+	return s
+}
+
+// CreateTable create table of keys if it does not exist
+func (s *Store) CreateTable(ctx context.Context) error {
+	_, err := s.exec(ctx, s.db, opCreate, nil)
+	return err
+}
+
+// Start delete expired rows every interval until ctx is done
+func (s *Store) Start(ctx context.Context, interval time.Duration) {
+	ticker := s.clock.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C():
+				if _, err := s.Purge(ctx); err != nil && ctx.Err() == nil {
+					log.Printf("sql storage purge error: %s", err.Error())
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Purge delete expired rows and return count of them
+func (s *Store) Purge(ctx context.Context) (int64, error) {
+	res, err := s.exec(ctx, s.db, opPurge, map[string]any{"now": s.now()})
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// Get value by key
+func (s *Store) Get(ctx context.Context, key []byte) ([]byte, error) {
+	val, _, err := s.get(ctx, key)
+	return val, err
+}
+
+// Set value by key. Expiration of the existing key is kept.
+func (s *Store) Set(ctx context.Context, key []byte, value []byte, ttl *uint64) error {
+	if len(key) == 0 {
+		return fmt.Errorf("key is empty")
+	}
+
+	_, err := s.exec(ctx, s.db, opSet, s.valueParams(key, value, ttl))
+	return err
+}
+
+// Del values by keys
+func (s *Store) Del(ctx context.Context, list ...[]byte) error {
+	for _, key := range list {
+		if _, err := s.exec(ctx, s.db, opDel, map[string]any{"key": key}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Has check is set value by key
+func (s *Store) Has(ctx context.Context, key []byte) bool {
+	var one int
+	err := s.queryRow(ctx, s.db, opHas, map[string]any{"key": key, "now": s.now()}).Scan(&one)
+	return err == nil
+}
+
+// Inc value by key
+func (s *Store) Inc(ctx context.Context, key []byte, ttl *uint64) (int64, error) {
+	val, _, err := s.IncrBy(ctx, key, 1, ttl)
+	return val, err
+}
+
+// Decr value by key
+func (s *Store) Decr(ctx context.Context, key []byte, ttl *uint64) (int64, error) {
+	val, _, err := s.IncrBy(ctx, key, -1, ttl)
+	return val, err
+}
+
+// Clear all
+func (s *Store) Clear(ctx context.Context) error {
+	_, err := s.exec(ctx, s.db, opClear, nil)
+	return err
+}
+
+// TTL return remaining time to live of key. storage.NoTTL if the key has no expiration.
+func (s *Store) TTL(ctx context.Context, key []byte) (time.Duration, error) {
+	_, expireAt, err := s.get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.remaining(expireAt), nil
+}
+
+// IncrBy add n to value by key by one upsert and return new value with remaining ttl
+func (s *Store) IncrBy(ctx context.Context, key []byte, n int64, ttl *uint64) (int64, time.Duration, error) {
+	params := map[string]any{"key": key, "delta": n, "expire": s.expireAt(ttl), "now": s.now()}
+
+	if s.dialect.returning {
+		var counter, expireAt int64
+		err := s.queryRow(ctx, s.db, opInc, params).Scan(&counter, &expireAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, 0, fmt.Errorf("value by key %s is not integer", key)
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+
+		return counter, s.remaining(expireAt), nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err = s.exec(ctx, tx, opInc, params); err != nil {
+		return 0, 0, err
+	}
+
+	var counter, expireAt int64
+	var value []byte
+	if err = s.queryRow(ctx, tx, opIncSelect, params).Scan(&counter, &value, &expireAt); err != nil {
+		return 0, 0, err
+	}
+	if value != nil {
+		return 0, 0, fmt.Errorf("value by key %s is not integer", key)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+
+	return counter, s.remaining(expireAt), nil
+}
+
+// CompareAndSwap set value by key to newValue only if current value is equal to old.
+// Not existing key is never swapped. Expiration of the key is kept, ttl is used if the key has none.
+func (s *Store) CompareAndSwap(ctx context.Context, key []byte, old, newValue []byte, ttl *uint64) (bool, error) {
+	if string(old) == string(newValue) {
+		val, err := s.Get(ctx, key)
+		if errors.Is(err, storage.ValueNotFoundByKey) {
+			return false, nil
+		}
+
+		return err == nil && string(val) == string(old), err
+	}
+
+	params := s.valueParams(key, newValue, ttl)
+	op := opCASValue
+	if oldCounter, ok := parseCounter(old); ok {
+		op = opCASCounter
+		params["old_counter"] = oldCounter
+	} else {
+		params["old_value"] = old
+	}
+
+	res, err := s.exec(ctx, s.db, op, params)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SetNX set value by key only if the key does not exist
+func (s *Store) SetNX(ctx context.Context, key []byte, value []byte, ttl *uint64) (bool, error) {
+	if len(key) == 0 {
+		return false, fmt.Errorf("key is empty")
+	}
+
+	res, err := s.exec(ctx, s.db, opSetNX, s.valueParams(key, value, ttl))
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Keys return sorted keys with prefix
+func (s *Store) Keys(ctx context.Context, prefix []byte) ([][]byte, error) {
+	keys := make([][]byte, 0)
+	cursor := ""
+	for {
+		infos, next, err := s.Scan(ctx, prefix, cursor, storage.DefaultScanCount)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			keys = append(keys, info.Key)
+		}
+		if next == "" {
+			return keys, nil
+		}
+		cursor = next
+	}
+}
+
+// Len return count of not expired keys
+func (s *Store) Len(ctx context.Context) (int, error) {
+	var n int
+	err := s.queryRow(ctx, s.db, opCount, map[string]any{"now": s.now()}).Scan(&n)
+	return n, err
+}
+
+// DelPrefix delete all keys with prefix
+func (s *Store) DelPrefix(ctx context.Context, prefix []byte) error {
+	params := map[string]any{"prefix": append([]byte{}, prefix...)}
+	op := opDelPrefix
+	if end, ok := prefixEnd(prefix); ok {
+		op = opDelRange
+		params["end"] = end
+	}
+
+	_, err := s.exec(ctx, s.db, op, params)
+	return err
+}
+
+// Scan return up to count keys with prefix in key order after cursor, and cursor of the next page.
+// Cursor is the last returned key, so it stays valid when keys are changed.
+func (s *Store) Scan(ctx context.Context, prefix []byte, cursor string, count int) ([]storage.KeyInfo, string, error) {
+	after, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if count <= 0 {
+		count = storage.DefaultScanCount
+	}
+
+	params := map[string]any{"after": after, "prefix": append([]byte{}, prefix...), "now": s.now(), "limit": count + 1}
+	op := opScan
+	if end, ok := prefixEnd(prefix); ok {
+		op = opScanRange
+		params["end"] = end
+	}
+
+	rows, err := s.query(ctx, s.db, op, params)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	res := make([]storage.KeyInfo, 0, count)
+	next := ""
+	for rows.Next() {
+		if len(res) == count {
+			next = encodeCursor(res[count-1].Key)
+			break
+		}
+
+		var key, value []byte
+		var counter, expireAt int64
+		if err = rows.Scan(&key, &counter, &value, &expireAt); err != nil {
+			return nil, "", err
+		}
+		if value == nil {
+			value = []byte(strconv.FormatInt(counter, 10))
+		}
+		res = append(res, storage.KeyInfo{Key: key, Value: value, TTL: s.remaining(expireAt)})
+	}
+
+	return res, next, rows.Err()
+}
+
+// get value and expiration by key
+func (s *Store) get(ctx context.Context, key []byte) ([]byte, int64, error) {
+	var counter, expireAt int64
+	var value []byte
+	err := s.queryRow(ctx, s.db, opGet, map[string]any{"key": key, "now": s.now()}).Scan(&counter, &value, &expireAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, storage.ValueNotFoundByKey
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if value == nil {
+		value = []byte(strconv.FormatInt(counter, 10))
+	}
+
+	return value, expireAt, nil
+}
+
+// valueParams are parameters of Set queries. Canonical integers are stored as counters.
+func (s *Store) valueParams(key []byte, value []byte, ttl *uint64) map[string]any {
+	params := map[string]any{"key": key, "expire": s.expireAt(ttl), "now": s.now(), "counter": int64(0), "value": nil}
+	if counter, ok := parseCounter(value); ok {
+		params["counter"] = counter
+	} else {
+		params["value"] = append([]byte{}, value...)
+	}
+
+	return params
+}
+
+// execer is *sql.DB or *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (s *Store) exec(ctx context.Context, db execer, op string, params map[string]any) (sql.Result, error) {
+	q := s.queries[op]
+	return db.ExecContext(ctx, q.sql, q.args(params)...)
+}
+
+func (s *Store) query(ctx context.Context, db execer, op string, params map[string]any) (*sql.Rows, error) {
+	q := s.queries[op]
+	return db.QueryContext(ctx, q.sql, q.args(params)...)
+}
+
+func (s *Store) queryRow(ctx context.Context, db execer, op string, params map[string]any) *sql.Row {
+	q := s.queries[op]
+	return db.QueryRowContext(ctx, q.sql, q.args(params)...)
+}
+
+// now is current unix time in milliseconds
+func (s *Store) now() int64 {
+	return s.clock.Now().UnixMilli()
+}
+
+// expireAt of new key with ttl in seconds, 0 - without expiration
+func (s *Store) expireAt(ttl *uint64) int64 {
+	if ttl == nil || *ttl == 0 {
+		return 0
+	}
+
+	return s.now() + int64(*ttl)*1000
+}
+
+// remaining time to live of expireAt
+func (s *Store) remaining(expireAt int64) time.Duration {
+	if expireAt == 0 {
+		return storage.NoTTL
+	}
+	if d := time.Duration(expireAt-s.now()) * time.Millisecond; d > 0 {
+		return d
+	}
+
+	return 0
+}
+
+// parseCounter parse canonical integer value
+func parseCounter(value []byte) (int64, bool) {
+	counter, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil || strconv.FormatInt(counter, 10) != string(value) {
+		return 0, false
+	}
+
+	return counter, true
+}
+
+// prefixEnd return the smallest key greater than all keys with prefix. False if there is no such key.
+func prefixEnd(prefix []byte) ([]byte, bool) {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1], true
+		}
+	}
+
+	return nil, false
+}
+
+// encodeCursor make opaque cursor of the last returned key
+func encodeCursor(key []byte) string {
+	return base64.RawURLEncoding.EncodeToString(key)
+}
+
+// decodeCursor return the last returned key of cursor
+func decodeCursor(cursor string) ([]byte, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+
+	return append([]byte{}, key...), nil
+}
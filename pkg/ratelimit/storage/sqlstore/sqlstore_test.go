@@ -0,0 +1,319 @@
+package sqlstore
+
+import (
+	"context"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+var ten = uint64(10)
+
+// dialects are tested dialects
+var dialects = []Dialect{Postgres, MySQL}
+
+// newTestStore create store over fake database with manual clock
+func newTestStore(t *testing.T, dialect Dialect) (*Store, *fakeDB, *clock.Manual) {
+	db, fake := newFakeDB(dialect, DefaultTable)
+	t.Cleanup(func() { _ = db.Close() })
+
+	clk := clock.NewManual(time.Unix(1700000000, 0))
+	s := New(db, dialect, WithClock(clk))
+	assert.Nil(t, s.CreateTable(context.Background()))
+
+	return s, fake, clk
+}
+
+// TestDialect_compile test compile function
+func TestDialect_compile(t *testing.T) {
+	q := Postgres.compile(opGet, "keys")
+	assert.Equal(t, "/* ratelimit:get */ SELECT counter, value, expire_at FROM keys WHERE key_name = $1 AND (expire_at = 0 OR expire_at > $2)", q.sql)
+	assert.Equal(t, []string{"key", "now"}, q.names)
+	assert.Equal(t, []any{[]byte("k"), int64(5)}, q.args(map[string]any{"now": int64(5), "key": []byte("k")}))
+
+	q = MySQL.compile(opPurge, "keys")
+	assert.Equal(t, "/* ratelimit:purge */ DELETE FROM keys WHERE (expire_at <> 0 AND expire_at <= ?)", q.sql)
+	assert.Equal(t, []string{"now"}, q.names)
+
+	for _, d := range dialects {
+		for op := range d.queries {
+			q := d.compile(op, DefaultTable)
+			assert.NotContains(t, strings.TrimPrefix(q.sql, "/* ratelimit:"+op+" */"), ":", d.String()+" "+op)
+		}
+	}
+}
+
+// TestStore_Inc test Inc, Decr and TTL functions
+func TestStore_Inc(t *testing.T) {
+	ctx := context.Background()
+	for _, d := range dialects {
+		t.Run(d.String(), func(t *testing.T) {
+			s, _, clk := newTestStore(t, d)
+			key := []byte("key")
+
+			val, err := s.Inc(ctx, key, &ten)
+			assert.Nil(t, err)
+			assert.Equal(t, int64(1), val)
+
+			clk.Advance(4 * time.Second)
+			val, left, err := s.IncrBy(ctx, key, 5, &ten)
+			assert.Nil(t, err)
+			assert.Equal(t, int64(6), val)
+			assert.Equal(t, 6*time.Second, left)
+
+			val, err = s.Decr(ctx, key, &ten)
+			assert.Nil(t, err)
+			assert.Equal(t, int64(5), val)
+
+			got, err := s.Get(ctx, key)
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("5"), got)
+			left, err = s.TTL(ctx, key)
+			assert.Nil(t, err)
+			assert.Equal(t, 6*time.Second, left)
+
+			clk.Advance(6 * time.Second)
+			assert.False(t, s.Has(ctx, key))
+			_, err = s.Get(ctx, key)
+			assert.Equal(t, storage.ValueNotFoundByKey, err)
+
+			val, err = s.Inc(ctx, key, &ten)
+			assert.Nil(t, err)
+			assert.Equal(t, int64(1), val)
+			left, err = s.TTL(ctx, key)
+			assert.Nil(t, err)
+			assert.Equal(t, 10*time.Second, left)
+
+			val, err = s.Inc(ctx, []byte("forever"), nil)
+			assert.Nil(t, err)
+			assert.Equal(t, int64(1), val)
+			left, err = s.TTL(ctx, []byte("forever"))
+			assert.Nil(t, err)
+			assert.Equal(t, storage.NoTTL, left)
+
+			assert.Nil(t, s.Set(ctx, []byte("text"), []byte("value"), &ten))
+			_, err = s.Inc(ctx, []byte("text"), &ten)
+			assert.NotNil(t, err)
+			got, err = s.Get(ctx, []byte("text"))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("value"), got)
+		})
+	}
+}
+
+// TestStore_Set test Set, Del, Has and Clear functions
+func TestStore_Set(t *testing.T) {
+	ctx := context.Background()
+	for _, d := range dialects {
+		t.Run(d.String(), func(t *testing.T) {
+			s, _, clk := newTestStore(t, d)
+
+			assert.NotNil(t, s.Set(ctx, nil, []byte("value"), nil))
+			assert.Nil(t, s.Set(ctx, []byte("a"), []byte("10"), &ten))
+			clk.Advance(3 * time.Second)
+			assert.Nil(t, s.Set(ctx, []byte("a"), []byte("20"), &ten))
+			left, err := s.TTL(ctx, []byte("a"))
+			assert.Nil(t, err)
+			assert.Equal(t, 7*time.Second, left)
+
+			val, err := s.Inc(ctx, []byte("a"), &ten)
+			assert.Nil(t, err)
+			assert.Equal(t, int64(21), val)
+
+			assert.Nil(t, s.Set(ctx, []byte("b"), []byte("007"), nil))
+			got, err := s.Get(ctx, []byte("b"))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("007"), got)
+			assert.Nil(t, s.Set(ctx, []byte("c"), []byte{}, nil))
+			got, err = s.Get(ctx, []byte("c"))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte{}, got)
+
+			assert.True(t, s.Has(ctx, []byte("b")))
+			assert.Nil(t, s.Del(ctx, []byte("b"), []byte("missing")))
+			assert.False(t, s.Has(ctx, []byte("b")))
+
+			assert.Nil(t, s.Clear(ctx))
+			assert.False(t, s.Has(ctx, []byte("a")))
+		})
+	}
+}
+
+// TestStore_SetNX test SetNX and CompareAndSwap functions
+func TestStore_SetNX(t *testing.T) {
+	ctx := context.Background()
+	for _, d := range dialects {
+		t.Run(d.String(), func(t *testing.T) {
+			s, _, clk := newTestStore(t, d)
+			key := []byte("key")
+
+			ok, err := s.SetNX(ctx, key, []byte("1"), &ten)
+			assert.Nil(t, err)
+			assert.True(t, ok)
+			ok, err = s.SetNX(ctx, key, []byte("2"), &ten)
+			assert.Nil(t, err)
+			assert.False(t, ok)
+
+			ok, err = s.CompareAndSwap(ctx, key, []byte("2"), []byte("3"), &ten)
+			assert.Nil(t, err)
+			assert.False(t, ok)
+			ok, err = s.CompareAndSwap(ctx, key, []byte("1"), []byte("state"), &ten)
+			assert.Nil(t, err)
+			assert.True(t, ok)
+			ok, err = s.CompareAndSwap(ctx, key, []byte("state"), []byte("state"), &ten)
+			assert.Nil(t, err)
+			assert.True(t, ok)
+			ok, err = s.CompareAndSwap(ctx, key, []byte("state"), []byte("5"), &ten)
+			assert.Nil(t, err)
+			assert.True(t, ok)
+			got, err := s.Get(ctx, key)
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("5"), got)
+
+			ok, err = s.CompareAndSwap(ctx, []byte("missing"), []byte("1"), []byte("2"), &ten)
+			assert.Nil(t, err)
+			assert.False(t, ok)
+
+			clk.Advance(10 * time.Second)
+			ok, err = s.CompareAndSwap(ctx, key, []byte("5"), []byte("6"), &ten)
+			assert.Nil(t, err)
+			assert.False(t, ok)
+			ok, err = s.SetNX(ctx, key, []byte("2"), &ten)
+			assert.Nil(t, err)
+			assert.True(t, ok)
+			got, err = s.Get(ctx, key)
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("2"), got)
+		})
+	}
+}
+
+// TestStore_Scan test Scan, Keys and DelPrefix functions
+func TestStore_Scan(t *testing.T) {
+	ctx := context.Background()
+	for _, d := range dialects {
+		t.Run(d.String(), func(t *testing.T) {
+			s, _, clk := newTestStore(t, d)
+			for _, key := range []string{"a:1", "a:2", "a:3", "b:1", "a\xff"} {
+				_, err := s.Inc(ctx, []byte(key), nil)
+				assert.Nil(t, err)
+			}
+			assert.Nil(t, s.Set(ctx, []byte("a:0"), []byte("text"), &ten))
+
+			infos, cursor, err := s.Scan(ctx, []byte("a:"), "", 2)
+			assert.Nil(t, err)
+			assert.Equal(t, []storage.KeyInfo{
+				{Key: []byte("a:0"), Value: []byte("text"), TTL: 10 * time.Second},
+				{Key: []byte("a:1"), Value: []byte("1"), TTL: storage.NoTTL},
+			}, infos)
+			assert.NotEmpty(t, cursor)
+
+			infos, cursor, err = s.Scan(ctx, []byte("a:"), cursor, 2)
+			assert.Nil(t, err)
+			assert.Len(t, infos, 2)
+			assert.Equal(t, []byte("a:3"), infos[1].Key)
+			assert.Empty(t, cursor)
+
+			_, _, err = s.Scan(ctx, nil, "!", 2)
+			assert.NotNil(t, err)
+
+			clk.Advance(10 * time.Second)
+			keys, err := s.Keys(ctx, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, [][]byte{[]byte("a:1"), []byte("a:2"), []byte("a:3"), []byte("a\xff"), []byte("b:1")}, keys)
+
+			assert.Nil(t, s.DelPrefix(ctx, []byte("a")))
+			keys, err = s.Keys(ctx, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, [][]byte{[]byte("b:1")}, keys)
+
+			assert.Nil(t, s.DelPrefix(ctx, []byte("\xff")))
+			assert.Nil(t, s.DelPrefix(ctx, nil))
+			keys, err = s.Keys(ctx, nil)
+			assert.Nil(t, err)
+			assert.Empty(t, keys)
+		})
+	}
+}
+
+// TestStore_Purge test Purge and Start functions
+func TestStore_Purge(t *testing.T) {
+	ctx := context.Background()
+	s, fake, clk := newTestStore(t, Postgres)
+
+	_, _ = s.Inc(ctx, []byte("short"), &ten)
+	_, _ = s.Inc(ctx, []byte("forever"), nil)
+	clk.Advance(10 * time.Second)
+
+	n, err := s.Purge(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), n)
+	assert.Len(t, fake.rows, 1)
+
+	_, _ = s.Inc(ctx, []byte("short"), &ten)
+	clk.Advance(10 * time.Second)
+	_ = fake.executed()
+
+	ctx, cancel := context.WithCancel(ctx)
+	s.Start(ctx, time.Second)
+	clk.Advance(time.Second)
+	assert.Eventually(t, func() bool {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		return len(fake.rows) == 1
+	}, time.Second, time.Millisecond)
+	cancel()
+	assert.Contains(t, fake.executed(), opPurge)
+}
+
+// TestStore_RateLimit test store as storage of rate limit
+func TestStore_RateLimit(t *testing.T) {
+	ctx := context.Background()
+	for _, d := range dialects {
+		t.Run(d.String(), func(t *testing.T) {
+			s, _, _ := newTestStore(t, d)
+			var _ ratelimit.ExtendedStorager = s
+			var _ ratelimit.Scanner = s
+			var _ ratelimit.KeyLister = s
+
+			cfg := ratelimit.Config{ByIp: ratelimit.ByIp{Data: []ratelimit.ByIpData{{
+				ID:        "rule",
+				Limit:     3,
+				BlockTime: 10,
+				Mask:      "10.0.0.0/8",
+			}}}}
+			rl := ratelimit.NewRateLimit(&cfg, s)
+
+			for i := 0; i < 2; i++ {
+				assert.Equal(t, int64(i+1), rl.IncByIDs(ctx, []string{"rule"}))
+				assert.False(t, rl.IsLimitedByIDs(ctx, []string{"rule"}))
+			}
+			assert.Equal(t, int64(3), rl.IncByIDs(ctx, []string{"rule"}))
+			assert.True(t, rl.IsLimitedByIDs(ctx, []string{"rule"}))
+
+			status, ok := rl.StatusByIDs(ctx, []string{"rule"})
+			assert.True(t, ok)
+			assert.Equal(t, 10*time.Second, status.Reset)
+		})
+	}
+}
+
+// TestStore_Conformance test store by storage conformance suite
+func TestStore_Conformance(t *testing.T) {
+	for _, d := range dialects {
+		d := d
+		t.Run(d.String(), func(t *testing.T) {
+			storagetest.Run(t, func(t *testing.T, clk clock.Clock) ratelimit.Storager {
+				db, _ := newFakeDB(d, DefaultTable)
+				t.Cleanup(func() { _ = db.Close() })
+
+				return New(db, d, WithClock(clk))
+			})
+		})
+	}
+}
@@ -0,0 +1,386 @@
+// Package storagetest is conformance test suite of ratelimit.Storager implementations.
+//
+// Every backend must keep the contract of storage.MemoryCache:
+//   - Get of missing key returns storage.ValueNotFoundByKey;
+//   - Set with empty key is an error;
+//   - the first Inc or Decr creates the counter with ttl, ttl of existing counter is kept;
+//   - Inc of not integer value is an error;
+//   - keys without ttl never expire.
+//
+// Optional extensions ratelimit.ExtendedStorager, ratelimit.KeyLister, ratelimit.KeyCounter
+// and ratelimit.Scanner are tested when the storage implements them.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Factory create empty storage for one test. Expirations of the storage must be driven by clk.
+type Factory func(t *testing.T, clk clock.Clock) ratelimit.Storager
+
+// Run conformance tests of storage created by factory
+func Run(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s ratelimit.Storager, clk *clock.Manual)
+	}{
+		{"GetSet", testGetSet},
+		{"Del", testDel},
+		{"Clear", testClear},
+		{"IncDecr", testIncDecr},
+		{"Expire", testExpire},
+		{"KeepTTL", testKeepTTL},
+		{"Concurrent", testConcurrent},
+		{"Extended", testExtended},
+		{"KeyLister", testKeyLister},
+		{"KeyCounter", testKeyCounter},
+		{"Scanner", testScanner},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			clk := clock.NewManual(time.Unix(1700000000, 0))
+			test.test(t, factory(t, clk), clk)
+		})
+	}
+}
+
+var (
+	ctx = context.Background()
+	one = uint64(1)
+	two = uint64(2)
+	ten = uint64(10)
+)
+
+// assertValue check value by key
+func assertValue(t *testing.T, s ratelimit.Storager, key string, want string) {
+	t.Helper()
+
+	val, err := s.Get(ctx, []byte(key))
+	if assert.Nil(t, err, key) {
+		assert.Equal(t, want, string(val), key)
+	}
+}
+
+// assertNotFound check key does not exist
+func assertNotFound(t *testing.T, s ratelimit.Storager, key string) {
+	t.Helper()
+
+	_, err := s.Get(ctx, []byte(key))
+	assert.ErrorIs(t, err, storage.ValueNotFoundByKey, key)
+	assert.False(t, s.Has(ctx, []byte(key)), key)
+}
+
+func testGetSet(t *testing.T, s ratelimit.Storager, _ *clock.Manual) {
+	assertNotFound(t, s, "key")
+
+	assert.Nil(t, s.Set(ctx, []byte("key"), []byte("value"), &ten))
+	assertValue(t, s, "key", "value")
+	assert.True(t, s.Has(ctx, []byte("key")))
+
+	assert.Nil(t, s.Set(ctx, []byte("key"), []byte("new"), &ten))
+	assertValue(t, s, "key", "new")
+
+	assert.Nil(t, s.Set(ctx, []byte("binary"), []byte{0, 1, 0xff}, nil))
+	assertValue(t, s, "binary", "\x00\x01\xff")
+
+	assert.NotNil(t, s.Set(ctx, []byte{}, []byte("value"), &ten), "empty key")
+	assert.False(t, s.Has(ctx, []byte{}))
+}
+
+func testDel(t *testing.T, s ratelimit.Storager, _ *clock.Manual) {
+	assert.Nil(t, s.Set(ctx, []byte("a"), []byte("1"), &ten))
+	assert.Nil(t, s.Set(ctx, []byte("b"), []byte("2"), &ten))
+	assert.Nil(t, s.Set(ctx, []byte("c"), []byte("3"), &ten))
+
+	assert.Nil(t, s.Del(ctx, []byte("a"), []byte("b"), []byte("missing")))
+	assertNotFound(t, s, "a")
+	assertNotFound(t, s, "b")
+	assertValue(t, s, "c", "3")
+	assert.Nil(t, s.Del(ctx))
+
+	// deleted counter starts from zero
+	_, err := s.Inc(ctx, []byte("c"), &ten)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Del(ctx, []byte("c")))
+	value, err := s.Inc(ctx, []byte("c"), &ten)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), value)
+}
+
+func testClear(t *testing.T, s ratelimit.Storager, _ *clock.Manual) {
+	for i := 0; i < 10; i++ {
+		_, err := s.Inc(ctx, []byte("key_"+strconv.Itoa(i)), &ten)
+		assert.Nil(t, err)
+	}
+
+	assert.Nil(t, s.Clear(ctx))
+	for i := 0; i < 10; i++ {
+		assertNotFound(t, s, "key_"+strconv.Itoa(i))
+	}
+	assert.Nil(t, s.Clear(ctx))
+}
+
+func testIncDecr(t *testing.T, s ratelimit.Storager, _ *clock.Manual) {
+	for i := 1; i <= 3; i++ {
+		value, err := s.Inc(ctx, []byte("inc"), &ten)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(i), value)
+	}
+	assertValue(t, s, "inc", "3")
+
+	value, err := s.Decr(ctx, []byte("inc"), &ten)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), value)
+
+	value, err = s.Decr(ctx, []byte("decr"), &ten)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-1), value)
+	assertValue(t, s, "decr", "-1")
+
+	// counter set as value
+	assert.Nil(t, s.Set(ctx, []byte("set"), []byte("41"), &ten))
+	value, err = s.Inc(ctx, []byte("set"), &ten)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(42), value)
+
+	assert.Nil(t, s.Set(ctx, []byte("text"), []byte("value"), &ten))
+	_, err = s.Inc(ctx, []byte("text"), &ten)
+	assert.NotNil(t, err, "inc of not integer value")
+	_, err = s.Decr(ctx, []byte("text"), &ten)
+	assert.NotNil(t, err, "decr of not integer value")
+	assertValue(t, s, "text", "value")
+}
+
+func testExpire(t *testing.T, s ratelimit.Storager, clk *clock.Manual) {
+	_, err := s.Inc(ctx, []byte("counter"), &one)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Set(ctx, []byte("value"), []byte("value"), &one))
+	_, err = s.Inc(ctx, []byte("forever"), nil)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Set(ctx, []byte("forever_value"), []byte("value"), nil))
+
+	clk.Advance(999 * time.Millisecond)
+	assertValue(t, s, "counter", "1")
+	assertValue(t, s, "value", "value")
+
+	clk.Advance(time.Millisecond)
+	assertNotFound(t, s, "counter")
+	assertNotFound(t, s, "value")
+
+	// expired counter starts from zero with new ttl
+	value, err := s.Inc(ctx, []byte("counter"), &one)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), value)
+
+	clk.Advance(time.Hour)
+	assertNotFound(t, s, "counter")
+	assertValue(t, s, "forever", "1")
+	assertValue(t, s, "forever_value", "value")
+}
+
+func testKeepTTL(t *testing.T, s ratelimit.Storager, clk *clock.Manual) {
+	_, err := s.Inc(ctx, []byte("key"), &two)
+	assert.Nil(t, err)
+
+	clk.Advance(time.Second)
+	value, err := s.Inc(ctx, []byte("key"), &two)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), value)
+
+	// window is counted from the first increment
+	clk.Advance(time.Second)
+	assertNotFound(t, s, "key")
+}
+
+func testConcurrent(t *testing.T, s ratelimit.Storager, _ *clock.Manual) {
+	const workers, count = 8, 100
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			own := []byte(fmt.Sprintf("own_%d", w))
+			for i := 0; i < count; i++ {
+				_, err := s.Inc(ctx, []byte("shared"), &ten)
+				assert.Nil(t, err)
+				assert.Nil(t, s.Set(ctx, own, []byte(strconv.Itoa(i)), &ten))
+				_, err = s.Get(ctx, []byte("shared"))
+				assert.Nil(t, err)
+				s.Has(ctx, own)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	assertValue(t, s, "shared", strconv.Itoa(workers*count))
+	for w := 0; w < workers; w++ {
+		assertValue(t, s, fmt.Sprintf("own_%d", w), strconv.Itoa(count-1))
+	}
+}
+
+func testExtended(t *testing.T, s ratelimit.Storager, clk *clock.Manual) {
+	ext, ok := s.(ratelimit.ExtendedStorager)
+	if !ok {
+		t.Skip("storage is not ratelimit.ExtendedStorager")
+	}
+
+	_, err := ext.TTL(ctx, []byte("missing"))
+	assert.ErrorIs(t, err, storage.ValueNotFoundByKey)
+
+	value, left, err := ext.IncrBy(ctx, []byte("key"), 5, &ten)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), value)
+	assert.Equal(t, 10*time.Second, left)
+
+	clk.Advance(4 * time.Second)
+	value, left, err = ext.IncrBy(ctx, []byte("key"), -2, &ten)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), value)
+	assert.Equal(t, 6*time.Second, left)
+
+	left, err = ext.TTL(ctx, []byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, 6*time.Second, left)
+
+	_, err = ext.Inc(ctx, []byte("forever"), nil)
+	assert.Nil(t, err)
+	left, err = ext.TTL(ctx, []byte("forever"))
+	assert.Nil(t, err)
+	assert.Equal(t, storage.NoTTL, left)
+
+	set, err := ext.SetNX(ctx, []byte("nx"), []byte("first"), &ten)
+	assert.Nil(t, err)
+	assert.True(t, set)
+	set, err = ext.SetNX(ctx, []byte("nx"), []byte("second"), &ten)
+	assert.Nil(t, err)
+	assert.False(t, set)
+	assertValue(t, s, "nx", "first")
+
+	swapped, err := ext.CompareAndSwap(ctx, []byte("nx"), []byte("second"), []byte("third"), &ten)
+	assert.Nil(t, err)
+	assert.False(t, swapped)
+	swapped, err = ext.CompareAndSwap(ctx, []byte("nx"), []byte("first"), []byte("third"), &ten)
+	assert.Nil(t, err)
+	assert.True(t, swapped)
+	assertValue(t, s, "nx", "third")
+	swapped, err = ext.CompareAndSwap(ctx, []byte("missing"), []byte("1"), []byte("2"), &ten)
+	assert.Nil(t, err)
+	assert.False(t, swapped)
+
+	// expired key can be set again
+	clk.Advance(10 * time.Second)
+	set, err = ext.SetNX(ctx, []byte("nx"), []byte("again"), &ten)
+	assert.Nil(t, err)
+	assert.True(t, set)
+}
+
+func testKeyLister(t *testing.T, s ratelimit.Storager, clk *clock.Manual) {
+	lister, ok := s.(ratelimit.KeyLister)
+	if !ok {
+		t.Skip("storage is not ratelimit.KeyLister")
+	}
+
+	for _, key := range []string{"b:2", "a:1", "b:1", "c"} {
+		_, err := s.Inc(ctx, []byte(key), nil)
+		assert.Nil(t, err)
+	}
+	_, err := s.Inc(ctx, []byte("b:expired"), &one)
+	assert.Nil(t, err)
+	clk.Advance(time.Second)
+
+	keys, err := lister.Keys(ctx, []byte("b:"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("b:1"), []byte("b:2")}, keys)
+
+	assert.Nil(t, lister.DelPrefix(ctx, []byte("b:")))
+	keys, err = lister.Keys(ctx, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a:1"), []byte("c")}, keys)
+}
+
+func testKeyCounter(t *testing.T, s ratelimit.Storager, clk *clock.Manual) {
+	counter, ok := s.(ratelimit.KeyCounter)
+	if !ok {
+		t.Skip("storage is not ratelimit.KeyCounter")
+	}
+
+	n, err := counter.Len(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	for _, key := range []string{"a", "b"} {
+		_, err := s.Inc(ctx, []byte(key), nil)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, s.Set(ctx, []byte("c"), []byte("value"), nil))
+	_, err = s.Inc(ctx, []byte("expired"), &one)
+	assert.Nil(t, err)
+	clk.Advance(time.Second)
+
+	n, err = counter.Len(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+
+	assert.Nil(t, s.Del(ctx, []byte("a")))
+	n, err = counter.Len(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+}
+
+func testScanner(t *testing.T, s ratelimit.Storager, clk *clock.Manual) {
+	scanner, ok := s.(ratelimit.Scanner)
+	if !ok {
+		t.Skip("storage is not ratelimit.Scanner")
+	}
+
+	for i := 0; i < 5; i++ {
+		_, err := s.Inc(ctx, []byte("key_"+strconv.Itoa(i)), &ten)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, s.Set(ctx, []byte("other"), []byte("value"), nil))
+	clk.Advance(time.Second)
+
+	infos, cursor, err := scanner.Scan(ctx, []byte("key_"), "", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, []storage.KeyInfo{
+		{Key: []byte("key_0"), Value: []byte("1"), TTL: 9 * time.Second},
+		{Key: []byte("key_1"), Value: []byte("1"), TTL: 9 * time.Second},
+	}, infos)
+	assert.NotEmpty(t, cursor)
+
+	// keys changed between pages don't break the cursor
+	assert.Nil(t, s.Del(ctx, []byte("key_2")))
+	keys := make([]string, 0)
+	for cursor != "" {
+		infos, cursor, err = scanner.Scan(ctx, []byte("key_"), cursor, 2)
+		if !assert.Nil(t, err) {
+			return
+		}
+		for _, info := range infos {
+			keys = append(keys, string(info.Key))
+		}
+	}
+	assert.Equal(t, []string{"key_3", "key_4"}, keys)
+
+	infos, cursor, err = scanner.Scan(ctx, nil, "", 0)
+	assert.Nil(t, err)
+	assert.Empty(t, cursor)
+	assert.Len(t, infos, 5)
+	assert.Equal(t, storage.KeyInfo{Key: []byte("other"), Value: []byte("value"), TTL: storage.NoTTL}, infos[4])
+
+	_, _, err = scanner.Scan(ctx, nil, "not a cursor!", 1)
+	assert.NotNil(t, err, "invalid cursor")
+}
@@ -0,0 +1,445 @@
+// Package tiered is two-tier storage: local counters of the instance in front of shared storage.
+//
+// Increments are counted locally and written to shared storage in batches, by FlushInterval
+// or when FlushThreshold increments of one key are pending. Values read from shared storage
+// are used locally for MaxStaleness, and blocked keys are answered locally until their ttl.
+//
+// This is a trade-off between accuracy and latency: until the next flush other instances
+// do not see local increments, so every instance can admit up to FlushThreshold
+// (or increments of one FlushInterval) requests over the limit.
+package tiered
+
+import (
+	"context"
+	"errors"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultFlushInterval is used when Options.FlushInterval is not set
+const DefaultFlushInterval = 100 * time.Millisecond
+
+// Options of Storage. Zero values are defaults.
+type Options struct {
+	// FlushInterval how often pending increments are written to shared storage
+	FlushInterval time.Duration
+	// FlushThreshold count of pending increments of one key that are written at once, 0 - only by interval
+	FlushThreshold int64
+	// MaxStaleness how long value read from shared storage is used without reading it again, 0 - always read
+	MaxStaleness time.Duration
+	// Clock of flushes and local expirations, nil - clock.Real
+	Clock clock.Clock
+}
+
+// Storage is ratelimit.Storager with local tier in front of shared storage
+type Storage struct {
+	backend ratelimit.Storager
+	opts    Options
+
+	mu       sync.Mutex
+	counters map[string]*counter
+	blocked  map[string]verdict
+}
+
+// counter is local state of one key
+type counter struct {
+	// base is the last value known from shared storage
+	base int64
+	// pending increments not written to shared storage yet
+	pending int64
+	ttl     *uint64
+	// syncedAt is time of the last read or write of shared storage, zero - never
+	syncedAt time.Time
+	// expireAt is local estimate of key expiration, zero - unknown
+	expireAt time.Time
+}
+
+// verdict is cached blocked key
+type verdict struct {
+	value int64
+	until time.Time
+}
+
+// New create two-tier storage in front of backend. Call Start to flush increments in background.
+func New(backend ratelimit.Storager, opts Options) *Storage {
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = DefaultFlushInterval
+	}
+	opts.Clock = clock.OrReal(opts.Clock)
+
+	return &Storage{
+		backend:  backend,
+		opts:     opts,
+		counters: make(map[string]*counter),
+		blocked:  make(map[string]verdict),
+	}
+}
+
+// Start flush pending increments every FlushInterval until ctx is done. The last flush is made after ctx is done.
+func (s *Storage) Start(ctx context.Context) {
+	ticker := s.opts.Clock.NewTicker(s.opts.FlushInterval)
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C():
+				if err := s.Flush(ctx); err != nil {
+					log.Printf("tiered storage flush error: %s", err.Error())
+				}
+			case <-ctx.Done():
+				if err := s.Flush(context.Background()); err != nil {
+					log.Printf("tiered storage flush error: %s", err.Error())
+				}
+				return
+			}
+		}
+	}()
+}
+
+// Get value by key. Blocked keys and fresh counters are answered locally.
+func (s *Storage) Get(ctx context.Context, key []byte) ([]byte, error) {
+	strKey := string(key)
+	now := s.opts.Clock.Now()
+
+	s.mu.Lock()
+	if v, ok := s.blocked[strKey]; ok {
+		if now.Before(v.until) {
+			s.mu.Unlock()
+			return []byte(strconv.FormatInt(v.value, 10)), nil
+		}
+		delete(s.blocked, strKey)
+	}
+
+	c := s.counter(strKey, now)
+	if c != nil && s.fresh(c, now) {
+		value := c.base + c.pending
+		s.mu.Unlock()
+		return []byte(strconv.FormatInt(value, 10)), nil
+	}
+	s.mu.Unlock()
+
+	val, err := s.backend.Get(ctx, key)
+	if err != nil && !errors.Is(err, storage.ValueNotFoundByKey) {
+		return nil, err
+	}
+
+	var base int64
+	if err == nil {
+		base, err = strconv.ParseInt(string(val), 10, 64)
+		if err != nil {
+			// not a counter, local tier is not used
+			return val, nil
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c = s.counters[strKey]
+	if c == nil {
+		if val == nil {
+			return nil, storage.ValueNotFoundByKey
+		}
+		if s.opts.MaxStaleness <= 0 {
+			return val, nil
+		}
+
+		c = &counter{}
+		s.counters[strKey] = c
+	}
+
+	c.base = base
+	c.syncedAt = now
+	if val == nil && c.pending == 0 {
+		delete(s.counters, strKey)
+		return nil, storage.ValueNotFoundByKey
+	}
+
+	return []byte(strconv.FormatInt(c.base+c.pending, 10)), nil
+}
+
+// Set value by key in shared storage. Local state of the key is dropped.
+func (s *Storage) Set(ctx context.Context, key []byte, value []byte, ttl *uint64) error {
+	s.forget(key)
+
+	return s.backend.Set(ctx, key, value, ttl)
+}
+
+// Del values by keys. Pending increments of the keys are dropped.
+func (s *Storage) Del(ctx context.Context, list ...[]byte) error {
+	s.forget(list...)
+
+	return s.backend.Del(ctx, list...)
+}
+
+// Has check is set value by key
+func (s *Storage) Has(ctx context.Context, key []byte) bool {
+	strKey := string(key)
+	now := s.opts.Clock.Now()
+
+	s.mu.Lock()
+	if v, ok := s.blocked[strKey]; ok && now.Before(v.until) {
+		s.mu.Unlock()
+		return true
+	}
+	if c := s.counter(strKey, now); c != nil && c.pending != 0 {
+		s.mu.Unlock()
+		return true
+	}
+	s.mu.Unlock()
+
+	return s.backend.Has(ctx, key)
+}
+
+// Inc value by key locally. Return the last known value of shared storage plus local increments.
+func (s *Storage) Inc(ctx context.Context, key []byte, ttl *uint64) (int64, error) {
+	return s.add(ctx, key, 1, ttl)
+}
+
+// Decr value by key locally
+func (s *Storage) Decr(ctx context.Context, key []byte, ttl *uint64) (int64, error) {
+	return s.add(ctx, key, -1, ttl)
+}
+
+// Clear all. Local state is dropped.
+func (s *Storage) Clear(ctx context.Context) error {
+	s.mu.Lock()
+	s.counters = make(map[string]*counter)
+	s.blocked = make(map[string]verdict)
+	s.mu.Unlock()
+
+	return s.backend.Clear(ctx)
+}
+
+// CacheBlocked answer the key locally with value until ttl
+func (s *Storage) CacheBlocked(ctx context.Context, key []byte, value int64, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.blocked[string(key)] = verdict{value: value, until: s.opts.Clock.Now().Add(ttl)}
+}
+
+// Flush write pending increments to shared storage. Increments that failed are kept until the next flush.
+func (s *Storage) Flush(ctx context.Context) error {
+	now := s.opts.Clock.Now()
+
+	s.mu.Lock()
+	keys := make([]string, 0, len(s.counters))
+	for strKey, c := range s.counters {
+		if c.pending != 0 {
+			keys = append(keys, strKey)
+			continue
+		}
+		// counters without pending increments are kept only while they are fresh
+		if !s.fresh(c, now) {
+			delete(s.counters, strKey)
+		}
+	}
+	for strKey, v := range s.blocked {
+		if !now.Before(v.until) {
+			delete(s.blocked, strKey)
+		}
+	}
+	s.mu.Unlock()
+
+	var firstErr error
+	for _, strKey := range keys {
+		if err := s.flushKey(ctx, strKey); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// Pending return count of keys with increments not written to shared storage
+func (s *Storage) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, c := range s.counters {
+		if c.pending != 0 {
+			n++
+		}
+	}
+
+	return n
+}
+
+// add delta to local counter, flush the key when threshold is reached
+func (s *Storage) add(ctx context.Context, key []byte, delta int64, ttl *uint64) (int64, error) {
+	strKey := string(key)
+	now := s.opts.Clock.Now()
+
+	s.mu.Lock()
+	c := s.counter(strKey, now)
+	if c == nil {
+		c = &counter{}
+		s.counters[strKey] = c
+	}
+	c.pending += delta
+	c.ttl = ttl
+	if c.expireAt.IsZero() && ttl != nil && *ttl > 0 {
+		c.expireAt = now.Add(time.Duration(*ttl) * time.Second)
+	}
+	value := c.base + c.pending
+	flush := s.opts.FlushThreshold > 0 && abs(c.pending) >= s.opts.FlushThreshold
+	s.mu.Unlock()
+
+	if !flush {
+		return value, nil
+	}
+
+	if err := s.flushKey(ctx, strKey); err != nil {
+		log.Printf("tiered storage flush %s error: %s", strKey, err.Error())
+		return value, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c = s.counters[strKey]; c != nil {
+		value = c.base + c.pending
+	}
+
+	return value, nil
+}
+
+// flushKey write pending increments of the key to shared storage
+func (s *Storage) flushKey(ctx context.Context, strKey string) error {
+	s.mu.Lock()
+	c := s.counters[strKey]
+	if c == nil || c.pending == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	n, ttl := c.pending, c.ttl
+	c.pending = 0
+	s.mu.Unlock()
+
+	value, left, err := s.incrBy(ctx, []byte(strKey), n, ttl)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c != s.counters[strKey] {
+		// the key was deleted or cleared meanwhile
+		return err
+	}
+	if err != nil {
+		var partial errPartial
+		if errors.As(err, &partial) {
+			if n < 0 {
+				n += partial.done
+			} else {
+				n -= partial.done
+			}
+		}
+		c.pending += n
+		return err
+	}
+
+	c.base = value
+	c.syncedAt = s.opts.Clock.Now()
+	if left > 0 {
+		c.expireAt = c.syncedAt.Add(left)
+	}
+
+	return nil
+}
+
+// incrBy add n to shared storage by one call if it is ExtendedStorager, otherwise by n calls of Inc or Decr
+func (s *Storage) incrBy(ctx context.Context, key []byte, n int64, ttl *uint64) (int64, time.Duration, error) {
+	if ext, ok := s.backend.(ratelimit.ExtendedStorager); ok {
+		value, left, err := ext.IncrBy(ctx, key, n, ttl)
+		if !errors.Is(err, ratelimit.ErrNotSupported) {
+			return value, left, err
+		}
+	}
+
+	op := s.backend.Inc
+	if n < 0 {
+		op = s.backend.Decr
+	}
+
+	var value int64
+	for i := int64(0); i < abs(n); i++ {
+		var err error
+		value, err = op(ctx, key, ttl)
+		if err != nil {
+			// increments made before the error are not repeated
+			return value, 0, errPartial{done: i, err: err}
+		}
+	}
+
+	return value, 0, nil
+}
+
+// counter return local counter by key. Expired counter is dropped, or starts the next window
+// with pending increments only if they are not written yet.
+func (s *Storage) counter(strKey string, now time.Time) *counter {
+	c, ok := s.counters[strKey]
+	if !ok {
+		return nil
+	}
+	if !c.expireAt.IsZero() && !now.Before(c.expireAt) {
+		if c.pending == 0 {
+			delete(s.counters, strKey)
+			return nil
+		}
+		// value of the previous window is not counted, shared storage is read again
+		c.base = 0
+		c.syncedAt = time.Time{}
+		c.expireAt = time.Time{}
+	}
+
+	return c
+}
+
+// fresh check can counter be answered without shared storage
+func (s *Storage) fresh(c *counter, now time.Time) bool {
+	return !c.syncedAt.IsZero() && now.Sub(c.syncedAt) < s.opts.MaxStaleness
+}
+
+// forget drop local state of keys
+func (s *Storage) forget(list ...[]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range list {
+		delete(s.counters, string(key))
+		delete(s.blocked, string(key))
+	}
+}
+
+// errPartial is error of incrBy after some increments were written
+type errPartial struct {
+	done int64
+	err  error
+}
+
+func (e errPartial) Error() string {
+	return e.err.Error()
+}
+
+func (e errPartial) Unwrap() error {
+	return e.err
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
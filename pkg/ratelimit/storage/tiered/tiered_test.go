@@ -0,0 +1,298 @@
+package tiered
+
+import (
+	"context"
+	"errors"
+	"github.com/itbellissimo/ratelimit/pkg/clock"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit"
+	"github.com/itbellissimo/ratelimit/pkg/ratelimit/storage"
+	"github.com/stretchr/testify/assert"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var ten = uint64(10)
+
+// countingStorage is shared storage that counts calls and can be down
+type countingStorage struct {
+	*storage.MemoryCache
+	down  atomic.Bool
+	gets  atomic.Int64
+	incrs atomic.Int64
+}
+
+var errStorageDown = errors.New("storage is down")
+
+func newCountingStorage() *countingStorage {
+	return &countingStorage{MemoryCache: storage.NewMemoryCache()}
+}
+
+func (s *countingStorage) Get(ctx context.Context, key []byte) ([]byte, error) {
+	s.gets.Add(1)
+	if s.down.Load() {
+		return nil, errStorageDown
+	}
+
+	return s.MemoryCache.Get(ctx, key)
+}
+
+func (s *countingStorage) IncrBy(ctx context.Context, key []byte, n int64, ttl *uint64) (int64, time.Duration, error) {
+	s.incrs.Add(1)
+	if s.down.Load() {
+		return 0, 0, errStorageDown
+	}
+
+	return s.MemoryCache.IncrBy(ctx, key, n, ttl)
+}
+
+// incOnlyStorage is shared storage without IncrBy
+type incOnlyStorage struct {
+	ratelimit.Storager
+}
+
+// TestStorage_Flush test increments are written to shared storage in batch
+func TestStorage_Flush(t *testing.T) {
+	backend := newCountingStorage()
+	s := New(backend, Options{})
+	ctx := context.Background()
+
+	for i := 1; i <= 5; i++ {
+		value, err := s.Inc(ctx, []byte("key"), &ten)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(i), value)
+	}
+	assert.Equal(t, int64(0), backend.incrs.Load())
+	assert.False(t, backend.MemoryCache.Has(ctx, []byte("key")))
+	assert.Equal(t, 1, s.Pending())
+
+	// local increments are visible before flush
+	val, err := s.Get(ctx, []byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, "5", string(val))
+
+	err = s.Flush(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), backend.incrs.Load())
+	assert.Equal(t, 0, s.Pending())
+
+	val, err = backend.MemoryCache.Get(ctx, []byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, "5", string(val))
+	ttl, err := backend.TTL(ctx, []byte("key"))
+	assert.Nil(t, err)
+	assert.True(t, ttl > 9*time.Second)
+
+	// other instance increments the same key
+	other := New(backend, Options{})
+	_, _ = other.Inc(ctx, []byte("key"), &ten)
+	_, _ = other.Inc(ctx, []byte("key"), &ten)
+	assert.Nil(t, other.Flush(ctx))
+
+	val, err = s.Get(ctx, []byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, "7", string(val))
+}
+
+// TestStorage_FlushThreshold test key is written when threshold is reached
+func TestStorage_FlushThreshold(t *testing.T) {
+	backend := newCountingStorage()
+	s := New(backend, Options{FlushThreshold: 3})
+	ctx := context.Background()
+
+	_, _ = backend.MemoryCache.Inc(ctx, []byte("key"), &ten)
+
+	_, _ = s.Inc(ctx, []byte("key"), &ten)
+	_, _ = s.Inc(ctx, []byte("key"), &ten)
+	assert.Equal(t, int64(0), backend.incrs.Load())
+
+	// the flush returns value of shared storage
+	value, err := s.Inc(ctx, []byte("key"), &ten)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), value)
+	assert.Equal(t, int64(1), backend.incrs.Load())
+	assert.Equal(t, 0, s.Pending())
+}
+
+// TestStorage_FlushError test increments are kept when shared storage fails
+func TestStorage_FlushError(t *testing.T) {
+	backend := newCountingStorage()
+	s := New(backend, Options{})
+	ctx := context.Background()
+
+	_, _ = s.Inc(ctx, []byte("key"), &ten)
+	_, _ = s.Inc(ctx, []byte("key"), &ten)
+
+	backend.down.Store(true)
+	err := s.Flush(ctx)
+	assert.ErrorIs(t, err, errStorageDown)
+	assert.Equal(t, 1, s.Pending())
+
+	_, err = s.Get(ctx, []byte("key"))
+	assert.ErrorIs(t, err, errStorageDown)
+
+	backend.down.Store(false)
+	assert.Nil(t, s.Flush(ctx))
+
+	val, err := backend.MemoryCache.Get(ctx, []byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, "2", string(val))
+}
+
+// TestStorage_IncOnlyBackend test flush to shared storage without IncrBy
+func TestStorage_IncOnlyBackend(t *testing.T) {
+	backend := incOnlyStorage{storage.NewMemoryCache()}
+	s := New(backend, Options{})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		_, _ = s.Inc(ctx, []byte("key"), &ten)
+	}
+	_, _ = s.Decr(ctx, []byte("other"), &ten)
+	assert.Nil(t, s.Flush(ctx))
+
+	val, err := backend.Get(ctx, []byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, "3", string(val))
+	val, err = backend.Get(ctx, []byte("other"))
+	assert.Nil(t, err)
+	assert.Equal(t, "-1", string(val))
+}
+
+// TestStorage_MaxStaleness test values of shared storage are read once per MaxStaleness
+func TestStorage_MaxStaleness(t *testing.T) {
+	backend := newCountingStorage()
+	clk := clock.NewManual(time.Now())
+	s := New(backend, Options{MaxStaleness: 200 * time.Millisecond, Clock: clk})
+	ctx := context.Background()
+
+	_, err := s.Get(ctx, []byte("key"))
+	assert.Equal(t, storage.ValueNotFoundByKey, err)
+
+	_ = backend.MemoryCache.Set(ctx, []byte("key"), []byte("4"), &ten)
+	for i := 0; i < 3; i++ {
+		val, err := s.Get(ctx, []byte("key"))
+		assert.Nil(t, err)
+		assert.Equal(t, "4", string(val))
+	}
+	assert.Equal(t, int64(2), backend.gets.Load())
+
+	// local increment is added to the cached value
+	_, _ = s.Inc(ctx, []byte("key"), &ten)
+	val, err := s.Get(ctx, []byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, "5", string(val))
+	assert.Equal(t, int64(2), backend.gets.Load())
+
+	clk.Advance(200 * time.Millisecond)
+	_, _ = backend.MemoryCache.Inc(ctx, []byte("key"), &ten)
+	val, err = s.Get(ctx, []byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, "6", string(val))
+	assert.Equal(t, int64(3), backend.gets.Load())
+}
+
+// TestStorage_CacheBlocked test blocked keys are answered locally until ttl
+func TestStorage_CacheBlocked(t *testing.T) {
+	backend := newCountingStorage()
+	clk := clock.NewManual(time.Now())
+	s := New(backend, Options{Clock: clk})
+	ctx := context.Background()
+
+	s.CacheBlocked(ctx, []byte("key"), 3, 200*time.Millisecond)
+	val, err := s.Get(ctx, []byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, "3", string(val))
+	assert.True(t, s.Has(ctx, []byte("key")))
+	assert.Equal(t, int64(0), backend.gets.Load())
+
+	clk.Advance(200 * time.Millisecond)
+	_, err = s.Get(ctx, []byte("key"))
+	assert.Equal(t, storage.ValueNotFoundByKey, err)
+	assert.Equal(t, int64(1), backend.gets.Load())
+
+	// Del forgets blocked key
+	s.CacheBlocked(ctx, []byte("key"), 3, time.Minute)
+	assert.Nil(t, s.Del(ctx, []byte("key")))
+	assert.False(t, s.Has(ctx, []byte("key")))
+}
+
+// TestStorage_RateLimit test rate limit caches blocked verdict in tiered storage
+func TestStorage_RateLimit(t *testing.T) {
+	backend := newCountingStorage()
+	s := New(backend, Options{})
+	ctx := context.Background()
+	cfg := &ratelimit.Config{ByIp: ratelimit.ByIp{Data: []ratelimit.ByIpData{
+		{ID: "rule", Limit: 2, BlockTime: 10},
+	}}}
+	rl := ratelimit.NewRateLimit(cfg, s)
+
+	rl.IncByIDs(ctx, []string{"rule"})
+	rl.IncByIDs(ctx, []string{"rule"})
+	assert.True(t, rl.IsLimitedByIDs(ctx, []string{"rule"}))
+	gets := backend.gets.Load()
+
+	assert.True(t, rl.IsLimitedByIDs(ctx, []string{"rule"}))
+	assert.True(t, rl.IsLimitedByIDs(ctx, []string{"rule"}))
+	assert.Equal(t, gets, backend.gets.Load())
+
+	assert.Nil(t, rl.ClearByIDs(ctx, []string{"rule"}))
+	assert.False(t, rl.IsLimitedByIDs(ctx, []string{"rule"}))
+}
+
+// TestStorage_Start test background flush
+func TestStorage_Start(t *testing.T) {
+	backend := newCountingStorage()
+	clk := clock.NewManual(time.Now())
+	s := New(backend, Options{FlushInterval: 20 * time.Millisecond, Clock: clk})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.Start(ctx)
+	_, _ = s.Inc(ctx, []byte("key"), &ten)
+	assert.False(t, backend.MemoryCache.Has(context.Background(), []byte("key")))
+	clk.Advance(20 * time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return backend.MemoryCache.Has(context.Background(), []byte("key"))
+	}, time.Second, 10*time.Millisecond)
+
+	// the last flush after ctx is done
+	_, _ = s.Inc(ctx, []byte("key"), &ten)
+	cancel()
+	assert.Eventually(t, func() bool {
+		val, _ := backend.MemoryCache.Get(context.Background(), []byte("key"))
+		return string(val) == "2"
+	}, time.Second, 10*time.Millisecond)
+}
+
+// TestStorage_ExpirePending test counter with pending increments starts the next window without the previous value
+func TestStorage_ExpirePending(t *testing.T) {
+	ctx := context.Background()
+	clk := clock.NewManual(time.Now())
+	backend := storage.NewMemoryCache(storage.WithClock(clk))
+	s := New(backend, Options{MaxStaleness: time.Minute, Clock: clk})
+
+	for i := 0; i < 5; i++ {
+		_, _ = s.Inc(ctx, []byte("key"), &ten)
+	}
+	assert.Nil(t, s.Flush(ctx))
+	value, err := s.Inc(ctx, []byte("key"), &ten)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), value)
+
+	// the window ends while the increment is pending
+	clk.Advance(11 * time.Second)
+	value, err = s.Inc(ctx, []byte("key"), &ten)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), value)
+	val, err := s.Get(ctx, []byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, "2", string(val))
+
+	assert.Nil(t, s.Flush(ctx))
+	val, err = backend.Get(ctx, []byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, "2", string(val))
+	value, err = s.Inc(ctx, []byte("key"), &ten)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), value)
+}